@@ -0,0 +1,144 @@
+package gopher
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Access is controlled by allow and deny rules, written in the
+// configuration file as
+//    allow|deny address[/bits]|all [selector-prefix]
+// either at the top level or inside a vhost block. The rules of the
+// connection's virtual host are tried first, then the server's, each in
+// the order written; the first rule matching both the client address and
+// the selector decides. A request no rule matches is allowed. Denied
+// clients get an error item with DenyMessage.
+
+// DefaultDenyMessage is shown to denied clients unless DenyMessage is set
+const DefaultDenyMessage = "Access denied"
+
+// ACLRule allows or denies clients in a network access to the selectors
+// under a prefix
+type ACLRule struct {
+	Allow  bool
+	IP     net.IP // Network address; nil matches every client
+	Bits   int    // Length of the network prefix in bits
+	Prefix string // Selector prefix; empty matches every selector
+}
+
+// String renders the rule the way it is written in a configuration file
+func (r *ACLRule) String() string {
+	s := "deny "
+	if r.Allow {
+		s = "allow "
+	}
+	if r.IP == nil {
+		s += "all"
+	} else {
+		s += fmt.Sprintf("%s/%d", r.IP, r.Bits)
+	}
+	if r.Prefix != "" {
+		s += " " + r.Prefix
+	}
+	return s
+}
+
+// ParseACLRule parses the arguments of an allow or deny line
+func ParseACLRule(allow bool, args []string) (*ACLRule, os.Error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, os.NewError("expected an address or network and an optional selector prefix")
+	}
+	r := &ACLRule{Allow: allow}
+	if len(args) == 2 {
+		r.Prefix = "/" + strings.Trim(args[1], "/")
+	}
+	if args[0] == "all" {
+		return r, nil
+	}
+	addr, bits := args[0], -1
+	if i := strings.Index(addr, "/"); i != -1 {
+		n, err := strconv.Atoi(addr[i+1:])
+		if err != nil || n < 0 {
+			return nil, os.NewError(fmt.Sprintf("bad prefix length in `%s'", args[0]))
+		}
+		addr, bits = addr[:i], n
+	}
+	if r.IP = net.ParseIP(addr); r.IP == nil {
+		return nil, os.NewError(fmt.Sprintf("bad address `%s'", addr))
+	}
+	if ip4 := r.IP.To4(); ip4 != nil {
+		r.IP = ip4
+	}
+	if bits == -1 {
+		bits = len(r.IP) * 8
+	}
+	if bits < 0 || bits > len(r.IP)*8 {
+		return nil, os.NewError(fmt.Sprintf("bad prefix length in `%s'", args[0]))
+	}
+	r.Bits = bits
+	return r, nil
+}
+
+// matches reports whether the rule covers a request for selector from ip
+func (r *ACLRule) matches(ip net.IP, selector string) bool {
+	if r.Prefix != "" && selector != r.Prefix && !strings.HasPrefix(selector, r.Prefix+"/") {
+		return false
+	}
+	if r.IP == nil {
+		return true
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip == nil || len(ip) != len(r.IP) {
+		return false
+	}
+	for i := 0; i < r.Bits; i += 8 {
+		mask := byte(0xff)
+		if r.Bits-i < 8 {
+			mask = byte(0xff << uint(8-(r.Bits-i)))
+		}
+		if ip[i/8]&mask != r.IP[i/8]&mask {
+			return false
+		}
+	}
+	return true
+}
+
+// AddACLRule appends a rule to the server's access rules
+func (s *Server) AddACLRule(r *ACLRule) {
+	s.acl = append(s.acl, r)
+}
+
+// checkACL returns the first rule in rules covering the request, or nil
+func checkACL(rules []*ACLRule, ip net.IP, selector string) *ACLRule {
+	for _, r := range rules {
+		if r.matches(ip, selector) {
+			return r
+		}
+	}
+	return nil
+}
+
+// allowed reports whether the client of ctx may request selector
+func (s *Server) allowed(ctx *Context, selector string) bool {
+	ip := net.ParseIP(ctx.ClientIP())
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	r := checkACL(ctx.Host.acl, ip, selector)
+	if r == nil {
+		r = checkACL(s.acl, ip, selector)
+	}
+	return r == nil || r.Allow
+}
+
+// denyMessage returns the text shown to denied clients
+func (s *Server) denyMessage() string {
+	if s.DenyMessage == "" {
+		return DefaultDenyMessage
+	}
+	return s.DenyMessage
+}
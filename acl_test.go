@@ -0,0 +1,88 @@
+package gopher
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseACLRule(t *testing.T) {
+	good := []struct {
+		allow bool
+		args  []string
+		want  string
+	}{
+		{true, []string{"all"}, "allow all"},
+		{false, []string{"all", "/private/"}, "deny all /private"},
+		{true, []string{"192.0.2.10"}, "allow 192.0.2.10/32"},
+		{false, []string{"192.0.2.0/24", "admin"}, "deny 192.0.2.0/24 /admin"},
+		{true, []string{"2001:db8::/32"}, "allow 2001:db8::/32"},
+		{true, []string{"::ffff:192.0.2.1"}, "allow 192.0.2.1/32"},
+	}
+	for _, test := range good {
+		r, err := ParseACLRule(test.allow, test.args)
+		if err != nil {
+			t.Errorf("%q: %s", test.args, err)
+			continue
+		}
+		if got := r.String(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.args, got, test.want)
+		}
+	}
+	bad := [][]string{
+		{},
+		{"all", "/a", "/b"},
+		{"example.com"},
+		{"192.0.2.0/x"},
+		{"192.0.2.0/33"},
+		{"192.0.2.0/-1"},
+		{"2001:db8::/129"},
+	}
+	for _, args := range bad {
+		if r, err := ParseACLRule(true, args); err == nil {
+			t.Errorf("%q: got %s, want an error", args, r)
+		}
+	}
+}
+
+func TestCheckACL(t *testing.T) {
+	var rules []*ACLRule
+	for _, line := range [][]string{
+		{"allow", "192.0.2.10", "/private"},
+		{"deny", "192.0.2.0/25", "/private"},
+		{"deny", "2001:db8::/32"},
+		{"allow", "all", "/private/open"},
+		{"deny", "all", "/private"},
+	} {
+		r, err := ParseACLRule(line[0] == "allow", line[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	tests := []struct {
+		ip       string
+		selector string
+		want     string // The deciding rule, or "" for none
+	}{
+		{"192.0.2.10", "/private/x.txt", "allow 192.0.2.10/32 /private"},
+		{"::ffff:192.0.2.10", "/private", "allow 192.0.2.10/32 /private"},
+		{"192.0.2.11", "/private/x.txt", "deny 192.0.2.0/25 /private"},
+		{"192.0.2.200", "/private/open/y.txt", "allow all /private/open"},
+		{"192.0.2.200", "/private/x.txt", "deny all /private"},
+		{"192.0.2.200", "/privateer", ""},
+		{"192.0.2.11", "/pub.txt", ""},
+		{"2001:db8::1", "/pub.txt", "deny 2001:db8::/32"},
+		{"2001:db9::1", "/pub.txt", ""},
+		{"", "/private/x.txt", "deny all /private"},
+	}
+	for _, test := range tests {
+		r := checkACL(rules, net.ParseIP(test.ip), test.selector)
+		got := ""
+		if r != nil {
+			got = r.String()
+		}
+		if got != test.want {
+			t.Errorf("%s %s: got %q, want %q", test.ip, test.selector, got, test.want)
+		}
+	}
+}
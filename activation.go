@@ -0,0 +1,87 @@
+package gopher
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Under systemd socket activation the listening socket is passed in as
+// file descriptor 3, announced by LISTEN_FDS and LISTEN_PID. Run uses it
+// instead of binding its own. Under inetd a single connection arrives on
+// stdin and stdout, and ServeInetd answers it.
+
+// systemdListener returns the listener passed by systemd, or nil if the
+// server was not socket activated
+func systemdListener() (net.Listener, os.Error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		return nil, os.NewError(fmt.Sprintf("expected one socket from systemd, got %d", fds))
+	}
+	os.Setenv("LISTEN_PID", "")
+	os.Setenv("LISTEN_FDS", "")
+	return net.FileListener(os.NewFile(3, "systemd"))
+}
+
+// stdioAddr is the address of either end of a connection on stdin and
+// stdout that is not a socket
+type stdioAddr struct{}
+
+func (a stdioAddr) Network() string { return "stdio" }
+func (a stdioAddr) String() string  { return "stdio" }
+
+// stdioConn is a connection read from stdin and written to stdout, for
+// running under inetd with a pipe rather than a socket, or by hand
+type stdioConn struct{}
+
+func (c stdioConn) Read(b []byte) (int, os.Error)  { return os.Stdin.Read(b) }
+func (c stdioConn) Write(b []byte) (int, os.Error) { return os.Stdout.Write(b) }
+func (c stdioConn) Close() os.Error                { return os.Stdout.Close() }
+func (c stdioConn) LocalAddr() net.Addr            { return stdioAddr{} }
+func (c stdioConn) RemoteAddr() net.Addr           { return stdioAddr{} }
+func (c stdioConn) SetTimeout(nsec int64) os.Error { return nil }
+func (c stdioConn) SetReadTimeout(nsec int64) os.Error {
+	return nil
+}
+func (c stdioConn) SetWriteTimeout(nsec int64) os.Error {
+	return nil
+}
+
+// ServeInetd answers the single request on stdin and stdout, as when run
+// from inetd or xinetd. Hostname and port are those menus point back at.
+// Log output to stdout would end up in the response, so stdout sinks are
+// replaced by syslog.
+func (s *Server) ServeInetd(hostname string, port int) {
+	s.init()
+	s.Hostname = hostname
+	s.Port = port
+	if sink, err := NewSyslogSink("gopherd"); err == nil {
+		s.Logger.replaceWriter(os.Stdout, sink)
+	} else {
+		s.Logger.replaceWriter(os.Stdout, nil)
+	}
+	if err := s.dropPrivileges(); err != nil {
+		s.Logger.Printf("ERROR: Could not drop privileges: %s\n", err)
+		os.Exit(1)
+	}
+	var conn net.Conn
+	conn, err := net.FileConn(os.Stdin)
+	if err != nil {
+		conn = stdioConn{}
+	}
+	s.handle(s.newContext(conn, time.Nanoseconds()))
+}
+
+// ServeInetd answers the request on stdin and stdout with DefaultServer
+func ServeInetd(hostname string, port int) {
+	DefaultServer.ServeInetd(hostname, port)
+}
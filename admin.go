@@ -0,0 +1,238 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The admin menu lets operators check on the server from any Gopher
+// client. With
+//    admin-menu /admin /etc/gopher/admin.tokens
+// the selectors below /admin are a protected area, so the menu is reached
+// as /admin/<token>, and list the server's counters, its recent log
+// entries, the state of its caches and a summary of its configuration.
+
+// adminPages are the pages of the admin menu by their selector below it. A
+// page with an action runs it for selectors below the page, such as
+// /admin/<token>/maintenance/on, before showing the page.
+var adminPages = []struct {
+	name    string
+	display string
+	serve   func(s *Server, menu *Menu)
+	act     func(s *Server, arg string) bool
+}{
+	{"stats", "Statistics", (*Server).adminStats, nil},
+	{"log", "Recent log entries", (*Server).adminLog, nil},
+	{"cache", "Caches", (*Server).adminCache, nil},
+	{"config", "Configuration", (*Server).adminConfig, nil},
+	{"subsystems", "Subsystems", (*Server).adminSubsystems, nil},
+	{"maintenance", "Maintenance mode", (*Server).adminMaintenance, (*Server).adminSetMaintenance},
+	{"roots", "Document roots", (*Server).adminRoots, (*Server).adminSwitchRoots},
+}
+
+// configureAdmin handles an `admin-menu prefix credentials-file' line
+func (s *Server) configureAdmin(args []string) os.Error {
+	if err := s.configureProtect(args); err != nil {
+		return err
+	}
+	s.AdminSelector = "/" + strings.Trim(args[0], "/")
+	return nil
+}
+
+// isAdmin reports whether selector is in the admin menu
+func (s *Server) isAdmin(selector string) bool {
+	return s.AdminSelector != "" && underPrefix(selector, s.AdminSelector)
+}
+
+// serveAdmin sends a page of the admin menu. Only requests authenticated
+// for the admin area itself are answered, whatever rewrite or alias led
+// to the selector, as some pages change the server's state.
+func (s *Server) serveAdmin(ctx *Context) Status {
+	if ctx.area == nil || ctx.area.Prefix != s.AdminSelector {
+		ctx.Logf("ERROR: Admin page `%s' requested without the admin token\n", ctx.Request)
+		return StatusDenied
+	}
+	page := strings.Trim(ctx.Request[len(s.AdminSelector):], "/")
+	menu := NewMenu(ctx)
+	if page == "" {
+		menu.Info(fmt.Sprintf("Administration of %s", s.Hostname))
+		menu.Info("")
+		for _, p := range adminPages {
+			menu.Item('1', p.display, s.AdminSelector+"/"+p.name)
+		}
+		menu.WriteTo(ctx)
+		return StatusOK
+	}
+	arg := ""
+	if i := strings.Index(page, "/"); i != -1 {
+		page, arg = page[:i], page[i+1:]
+	}
+	for _, p := range adminPages {
+		if p.name == page {
+			if arg != "" && (p.act == nil || !p.act(s, arg)) {
+				return StatusNotFound
+			}
+			menu.Info(p.display)
+			menu.Info("")
+			p.serve(s, menu)
+			menu.Item('1', "Back", s.AdminSelector)
+			menu.WriteTo(ctx)
+			ctx.Logf("Served admin page `%s'\n", page)
+			return StatusOK
+		}
+	}
+	return StatusNotFound
+}
+
+// uptime returns how long the server has been running
+func (s *Server) uptime() string {
+	if s.started == 0 {
+		return "not started"
+	}
+	return fmt.Sprintf("%ds", (time.Nanoseconds()-s.started)/1e9)
+}
+
+func (s *Server) adminStats(menu *Menu) {
+	st := s.Stats()
+	menu.Info("Uptime: " + s.uptime())
+	menu.Info(fmt.Sprintf("Requests: %d", st.Requests))
+	menu.Info(fmt.Sprintf("Errors: %d", st.Errors))
+	menu.Info(fmt.Sprintf("Bytes sent: %d", st.BytesSent))
+	menu.Info(fmt.Sprintf("Active connections: %d", st.ActiveConnections))
+	if st.Requests > 0 {
+		menu.Info(fmt.Sprintf("Mean latency: %.3fs", st.LatencySum/float64(st.Requests)))
+	}
+	types := make([]string, 0, len(st.ItemTypes))
+	for t, n := range st.ItemTypes {
+		types = append(types, fmt.Sprintf("%c=%d", t, n))
+	}
+	sort.SortStrings(types)
+	if len(types) > 0 {
+		menu.Info("By item type: " + strings.Join(types, " "))
+	}
+	menu.Info("")
+	for _, sel := range s.TopSelectors(10) {
+		menu.Info(fmt.Sprintf("%6d hits %10d bytes %s", sel.Hits, sel.Bytes, sel.Selector))
+	}
+	menu.Info("")
+}
+
+func (s *Server) adminLog(menu *Menu) {
+	entries := s.Logger.Recent()
+	if len(entries) == 0 {
+		menu.Info("Nothing has been logged yet.")
+	}
+	for _, entry := range entries {
+		stamp := time.SecondsToLocalTime(entry.Time / 1e9).Format("2006/01/02 15:04:05")
+		menu.Info(stamp + " " + entry.String())
+	}
+	menu.Info("")
+}
+
+func (s *Server) adminCache(menu *Menu) {
+	c := s.sidecars
+	if c == nil {
+		menu.Info("Sidecar files are not cached.")
+	} else {
+		c.RLock()
+		menu.Info(fmt.Sprintf("Sidecar files cached: %d of at most %d", len(c.files), MaxCachedSidecars))
+		menu.Info(fmt.Sprintf("Directories watched: %d", len(c.watched)))
+		menu.Info(fmt.Sprintf("Invalidations: %d", c.generation))
+		c.RUnlock()
+	}
+	if s.FileCacheSize > 0 {
+		f := &s.files
+		f.Lock()
+		menu.Info(fmt.Sprintf("Files cached: %d, %d of at most %d bytes", len(f.files), f.bytes, s.FileCacheSize))
+		f.Unlock()
+	} else {
+		menu.Info("Files are not cached.")
+	}
+	if s.Thumbnails {
+		t := &s.thumbnails
+		t.Lock()
+		menu.Info(fmt.Sprintf("Image views cached: %d of at most %d", len(t.views), MaxCachedThumbnails))
+		t.Unlock()
+	}
+	menu.Info("")
+}
+
+func (s *Server) adminConfig(menu *Menu) {
+	s.reloadMu.RLock()
+	lines := []string{
+		"Configuration file: " + s.configFile,
+		fmt.Sprintf("Listening as: %s:%d", s.Hostname, s.Port),
+		"Document root: " + s.Cwd,
+		fmt.Sprintf("Workers: %d, queue: %d", s.Workers, s.QueueLength),
+		fmt.Sprintf("Virtual hosts: %d", len(s.vhosts)),
+		fmt.Sprintf("Protected areas: %d", len(s.protected)),
+		fmt.Sprintf("Rewrite rules: %d, redirects: %d", len(s.rewrites), len(s.redirects)),
+		fmt.Sprintf("Proxies: %d, mirrors: %d", len(s.proxies), len(s.mirrors)),
+		fmt.Sprintf("Phlogs: %d, guestbooks: %d, drop boxes: %d", len(s.phlogs), len(s.guestbooks), len(s.dropboxes)),
+	}
+	s.reloadMu.RUnlock()
+	for _, line := range lines {
+		menu.Info(line)
+	}
+	for _, f := range s.Features() {
+		menu.Info("Feature: " + f.String())
+	}
+	menu.Info("")
+}
+
+func (s *Server) adminSubsystems(menu *Menu) {
+	for _, sub := range s.Subsystems() {
+		menu.Info(sub.String())
+	}
+	menu.Info("")
+}
+
+func (s *Server) adminMaintenance(menu *Menu) {
+	if s.InMaintenance() {
+		menu.Info("The server is in maintenance mode.")
+		menu.Item('1', "Leave maintenance mode", s.AdminSelector+"/maintenance/off")
+	} else {
+		menu.Info("The server is serving as usual.")
+		menu.Item('1', "Enter maintenance mode", s.AdminSelector+"/maintenance/on")
+	}
+	menu.Info("")
+}
+
+// adminSetMaintenance switches maintenance mode for the selectors below
+// the maintenance page
+func (s *Server) adminSetMaintenance(arg string) bool {
+	if arg != "on" && arg != "off" {
+		return false
+	}
+	s.SetMaintenance(arg == "on")
+	return true
+}
+
+func (s *Server) adminRoots(menu *Menu) {
+	s.reloadMu.RLock()
+	live, staged := s.Cwd, s.StagedRoot
+	s.reloadMu.RUnlock()
+	menu.Info("Live: " + live)
+	if staged == "" {
+		menu.Info("No staged root is configured.")
+	} else {
+		menu.Info("Staged: " + staged)
+		menu.Item('1', "Switch to "+staged, s.AdminSelector+"/roots/switch")
+	}
+	menu.Info("")
+}
+
+// adminSwitchRoots switches the document roots for the switch selector
+// below the roots page
+func (s *Server) adminSwitchRoots(arg string) bool {
+	if arg != "switch" {
+		return false
+	}
+	if err := s.SwitchRoots(); err != nil {
+		s.Logger.Printf("ERROR: Could not switch roots: %s\n", err)
+	}
+	return true
+}
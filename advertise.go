@@ -0,0 +1,82 @@
+package gopher
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Menus normally point local items at Hostname and Port, which are also
+// where the server listens. Behind NAT, or when bound to a wildcard or
+// internal address, AdvertiseHost and AdvertisePort give the host and port
+// clients reach the server at instead. An advertised host can also be set
+// for the selectors below a prefix, as when a subtree
+// is reached through another name or a forwarded port, or is canonically
+// served by another server:
+//
+//    advertise /archive archive.example.org
+//    advertise /nat gopher.example.org:7070
+//
+// The override applies to every menu line, Gopher+ INFO line and URL the
+// server generates for those selectors.
+
+// AdvertisedHost is the host and port menus give for the selectors below
+// Prefix
+type AdvertisedHost struct {
+	Prefix string
+	Host   string
+	Port   int
+}
+
+// AddAdvertisedHost points the menu lines for selectors below prefix at
+// host and port
+func (s *Server) AddAdvertisedHost(prefix string, host string, port int) {
+	s.advertised = append(s.advertised, &AdvertisedHost{"/" + strings.Trim(prefix, "/"), host, port})
+}
+
+// configureAdvertise handles an `advertise prefix host[:port]' line
+func (s *Server) configureAdvertise(args []string) os.Error {
+	if len(args) != 2 {
+		return os.NewError("expected a selector prefix and a host[:port]")
+	}
+	host, port := args[1], 0
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		var err os.Error
+		if port, err = strconv.Atoi(host[i+1:]); err != nil {
+			return os.NewError("bad port in " + args[1])
+		}
+		host = host[:i]
+	}
+	s.AddAdvertisedHost(args[0], host, port)
+	return nil
+}
+
+// advertise returns the host and port menus give for a local selector. A
+// port left out of an override is the one advertised for the server.
+func (s *Server) advertise(selector string) (string, int) {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	host, port := s.Hostname, s.Port
+	if s.AdvertiseHost != "" {
+		host = s.AdvertiseHost
+	}
+	if s.AdvertisePort != 0 {
+		port = s.AdvertisePort
+	}
+	for _, a := range s.advertised {
+		if a.Prefix == "/" || selector == a.Prefix || strings.HasPrefix(selector, a.Prefix+"/") {
+			if a.Port == 0 {
+				return a.Host, port
+			}
+			return a.Host, a.Port
+		}
+	}
+	return host, port
+}
+
+// advertisedLocal reports whether a menu entry the server generated points
+// back at it
+func (s *Server) advertisedLocal(entry *MenuEntry) bool {
+	host, port := s.advertise(entry.Selector)
+	return entry.Host == host && entry.Port == port
+}
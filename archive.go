@@ -0,0 +1,136 @@
+package gopher
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// With Archives set, a directory can be fetched whole by adding
+// ;archive=tar (or ;archive=tgz for a gzipped one) to its selector, as in
+//    /software/;archive=tgz
+// The archive is generated as it is sent, holding the files a listing
+// would show and the client may fetch, leaving out protected areas other
+// than the one the request is authenticated for, the admin menu and
+// unpublished files, and is served as a binary item. archive/zip cannot
+// write archives yet, so zip is not offered.
+
+// ArchiveMarker starts the last element of an archive selector
+const ArchiveMarker = ";archive="
+
+// archiveSelector splits an archive selector into its directory and format
+func archiveSelector(selector string) (dir string, format string, ok bool) {
+	i := strings.LastIndex(selector, "/"+ArchiveMarker)
+	if i == -1 {
+		return "", "", false
+	}
+	dir, format = selector[:i], selector[i+1+len(ArchiveMarker):]
+	if dir == "" {
+		dir = "/"
+	}
+	return dir, format, format == "tar" || format == "tgz"
+}
+
+// archiveWriter adds the files below a directory to a tar archive
+type archiveWriter struct {
+	s   *Server
+	ctx *Context
+	dir string // Selector of the directory being archived
+	tw  *tar.Writer
+	err os.Error // First write error; nothing more is written after one
+}
+
+// addDir adds the entries of the directory at selector that a client may
+// fetch in bulk, and those of its subdirectories
+func (a *archiveWriter) addDir(selector string) {
+	fs, name := a.s.fileSystem(a.ctx.Host.Root + selector)
+	infos, err := fs.ReadDir(name)
+	if err != nil {
+		return
+	}
+	for i := range infos {
+		f := &infos[i]
+		sel := strings.TrimRight(selector, "/") + "/" + f.Name
+		if a.err != nil {
+			return
+		}
+		if !a.s.public(a.ctx, sel, f.Name) {
+			continue
+		}
+		switch {
+		case f.IsDirectory():
+			a.err = a.tw.WriteHeader(&tar.Header{
+				Name:     a.member(sel) + "/",
+				Mode:     int64(f.Permission()),
+				Mtime:    f.Mtime_ns / 1e9,
+				Typeflag: tar.TypeDir,
+			})
+			a.addDir(sel)
+		case f.IsRegular():
+			a.addFile(sel, f)
+		}
+	}
+}
+
+// addFile adds the file at selector
+func (a *archiveWriter) addFile(selector string, f *os.FileInfo) {
+	file, err := a.s.openFile(a.ctx.Host.Root + selector)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	a.err = a.tw.WriteHeader(&tar.Header{
+		Name:     a.member(selector),
+		Mode:     int64(f.Permission()),
+		Size:     f.Size,
+		Mtime:    f.Mtime_ns / 1e9,
+		Typeflag: tar.TypeReg,
+	})
+	if a.err == nil {
+		_, a.err = io.Copyn(a.tw, file, f.Size)
+	}
+}
+
+// member returns the name in the archive of the item at selector
+func (a *archiveWriter) member(selector string) string {
+	return strings.TrimLeft(selector[len(strings.TrimRight(a.dir, "/")):], "/")
+}
+
+// serveArchive sends the directory dir as an archive in format
+func (s *Server) serveArchive(ctx *Context, dir string, format string) (Status, os.Error) {
+	root := path.Clean(ctx.Host.Root + dir)
+	if !strings.HasPrefix(root, ctx.Host.Root) {
+		return StatusNotFound, os.NewError("not in document root")
+	}
+	if info, err := s.statFile(root); err != nil || !info.IsDirectory() {
+		return StatusNotFound, err
+	}
+	ctx.itemType = '9'
+	var out io.Writer = ctx.conn
+	var gz io.WriteCloser
+	if format == "tgz" {
+		var err os.Error
+		if gz, err = gzip.NewWriter(ctx.conn); err != nil {
+			return StatusError, err
+		}
+		out = gz
+	}
+	a := &archiveWriter{s: s, ctx: ctx, dir: root[len(ctx.Host.Root):], tw: tar.NewWriter(out)}
+	a.addDir(a.dir)
+	if a.err != nil {
+		return StatusError, a.err
+	}
+	if err := a.tw.Close(); err != nil {
+		return StatusError, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return StatusError, err
+		}
+	}
+	ctx.Logf("Served `%s' as a %s archive\n", dir, format)
+	return StatusOK, nil
+}
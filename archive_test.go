@@ -0,0 +1,60 @@
+package gopher_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// archiveMembers requests selector and returns the names in the tar
+// archive sent back
+func archiveMembers(t *testing.T, selector string, files map[string]string, config ...string) []string {
+	ts := newSite(t, files, config...)
+	defer ts.Close()
+	rec := get(t, ts, selector)
+	r := tar.NewReader(bytes.NewBuffer(rec.Body.Bytes()))
+	var names []string
+	for {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %s", selector, err)
+		}
+		if hdr == nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	sort.SortStrings(names)
+	return names
+}
+
+func TestArchiveFiltering(t *testing.T) {
+	tokens := writeTestFile(t, "archive.tokens", "alice:tok123\n")
+	files := map[string]string{
+		"/pub/a.txt":                 "alpha",
+		"/pub/sub/b.txt":             "beta",
+		"/pub/.hidden":               "dot file",
+		"/pub/.header":               "sidecar",
+		"/pub/a.txt.license":         "License: CC0",
+		"/pub/later.txt":             "not yet",
+		"/pub/later.txt.schedule":    "Publish: 2999-01-01",
+		"/pub/denied/c.txt":          "denied",
+		"/pub/secret/d.txt":          "secret",
+		"/pub/secret/e.txt.schedule": "Expire: 2000-01-01",
+		"/pub/secret/e.txt":          "expired",
+	}
+	config := []string{"archives on", "deny all /pub/denied", "protect /pub/secret " + tokens}
+
+	got := strings.Join(archiveMembers(t, "/pub/;archive=tar", files, config...), " ")
+	if want := "a.txt sub/ sub/b.txt"; got != want {
+		t.Errorf("archive of /pub holds %q, want %q", got, want)
+	}
+	// The area the request is authenticated for is archived like any
+	// other directory
+	got = strings.Join(archiveMembers(t, "/pub/secret/tok123/;archive=tar", files, config...), " ")
+	if want := "d.txt"; got != want {
+		t.Errorf("archive of /pub/secret holds %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package gopher
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// A document root, of the server or of a virtual host, may name a zip or
+// tar archive instead of a directory:
+//    root /srv/gopher/hole.tar.gz
+// The archive is served as it is, without being unpacked. Its directory
+// is read when the configuration is loaded and kept until it is reloaded,
+// so looking selectors up needs no I/O. Zip and plain tar archives are
+// read file by file as they are served; gzipped tar archives are read into
+// memory whole, and refused if they hold more than MaxGzipTarSize bytes.
+
+// archiveRoot reports whether a document root names an archive
+func archiveRoot(root string) bool {
+	for _, suffix := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(root, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// openArchive opens the archive at name as a FileSystem
+func openArchive(name string) (FileSystem, io.Closer, os.Error) {
+	if strings.HasSuffix(name, ".zip") {
+		z, err := OpenZipFS(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return z, z, nil
+	}
+	t, err := OpenTarFS(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, t, nil
+}
+
+// mountArchives mounts the archives named as document roots
+func (s *Server) mountArchives() os.Error {
+	roots := []string{s.Cwd}
+	if s.StagedRoot != "" {
+		roots = append(roots, s.StagedRoot)
+	}
+	for _, vh := range s.vhosts {
+		roots = append(roots, vh.Root)
+	}
+	for _, root := range roots {
+		if !archiveRoot(root) || s.mounted(root) {
+			continue
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsRegular() {
+			// A directory named like an archive is served as usual
+			continue
+		}
+		fs, closer, err := openArchive(root)
+		if err != nil {
+			return err
+		}
+		s.reloadMu.Lock()
+		s.mounts = append(s.mounts, &mount{root, fs, closer, true})
+		s.reloadMu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package gopher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Gopher+ ASK forms. A Gopher+ client asks for the attributes of a form
+// item, finds its +ASK block and prompts the user. It then sends the
+// selector followed by a tab, "+", a tab and "1", and a data block holding
+// one answer per question. Multi-line answers start with their line count.
+
+// Kinds of ASK form fields
+const (
+	Ask    = "Ask"    // One line of text, with an optional default
+	AskP   = "AskP"   // A password
+	AskL   = "AskL"   // Several lines of text
+	Choose = "Choose" // One of Values
+	Select = "Select" // A yes or no checkbox, Values holds the default 0 or 1
+	Note   = "Note"   // Text shown to the user, needing no answer
+)
+
+// MaxFormSize is the largest data block accepted with a form, in bytes
+var MaxFormSize int64 = 64 << 10
+
+// MaxFormLines is the most lines an AskL answer may have
+var MaxFormLines = 1000
+
+// MaxFormLineLength is the longest line of an answer, in bytes
+var MaxFormLineLength = 4096
+
+// FormTimeout is how long a client may take to send a form's answers, in
+// nanoseconds
+var FormTimeout int64 = 60e9
+
+// AskField is one question of a form
+type AskField struct {
+	Kind   string
+	Prompt string
+	Values []string // Default answer, or the choices of a Choose field
+}
+
+// String renders the field as a line of an +ASK block
+func (f *AskField) String() string {
+	if f.Kind == Select && len(f.Values) > 0 {
+		return fmt.Sprintf("%s: %s:%s", f.Kind, f.Prompt, f.Values[0])
+	}
+	return strings.Join(append([]string{f.Kind + ": " + f.Prompt}, f.Values...), "\t")
+}
+
+// Form is a Gopher+ ASK form
+type Form struct {
+	Title  string
+	Fields []*AskField
+}
+
+// Add appends a field to the form and returns the form
+func (form *Form) Add(kind string, prompt string, values ...string) *Form {
+	form.Fields = append(form.Fields, &AskField{kind, prompt, values})
+	return form
+}
+
+// FormFunc receives the answers to a form, one per field that is not a
+// Note, in field order
+type FormFunc func(ctx *Context, answers []string) Status
+
+type formHandler struct {
+	form   *Form
+	answer FormFunc
+}
+
+// HandleForm registers a form at the selectors matching pattern. Clients
+// without Gopher+ support are told they need it.
+func (s *Server) HandleForm(pattern string, form *Form, answer FormFunc) os.Error {
+	return s.Handle(pattern, &formHandler{form, answer})
+}
+
+func (h *formHandler) ServeGopher(ctx *Context) Status {
+	s := ctx.Server
+	switch {
+	case strings.HasPrefix(ctx.extra, "!"):
+		host, port := s.advertise(ctx.Request)
+		info := &MenuEntry{Type: '0', Display: h.form.Title, Selector: ctx.Request, Host: host, Port: port}
+		ctx.Write("+-1")
+		ctx.Write("+INFO: " + info.String() + "\t?")
+		ctx.Write("+ASK:")
+		for _, field := range h.form.Fields {
+			ctx.Write(" " + field.String())
+		}
+		ctx.Write(".")
+	case strings.HasPrefix(ctx.extra, "+"):
+		ctx.conn.SetReadTimeout(FormTimeout)
+		answers, err := h.readAnswers(ctx.reader)
+		ctx.conn.SetReadTimeout(0)
+		if err != nil {
+			ctx.Error("Could not read the form's answers")
+			ctx.Logf("ERROR: Bad form data for `%s': %s\n", ctx.Request, err)
+			return StatusError
+		}
+		return h.answer(ctx, answers)
+	default:
+		menu := NewMenu(ctx)
+		menu.Info(h.form.Title)
+		menu.Info("")
+		for _, field := range h.form.Fields {
+			if field.Kind == Note {
+				menu.Info(field.Prompt)
+			}
+		}
+		menu.Info("This form needs a Gopher+ client.")
+		menu.WriteTo(ctx)
+	}
+	return StatusOK
+}
+
+// readAnswers reads the data block posted with a form. Blocks are ended
+// by a period line (+-1), by the client closing its end (+-2) or after
+// their length; none may exceed MaxFormSize.
+func (h *formHandler) readAnswers(r io.Reader) (answers []string, err os.Error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(header, "+") {
+		return nil, os.NewError(fmt.Sprintf("bad data block header %q", header))
+	}
+	limit := MaxFormSize
+	switch length, aerr := strconv.Atoi64(header[1:]); {
+	case aerr != nil || length < -2:
+		return nil, os.NewError(fmt.Sprintf("bad data block header %q", header))
+	case length > MaxFormSize:
+		return nil, os.NewError(fmt.Sprintf("data block of %d bytes is too large", length))
+	case length >= 0:
+		limit = length
+	}
+	lines := bufio.NewReader(io.LimitReader(reader, limit))
+	next := func() (string, os.Error) {
+		line, err := lines.ReadString('\n')
+		if err == os.EOF && line != "" {
+			err = nil
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) > MaxFormLineLength {
+			return "", os.NewError("answer line too long")
+		}
+		if line == "." && header == "+-1" {
+			return "", os.EOF
+		}
+		return line, err
+	}
+	for _, field := range h.form.Fields {
+		if field.Kind == Note {
+			continue
+		}
+		answer, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if field.Kind == AskL {
+			count, err := strconv.Atoi(answer)
+			if err != nil || count < 0 || count > MaxFormLines {
+				return nil, os.NewError(fmt.Sprintf("bad line count %q", answer))
+			}
+			text := make([]string, count)
+			for i := range text {
+				if text[i], err = next(); err != nil {
+					return nil, err
+				}
+			}
+			answer = strings.Join(text, "\n")
+		}
+		answers = append(answers, answer)
+	}
+	return
+}
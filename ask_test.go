@@ -0,0 +1,72 @@
+package gopher
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testForm asks for a name, a message and a colour, with a note between
+var testForm = new(Form).
+	Add(Ask, "Name", "anonymous").
+	Add(Note, "Be nice.").
+	Add(AskL, "Message").
+	Add(Choose, "Colour", "red", "blue")
+
+func TestReadAnswers(t *testing.T) {
+	h := &formHandler{form: testForm}
+	want := "alice|first line\nsecond line|blue"
+	blocks := []string{
+		// Terminated by a period
+		"+-1\r\nalice\r\n2\r\nfirst line\r\nsecond line\r\nblue\r\n.\r\n",
+		// Of a given length, with the last line left unterminated
+		"+39\r\nalice\r\n2\r\nfirst line\r\nsecond line\r\nblue",
+		// Ended by closing the connection
+		"+-2\r\nalice\n2\nfirst line\nsecond line\nblue\n",
+	}
+	for _, block := range blocks {
+		answers, err := h.readAnswers(strings.NewReader(block))
+		if got := strings.Join(answers, "|"); err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", block, got, err, want)
+		}
+	}
+	bad := []string{
+		"",
+		"alice\r\n",
+		"+x\r\nalice\r\n",
+		"+-1\r\nalice\r\nmany\r\n",
+		// The block ends before every field is answered
+		"+-1\r\nalice\r\n2\r\nonly line\r\n.\r\n",
+		"+8\r\nalice\r\n2\r\nfirst line\r\nsecond line\r\nblue",
+	}
+	for _, block := range bad {
+		if answers, err := h.readAnswers(strings.NewReader(block)); err == nil {
+			t.Errorf("%q: got %q, want an error", block, answers)
+		}
+	}
+}
+
+func TestReadAnswersLimits(t *testing.T) {
+	h := &formHandler{form: testForm}
+	long := strings.Repeat("x", MaxFormLineLength+1)
+	many := strings.Repeat(strings.Repeat("y", 99)+"\n", MaxFormLines)
+	bad := []string{
+		"+-3\r\nalice\r\n1\r\nline\r\nblue\r\n",
+		fmt.Sprintf("+%d\r\nalice\r\n1\r\nline\r\nblue\r\n", MaxFormSize+1),
+		"+-2\r\nalice\r\n-1\r\nblue\r\n",
+		fmt.Sprintf("+-2\r\nalice\r\n%d\r\n", MaxFormLines+1),
+		"+-2\r\n" + long + "\r\n1\r\nline\r\nblue\r\n",
+		// A block ended by closing the connection is cut off at MaxFormSize
+		fmt.Sprintf("+-2\r\nalice\r\n%d\r\n%sblue\r\n", MaxFormLines, many),
+	}
+	for _, block := range bad {
+		if answers, err := h.readAnswers(strings.NewReader(block)); err == nil {
+			t.Errorf("%q...: got %d answers, want an error", block[:20], len(answers))
+		}
+	}
+	// Half as many lines fit
+	block := fmt.Sprintf("+-2\r\nalice\r\n%d\r\n%sblue\r\n", MaxFormLines/2, many[:len(many)/2])
+	if answers, err := h.readAnswers(strings.NewReader(block)); err != nil || len(answers) != 3 {
+		t.Errorf("%d lines of 100 bytes: got %d answers, %v", MaxFormLines/2, len(answers), err)
+	}
+}
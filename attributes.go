@@ -0,0 +1,71 @@
+package gopher
+
+import (
+	"path"
+	"strings"
+)
+
+// An item's Gopher+ attributes can be extended by sidecar files: the lines
+// of file.txt.abstract become its +ABSTRACT block, and, as with UMN
+// gopherd, a .cap/file.txt file in the same directory holds lines like
+//    Name=A prettier title
+//    Abstract=What the file is about
+//    Admin=Who to ask about it
+// Name replaces the file name in listings and +INFO; Abstract and Admin
+// lines are added to the +ABSTRACT and +ADMIN blocks.
+
+// AbstractSuffix is added to a file's name to find its abstract
+const AbstractSuffix = ".abstract"
+
+// CapDir is the directory holding UMN-style .cap files
+const CapDir = ".cap"
+
+// ItemAttributes is what the sidecar files of an item say about it
+type ItemAttributes struct {
+	Name     string
+	Abstract []string
+	Admin    []string
+}
+
+// parseCap reads the Key=value lines of a .cap file
+func parseCap(data []byte, a *ItemAttributes) {
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		switch strings.ToLower(strings.TrimSpace(fields[0])) {
+		case "name":
+			a.Name = value
+		case "abstract":
+			a.Abstract = append(a.Abstract, value)
+		case "admin":
+			a.Admin = append(a.Admin, value)
+		}
+	}
+}
+
+// itemAttributes returns what the sidecar files of the file at absPath
+// say about it
+func (s *Server) itemAttributes(absPath string) *ItemAttributes {
+	a := new(ItemAttributes)
+	dir, name := path.Split(absPath)
+	if data, err := s.readSidecar(dir + CapDir + "/" + name); err == nil {
+		parseCap(data, a)
+	}
+	a.Abstract = append(a.Abstract, s.infoLines(absPath+AbstractSuffix)...)
+	return a
+}
+
+// listingName returns the name a file is shown under in listings, given
+// the titles of its directory's names file
+func (s *Server) listingName(absPath string, names map[string]string) string {
+	if a := s.itemAttributes(absPath); a.Name != "" {
+		return s.displayName(a.Name)
+	}
+	if title, ok := names[path.Base(absPath)]; ok {
+		return s.displayName(title)
+	}
+	return s.displayName(path.Base(absPath))
+}
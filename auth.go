@@ -0,0 +1,138 @@
+package gopher
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// A protected area is a subtree only reachable with a token in the
+// selector: with
+//    protect /secret /etc/gopher/secret.tokens
+// the file /secret/notes.txt is requested as /secret/<token>/notes.txt.
+// The credentials file holds one `user:token' pair per line. Menus served
+// inside the area carry the token along in their links.
+
+// ProtectedArea is a subtree of selectors guarded by tokens
+type ProtectedArea struct {
+	Prefix string
+	tokens map[string]string // User by token
+}
+
+// loadCredentials reads a file of user:token lines
+func loadCredentials(name string) (map[string]string, os.Error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n", -1) {
+		if j := strings.Index(line, "#"); j != -1 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ":", 2)
+		if len(fields) != 2 || fields[1] == "" || strings.Index(fields[1], "/") != -1 {
+			return nil, os.NewError(fmt.Sprintf("%s:%d: expected user:token", name, i+1))
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	return tokens, nil
+}
+
+// configureProtect handles a `protect prefix credentials-file' line
+func (s *Server) configureProtect(args []string) os.Error {
+	if len(args) != 2 {
+		return os.NewError("expected a selector prefix and a credentials file")
+	}
+	tokens, err := loadCredentials(args[1])
+	if err != nil {
+		return err
+	}
+	s.protected = append(s.protected, &ProtectedArea{"/" + strings.Trim(args[0], "/"), tokens})
+	return nil
+}
+
+// user returns the user a token belongs to, comparing in constant time
+func (a *ProtectedArea) user(token string) (user string, ok bool) {
+	for t, u := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			user, ok = u, true
+		}
+	}
+	return
+}
+
+// inArea reports whether selector lies in the area below prefix, ignoring
+// case on a case-insensitive virtual host
+func (ctx *Context) inArea(selector string, prefix string) bool {
+	if ctx.Host != nil && ctx.Host.CaseInsensitive {
+		selector, prefix = strings.ToLower(selector), strings.ToLower(prefix)
+	}
+	return underPrefix(selector, prefix)
+}
+
+// takeToken strips the token from a request sent into a protected area,
+// keeping it in ctx for authenticate. It runs before the selector is
+// rewritten and resolved, as the token is no part of any file name.
+func (s *Server) takeToken(ctx *Context) {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, a := range s.protected {
+		if !ctx.inArea(ctx.Request, a.Prefix) {
+			continue
+		}
+		rest := strings.TrimLeft(ctx.Request[len(a.Prefix):], "/")
+		token := rest
+		if i := strings.Index(rest, "/"); i != -1 {
+			token, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+		ctx.Request = a.Prefix + rest
+		ctx.token = token
+		return
+	}
+}
+
+// authenticate checks the token taken from a request against the protected
+// area its final selector lies in, once rewrites, case-insensitive names
+// and aliases have been applied, so none of them leads into an area
+// without a token. It reports false if the token is missing or wrong.
+func (s *Server) authenticate(ctx *Context) bool {
+	// Resolving reads mounts under reloadMu, so it is not held here
+	s.reloadMu.RLock()
+	protected := s.protected
+	s.reloadMu.RUnlock()
+	for _, a := range protected {
+		if !ctx.inArea(ctx.Request, a.Prefix) && !underPrefix(ctx.Request, s.resolve(ctx.Host, a.Prefix)) {
+			continue
+		}
+		user, ok := a.user(ctx.token)
+		if !ok {
+			return false
+		}
+		ctx.area = a
+		ctx.Logf("Authenticated %s for `%s'\n", user, a.Prefix)
+		return true
+	}
+	return true
+}
+
+// withToken puts the token of the request back into a local selector that
+// leads into its protected area
+func (ctx *Context) withToken(selector string) string {
+	if ctx.area == nil {
+		return selector
+	}
+	prefix := ctx.area.Prefix
+	if selector != prefix && !strings.HasPrefix(selector, prefix+"/") {
+		return selector
+	}
+	return prefix + "/" + ctx.token + selector[len(prefix):]
+}
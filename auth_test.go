@@ -0,0 +1,47 @@
+package gopher_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProtectedArea(t *testing.T) {
+	tokens := writeTestFile(t, "secret.tokens", "alice:tok123\n")
+	ts := newSite(t, map[string]string{"/secret/notes.txt": "the plans", "/pub.txt": "hello"},
+		"case-insensitive on",
+		"protect /secret "+tokens,
+		"rewrite ^/hidden(.*)$ /secret$1",
+		"alias /old /secret")
+	defer ts.Close()
+
+	for _, selector := range []string{"/secret/tok123/notes.txt", "/SeCrEt/tok123/NOTES.TXT"} {
+		if body := get(t, ts, selector).Body.String(); strings.Index(body, "the plans") == -1 {
+			t.Errorf("%s: got %q, want the protected file", selector, body)
+		}
+	}
+	denied := []string{
+		"/secret/notes.txt",
+		"/secret/wrong/notes.txt",
+		// Case-insensitive names lead into the area too
+		"/SECRET/notes.txt",
+		"/Secret/wrong/notes.txt",
+		// So do rewrites and aliases, which are applied after the token
+		// is taken from the selector
+		"/hidden/notes.txt",
+		"/hidden/tok123/notes.txt",
+		"/old/notes.txt",
+		"/OLD/notes.txt",
+	}
+	for _, selector := range denied {
+		rec := get(t, ts, selector)
+		if strings.Index(rec.Body.String(), "the plans") != -1 {
+			t.Errorf("%s: protected file served without a valid token", selector)
+		}
+		if rec.ErrorText() == "" {
+			t.Errorf("%s: got %q, want an error item", selector, rec.Body.String())
+		}
+	}
+	if body := get(t, ts, "/pub.txt").Body.String(); strings.Index(body, "hello") == -1 {
+		t.Errorf("/pub.txt: got %q, want the file outside the area", body)
+	}
+}
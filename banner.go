@@ -0,0 +1,89 @@
+package gopher
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"template"
+	"time"
+)
+
+// A banner of info lines can be put above every menu the server sends,
+// given in the configuration file line by line or read from a file:
+//    banner Welcome to the example gopherhole
+//    banner-file /etc/gopher/banner
+//    motd /etc/gopher/motd
+// Lines from the banner file follow those given with banner, and a blank
+// line separates the banner from the menu. A banner file whose first line
+// is TemplateMagic is run through the template package with a
+// *BannerData, so it can say
+//    #!template
+//    {Hostname} at {Time} on {Date}
+//    {Motd}
+// Menus relayed from a proxied server go without the banner.
+
+// BannerData is what a banner template is executed with
+type BannerData struct {
+	Selector string
+	ClientIP string
+	Date     string // 2006-01-02, local time
+	Time     string // 15:04:05, local time
+	Hostname string
+	Port     int
+	Motd     string // Contents of the message of the day file, if any
+}
+
+// bannerLines returns the info lines of the banner for a request
+func (s *Server) bannerLines(ctx *Context) []string {
+	lines := make([]string, 0, len(s.Banner))
+	for _, line := range s.Banner {
+		lines = append(lines, s.displayName(line))
+	}
+	if s.BannerFile == "" {
+		return lines
+	}
+	src, err := s.readSidecar(s.BannerFile)
+	if err != nil {
+		ctx.Logf("ERROR: Could not read banner: %s\n", err)
+		return lines
+	}
+	if bytes.HasPrefix(src, []byte(TemplateMagic)) {
+		if src, err = s.expandBanner(ctx, src); err != nil {
+			ctx.Logf("ERROR: Bad banner template: %s\n", err)
+			return lines
+		}
+	}
+	return append(lines, s.textLines(src)...)
+}
+
+// expandBanner runs a banner file through the template package
+func (s *Server) expandBanner(ctx *Context, src []byte) ([]byte, os.Error) {
+	if i := bytes.IndexByte(src, '\n'); i != -1 {
+		src = src[i+1:]
+	} else {
+		src = nil
+	}
+	t, err := template.Parse(string(src), nil)
+	if err != nil {
+		return nil, err
+	}
+	now := time.LocalTime()
+	data := &BannerData{
+		Selector: ctx.Request,
+		ClientIP: ctx.ClientIP(),
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("15:04:05"),
+		Hostname: s.Hostname,
+		Port:     s.Port,
+	}
+	if s.MotdFile != "" {
+		if motd, err := s.readSidecar(s.MotdFile); err == nil {
+			data.Motd = strings.TrimRight(string(motd), "\r\n")
+		}
+	}
+	var out bytes.Buffer
+	if err = t.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
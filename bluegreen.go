@@ -0,0 +1,84 @@
+package gopher
+
+import (
+	"os"
+	"path"
+)
+
+// A site can be deployed to a second document root while the first is
+// served, and the two swapped in one step once the copy is complete:
+//    root /srv/gopher/blue
+//    staged-root /srv/gopher/green
+// SwitchRoots, the `switch-roots' control command or the roots page of
+// the admin menu make the staged root live and the live one staged, ready
+// for the next deploy. Requests already being answered finish from the
+// root they started on, and every later one sees the other root whole, so
+// no client ever sees a tree half copied. Virtual hosts with roots of their
+// own are not switched. The switch survives reloads as long as the two
+// roots stay configured, but not restarts: change the configuration to
+// keep it.
+
+// SwitchRoots makes StagedRoot the document root and the document root
+// StagedRoot
+func (s *Server) SwitchRoots() os.Error {
+	if s.Chroot {
+		return os.NewError("cannot switch roots from inside a chroot")
+	}
+	s.reloadMu.RLock()
+	staged := s.StagedRoot
+	s.reloadMu.RUnlock()
+	if staged == "" {
+		return os.NewError("no staged root configured")
+	}
+	if info, err := s.statFile(staged); err != nil {
+		return err
+	} else if !info.IsDirectory() {
+		return os.NewError(staged + " is not a directory")
+	}
+	s.reloadMu.Lock()
+	s.switchRoots()
+	live := s.Cwd
+	s.reloadMu.Unlock()
+	s.missing.clear()
+	s.Logger.Printf("Switched document root to %s\n", live)
+	return nil
+}
+
+// switchRoots swaps the live and staged roots. The server must be locked
+// for reloading unless it is not serving yet.
+func (s *Server) switchRoots() {
+	old := s.Cwd
+	s.Cwd, s.StagedRoot = s.StagedRoot, old
+	// Requests hold on to their virtual host, so the hosts are replaced
+	// rather than changed
+	s.defaultHost = s.switchedHost(s.defaultHost, old)
+	vhosts := make([]*VirtualHost, len(s.vhosts))
+	for i, vh := range s.vhosts {
+		vhosts[i] = s.switchedHost(vh, old)
+	}
+	s.vhosts = vhosts
+}
+
+// switchedHost returns vh serving the document root in place of old, or vh
+// itself if it serves a root of its own
+func (s *Server) switchedHost(vh *VirtualHost, old string) *VirtualHost {
+	if vh == nil || vh.Root != old {
+		return vh
+	}
+	return &VirtualHost{
+		Name:            vh.Name,
+		Root:            s.Cwd,
+		CaseInsensitive: vh.CaseInsensitive,
+		LogLevel:        vh.LogLevel,
+		logger:          vh.logger,
+		acl:             vh.acl,
+	}
+}
+
+// configureStagedRoot handles a `staged-root directory' line
+func (s *Server) configureStagedRoot(args []string) (err os.Error) {
+	if s.StagedRoot, err = configString(args); err == nil {
+		s.StagedRoot = path.Clean(s.StagedRoot)
+	}
+	return
+}
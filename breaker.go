@@ -0,0 +1,98 @@
+package gopher
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// A handler or gophermap command that fails BreakerThreshold times in a
+// row is taken out of service for BreakerCooldown: its requests get an
+// error item straight away instead of tying up goroutines on something
+// known to be broken. After the cooldown one request is let through; if it
+// succeeds the handler is back, and if not it stays out for another
+// cooldown.
+
+// BreakerThreshold is how many failures in a row take a handler out of
+// service
+var BreakerThreshold = 5
+
+// BreakerCooldown is how long a failing handler stays out of service, in
+// nanoseconds
+var BreakerCooldown int64 = 30e9
+
+// errBroken is the error of a request refused because its handler is out
+// of service
+var errBroken = os.NewError("out of service after repeated failures")
+
+// circuit is the failure record of one handler
+type circuit struct {
+	failures  int   // Failures in a row
+	openUntil int64 // When the handler is back in service, if it is out
+}
+
+// breaker tracks the failures of every handler by name
+type breaker struct {
+	sync.Mutex
+	circuits map[string]*circuit
+}
+
+// allow reports whether the named handler may be run now
+func (b *breaker) allow(name string) bool {
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[name]
+	if !ok || c.openUntil == 0 {
+		return true
+	}
+	now := time.Nanoseconds()
+	if now < c.openUntil {
+		return false
+	}
+	// Let one request through to see whether the handler has recovered
+	c.openUntil = now + BreakerCooldown
+	return true
+}
+
+// record notes the outcome of running the named handler and returns
+// whether that took it out of service
+func (b *breaker) record(name string, failed bool) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[name]
+	if !ok {
+		if !failed {
+			return false
+		}
+		c = &circuit{}
+		b.circuits[name] = c
+	}
+	if !failed {
+		c.failures, c.openUntil = 0, 0
+		return false
+	}
+	c.failures++
+	if c.failures < BreakerThreshold || c.openUntil != 0 {
+		return false
+	}
+	c.openUntil = time.Nanoseconds() + BreakerCooldown
+	return true
+}
+
+// guard runs a handler unless it is out of service, recording whether
+// it failed
+func (s *Server) guard(ctx *Context, name string, run func() Status) Status {
+	if !s.breaker.allow(name) {
+		ctx.Error("This item is temporarily unavailable, please try again later")
+		ctx.Logf("ERROR: `%s' refused: handler %s is %s\n", ctx.Request, name, errBroken)
+		return StatusError
+	}
+	status := run()
+	if s.breaker.record(name, status == StatusError) {
+		s.Logger.Printf("ERROR: Handler %s failed %d times in a row; out of service for %ds\n", name, BreakerThreshold, BreakerCooldown/1e9)
+	}
+	return status
+}
@@ -0,0 +1,190 @@
+package gopher
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+// Type 2 items point clients at a CCSO (ph/qi) phone book server, which
+// few clients still speak to. A CCSO gateway instead offers the phone book
+// as a search: the search string is sent to the CCSO server at Addr as a
+// query, or looked up in File, and the matching entries come back as a
+// menu. A phone book file holds entries separated by blank lines, each
+// line a field:
+//    name: Smith, John
+//    email: jsmith@example.org
+// A search matches entries holding all its words; a word of the form
+// field=value only matches that field.
+//
+//    ccso /phonebook ns.example.edu:105
+//    phonebook /staff /srv/gopher/staff.ph
+
+// CCSOTimeout is how long a CCSO server has to answer, in nanoseconds
+var CCSOTimeout int64 = 10e9
+
+// CCSOField is one field of a phone book entry
+type CCSOField struct {
+	Name  string
+	Value string
+}
+
+// CCSOGateway answers searches from a CCSO server or a phone book file
+type CCSOGateway struct {
+	Selector string
+	Addr     string // host:port of a CCSO server; empty to use File
+	File     string
+}
+
+// AddCCSOGateway offers the phone book at addr, a CCSO server's host:port,
+// or in file as a search at selector
+func (s *Server) AddCCSOGateway(selector string, addr string, file string) {
+	s.ccso = append(s.ccso, &CCSOGateway{"/" + strings.Trim(selector, "/"), addr, file})
+}
+
+// ccsoFor returns the gateway at selector, if any
+func (s *Server) ccsoFor(selector string) *CCSOGateway {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, g := range s.ccso {
+		if selector == g.Selector {
+			return g
+		}
+	}
+	return nil
+}
+
+// queryServer runs a query on the CCSO server
+func (g *CCSOGateway) queryServer(query string) ([][]CCSOField, os.Error) {
+	conn, err := net.Dial("tcp", "", g.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(CCSOTimeout)
+	if _, err = fmt.Fprintf(conn, "query %s return all\r\nquit\r\n", query); err != nil {
+		return nil, err
+	}
+	var entries [][]CCSOField
+	index := ""
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == os.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		// Entry lines look like -200:1:  name: Smith, John
+		parts := strings.Split(strings.TrimRight(line, "\r\n"), ":", 4)
+		if len(parts) < 2 {
+			continue
+		}
+		code := strings.TrimLeft(parts[0], "-")
+		switch {
+		case code == "200" && !strings.HasPrefix(parts[0], "-"):
+			return entries, nil
+		case code == "501" || code == "502":
+			// No matches, or too many
+			return entries, nil
+		case code >= "500":
+			return entries, os.NewError("CCSO server: " + strings.Join(parts[1:], ":"))
+		case code != "200" || len(parts) != 4:
+			continue
+		}
+		if parts[1] != index || len(entries) == 0 {
+			entries = append(entries, nil)
+			index = parts[1]
+		}
+		last := len(entries) - 1
+		entries[last] = append(entries[last], CCSOField{strings.TrimSpace(parts[2]), strings.TrimSpace(parts[3])})
+	}
+	return entries, nil
+}
+
+// queryFile looks a query up in the phone book file
+func (g *CCSOGateway) queryFile(query string) ([][]CCSOField, os.Error) {
+	data, err := ioutil.ReadFile(g.File)
+	if err != nil {
+		return nil, err
+	}
+	var entries [][]CCSOField
+	var entry []CCSOField
+	for _, line := range strings.Split(string(data)+"\n\n", "\n", -1) {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			if entry != nil && ccsoMatches(entry, query) {
+				entries = append(entries, entry)
+			}
+			entry = nil
+			continue
+		}
+		if fields := strings.Split(line, ":", 2); len(fields) == 2 {
+			entry = append(entry, CCSOField{strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])})
+		}
+	}
+	return entries, nil
+}
+
+// ccsoMatches reports whether an entry holds every word of the query
+func ccsoMatches(entry []CCSOField, query string) bool {
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		field := ""
+		if i := strings.Index(word, "="); i != -1 {
+			field, word = word[:i], word[i+1:]
+		}
+		found := false
+		for _, f := range entry {
+			if (field == "" || strings.ToLower(f.Name) == field) && strings.Index(strings.ToLower(f.Value), word) != -1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeGopher offers a search of the phone book, or answers one
+func (g *CCSOGateway) ServeGopher(ctx *Context) Status {
+	w := NewEntryWriter(ctx)
+	query := strings.TrimSpace(ctx.Query)
+	if query == "" {
+		w.Item('7', "Search the phone book", ctx.Request)
+		w.Close()
+		return StatusOK
+	}
+	var entries [][]CCSOField
+	var err os.Error
+	if g.Addr != "" {
+		entries, err = g.queryServer(query)
+	} else {
+		entries, err = g.queryFile(query)
+	}
+	if err != nil {
+		ctx.Logf("ERROR: Phone book search at `%s' failed: %s\n", g.Selector, err)
+		w.Info("The phone book is not available right now.")
+		w.Close()
+		return StatusError
+	}
+	if len(entries) == 0 {
+		w.Info("No entries match " + ctx.Server.displayName(query) + ".")
+	}
+	for _, entry := range entries {
+		for _, f := range entry {
+			w.Info(ctx.Server.displayName(fmt.Sprintf("%12s: %s", f.Name, f.Value)))
+		}
+		w.Info("")
+	}
+	ctx.Logf("Searched phone book `%s'\n", g.Selector)
+	if err = w.Close(); err != nil {
+		return StatusError
+	}
+	return StatusOK
+}
@@ -0,0 +1,142 @@
+package gopher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"utf8"
+)
+
+// Character sets text can be served in
+const (
+	CharsetUTF8   = "UTF-8"
+	CharsetLatin1 = "ISO-8859-1"
+	CharsetASCII  = "US-ASCII"
+)
+
+// ParseCharset returns the canonical name of a supported character set
+func ParseCharset(name string) (string, os.Error) {
+	switch strings.ToUpper(strings.Replace(name, "_", "-", -1)) {
+	case "UTF-8", "UTF8":
+		return CharsetUTF8, nil
+	case "ISO-8859-1", "LATIN1", "LATIN-1":
+		return CharsetLatin1, nil
+	case "US-ASCII", "ASCII":
+		return CharsetASCII, nil
+	}
+	return "", os.NewError(fmt.Sprintf("unsupported charset `%s'", name))
+}
+
+// charset returns the character set text is served in
+func (s *Server) charset() string {
+	if s.Charset == "" {
+		return CharsetUTF8
+	}
+	return s.Charset
+}
+
+// sniffCharset guesses the encoding of a file from its first block: binary
+// data yields "", text that is not valid UTF-8 is taken to be Latin-1
+func sniffCharset(block []byte) string {
+	for _, c := range block {
+		if c == 0 {
+			return ""
+		}
+	}
+	// Ignore a multibyte sequence cut off by the end of the block
+	for i := len(block) - 1; i >= 0 && i >= len(block)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(block[i]) {
+			if !utf8.FullRune(block[i:]) {
+				block = block[:i]
+			}
+			break
+		}
+	}
+	if utf8.Valid(block) {
+		return CharsetUTF8
+	}
+	return CharsetLatin1
+}
+
+// charsetWriter transcodes text between character sets as it is written
+type charsetWriter struct {
+	w     io.Writer
+	from  string
+	to    string
+	carry []byte // Incomplete UTF-8 sequence from the previous write
+}
+
+// newCharsetWriter returns a writer transcoding from one charset to
+// another, or w itself if no transcoding is needed
+func newCharsetWriter(w io.Writer, from string, to string) io.Writer {
+	if from == to || (from == CharsetASCII && to != CharsetASCII) {
+		return w
+	}
+	return &charsetWriter{w: w, from: from, to: to}
+}
+
+func (cw *charsetWriter) Write(p []byte) (n int, err os.Error) {
+	out := make([]byte, 0, len(p)+len(p)/2)
+	if cw.from != CharsetUTF8 {
+		for _, c := range p {
+			switch {
+			case c < 0x80 || cw.to == CharsetLatin1:
+				out = append(out, c)
+			case cw.to == CharsetUTF8:
+				out = append(out, []byte(string(int(c)))...)
+			default:
+				out = append(out, '?')
+			}
+		}
+	} else {
+		data := append(cw.carry, p...)
+		cw.carry = nil
+		for i := 0; i < len(data); {
+			if !utf8.FullRune(data[i:]) {
+				cw.carry = append([]byte(nil), data[i:]...)
+				break
+			}
+			rune, size := utf8.DecodeRune(data[i:])
+			switch {
+			case rune < 0x80:
+				out = append(out, byte(rune))
+			case rune < 0x100 && cw.to == CharsetLatin1 && size > 1:
+				out = append(out, byte(rune))
+			default:
+				out = append(out, '?')
+			}
+			i += size
+		}
+	}
+	if _, err = cw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// displayName makes a file name safe to show in a menu: tabs and control
+// characters would break the menu line, and names that are not valid UTF-8
+// are taken to be Latin-1 and transcoded to the served charset
+func (s *Server) displayName(name string) string {
+	from := CharsetUTF8
+	if !utf8.ValidString(name) {
+		from = CharsetLatin1
+	}
+	var b bytes.Buffer
+	w := newCharsetWriter(&b, from, s.charset())
+	w.Write([]byte(name))
+	clean := b.Bytes()
+	for i, c := range clean {
+		if c < 0x20 || c == 0x7f {
+			clean[i] = '?'
+		}
+	}
+	return string(clean)
+}
+
+// validSelector reports whether a selector can be put in a menu line
+func validSelector(selector string) bool {
+	return strings.IndexAny(selector, "\t\r\n") == -1
+}
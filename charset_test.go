@@ -0,0 +1,99 @@
+package gopher
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseCharset(t *testing.T) {
+	names := map[string]string{
+		"utf-8":      CharsetUTF8,
+		"UTF8":       CharsetUTF8,
+		"iso_8859-1": CharsetLatin1,
+		"latin1":     CharsetLatin1,
+		"ascii":      CharsetASCII,
+		"US-ASCII":   CharsetASCII,
+	}
+	for name, want := range names {
+		if got, err := ParseCharset(name); err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", "utf-16", "koi8-r"} {
+		if _, err := ParseCharset(name); err == nil {
+			t.Errorf("%q: want an error", name)
+		}
+	}
+}
+
+func TestSniffCharset(t *testing.T) {
+	tests := []struct {
+		block string
+		want  string
+	}{
+		{"plain text\n", CharsetUTF8},
+		{"caf\xc3\xa9\n", CharsetUTF8},
+		{"caf\xe9\n", CharsetLatin1},
+		// A sequence cut off by the end of the block is still UTF-8
+		{"na\xc3\xafve \xe2\x82", CharsetUTF8},
+		{"GIF89a\x00\x01", ""},
+	}
+	for _, test := range tests {
+		if got := sniffCharset([]byte(test.block)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.block, got, test.want)
+		}
+	}
+}
+
+func TestCharsetWriter(t *testing.T) {
+	tests := []struct {
+		from, to string
+		writes   []string
+		want     string
+	}{
+		{CharsetLatin1, CharsetUTF8, []string{"caf\xe9"}, "caf\xc3\xa9"},
+		{CharsetLatin1, CharsetASCII, []string{"caf\xe9"}, "caf?"},
+		{CharsetUTF8, CharsetLatin1, []string{"caf\xc3\xa9"}, "caf\xe9"},
+		{CharsetUTF8, CharsetLatin1, []string{"\xe2\x82\xac5"}, "?5"},
+		{CharsetUTF8, CharsetLatin1, []string{"bad\xff"}, "bad?"},
+		{CharsetUTF8, CharsetASCII, []string{"caf\xc3\xa9"}, "caf?"},
+		// A sequence split between writes is decoded whole
+		{CharsetUTF8, CharsetLatin1, []string{"caf\xc3", "\xa9!"}, "caf\xe9!"},
+		{CharsetUTF8, CharsetASCII, []string{"\xe2", "\x82", "\xac"}, "?"},
+		// Nothing to do
+		{CharsetUTF8, CharsetUTF8, []string{"caf\xc3\xa9"}, "caf\xc3\xa9"},
+		{CharsetASCII, CharsetUTF8, []string{"plain"}, "plain"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		w := newCharsetWriter(&b, test.from, test.to)
+		for _, s := range test.writes {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("%s to %s: writing %q: got %d, %v", test.from, test.to, s, n, err)
+			}
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("%s to %s: %q: got %q, want %q", test.from, test.to, test.writes, got, test.want)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	s := new(Server)
+	names := map[string]string{
+		"notes.txt":       "notes.txt",
+		"caf\xc3\xa9.txt": "caf\xc3\xa9.txt",
+		"caf\xe9.txt":     "caf\xc3\xa9.txt",
+		"tab\there.txt":   "tab?here.txt",
+		"line\r\nbreak":   "line??break",
+	}
+	for name, want := range names {
+		if got := s.displayName(name); got != want {
+			t.Errorf("%q: got %q, want %q", name, got, want)
+		}
+	}
+	s.Charset = CharsetASCII
+	if got, want := s.displayName("caf\xc3\xa9.txt"), "caf?.txt"; got != want {
+		t.Errorf("ASCII: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,202 @@
+package gopher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// CheckContent looks over the content below the document roots before it
+// is served, for deploy scripts to run ahead of a restart. It reports
+// gophermap lines that are malformed, name an unknown item type, a
+// missing include or an unconfigured command, or link to local selectors
+// that lead nowhere, and file names clients cannot request or may have
+// trouble with. Links to other servers are left to CheckLinks, and
+// templated gophermaps are not checked, as what they say depends on the
+// request.
+
+// knownItemTypes are the item types of RFC 1436, Gopher+ and common use
+const knownItemTypes = "0123456789+TgIhisdp:;<Mw"
+
+// ContentProblem is something wrong with the content below a document root
+type ContentProblem struct {
+	File    string
+	Line    int // Line of File the problem is on, if not 0
+	Problem string
+}
+
+func (p *ContentProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Problem)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Problem)
+}
+
+// contentChecker collects the problems of one document root
+type contentChecker struct {
+	s        *Server
+	host     *VirtualHost
+	problems []*ContentProblem
+}
+
+func (c *contentChecker) report(file string, line int, format string, v ...interface{}) {
+	c.problems = append(c.problems, &ContentProblem{file, line, fmt.Sprintf(format, v...)})
+}
+
+func (c *contentChecker) VisitDir(name string, f *os.FileInfo) bool {
+	if name == c.host.Root+"/" {
+		return true
+	}
+	c.checkName(name)
+	return !strings.HasPrefix(f.Name, ".")
+}
+
+func (c *contentChecker) VisitFile(name string, f *os.FileInfo) {
+	c.checkName(name)
+	if f.Name == "gophermap" {
+		c.checkGophermap(name)
+	}
+	if strings.HasSuffix(f.Name, ScheduleSuffix) {
+		c.checkSchedule(name)
+	}
+}
+
+// checkSchedule reports a schedule sidecar that cannot be read, which
+// holds its file back for good
+func (c *contentChecker) checkSchedule(name string) {
+	data, err := c.s.readFile(name)
+	if err == nil {
+		_, err = parseSchedule(data)
+	}
+	if err != nil {
+		c.report(name, 0, "%s", err)
+	}
+}
+
+// checkName reports a file whose name cannot be requested, or is not
+// plain ASCII and so may be out of reach of some clients
+func (c *contentChecker) checkName(name string) {
+	selector := name[len(c.host.Root):]
+	if !validSelector(selector) {
+		c.report(name, 0, "name holds a tab or line break and cannot be requested")
+		return
+	}
+	if !isASCII(selector) {
+		c.report(name, 0, "name is not ASCII; some clients cannot request it")
+	}
+}
+
+// isASCII reports whether s is plain ASCII
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkGophermap reports the problems of the lines of a gophermap
+func (c *contentChecker) checkGophermap(name string) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		c.report(name, 0, "%s", err)
+		return
+	}
+	if strings.HasPrefix(string(data), TemplateMagic) {
+		return
+	}
+	dir := path.Dir(name)
+	ctx := &Context{Server: c.s, Host: c.host, Request: strings.TrimRight(dir[len(c.host.Root):], "/")}
+	for i, line := range strings.Split(string(data), "\n", -1) {
+		line = strings.TrimRight(line, "\r")
+		if include, ok := includeDirective(line); ok {
+			file := path.Join(dir, include)
+			if strings.HasPrefix(include, "/") {
+				file = path.Join(c.host.Root, include)
+			}
+			if _, err := os.Stat(file); err != nil {
+				c.report(name, i+1, "include: %s", err)
+			}
+			continue
+		}
+		if command, ok := execDirective(line); ok {
+			c.s.reloadMu.RLock()
+			_, found := c.s.commands[command]
+			c.s.reloadMu.RUnlock()
+			if !found {
+				c.report(name, i+1, "no command named `%s' is configured", command)
+			}
+			continue
+		}
+		if strings.Index(line, "\t") == -1 {
+			continue
+		}
+		if c.checkItemLine(name, i+1, line) {
+			c.checkEntries(name, i+1, ctx, line)
+		}
+	}
+}
+
+// checkItemLine reports what is malformed about a gophermap item line and
+// returns whether it is well formed enough to follow
+func (c *contentChecker) checkItemLine(name string, n int, line string) bool {
+	if line[0] == '\t' {
+		c.report(name, n, "item line without an item type")
+		return false
+	}
+	if strings.IndexRune(knownItemTypes, int(line[0])) == -1 {
+		c.report(name, n, "unknown item type `%c'", line[0])
+	}
+	fields := strings.Split(line[1:], "\t", -1)
+	if len(fields) > 5 || len(fields) == 5 && fields[4] != "+" {
+		c.report(name, n, "more fields than an item line has")
+	}
+	if len(fields) > 3 {
+		if port, err := strconv.Atoi(strings.TrimSpace(fields[3])); err != nil || port <= 0 || port > 65535 {
+			c.report(name, n, "bad port `%s'", fields[3])
+			return false
+		}
+	}
+	if len(fields) > 1 && !isASCII(fields[1]) {
+		c.report(name, n, "selector is not ASCII; some clients cannot request it")
+	}
+	return true
+}
+
+// checkEntries reports the local entries of an item line that lead nowhere
+func (c *contentChecker) checkEntries(name string, n int, ctx *Context, line string) {
+	entries := c.s.ParseGophermapLine(ctx, line)
+	for e := 0; e < entries.Len(); e++ {
+		entry := entries[e].(*MenuEntry)
+		if entry.Type == 'i' || entry.Type == '3' || strings.HasPrefix(entry.Selector, "URL:") {
+			continue
+		}
+		if entry.Host != c.s.Hostname || entry.Port != c.s.Port {
+			continue
+		}
+		if err := c.s.checkLocal(c.host, entry.Selector); err != nil {
+			c.report(name, n, "`%s' leads nowhere: %s", entry.Selector, err)
+		}
+	}
+}
+
+// CheckContent returns the problems found below every document root
+func (s *Server) CheckContent() []*ContentProblem {
+	s.init()
+	var problems []*ContentProblem
+	roots := make(map[string]bool)
+	for _, host := range append([]*VirtualHost{s.defaultHost}, s.VirtualHosts()...) {
+		if roots[host.Root] {
+			continue
+		}
+		roots[host.Root] = true
+		c := &contentChecker{s: s, host: host}
+		path.Walk(host.Root+"/", c, nil)
+		problems = append(problems, c.problems...)
+	}
+	return problems
+}
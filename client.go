@@ -0,0 +1,143 @@
+package gopher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Dial connects to the Gopher server at addr, which defaults to port 70
+func Dial(addr string) (net.Conn, os.Error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "70")
+	}
+	return net.Dial("tcp", "", addr)
+}
+
+// Response is the reply to a selector, read straight off the connection
+type Response struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Get sends the selector to the server at addr and returns its reply
+func Get(addr string, selector string) (*Response, os.Error) {
+	conn, err := Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fmt.Fprintf(conn, "%s\r\n", selector); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Response{conn, bufio.NewReader(conn)}, nil
+}
+
+// Read reads the raw reply, as needed for binary items
+func (r *Response) Read(p []byte) (n int, err os.Error) {
+	return r.reader.Read(p)
+}
+
+func (r *Response) Close() os.Error {
+	return r.conn.Close()
+}
+
+// Text returns a reader for a text reply that stops at the terminating
+// period, undoes dot-stuffing and turns <CR><LF> into <LF>
+func (r *Response) Text() io.Reader {
+	return &textReader{reader: r.reader}
+}
+
+// Menu reads and parses a menu reply
+func (r *Response) Menu() ([]*MenuEntry, os.Error) {
+	return ParseMenu(r.Text())
+}
+
+type textReader struct {
+	reader *bufio.Reader
+	line   []byte
+	done   bool
+}
+
+func (t *textReader) Read(p []byte) (n int, err os.Error) {
+	for len(t.line) == 0 {
+		if t.done {
+			return 0, os.EOF
+		}
+		line, err := t.reader.ReadString('\n')
+		if err == os.EOF && line == "" {
+			// Servers that close without a terminator are common enough
+			t.done = true
+			continue
+		} else if err != nil && err != os.EOF {
+			return 0, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." {
+			t.done = true
+			continue
+		}
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		t.line = []byte(line + "\n")
+	}
+	n = copy(p, t.line)
+	t.line = t.line[n:]
+	return
+}
+
+// ParseMenu parses the lines of a menu until the terminating period
+func ParseMenu(r io.Reader) (entries []*MenuEntry, err os.Error) {
+	reader := bufio.NewReader(r)
+	for {
+		line, rerr := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." || (rerr == os.EOF && line == "") {
+			return
+		}
+		if rerr != nil && rerr != os.EOF {
+			return entries, rerr
+		}
+		if line != "" {
+			entry, perr := ParseMenuLine(line)
+			if perr != nil {
+				return entries, perr
+			}
+			entries = append(entries, entry)
+		}
+		if rerr == os.EOF {
+			return
+		}
+	}
+	return
+}
+
+// ParseMenuLine parses one menu line. Missing trailing fields are left
+// empty, since many servers are sloppy about info lines.
+func ParseMenuLine(line string) (*MenuEntry, os.Error) {
+	if line == "" {
+		return nil, os.NewError("empty menu line")
+	}
+	entry := &MenuEntry{Type: line[0]}
+	parts := strings.Split(line[1:], "\t", -1)
+	entry.Display = parts[0]
+	if len(parts) > 1 {
+		entry.Selector = parts[1]
+	}
+	if len(parts) > 2 {
+		entry.Host = parts[2]
+	}
+	if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
+		port, err := strconv.Atoi(strings.TrimSpace(parts[3]))
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("bad port in menu line %q", line))
+		}
+		entry.Port = port
+	}
+	return entry, nil
+}
@@ -0,0 +1,58 @@
+package gopher
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseMenuLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		entry MenuEntry
+	}{
+		{"1Docs\t/docs\texample.org\t70", MenuEntry{Type: '1', Display: "Docs", Selector: "/docs", Host: "example.org", Port: 70}},
+		{"0About\t/about.txt\texample.org\t7070 ", MenuEntry{Type: '0', Display: "About", Selector: "/about.txt", Host: "example.org", Port: 7070}},
+		// Sloppy info lines leave out the trailing fields
+		{"iJust text", MenuEntry{Type: 'i', Display: "Just text"}},
+		{"iText\t\t\t", MenuEntry{Type: 'i', Display: "Text"}},
+	}
+	for _, test := range tests {
+		entry, err := ParseMenuLine(test.line)
+		if err != nil {
+			t.Errorf("%q: %s", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(*entry, test.entry) {
+			t.Errorf("%q: got %+v, want %+v", test.line, *entry, test.entry)
+		}
+	}
+	for _, line := range []string{"", "1Docs\t/docs\texample.org\tseventy"} {
+		if _, err := ParseMenuLine(line); err == nil {
+			t.Errorf("%q: parsed, want an error", line)
+		}
+	}
+}
+
+func TestParseMenu(t *testing.T) {
+	menu := "iHello\t\terror.host\t1\r\n" +
+		"\r\n" +
+		"1Docs\t/docs\texample.org\t70\r\n" +
+		".\r\n" +
+		"0After the end\t/x\texample.org\t70\r\n"
+	entries, err := ParseMenu(bytes.NewBufferString(menu))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(entries) != 2 || entries[0].Display != "Hello" || entries[1].Selector != "/docs" {
+		t.Errorf("got %d entries, want the two before the end marker", len(entries))
+	}
+	// Servers that close the connection without the marker are accepted
+	entries, err = ParseMenu(bytes.NewBufferString("1Docs\t/docs\texample.org\t70"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("unterminated menu: got %d entries, error %v", len(entries), err)
+	}
+	if _, err = ParseMenu(bytes.NewBufferString("1Docs\t/docs\texample.org\tx\r\n.\r\n")); err == nil {
+		t.Errorf("bad port: parsed, want an error")
+	}
+}
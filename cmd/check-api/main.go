@@ -0,0 +1,97 @@
+// check-api reports uses of deprecated gopher package APIs in Go source,
+// in the style of govet.
+//
+// Usage: check-api [file.go | directory]...
+//
+// Without type information any method call with a deprecated name is
+// reported, so the odd false positive is possible.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"strings"
+)
+
+// Deprecated names and what to use instead
+var deprecated = map[string]string{
+	"InfoLine":      "Menu.Info or EntryWriter.Info",
+	"TextfileLine":  "Menu.Item or EntryWriter.Item",
+	"DirectoryLine": "Menu.Item or EntryWriter.Item",
+}
+
+var (
+	fset     = token.NewFileSet()
+	problems = 0
+)
+
+type checker struct{}
+
+func (c checker) Visit(node ast.Node) ast.Visitor {
+	if sel, ok := node.(*ast.SelectorExpr); ok {
+		if instead, found := deprecated[sel.Sel.Name]; found {
+			pos := fset.Position(sel.Sel.Pos())
+			fmt.Printf("%s:%d: %s is deprecated; use %s\n", pos.Filename, pos.Line, sel.Sel.Name, instead)
+			problems++
+		}
+	}
+	return c
+}
+
+func checkFile(name string) {
+	file, err := parser.ParseFile(fset, name, nil, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		problems++
+		return
+	}
+	ast.Walk(checker{}, file)
+}
+
+// dirVisitor checks every Go file below a directory
+type dirVisitor struct{}
+
+func (v dirVisitor) VisitDir(dir string, f *os.FileInfo) bool {
+	return true
+}
+
+func (v dirVisitor) VisitFile(name string, f *os.FileInfo) {
+	if strings.HasSuffix(name, ".go") {
+		checkFile(name)
+	}
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if info.IsDirectory() {
+			errors := make(chan os.Error, 16)
+			go func() {
+				path.Walk(arg, dirVisitor{}, errors)
+				close(errors)
+			}()
+			for err := range errors {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		} else {
+			checkFile(arg)
+		}
+	}
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"gopher"
+	"os"
+)
+
+// checkContent reports the problems in the content the server would serve,
+// exiting with status 1 if there are any
+func checkContent(server *gopher.Server) {
+	problems := server.CheckContent()
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems\n", len(problems))
+		os.Exit(1)
+	}
+}
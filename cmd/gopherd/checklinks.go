@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"gopher"
+	"os"
+)
+
+// checklinks reports the dead links in the server's gophermaps, exiting
+// with status 1 if there are any
+func checklinks(server *gopher.Server) {
+	dead := server.CheckLinks()
+	for _, d := range dead {
+		fmt.Println(d)
+	}
+	if len(dead) > 0 {
+		fmt.Fprintf(os.Stderr, "%d dead links\n", len(dead))
+		os.Exit(1)
+	}
+}
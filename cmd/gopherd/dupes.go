@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"gopher"
+	"os"
+	"path"
+)
+
+// dupes reports the files served more than once under different
+// selectors. With `gophermap' it writes a menu pointing each duplicate at
+// its canonical selector instead, and with `aliases' configuration lines
+// serving the canonical file in place of each duplicate, for once the
+// duplicates are removed.
+func dupes(server *gopher.Server, args []string) {
+	format := "report"
+	if len(args) > 0 {
+		format = args[0]
+	}
+	if len(args) > 1 || format != "report" && format != "gophermap" && format != "aliases" {
+		fmt.Fprintln(os.Stderr, "usage: gopherd [flags] dupes [report|gophermap|aliases]")
+		os.Exit(2)
+	}
+	groups := server.DuplicateReport()
+	var wasted int64
+	for _, g := range groups {
+		wasted += g.Wasted()
+		canon := g.Selectors[0]
+		switch format {
+		case "report":
+			fmt.Printf("%s (%d bytes, md5 %s)\n", canon, g.Size, g.Sum)
+			for _, dup := range g.Selectors[1:] {
+				fmt.Printf("    %s\n", dup)
+			}
+		case "gophermap":
+			fmt.Printf("Same as %s:\n", canon)
+			for _, dup := range g.Selectors[1:] {
+				fmt.Printf("0%s\t%s\n", path.Base(dup), canon)
+			}
+		case "aliases":
+			for _, dup := range g.Selectors[1:] {
+				fmt.Printf("alias %s %s\n", dup, canon)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d groups of duplicates, %d bytes in extra copies\n", len(groups), wasted)
+}
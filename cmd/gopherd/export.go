@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"gopher"
+	"os"
+)
+
+// export renders the server's site as static HTML into the directory
+// named in args
+func export(server *gopher.Server, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gopherd [flags] export directory")
+		os.Exit(2)
+	}
+	n, err := server.Export(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported %d items into %s\n", n, args[0])
+}
@@ -0,0 +1,206 @@
+// gopherd serves the files and folders below its working directory over
+// Gopher.
+//
+// Run `gopherd setup [file]' to create a configuration file interactively,
+// `gopherd mirror gopher://host/1/selector directory' to copy a remote site
+// into a directory, `gopherd checklinks' with the usual flags to report
+// dead links in the gophermaps served, `gopherd dupes' to list files served
+// more than once, `gopherd export directory' to render the site as static
+// HTML, and `gopherd simulate selector' to show how a request would be
+// answered without serving it. `gopherd replay host:port transcript...'
+// sends requests recorded in the journal to another server and reports the
+// responses that changed. `gopherd -check' validates the gophermaps and
+// file names below the document roots and exits with status 1 on any
+// problem.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopher"
+	"os"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	var defaulthost string
+	var err os.Error
+	if defaulthost, err = os.Hostname(); err != nil {
+		fmt.Fprintln(os.Stderr, "could not determine hostname, defaulting to localhost")
+		defaulthost = "localhost"
+	}
+	var config *string = flag.String("config", "", "configuration file")
+	var hostname *string = flag.String("hostname", defaulthost, "hostname of the server")
+	var port *int = flag.Int("port", 70, "port of the server")
+	var dupes *string = flag.String("dupes", "off", "duplicate files in listings: off, annotate or collapse")
+	var dupeChecksums *bool = flag.Bool("dupe-checksums", false, "detect duplicate files by checksum as well as by inode")
+	var acceptURLs *bool = flag.Bool("accept-urls", false, "accept full gopher:// URLs sent in place of selectors")
+	var charset *string = flag.String("charset", "UTF-8", "character set to serve text in: UTF-8, ISO-8859-1 or US-ASCII")
+	var metrics *string = flag.String("metrics", "", "address of an optional HTTP metrics listener, e.g. :9070")
+	var control *string = flag.String("control", "", "path of an optional unix control socket")
+	var unix *string = flag.String("unix", "", "path of a unix socket to serve on instead of TCP, e.g. behind a proxy")
+	var proxyProtocol *bool = flag.Bool("proxy-protocol", false, "expect a PROXY protocol header naming the client on every connection")
+	var trace *bool = flag.Bool("trace", false, "log the time spent in each phase of every request")
+	var watch *bool = flag.Bool("watch", false, "cache gophermaps and other sidecar files until they change")
+	var workers *int = flag.Int("workers", 0, "goroutines answering connections (default 256)")
+	var markdown *string = flag.String("markdown", "raw", "markdown files: raw, text (rendered) or auto (menus when mostly links)")
+	var reflow *int = flag.Int("reflow", 0, "wrap long lines of text files at this column, e.g. 70")
+	var keepAlive *bool = flag.Bool("keep-alive", false, "let clients that ask send several requests on one connection")
+	var reusePort *bool = flag.Bool("reuse-port", false, "listen with SO_REUSEPORT, so several gopherd processes can share the port")
+	var advertiseHost *string = flag.String("advertise-host", "", "host menus point at, if not the one listened on, e.g. behind NAT")
+	var advertisePort *int = flag.Int("advertise-port", 0, "port menus point at, if not the one listened on")
+	var logs *string = flag.String("log", "stdout", "comma separated log destinations: stdout, stderr, syslog or a file")
+	var chroot *bool = flag.Bool("chroot", false, "chroot into the document root once listening")
+	var user *string = flag.String("user", "", "user to switch to once listening")
+	var group *string = flag.String("group", "", "group to switch to once listening")
+	var inetd *bool = flag.Bool("inetd", false, "answer a single request on stdin and stdout, for inetd")
+	var check *bool = flag.Bool("check", false, "validate the content below the document roots and exit, e.g. before a restart")
+	flag.Parse()
+	if flag.NArg() > 0 && flag.Arg(0) == "setup" {
+		setup(flag.Args()[1:])
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "mirror" {
+		mirror(flag.Args()[1:])
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "replay" {
+		replay(flag.Args()[1:])
+		return
+	}
+	server := gopher.DefaultServer
+	if *config != "" {
+		if err = server.LoadConfig(*config); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	// Flags given on the command line override the configuration file, on
+	// start and on every reload
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	override := func(server *gopher.Server) (err os.Error) {
+		if set["hostname"] || server.Hostname == "" {
+			server.Hostname = *hostname
+		}
+		if set["port"] || server.Port == 0 {
+			server.Port = *port
+		}
+		if set["dupes"] {
+			if server.Dupes, err = gopher.ParseDupesMode(*dupes); err != nil {
+				return
+			}
+		}
+		if set["dupe-checksums"] {
+			server.DupeChecksums = *dupeChecksums
+		}
+		if set["accept-urls"] {
+			server.AcceptURLs = *acceptURLs
+		}
+		if set["charset"] {
+			if server.Charset, err = gopher.ParseCharset(*charset); err != nil {
+				return
+			}
+		}
+		if set["metrics"] {
+			server.MetricsAddr = *metrics
+		}
+		if set["control"] {
+			server.ControlSocket = *control
+		}
+		if set["unix"] {
+			server.UnixSocket = *unix
+		}
+		if set["proxy-protocol"] {
+			server.ProxyProtocol = *proxyProtocol
+		}
+		if set["trace"] {
+			server.Trace = *trace
+		}
+		if set["watch"] {
+			server.WatchFiles = *watch
+		}
+		if set["workers"] {
+			server.Workers = *workers
+		}
+		if set["markdown"] {
+			if server.MarkdownMode, err = gopher.ParseMarkdownMode(*markdown); err != nil {
+				return
+			}
+		}
+		if set["reflow"] {
+			server.ReflowWidth = *reflow
+		}
+		if set["keep-alive"] {
+			server.KeepAlive = *keepAlive
+		}
+		if set["reuse-port"] {
+			server.ReusePort = *reusePort
+		}
+		if set["advertise-host"] {
+			server.AdvertiseHost = *advertiseHost
+		}
+		if set["advertise-port"] {
+			server.AdvertisePort = *advertisePort
+		}
+		if set["log"] {
+			if err = server.SetLogSinks(strings.Split(*logs, ",", -1)); err != nil {
+				return
+			}
+		}
+		if set["chroot"] {
+			server.Chroot = *chroot
+		}
+		if set["user"] {
+			server.User = *user
+		}
+		if set["group"] {
+			server.Group = *group
+		}
+		return
+	}
+	if err = override(server); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *check {
+		checkContent(server)
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "checklinks" {
+		checklinks(server)
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "dupes" {
+		dupes(server, flag.Args()[1:])
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "export" {
+		export(server, flag.Args()[1:])
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "simulate" {
+		simulate(server, flag.Args()[1:])
+		return
+	}
+	if *inetd {
+		gopher.ServeInetd(server.Hostname, server.Port)
+		return
+	}
+	handleSignal(syscall.SIGHUP, func() {
+		if err := server.Reload(override); err != nil {
+			server.Logger.Printf("ERROR: Could not reload: %s\n", err)
+		}
+	})
+	handleSignal(syscall.SIGUSR1, func() {
+		server.ToggleMaintenance()
+	})
+	handleSignal(syscall.SIGUSR2, func() {
+		if err := server.Upgrade(); err != nil {
+			server.Logger.Printf("ERROR: Could not upgrade: %s\n", err)
+		}
+	})
+	go dispatchSignals()
+	gopher.Run(server.Hostname, server.Port)
+}
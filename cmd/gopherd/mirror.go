@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopher"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxMirrorItems bounds the number of items a mirror run fetches
+const maxMirrorItems = 10000
+
+// mirrorDelay is the pause between requests to the origin, in nanoseconds
+const mirrorDelay = 200e6
+
+// mirrorer copies the part of a remote site below a menu into a directory
+type mirrorer struct {
+	origin  *gopher.URL
+	dir     string
+	fetched map[string]bool
+	queue   []*gopher.MenuEntry
+}
+
+// mirror crawls a remote menu and everything below it on the same server,
+// writing menus as gophermaps and other items as files. Selectors become
+// paths below the directory, as the mirror configuration directive
+// expects, so links back to the origin are rewritten when served.
+func mirror(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: gopherd mirror gopher://host[:port]/1/selector directory")
+		os.Exit(2)
+	}
+	origin, err := gopher.ParseURL(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if origin.Type != '1' {
+		fmt.Fprintln(os.Stderr, "the URL must point at a menu")
+		os.Exit(2)
+	}
+	m := &mirrorer{origin: origin, dir: path.Clean(args[1]), fetched: make(map[string]bool)}
+	m.queue = append(m.queue, &gopher.MenuEntry{Type: '1', Selector: origin.Selector, Host: origin.Host, Port: origin.Port})
+	failed := 0
+	for len(m.queue) > 0 && len(m.fetched) < maxMirrorItems {
+		entry := m.queue[0]
+		m.queue = m.queue[1:]
+		if m.fetched[entry.Selector] {
+			continue
+		}
+		m.fetched[entry.Selector] = true
+		if err := m.fetch(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", entry.Selector, err)
+			failed++
+		}
+		time.Sleep(mirrorDelay)
+	}
+	if len(m.queue) > 0 {
+		fmt.Fprintf(os.Stderr, "stopped after %d items\n", maxMirrorItems)
+	}
+	fmt.Printf("Mirrored %d items into %s, %d failed\n", len(m.fetched), m.dir, failed)
+	fmt.Printf("Serve them with `mirror /selector %s:%d', /selector being where %s is served\n", origin.Host, origin.Port, m.dir)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// follows reports whether an entry of a mirrored menu is mirrored too
+func (m *mirrorer) follows(entry *gopher.MenuEntry) bool {
+	if entry.Host != m.origin.Host || entry.Port != m.origin.Port || strings.HasPrefix(entry.Selector, "URL:") {
+		return false
+	}
+	if !strings.HasPrefix(entry.Selector, m.origin.Selector) {
+		return false
+	}
+	switch entry.Type {
+	case 'i', '3', '2', '7', '8', 'T':
+		return false
+	}
+	return true
+}
+
+// localPath returns where the item at selector is written
+func (m *mirrorer) localPath(selector string) string {
+	return path.Join(m.dir, path.Clean("/"+selector))
+}
+
+// fetch copies one item, queueing the entries of a menu
+func (m *mirrorer) fetch(entry *gopher.MenuEntry) os.Error {
+	response, err := gopher.Get(fmt.Sprintf("%s:%d", m.origin.Host, m.origin.Port), entry.Selector)
+	if err != nil {
+		return err
+	}
+	defer response.Close()
+	name := m.localPath(entry.Selector)
+	if entry.Type == '1' {
+		name = path.Join(name, "gophermap")
+	}
+	if err = os.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+	switch entry.Type {
+	case '1':
+		entries, err := response.Menu()
+		if err != nil {
+			return err
+		}
+		var gmap bytes.Buffer
+		for _, e := range entries {
+			if e.Type == 'i' || e.Type == '3' {
+				// Keep info lines from being read as gophermap directives
+				if strings.HasPrefix(e.Display, "=") || strings.HasPrefix(e.Display, "exec:") || strings.HasPrefix(e.Display, "!banner ") {
+					gmap.WriteString(" ")
+				}
+				fmt.Fprintln(&gmap, e.Display)
+				continue
+			}
+			fmt.Fprintf(&gmap, "%c%s\t%s\t%s\t%d\n", e.Type, e.Display, e.Selector, e.Host, e.Port)
+			if m.follows(e) {
+				m.queue = append(m.queue, e)
+			}
+		}
+		return ioutil.WriteFile(name, gmap.Bytes(), 0644)
+	case '0':
+		return writeItem(name, response.Text())
+	}
+	return writeItem(name, response)
+}
+
+// writeItem copies an item into the file name
+func writeItem(name string, r io.Reader) os.Error {
+	file, err := os.Open(name, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
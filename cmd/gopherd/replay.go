@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"gopher"
+	"os"
+)
+
+// replay sends the requests recorded in journal transcripts to a server,
+// usually a test instance, and reports the responses that differ from the
+// recorded ones
+func replay(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: gopherd replay host:port transcript...")
+		os.Exit(2)
+	}
+	differ := 0
+	for _, name := range args[1:] {
+		t, err := gopher.ReadTranscript(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		reply, same, err := gopher.ReplayTranscript(t, args[0])
+		switch {
+		case err != nil:
+			fmt.Printf("FAILED  %s: %s\n", t, err)
+			differ++
+		case !same:
+			fmt.Printf("DIFFERS %s: %d bytes, recorded %d\n", t, len(reply), t.Sent)
+			differ++
+		default:
+			fmt.Printf("SAME    %s\n", t)
+		}
+	}
+	if differ > 0 {
+		os.Exit(1)
+	}
+}
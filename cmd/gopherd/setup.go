@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// setup interactively asks for the basic settings of a new server and
+// writes them to a commented configuration file
+func setup(args []string) {
+	name := "gopherd.conf"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	in := bufio.NewReader(os.Stdin)
+	ask := func(question string, def string) string {
+		if def != "" {
+			fmt.Printf("%s [%s]: ", question, def)
+		} else {
+			fmt.Printf("%s: ", question)
+		}
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Println()
+			os.Exit(1)
+		}
+		if answer = strings.TrimSpace(answer); answer == "" {
+			return def
+		}
+		return answer
+	}
+	confirm := func(question string) bool {
+		answer := strings.ToLower(ask(question+" (y/n)", "n"))
+		return strings.HasPrefix(answer, "y")
+	}
+
+	fmt.Println("This will write a configuration file for gopherd.")
+	if _, err := os.Stat(name); err == nil && !confirm(fmt.Sprintf("%s exists, overwrite it?", name)) {
+		os.Exit(1)
+	}
+
+	defaulthost, _ := os.Hostname()
+	var hostname string
+	for {
+		hostname = ask("Hostname clients will use to reach this server", defaulthost)
+		if _, err := net.LookupHost(hostname); err == nil {
+			break
+		} else {
+			fmt.Printf("  %s does not resolve: %s\n", hostname, err)
+		}
+		if confirm("Use it anyway?") {
+			break
+		}
+	}
+
+	var port int
+	for {
+		var err os.Error
+		if port, err = strconv.Atoi(ask("Port to listen on", "70")); err != nil || port <= 0 || port > 65535 {
+			fmt.Println("  Please enter a port number between 1 and 65535")
+			continue
+		}
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			listener.Close()
+			break
+		}
+		fmt.Printf("  Cannot listen on port %d: %s\n", port, err)
+		if port < 1024 {
+			fmt.Println("  Ports below 1024 usually need the server to be started as root.")
+		}
+		if confirm("Use it anyway?") {
+			break
+		}
+	}
+
+	cwd, _ := os.Getwd()
+	var root string
+	for {
+		root = path.Clean(ask("Directory to serve", cwd))
+		if !strings.HasPrefix(root, "/") {
+			root = path.Join(cwd, root)
+		}
+		if info, err := os.Stat(root); err == nil && info.IsDirectory() {
+			break
+		}
+		fmt.Printf("  %s is not a directory\n", root)
+	}
+
+	var cert, key string
+	if confirm("Serve over TLS?") {
+		for {
+			cert = ask("Certificate file", "")
+			key = ask("Private key file", "")
+			_, certErr := os.Stat(cert)
+			_, keyErr := os.Stat(key)
+			if certErr == nil && keyErr == nil {
+				break
+			}
+			fmt.Println("  Both files must exist")
+		}
+	}
+
+	admin := ask("Administrator contact (name and email)", "")
+
+	var conf bytes.Buffer
+	fmt.Fprintln(&conf, "# gopherd configuration, written by `gopherd setup'")
+	fmt.Fprintln(&conf, "# Flags given on the command line override these settings.")
+	fmt.Fprintln(&conf)
+	fmt.Fprintln(&conf, "# Name and port clients use to reach the server; also used in menus")
+	fmt.Fprintf(&conf, "hostname %s\n", hostname)
+	fmt.Fprintf(&conf, "port %d\n", port)
+	fmt.Fprintln(&conf)
+	fmt.Fprintln(&conf, "# Only files and folders below this directory are served")
+	fmt.Fprintf(&conf, "root %s\n", root)
+	fmt.Fprintln(&conf)
+	fmt.Fprintln(&conf, "# Certificate and key to serve over TLS; comment out for plain Gopher")
+	if cert != "" {
+		fmt.Fprintf(&conf, "tls-cert %s\n", cert)
+		fmt.Fprintf(&conf, "tls-key %s\n", key)
+	} else {
+		fmt.Fprintln(&conf, "#tls-cert /etc/ssl/certs/gopher.pem")
+		fmt.Fprintln(&conf, "#tls-key /etc/ssl/private/gopher.key")
+	}
+	fmt.Fprintln(&conf)
+	fmt.Fprintln(&conf, "# Who to contact about this server")
+	if admin != "" {
+		fmt.Fprintf(&conf, "admin %s\n", admin)
+	} else {
+		fmt.Fprintln(&conf, "#admin Jane Doe <jane@example.org>")
+	}
+	fmt.Fprintln(&conf)
+	fmt.Fprintln(&conf, "# Log destinations: stdout, stderr, syslog or a file")
+	fmt.Fprintln(&conf, "log stdout")
+
+	if err := ioutil.WriteFile(name, conf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s; start the server with: gopherd -config %s\n", name, name)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// signalHandlers are run by dispatchSignals, by signal
+var signalHandlers = make(map[signal.UnixSignal]func())
+
+// handleSignal makes f run whenever sig arrives. Handlers must be set up
+// before dispatchSignals starts.
+func handleSignal(sig int, f func()) {
+	signalHandlers[signal.UnixSignal(sig)] = f
+}
+
+// dispatchSignals runs the handler for each incoming signal. Once os/signal
+// is in use interrupts no longer end the process by themselves, so SIGINT
+// and SIGTERM exit unless they are handled.
+func dispatchSignals() {
+	for sig := range signal.Incoming {
+		unix, ok := sig.(signal.UnixSignal)
+		if !ok {
+			continue
+		}
+		if f, found := signalHandlers[unix]; found {
+			f()
+			continue
+		}
+		switch int(unix) {
+		case syscall.SIGINT, syscall.SIGTERM:
+			os.Exit(1)
+		}
+	}
+}
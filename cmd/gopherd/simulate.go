@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"gopher"
+	"os"
+)
+
+// simulate shows how the server would answer the selector in args, without
+// listening or touching the network
+func simulate(server *gopher.Server, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gopherd [flags] simulate selector")
+		os.Exit(2)
+	}
+	sim, err := server.Simulate(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Selector:  %s\n", sim.Selector)
+	fmt.Printf("Resolved:  %s\n", sim.Request)
+	if sim.File != "" {
+		fmt.Printf("File:      %s\n", sim.File)
+	}
+	if sim.ItemType != 0 {
+		fmt.Printf("Item type: %c\n", sim.ItemType)
+	}
+	fmt.Printf("Outcome:   %s\n", sim.Outcome)
+	for _, message := range sim.Log {
+		fmt.Printf("Log:       %s\n", message)
+	}
+	fmt.Printf("\n")
+	os.Stdout.Write(sim.Reply)
+}
@@ -0,0 +1,98 @@
+package gopher
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// With Compress on, text files of at least CompressMinSize bytes are also
+// offered in the application/gzip view of their Gopher+ attributes, and a
+// Gopher+ client asking for that view gets the file gzipped. A file.gz
+// next to the file that is at least as new is sent as is; otherwise the
+// file is compressed on the fly. CompressTypes, if set, limits this to
+// files with the listed extensions.
+
+// GzipView is the Gopher+ view compressed files are offered as
+const GzipView = "application/gzip"
+
+// compressible reports whether the file at absPath is offered gzipped
+func (s *Server) compressible(absPath string, info *os.FileInfo) bool {
+	if !s.Compress || !info.IsRegular() || info.Size < s.CompressMinSize {
+		return false
+	}
+	if len(s.CompressTypes) == 0 {
+		return true
+	}
+	ext := strings.TrimLeft(path.Ext(absPath), ".")
+	for _, t := range s.CompressTypes {
+		if strings.TrimLeft(t, ".") == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// precompressed returns the gzipped twin of the file at absPath if there is
+// one no older than the file itself
+func (s *Server) precompressed(absPath string, info *os.FileInfo) File {
+	twin, err := s.statFile(absPath + ".gz")
+	if err != nil || !twin.IsRegular() || twin.Mtime_ns < info.Mtime_ns {
+		return nil
+	}
+	file, err := s.openFile(absPath + ".gz")
+	if err != nil {
+		return nil
+	}
+	return file
+}
+
+// gzipViewSize estimates the size in kilobytes of the gzip view, for the
+// VIEWS attribute
+func (s *Server) gzipViewSize(absPath string, info *os.FileInfo) int64 {
+	if twin, err := s.statFile(absPath + ".gz"); err == nil && twin.Mtime_ns >= info.Mtime_ns {
+		return (twin.Size + 1023) / 1024
+	}
+	return (info.Size/3 + 1023) / 1024
+}
+
+// ServeCompressed sends a file gzipped as a Gopher+ +-2 reply, ended by
+// closing the connection
+func (s *Server) ServeCompressed(ctx *Context, file File, info *os.FileInfo) (Status, os.Error) {
+	ctx.itemType = '9'
+	absPath := file.Name()
+	if _, err := ctx.Write("+-2"); err != nil {
+		return StatusError, err
+	}
+	if twin := s.precompressed(absPath, info); twin != nil {
+		defer twin.Close()
+		if _, err := io.Copy(ctx.conn, twin); err != nil {
+			return StatusError, err
+		}
+		ctx.Logf("Served precompressed `%s'\n", ctx.Request)
+		return StatusOK, nil
+	}
+	gz, err := gzip.NewWriter(ctx.conn)
+	if err != nil {
+		return StatusError, err
+	}
+	if _, err = io.Copy(gz, file); err != nil {
+		return StatusError, err
+	}
+	if err = gz.Close(); err != nil {
+		return StatusError, err
+	}
+	ctx.Logf("Served `%s' compressed on the fly\n", ctx.Request)
+	return StatusOK, nil
+}
+
+// configureCompressTypes handles a `compress-types ext...' line
+func (s *Server) configureCompressTypes(args []string) os.Error {
+	if len(args) == 0 {
+		return os.NewError("expected one or more file extensions")
+	}
+	s.CompressTypes = args
+	return nil
+}
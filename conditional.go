@@ -0,0 +1,64 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A mirroring client can skip documents it already has by asking for an
+// item only if it changed after a time, given in the form of the bracketed
+// part of the Mod-Date attribute:
+//    selector<TAB>+if-modified-since=20110301120000
+// or as a parameter of a view, as with offset. An unchanged file is
+// answered with a Gopher+ error of code 4, an extension to the codes of
+// the Gopher+ specification:
+//    --1
+//    4 Not modified since <Mod-Date>
+//    .
+
+// ModDateFormat is the layout of the machine readable part of Mod-Date
+const ModDateFormat = "20060102150405"
+
+// plusParam returns the value of a parameter of the Gopher+ part of a
+// request, if it is there
+func plusParam(plus string, name string) (string, bool) {
+	if !strings.HasPrefix(plus, "+") {
+		return "", false
+	}
+	for _, param := range strings.Split(plus[1:], ";", -1) {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, name+"=") {
+			return param[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// notModified reports whether the Gopher+ part of a request asks for the
+// file only if it changed, and it has not
+func notModified(plus string, info *os.FileInfo) bool {
+	since, ok := plusParam(plus, "if-modified-since")
+	if !ok {
+		return false
+	}
+	t, err := time.Parse(ModDateFormat, since)
+	if err != nil {
+		return false
+	}
+	return info.Mtime_ns/1e9 <= t.Seconds()
+}
+
+// serveNotModified tells the client the file it asked for has not changed
+func (s *Server) serveNotModified(ctx *Context, info *os.FileInfo) (Status, os.Error) {
+	ctx.itemType = '9'
+	mtime := time.SecondsToUTC(info.Mtime_ns / 1e9)
+	ctx.Write("--1")
+	ctx.Write(fmt.Sprintf("4 Not modified since %s", mtime.Format(ModDateFormat)))
+	if _, err := ctx.Write("."); err != nil {
+		return StatusError, err
+	}
+	ctx.Logf("`%s' not modified\n", ctx.Request)
+	return StatusOK, nil
+}
@@ -0,0 +1,502 @@
+package gopher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A configuration file is a list of lines of the form
+//    directive arg...
+// Arguments are separated by blanks, and everything after a # is a comment.
+// Virtual hosts are declared in blocks:
+//    vhost 192.0.2.10
+//    root /srv/gopher/example
+//    end
+
+// configDirective applies the arguments of one configuration line
+type configDirective func(s *Server, args []string) os.Error
+
+var configDirectives map[string]configDirective
+
+func init() {
+	configDirectives = map[string]configDirective{
+		"hostname": func(s *Server, args []string) (err os.Error) {
+			s.Hostname, err = configString(args)
+			return
+		},
+		"port": func(s *Server, args []string) (err os.Error) {
+			s.Port, err = configInt(args)
+			return
+		},
+		"root": func(s *Server, args []string) (err os.Error) {
+			s.Cwd, err = configString(args)
+			s.Cwd = path.Clean(s.Cwd)
+			return
+		},
+		"staged-root": func(s *Server, args []string) os.Error {
+			return s.configureStagedRoot(args)
+		},
+		"case-insensitive": func(s *Server, args []string) (err os.Error) {
+			s.CaseInsensitive, err = configBool(args)
+			return
+		},
+		"charset": func(s *Server, args []string) (err os.Error) {
+			var name string
+			if name, err = configString(args); err == nil {
+				s.Charset, err = ParseCharset(name)
+			}
+			return
+		},
+		"admin": func(s *Server, args []string) os.Error {
+			if len(args) == 0 {
+				return os.NewError("expected a contact name or address")
+			}
+			s.Admin = strings.Join(args, " ")
+			return nil
+		},
+		"tls-cert": func(s *Server, args []string) (err os.Error) {
+			s.TLSCert, err = configString(args)
+			return
+		},
+		"tls-key": func(s *Server, args []string) (err os.Error) {
+			s.TLSKey, err = configString(args)
+			return
+		},
+		"dupes": func(s *Server, args []string) (err os.Error) {
+			var mode string
+			if mode, err = configString(args); err == nil {
+				s.Dupes, err = ParseDupesMode(mode)
+			}
+			return
+		},
+		"dupe-checksums": func(s *Server, args []string) (err os.Error) {
+			s.DupeChecksums, err = configBool(args)
+			return
+		},
+		"accept-urls": func(s *Server, args []string) (err os.Error) {
+			s.AcceptURLs, err = configBool(args)
+			return
+		},
+		"metrics": func(s *Server, args []string) (err os.Error) {
+			s.MetricsAddr, err = configString(args)
+			return
+		},
+		"stats-file": func(s *Server, args []string) (err os.Error) {
+			s.StatsFile, err = configString(args)
+			return
+		},
+		"stats-interval": func(s *Server, args []string) (err os.Error) {
+			s.StatsInterval, err = configInt(args)
+			return
+		},
+		"banner": func(s *Server, args []string) os.Error {
+			s.Banner = append(s.Banner, strings.Join(args, " "))
+			return nil
+		},
+		"banner-file": func(s *Server, args []string) (err os.Error) {
+			s.BannerFile, err = configString(args)
+			return
+		},
+		"maintenance": func(s *Server, args []string) os.Error {
+			return s.configureMaintenance(args)
+		},
+		"maintenance-file": func(s *Server, args []string) (err os.Error) {
+			s.MaintenanceFile, err = configString(args)
+			return
+		},
+		"maintenance-message": func(s *Server, args []string) os.Error {
+			s.MaintenanceMessage = append(s.MaintenanceMessage, strings.Join(args, " "))
+			return nil
+		},
+		"motd": func(s *Server, args []string) (err os.Error) {
+			s.MotdFile, err = configString(args)
+			return
+		},
+		"file-cache": func(s *Server, args []string) (err os.Error) {
+			s.FileCacheSize, err = configSize(args)
+			return
+		},
+		"file-cache-max-file": func(s *Server, args []string) (err os.Error) {
+			s.FileCacheMaxFile, err = configSize(args)
+			return
+		},
+		"reuse-port": func(s *Server, args []string) (err os.Error) {
+			s.ReusePort, err = configBool(args)
+			return
+		},
+		"shards": func(s *Server, args []string) (err os.Error) {
+			s.Shards, err = configInt(args)
+			return
+		},
+		"handler-timeout": func(s *Server, args []string) os.Error {
+			seconds, err := configInt(args)
+			s.HandlerTimeout = int64(seconds) * 1e9
+			return err
+		},
+		"admin-menu": func(s *Server, args []string) os.Error {
+			return s.configureAdmin(args)
+		},
+		"stats-selector": func(s *Server, args []string) (err os.Error) {
+			var sel string
+			if sel, err = configString(args); err == nil {
+				s.StatsSelector = "/" + strings.Trim(sel, "/")
+			}
+			return
+		},
+		"compress": func(s *Server, args []string) (err os.Error) {
+			s.Compress, err = configBool(args)
+			return
+		},
+		"compress-min-size": func(s *Server, args []string) (err os.Error) {
+			var n int
+			n, err = configInt(args)
+			s.CompressMinSize = int64(n)
+			return
+		},
+		"compress-types": func(s *Server, args []string) os.Error {
+			return s.configureCompressTypes(args)
+		},
+		"control": func(s *Server, args []string) (err os.Error) {
+			s.ControlSocket, err = configString(args)
+			return
+		},
+		"unix-socket": func(s *Server, args []string) (err os.Error) {
+			s.UnixSocket, err = configString(args)
+			return
+		},
+		"max-file-size": func(s *Server, args []string) (err os.Error) {
+			s.MaxFileSize, err = configSize(args)
+			return
+		},
+		"max-response-size": func(s *Server, args []string) (err os.Error) {
+			s.MaxResponseSize, err = configSize(args)
+			return
+		},
+		"workers": func(s *Server, args []string) (err os.Error) {
+			s.Workers, err = configInt(args)
+			return
+		},
+		"queue-length": func(s *Server, args []string) (err os.Error) {
+			s.QueueLength, err = configInt(args)
+			return
+		},
+		"proxy-protocol": func(s *Server, args []string) (err os.Error) {
+			s.ProxyProtocol, err = configBool(args)
+			return
+		},
+		"trace": func(s *Server, args []string) (err os.Error) {
+			s.Trace, err = configBool(args)
+			return
+		},
+		"watch": func(s *Server, args []string) (err os.Error) {
+			s.WatchFiles, err = configBool(args)
+			return
+		},
+		"archives": func(s *Server, args []string) (err os.Error) {
+			s.Archives, err = configBool(args)
+			return
+		},
+		"forbid-telnet": func(s *Server, args []string) (err os.Error) {
+			s.ForbidTelnet, err = configBool(args)
+			return
+		},
+		"finger": func(s *Server, args []string) os.Error {
+			if len(args) != 2 {
+				return os.NewError("expected an address and a directory of home directories")
+			}
+			s.FingerAddr, s.FingerHomes = args[0], args[1]
+			return nil
+		},
+		"gemini": func(s *Server, args []string) (err os.Error) {
+			s.GeminiAddr, err = configString(args)
+			return
+		},
+		"gemini-cert": func(s *Server, args []string) (err os.Error) {
+			s.GeminiCert, err = configString(args)
+			return
+		},
+		"gemini-key": func(s *Server, args []string) (err os.Error) {
+			s.GeminiKey, err = configString(args)
+			return
+		},
+		"log": func(s *Server, args []string) os.Error {
+			if len(args) == 0 {
+				return os.NewError("expected stdout, stderr, syslog or a file name")
+			}
+			return s.SetLogSinks(args)
+		},
+		"log-level": func(s *Server, args []string) (err os.Error) {
+			var level string
+			if level, err = configString(args); err == nil {
+				s.LogLevel, err = ParseLogLevel(level)
+			}
+			return
+		},
+		"log-area": func(s *Server, args []string) os.Error {
+			return s.configureLogArea(args)
+		},
+		"policy": func(s *Server, args []string) os.Error {
+			return s.subsystems.configure(args)
+		},
+		"mirror": func(s *Server, args []string) os.Error {
+			return s.configureMirror(args)
+		},
+		"feature": func(s *Server, args []string) os.Error {
+			return s.features.configure(args)
+		},
+		"chroot": func(s *Server, args []string) (err os.Error) {
+			s.Chroot, err = configBool(args)
+			return
+		},
+		"user": func(s *Server, args []string) (err os.Error) {
+			s.User, err = configString(args)
+			return
+		},
+		"group": func(s *Server, args []string) (err os.Error) {
+			s.Group, err = configString(args)
+			return
+		},
+		"allow": func(s *Server, args []string) os.Error {
+			r, err := ParseACLRule(true, args)
+			if err == nil {
+				s.AddACLRule(r)
+			}
+			return err
+		},
+		"deny": func(s *Server, args []string) os.Error {
+			r, err := ParseACLRule(false, args)
+			if err == nil {
+				s.AddACLRule(r)
+			}
+			return err
+		},
+		"deny-message": func(s *Server, args []string) os.Error {
+			if len(args) == 0 {
+				return os.NewError("expected a message")
+			}
+			s.DenyMessage = strings.Join(args, " ")
+			return nil
+		},
+		"protect": func(s *Server, args []string) os.Error {
+			return s.configureProtect(args)
+		},
+		"phlog": func(s *Server, args []string) os.Error {
+			prefix, err := configString(args)
+			if err == nil {
+				s.AddPhlog(prefix)
+			}
+			return err
+		},
+		"guestbook": func(s *Server, args []string) os.Error {
+			return s.configureGuestbook(args)
+		},
+		"dropbox": func(s *Server, args []string) os.Error {
+			return s.configureDropbox(args)
+		},
+		"ccso": func(s *Server, args []string) os.Error {
+			if len(args) != 2 {
+				return os.NewError("expected a selector and a CCSO server's host:port")
+			}
+			s.AddCCSOGateway(args[0], args[1], "")
+			return nil
+		},
+		"phonebook": func(s *Server, args []string) os.Error {
+			if len(args) != 2 {
+				return os.NewError("expected a selector and a phone book file")
+			}
+			s.AddCCSOGateway(args[0], "", args[1])
+			return nil
+		},
+		"proxy": func(s *Server, args []string) os.Error {
+			return s.configureProxy(args)
+		},
+		"proxy-balance": func(s *Server, args []string) (err os.Error) {
+			var mode string
+			if mode, err = configString(args); err == nil {
+				s.ProxyBalance, err = ParseBalanceMode(mode)
+			}
+			return
+		},
+		"advertise-host": func(s *Server, args []string) (err os.Error) {
+			s.AdvertiseHost, err = configString(args)
+			return
+		},
+		"advertise-port": func(s *Server, args []string) (err os.Error) {
+			s.AdvertisePort, err = configInt(args)
+			return
+		},
+		"advertise": func(s *Server, args []string) os.Error {
+			return s.configureAdvertise(args)
+		},
+		"rewrite": func(s *Server, args []string) os.Error {
+			return s.configureRewrite(args)
+		},
+		"alias": func(s *Server, args []string) os.Error {
+			return s.configureRedirect(true, args)
+		},
+		"redirect": func(s *Server, args []string) os.Error {
+			return s.configureRedirect(false, args)
+		},
+		"index-mode": func(s *Server, args []string) (err os.Error) {
+			var mode string
+			if mode, err = configString(args); err == nil {
+				s.IndexMode, err = ParseIndexMode(mode)
+			}
+			return
+		},
+		"markdown": func(s *Server, args []string) (err os.Error) {
+			var mode string
+			if mode, err = configString(args); err == nil {
+				s.MarkdownMode, err = ParseMarkdownMode(mode)
+			}
+			return
+		},
+		"markdown-width": func(s *Server, args []string) (err os.Error) {
+			s.MarkdownWidth, err = configInt(args)
+			return
+		},
+		"item-type": func(s *Server, args []string) os.Error {
+			return s.configureItemType(args)
+		},
+		"thumbnails": func(s *Server, args []string) (err os.Error) {
+			s.Thumbnails, err = configBool(args)
+			return
+		},
+		"thumbnail-size": func(s *Server, args []string) (err os.Error) {
+			s.ThumbnailSize, err = configInt(args)
+			return
+		},
+		"keep-alive": func(s *Server, args []string) (err os.Error) {
+			s.KeepAlive, err = configBool(args)
+			return
+		},
+		"reflow": func(s *Server, args []string) (err os.Error) {
+			if len(args) == 0 {
+				s.ReflowWidth = DefaultReflowWidth
+				return nil
+			}
+			s.ReflowWidth, err = configInt(args)
+			return
+		},
+		"index-files": func(s *Server, args []string) os.Error {
+			if len(args) == 0 {
+				return os.NewError("expected one or more file names")
+			}
+			s.IndexFiles = args
+			return nil
+		},
+		"exec": func(s *Server, args []string) os.Error {
+			return s.configureCommand(args)
+		},
+		"git": func(s *Server, args []string) os.Error {
+			return s.configureGit(args)
+		},
+		"s3": func(s *Server, args []string) os.Error {
+			return s.configureS3(args)
+		},
+		"sqlite": func(s *Server, args []string) os.Error {
+			return s.configureSQLite(args)
+		},
+		"journal": func(s *Server, args []string) os.Error {
+			return s.configureJournal(args)
+		},
+	}
+}
+
+// LoadConfig reads a configuration file and applies it to the server
+func (s *Server) LoadConfig(name string) os.Error {
+	s.configFile = name
+	file, err := os.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var vhost *VirtualHost
+	for lineno := 1; ; lineno++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != os.EOF {
+			return err
+		}
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if derr := s.configLine(&vhost, fields); derr != nil {
+				return os.NewError(fmt.Sprintf("%s:%d: %s: %s", name, lineno, fields[0], derr))
+			}
+		}
+		if err == os.EOF {
+			break
+		}
+	}
+	if vhost != nil {
+		return os.NewError(fmt.Sprintf("%s: vhost %s is missing its end", name, vhost.Name))
+	}
+	return s.mountArchives()
+}
+
+// configLine applies one configuration line, tracking the vhost block the
+// line is in
+func (s *Server) configLine(vhost **VirtualHost, fields []string) os.Error {
+	switch {
+	case fields[0] == "vhost":
+		if *vhost != nil {
+			return os.NewError("vhost blocks cannot be nested")
+		}
+		name, err := configString(fields[1:])
+		if err != nil {
+			return err
+		}
+		*vhost = &VirtualHost{Name: name}
+		s.vhosts = append(s.vhosts, *vhost)
+		return nil
+	case fields[0] == "end" && *vhost != nil:
+		*vhost = nil
+		return nil
+	case *vhost != nil:
+		directive, ok := vhostDirectives[fields[0]]
+		if !ok {
+			return os.NewError("unknown directive in a vhost block")
+		}
+		return directive(*vhost, fields[1:])
+	}
+	directive, ok := configDirectives[fields[0]]
+	if !ok {
+		return os.NewError("unknown directive")
+	}
+	return directive(s, fields[1:])
+}
+
+func configString(args []string) (string, os.Error) {
+	if len(args) != 1 {
+		return "", os.NewError("expected exactly one argument")
+	}
+	return args[0], nil
+}
+
+func configInt(args []string) (int, os.Error) {
+	arg, err := configString(args)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(arg)
+}
+
+func configBool(args []string) (bool, os.Error) {
+	arg, err := configString(args)
+	if err != nil {
+		return false, err
+	}
+	switch arg {
+	case "on", "yes", "true":
+		return true, nil
+	case "off", "no", "false":
+		return false, nil
+	}
+	return false, os.NewError(fmt.Sprintf("expected on or off, got `%s'", arg))
+}
@@ -0,0 +1,126 @@
+package gopher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The control socket accepts one command per line and answers with any
+// output followed by a line reading "ok" or "error: <reason>".
+
+// controlCommand runs one control socket command, writing output to w
+type controlCommand func(s *Server, w io.Writer, args []string) os.Error
+
+var controlCommands map[string]controlCommand
+
+func init() {
+	controlCommands = map[string]controlCommand{
+		"help": func(s *Server, w io.Writer, args []string) os.Error {
+			names := make([]string, 0, len(controlCommands))
+			for name := range controlCommands {
+				names = append(names, name)
+			}
+			sort.SortStrings(names)
+			fmt.Fprintln(w, strings.Join(names, " "))
+			return nil
+		},
+		"subsystems": func(s *Server, w io.Writer, args []string) os.Error {
+			for _, sub := range s.Subsystems() {
+				fmt.Fprintln(w, sub)
+			}
+			return nil
+		},
+		"features": func(s *Server, w io.Writer, args []string) os.Error {
+			for _, f := range s.Features() {
+				fmt.Fprintln(w, f)
+			}
+			return nil
+		},
+		"top": func(s *Server, w io.Writer, args []string) os.Error {
+			n := 20
+			if len(args) == 1 {
+				var err os.Error
+				if n, err = strconv.Atoi(args[0]); err != nil {
+					return os.NewError("usage: top [count]")
+				}
+			}
+			for _, st := range s.TopSelectors(n) {
+				fmt.Fprintf(w, "%d %d %s\n", st.Hits, st.Bytes, st.Selector)
+			}
+			return nil
+		},
+		"maintenance": func(s *Server, w io.Writer, args []string) os.Error {
+			if len(args) != 1 || args[0] != "on" && args[0] != "off" {
+				return os.NewError("usage: maintenance on|off")
+			}
+			s.SetMaintenance(args[0] == "on")
+			return nil
+		},
+		"switch-roots": func(s *Server, w io.Writer, args []string) os.Error {
+			return s.SwitchRoots()
+		},
+		"feature": func(s *Server, w io.Writer, args []string) os.Error {
+			if len(args) != 2 {
+				return os.NewError("usage: feature name on|off|N%")
+			}
+			percent, err := parsePercent(args[1])
+			if err != nil {
+				return err
+			}
+			return s.SetFeature(args[0], percent)
+		},
+	}
+}
+
+// ServeControl listens for control commands on the unix socket at path
+func (s *Server) ServeControl(path string) os.Error {
+	listener, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	return s.serveControl(listener)
+}
+
+func (s *Server) serveControl(listener net.Listener) os.Error {
+	s.Logger.Printf("control socket listening on %s...\n", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.control(conn)
+	}
+	return nil
+}
+
+func (s *Server) control(conn net.Conn) {
+	defer conn.Close()
+	defer s.recoverConn("control")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		command, ok := controlCommands[fields[0]]
+		if !ok {
+			fmt.Fprintf(conn, "error: unknown command `%s'\n", fields[0])
+			continue
+		}
+		if err := command(s, conn, fields[1:]); err != nil {
+			fmt.Fprintf(conn, "error: %s\n", err)
+		} else {
+			fmt.Fprintln(conn, "ok")
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package gopher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A drop box at prefix takes files sent after the request line:
+//    prefix<TAB>name.txt<CR><LF>
+//    ...data, until the client closes its end...
+// The optional name is only a hint. Each file is written to Dir under a
+// name of its own, such as 20110304-150405-4d7a1b2c-1f-name.txt, readable
+// only by the server's user, so nothing submitted is ever served or run
+// until someone moves it. Files larger than MaxSize are discarded.
+//
+// Drop boxes whose directory is missing at startup or after a reload are
+// down, and answer with the "dropbox" subsystem's notice. A reload that
+// would lose one under a fatal policy is refused.
+
+// DefaultDropboxMaxSize is the largest file accepted unless MaxSize is set
+var DefaultDropboxMaxSize int64 = 1 << 20
+
+// DropboxTimeout is how long a client may take to send a file, in
+// nanoseconds
+var DropboxTimeout int64 = 60e9
+
+// Dropbox accepts files sent to a selector
+type Dropbox struct {
+	Prefix  string
+	Dir     string // Quarantine directory files are written to
+	MaxSize int64  // Largest file accepted, in bytes
+}
+
+// AddDropbox accepts files sent to prefix, writing them to dir
+func (s *Server) AddDropbox(prefix string, dir string, maxSize int64) {
+	if maxSize <= 0 {
+		maxSize = DefaultDropboxMaxSize
+	}
+	s.dropboxes = append(s.dropboxes, &Dropbox{"/" + strings.Trim(prefix, "/"), dir, maxSize})
+}
+
+// configureDropbox handles a `dropbox prefix dir [max-bytes]' line
+func (s *Server) configureDropbox(args []string) os.Error {
+	if len(args) != 2 && len(args) != 3 {
+		return os.NewError("expected a selector, a directory and an optional size limit")
+	}
+	var maxSize int64
+	if len(args) == 3 {
+		var err os.Error
+		if maxSize, err = strconv.Atoi64(args[2]); err != nil || maxSize <= 0 {
+			return os.NewError("expected a size limit in bytes")
+		}
+	}
+	s.AddDropbox(args[0], args[1], maxSize)
+	return nil
+}
+
+// dropboxFor returns the drop box at selector, if any
+func (s *Server) dropboxFor(selector string) *Dropbox {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, d := range s.dropboxes {
+		if selector == d.Prefix {
+			return d
+		}
+	}
+	return nil
+}
+
+// dropboxError reports the first drop box whose directory is missing
+func (s *Server) dropboxError() os.Error {
+	for _, d := range s.dropboxes {
+		if info, err := os.Stat(d.Dir); err != nil || !info.IsDirectory() {
+			return os.NewError(d.Dir + " is not a directory")
+		}
+	}
+	return nil
+}
+
+// checkDropboxes takes the drop box subsystem down if a directory is
+// missing
+func (s *Server) checkDropboxes() {
+	if err := s.dropboxError(); err != nil {
+		s.Degrade("dropbox", err)
+	} else if len(s.dropboxes) > 0 {
+		s.Recover("dropbox")
+	}
+}
+
+// uploadName returns a file name for an upload that no other can have,
+// keeping the safe characters of the client's hint
+func uploadName(ctx *Context, hint string) string {
+	hint = strings.Map(func(c int) int {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+			return c
+		}
+		return -1
+	}, hint)
+	hint = strings.TrimLeft(hint, ".")
+	if len(hint) > 64 {
+		hint = hint[:64]
+	}
+	name := time.LocalTime().Format("20060102-150405") + "-" + ctx.ID
+	if hint != "" {
+		name += "-" + hint
+	}
+	return name
+}
+
+// serveDropbox stores the file sent with the request
+func (s *Server) serveDropbox(ctx *Context, d *Dropbox) (Status, os.Error) {
+	if !s.SubsystemUp("dropbox") {
+		ctx.Error(s.subsystemNotice("dropbox"))
+		return StatusError, os.NewError("drop box unavailable")
+	}
+	name := d.Dir + "/" + uploadName(ctx, ctx.Query)
+	file, err := os.Open(name, os.O_WRONLY|os.O_CREAT|os.O_EXCL, 0600)
+	if err != nil {
+		return StatusError, err
+	}
+	ctx.conn.SetReadTimeout(DropboxTimeout)
+	n, err := io.Copyn(file, ctx.reader, d.MaxSize+1)
+	file.Close()
+	switch {
+	case err != nil && err != os.EOF:
+		os.Remove(name)
+		return StatusError, err
+	case n == 0:
+		os.Remove(name)
+		ctx.Error("Nothing was received")
+		return StatusBadRequest, os.NewError("empty upload")
+	case n > d.MaxSize:
+		os.Remove(name)
+		ctx.Error(fmt.Sprintf("Files may be at most %d bytes", d.MaxSize))
+		return StatusBadRequest, os.NewError("upload too large")
+	}
+	w := NewEntryWriter(ctx)
+	w.Info(fmt.Sprintf("Received %d bytes, thank you.", n))
+	ctx.Logf("Received upload `%s' of %d bytes from %s\n", name, n, ctx.ClientIP())
+	return StatusOK, w.Close()
+}
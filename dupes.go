@@ -0,0 +1,107 @@
+package gopher
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Duplicate handling modes for automatic directory listings
+const (
+	DupesOff = iota
+	DupesAnnotate
+	DupesCollapse
+)
+
+// ParseDupesMode converts a command line mode name into a Dupes* constant
+func ParseDupesMode(mode string) (int, os.Error) {
+	switch mode {
+	case "", "off":
+		return DupesOff, nil
+	case "annotate":
+		return DupesAnnotate, nil
+	case "collapse":
+		return DupesCollapse, nil
+	}
+	return DupesOff, os.NewError(fmt.Sprintf("unknown duplicate mode `%s'", mode))
+}
+
+// findDuplicates maps the name of every duplicate entry in a directory to the
+// name of the entry it duplicates. Hard links are detected by device and
+// inode; if checksums are enabled, regular files of equal size are hashed
+// too. The canonical entry of a group is the one with the lowest name.
+func (s *Server) findDuplicates(dir string, entries []os.FileInfo) (dupes map[string]string) {
+	dupes = make(map[string]string)
+	if s.Dupes == DupesOff {
+		return
+	}
+	names := make([]string, 0, len(entries))
+	byName := make(map[string]*os.FileInfo)
+	for i := range entries {
+		if entries[i].IsRegular() {
+			names = append(names, entries[i].Name)
+			byName[entries[i].Name] = &entries[i]
+		}
+	}
+	sort.SortStrings(names)
+
+	inodes := make(map[string]string)
+	for _, name := range names {
+		entry := byName[name]
+		if entry.Nlink < 2 {
+			continue
+		}
+		key := fmt.Sprintf("%d:%d", entry.Dev, entry.Ino)
+		if canon, seen := inodes[key]; seen {
+			dupes[name] = canon
+		} else {
+			inodes[key] = name
+		}
+	}
+	if !s.DupeChecksums {
+		return
+	}
+
+	sizes := make(map[int64]int)
+	for _, name := range names {
+		if _, dup := dupes[name]; !dup {
+			sizes[byName[name].Size]++
+		}
+	}
+	sums := make(map[string]string)
+	for _, name := range names {
+		entry := byName[name]
+		if _, dup := dupes[name]; dup || entry.Size == 0 || sizes[entry.Size] < 2 {
+			continue
+		}
+		sum, err := checksumFile(dir + "/" + name)
+		if err != nil {
+			s.Logger.Printf("Could not checksum `%s/%s': %s\n", dir, name, err)
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", entry.Size, sum)
+		if canon, seen := sums[key]; seen {
+			dupes[name] = canon
+		} else {
+			sums[key] = name
+		}
+	}
+	return
+}
+
+// checksumFile returns the hex encoded MD5 digest of the file's contents
+func checksumFile(name string) (sum string, err os.Error) {
+	file, err := os.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return
+	}
+	return hex.EncodeToString(hash.Sum()), nil
+}
@@ -0,0 +1,54 @@
+// embedded runs a gopher server with a few handlers written in Go next to
+// the files in the working directory
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopher"
+	"time"
+)
+
+func main() {
+	var hostname *string = flag.String("hostname", "localhost", "hostname of the server")
+	var port *int = flag.Int("port", 7070, "port of the server")
+	flag.Parse()
+
+	server := gopher.NewServer()
+	server.HandleFunc("^/$", func(ctx *gopher.Context) gopher.Status {
+		menu := gopher.NewMenu(ctx)
+		menu.Info("An embedded gopher server")
+		menu.Info("")
+		menu.Item('0', "The time", "/time")
+		menu.Item('1', "Server statistics", "/stats")
+		menu.Item('1', "Files", "/files")
+		menu.Item('0', "Say hello (needs a Gopher+ client)", "/hello")
+		menu.WriteTo(ctx)
+		return gopher.StatusOK
+	})
+	server.HandleFunc("^/time$", func(ctx *gopher.Context) gopher.Status {
+		ctx.Write(time.LocalTime().String())
+		ctx.Write(".")
+		return gopher.StatusOK
+	})
+	server.HandleFunc("^/stats$", func(ctx *gopher.Context) gopher.Status {
+		stats := ctx.Server.Stats()
+		menu := gopher.NewMenu(ctx)
+		menu.Info(fmt.Sprintf("Requests served: %d", stats.Requests))
+		menu.Info(fmt.Sprintf("Bytes sent:      %d", stats.BytesSent))
+		menu.Info(fmt.Sprintf("Errors:          %d", stats.Errors))
+		menu.WriteTo(ctx)
+		return gopher.StatusOK
+	})
+	form := &gopher.Form{Title: "Say hello"}
+	form.Add(gopher.Note, "Tell us who you are.")
+	form.Add(gopher.Ask, "Your name", "anonymous")
+	form.Add(gopher.Choose, "Favourite item type", "0", "1", "7")
+	server.HandleForm("^/hello$", form, func(ctx *gopher.Context, answers []string) gopher.Status {
+		ctx.Write(fmt.Sprintf("Hello %s, type %s is a fine choice.", answers[0], answers[1]))
+		ctx.Write(".")
+		return gopher.StatusOK
+	})
+	// Everything else, including /files, falls through to the working directory
+	server.Run(*hostname, *port)
+}
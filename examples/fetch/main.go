@@ -0,0 +1,46 @@
+// fetch prints a gopher item using the client half of the gopher package.
+//
+// Usage: fetch [-type 1] host[:port] [selector]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopher"
+	"io"
+	"os"
+)
+
+func main() {
+	var itemType *string = flag.String("type", "1", "item type of the selector: 0 for text, 1 for a menu, anything else is copied raw")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: fetch [-type 1] host[:port] [selector]")
+		os.Exit(2)
+	}
+	response, err := gopher.Get(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer response.Close()
+	switch *itemType {
+	case "1":
+		entries, err := response.Menu()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, entry := range entries {
+			if entry.Type == 'i' {
+				fmt.Printf("      %s\n", entry.Display)
+			} else {
+				fmt.Printf("[%c]   %s -> %s:%d%s\n", entry.Type, entry.Display, entry.Host, entry.Port, entry.Selector)
+			}
+		}
+	case "0":
+		io.Copy(os.Stdout, response.Text())
+	default:
+		io.Copy(os.Stdout, response)
+	}
+}
@@ -0,0 +1,33 @@
+// gemini serves the working directory over Gopher and, from the same
+// files, gophermaps and handlers, over Gemini.
+//
+// Usage: gemini [-gemini :1965] -cert cert.pem -key key.pem
+//
+// Menus reach Gemini clients as gemtext; everything else is sent as it is.
+package main
+
+import (
+	"flag"
+	"gopher"
+	"time"
+)
+
+func main() {
+	var geminiAddr *string = flag.String("gemini", ":1965", "address of the Gemini listener")
+	var cert *string = flag.String("cert", "cert.pem", "TLS certificate of the Gemini listener")
+	var key *string = flag.String("key", "key.pem", "TLS key of the Gemini listener")
+	var hostname *string = flag.String("hostname", "localhost", "hostname of the server")
+	var port *int = flag.Int("port", 7070, "port of the server")
+	flag.Parse()
+
+	server := gopher.NewServer()
+	server.GeminiAddr = *geminiAddr
+	server.GeminiCert = *cert
+	server.GeminiKey = *key
+	server.HandleFunc("^/time$", func(ctx *gopher.Context) gopher.Status {
+		ctx.Write(time.LocalTime().String())
+		ctx.Write(".")
+		return gopher.StatusOK
+	})
+	server.Run(*hostname, *port)
+}
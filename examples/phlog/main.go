@@ -0,0 +1,35 @@
+// phlog serves a phlog kept in a directory of dated posts, with a small
+// front page linking to its index, archive and feed.
+//
+// Usage: phlog [-dir phlog] [-hostname localhost] [-port 7070]
+//
+// Posts are files or directories below the working directory's phlog
+// directory named like 2011-03-04-spring.txt; the first line of a post is
+// its title.
+package main
+
+import (
+	"flag"
+	"gopher"
+)
+
+func main() {
+	var dir *string = flag.String("dir", "phlog", "directory of posts, relative to the working directory")
+	var hostname *string = flag.String("hostname", "localhost", "hostname of the server")
+	var port *int = flag.Int("port", 7070, "port of the server")
+	flag.Parse()
+
+	server := gopher.NewServer()
+	server.AddPhlog(*dir)
+	server.HandleFunc("^/$", func(ctx *gopher.Context) gopher.Status {
+		menu := gopher.NewMenu(ctx)
+		menu.Info("Welcome to my phlog")
+		menu.Info("")
+		menu.Item('1', "Latest posts", "/"+*dir)
+		menu.Item('1', "Archive by month", "/"+*dir+"/archive")
+		menu.Item('0', "RSS feed", "/"+*dir+"/.rss")
+		menu.WriteTo(ctx)
+		return gopher.StatusOK
+	})
+	server.Run(*hostname, *port)
+}
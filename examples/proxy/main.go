@@ -0,0 +1,29 @@
+// proxy serves the working directory and forwards a selector subtree to
+// other gopher servers, spreading requests over them.
+//
+// Usage: proxy [-prefix /mirror] [-upstreams host:port,...] [-root /]
+//
+// A request for /mirror/docs is answered with what the upstream servers
+// serve for /docs; a server that stops answering is skipped until it
+// recovers.
+package main
+
+import (
+	"flag"
+	"gopher"
+	"strings"
+)
+
+func main() {
+	var prefix *string = flag.String("prefix", "/mirror", "selector prefix to forward")
+	var upstreams *string = flag.String("upstreams", "gopher.floodgap.com:70", "comma separated host:port of the upstream servers")
+	var root *string = flag.String("root", "", "selector on the upstream servers the prefix stands for")
+	var hostname *string = flag.String("hostname", "localhost", "hostname of the server")
+	var port *int = flag.Int("port", 7070, "port of the server")
+	flag.Parse()
+
+	server := gopher.NewServer()
+	server.AddProxy(*prefix, strings.Split(*upstreams, ",", -1), *root)
+	server.ProxyBalance = gopher.BalanceLeastConn
+	server.Run(*hostname, *port)
+}
@@ -0,0 +1,149 @@
+package gopher
+
+import (
+	"exec"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A gophermap line of the form
+//    =name
+// or
+//    exec:name
+// runs the command configured under name and splices its output into the
+// menu. Output lines holding a tab are read as gophermap item lines, the
+// rest become info lines. Only commands named in the configuration can be
+// run; a gophermap cannot name a program directly.
+//
+// The command runs in the gophermap's directory. As with Bucktooth moles,
+// the words of a search string are passed as extra arguments, and the
+// environment describes the request: SELECTOR, REQUEST (the selector with
+// any search string after a ?), QUERY_STRING and SEARCHREQUEST (the search
+// string), REMOTE_ADDR and REMOTE_HOST, SERVER_HOST, SERVER_NAME,
+// SERVER_PORT, SERVER_SOFTWARE and DOCUMENT_ROOT.
+
+// Command is a program gophermaps may run
+type Command struct {
+	Name string
+	Argv []string
+}
+
+// SetCommand makes the program argv available to gophermaps as name
+func (s *Server) SetCommand(name string, argv []string) {
+	if s.commands == nil {
+		s.commands = make(map[string]*Command)
+	}
+	s.commands[name] = &Command{name, argv}
+}
+
+// configureCommand handles an `exec name program arg...' line
+func (s *Server) configureCommand(args []string) os.Error {
+	if len(args) < 2 {
+		return os.NewError("expected a name and a program")
+	}
+	s.SetCommand(args[0], args[1:])
+	return nil
+}
+
+// execDirective returns the command name of a gophermap exec line, if it is
+// one
+func execDirective(line string) (name string, ok bool) {
+	switch {
+	case strings.HasPrefix(line, "="):
+		name = line[1:]
+	case strings.HasPrefix(line, "exec:"):
+		name = line[5:]
+	default:
+		return "", false
+	}
+	return strings.TrimSpace(name), true
+}
+
+// runCommand runs a configured command for a gophermap in dir and returns
+// its output lines
+func (s *Server) runCommand(ctx *Context, name string, dir string) (lines []string, err os.Error) {
+	s.reloadMu.RLock()
+	c, ok := s.commands[name]
+	s.reloadMu.RUnlock()
+	if !ok {
+		return nil, os.NewError("no command named " + name)
+	}
+	if !s.breaker.allow("exec:" + name) {
+		return nil, errBroken
+	}
+	defer func() {
+		if s.breaker.record("exec:"+name, err != nil) {
+			s.Logger.Printf("ERROR: Command %s failed %d times in a row; out of service for %ds\n", name, BreakerThreshold, BreakerCooldown/1e9)
+		}
+	}()
+	argv0, err := exec.LookPath(c.Argv[0])
+	if err != nil {
+		return
+	}
+	argv := append(append([]string{}, c.Argv...), strings.Fields(ctx.Query)...)
+	cmd, err := exec.Run(argv0, argv, s.commandEnv(ctx), dir, exec.DevNull, exec.Pipe, exec.PassThrough)
+	if err != nil {
+		return
+	}
+	defer cmd.Close()
+	if s.HandlerTimeout > 0 {
+		finished := make(chan bool, 1)
+		defer func() { finished <- true }()
+		go killAfter(cmd.Pid, s.HandlerTimeout, finished)
+	}
+	out, err := ioutil.ReadAll(cmd.Stdout)
+	if err != nil {
+		return
+	}
+	msg, err := cmd.Wait(0)
+	if err != nil {
+		return
+	}
+	if !msg.Exited() || msg.ExitStatus() != 0 {
+		return nil, os.NewError(fmt.Sprintf("%s: %s", name, msg))
+	}
+	text := strings.TrimRight(string(out), "\r\n")
+	if text == "" {
+		return nil, nil
+	}
+	lines = strings.Split(text, "\n", -1)
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines, nil
+}
+
+// killAfter kills the process pid unless finished is signalled within nsec
+// nanoseconds
+func killAfter(pid int, nsec int64, finished <-chan bool) {
+	select {
+	case <-finished:
+	case <-time.After(nsec):
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+// commandEnv returns the environment of a command run for a request, as
+// Bucktooth moles expect it
+func (s *Server) commandEnv(ctx *Context) []string {
+	request := ctx.Request
+	if ctx.Query != "" {
+		request += "?" + ctx.Query
+	}
+	return append(os.Environ(),
+		"SELECTOR="+ctx.Request,
+		"REQUEST="+request,
+		"QUERY_STRING="+ctx.Query,
+		"SEARCHREQUEST="+ctx.Query,
+		"REMOTE_ADDR="+ctx.ClientIP(),
+		"REMOTE_HOST="+ctx.ClientIP(),
+		"SERVER_HOST="+s.Hostname,
+		"SERVER_NAME="+s.Hostname,
+		fmt.Sprintf("SERVER_PORT=%d", s.Port),
+		"SERVER_SOFTWARE=gopherd",
+		"DOCUMENT_ROOT="+ctx.Host.Root)
+}
@@ -0,0 +1,179 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Export renders the site as static HTML, for putting a copy on the web.
+// Every local item reachable from the root menu is requested from the
+// server itself, so gophermaps, generated listings and handlers all come
+// out as clients see them. Menus become index.html pages in a directory
+// named after their selector, with links between pages made relative so
+// the copy works from any place; other items are written as they are.
+// Links to other servers and search items stay gopher:// links.
+
+// ExportStylesheet is put in the head of every exported page
+var ExportStylesheet = "body { font-family: monospace; white-space: pre; }"
+
+// htmlEscape escapes text for an HTML page
+func htmlEscape(text string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '&':
+			b.WriteString("&amp;")
+		case '"':
+			b.WriteString("&quot;")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// exporter renders the items of a site into a directory
+type exporter struct {
+	s     *Server
+	dir   string
+	done  map[string]bool
+	queue []*MenuEntry
+}
+
+// fetch requests selector from the server in memory and returns the reply
+func (e *exporter) fetch(selector string) (io.Reader, os.Error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go e.s.handle(e.s.newContext(server, time.Nanoseconds()))
+	if _, err := fmt.Fprintf(client, "%s\r\n", selector); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(client)
+	if err != nil && err != os.EOF {
+		return nil, err
+	}
+	return bytes.NewBuffer(data), nil
+}
+
+// pagePath returns where the page for a menu at selector goes, relative to
+// the top of the export
+func pagePath(selector string) string {
+	return path.Join(selector, "index.html")[1:]
+}
+
+// link returns the href of an entry in the page for the menu at selector
+func (e *exporter) link(selector string, entry *MenuEntry) string {
+	switch {
+	case strings.HasPrefix(entry.Selector, "URL:"):
+		return entry.Selector[4:]
+	case !e.s.advertisedLocal(entry) || entry.Type == '7' || isTelnetType(entry.Type):
+		if isTelnetType(entry.Type) {
+			return fmt.Sprintf("telnet://%s:%d", entry.Host, entry.Port)
+		}
+		return (&URL{Host: entry.Host, Port: entry.Port, Type: entry.Type, Selector: entry.Selector}).String()
+	}
+	up := strings.Repeat("../", strings.Count(strings.Trim(selector, "/"), "/")+1)
+	if selector == "/" {
+		up = ""
+	}
+	target := exportSelector(entry.Selector)
+	if entry.Type == '1' {
+		return up + pagePath(target)
+	}
+	return up + target[1:]
+}
+
+// exportSelector cleans a local selector for use as a path
+func exportSelector(selector string) string {
+	return "/" + strings.Trim(path.Clean("/"+selector), "/")
+}
+
+// page renders a menu as an HTML page
+func (e *exporter) page(selector string, entries []*MenuEntry) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n",
+		htmlEscape(e.s.Hostname+selector), ExportStylesheet)
+	for _, entry := range entries {
+		switch entry.Type {
+		case 'i':
+			b.WriteString(htmlEscape(entry.Display) + "\n")
+		case '3':
+			b.WriteString("<strong>" + htmlEscape(entry.Display) + "</strong>\n")
+		default:
+			fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", htmlEscape(e.link(selector, entry)), htmlEscape(entry.Display))
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.Bytes()
+}
+
+// export renders one item, queueing the local items of a menu
+func (e *exporter) export(entry *MenuEntry) os.Error {
+	selector := exportSelector(entry.Selector)
+	reply, err := e.fetch(selector)
+	if err != nil {
+		return err
+	}
+	name := path.Join(e.dir, selector)
+	if entry.Type == '1' {
+		name = path.Join(e.dir, pagePath(selector))
+	}
+	if err = os.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+	if entry.Type != '1' {
+		data, _ := ioutil.ReadAll(reply)
+		return ioutil.WriteFile(name, data, 0644)
+	}
+	entries, err := ParseMenu(&textReader{reader: bufio.NewReader(reply)})
+	if err != nil {
+		return err
+	}
+	for _, child := range entries {
+		local := e.s.advertisedLocal(child)
+		if local && child.Type != 'i' && child.Type != '3' && child.Type != '7' && !isTelnetType(child.Type) && !strings.HasPrefix(child.Selector, "URL:") {
+			e.queue = append(e.queue, child)
+		}
+	}
+	return ioutil.WriteFile(name, e.page(selector, entries), 0644)
+}
+
+// Export renders every local item reachable from the root menu into dir,
+// returning the number of items written. Items that cannot be rendered are
+// logged and skipped.
+func (s *Server) Export(dir string) (int, os.Error) {
+	s.init()
+	e := &exporter{s: s, dir: path.Clean(dir), done: make(map[string]bool)}
+	e.queue = []*MenuEntry{&MenuEntry{Type: '1', Selector: "/"}}
+	written := 0
+	for len(e.queue) > 0 {
+		entry := e.queue[0]
+		e.queue = e.queue[1:]
+		selector := exportSelector(entry.Selector)
+		if e.done[selector] {
+			continue
+		}
+		e.done[selector] = true
+		if err := e.export(entry); err != nil {
+			s.Logger.Printf("ERROR: Could not export `%s': %s\n", selector, err)
+			continue
+		}
+		written++
+	}
+	if written == 0 {
+		return 0, os.NewError("nothing could be exported")
+	}
+	return written, nil
+}
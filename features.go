@@ -0,0 +1,157 @@
+package gopher
+
+import (
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature gates the selectors under Prefix so they can be rolled out to a
+// share of clients before everybody sees them
+type Feature struct {
+	Name    string
+	Prefix  string
+	Percent int    // Share of clients, 0 to 100, that can see the feature
+	Host    string // Virtual host the feature is limited to, if any
+}
+
+// String renders the feature the way it is written in a configuration file
+func (f *Feature) String() string {
+	s := fmt.Sprintf("%s %s %s", f.Name, f.Prefix, formatPercent(f.Percent))
+	if f.Host != "" {
+		s += " " + f.Host
+	}
+	return s
+}
+
+// enabledFor reports whether the feature is visible to the client at ip.
+// Clients are bucketed by a hash of their address so that a client keeps
+// seeing the same thing while the percentage stays the same.
+func (f *Feature) enabledFor(ip string) bool {
+	switch {
+	case f.Percent >= 100:
+		return true
+	case f.Percent <= 0:
+		return false
+	}
+	return int(crc32.ChecksumIEEE([]byte(f.Name+" "+ip))%100) < f.Percent
+}
+
+type featureSet struct {
+	sync.RWMutex
+	features map[string]*Feature
+}
+
+// configure handles a `feature name prefix on|off|N% [vhost]' configuration
+// line
+func (fs *featureSet) configure(args []string) os.Error {
+	if len(args) != 3 && len(args) != 4 {
+		return os.NewError("expected a name, a selector prefix, on, off or a percentage and an optional vhost")
+	}
+	percent, err := parsePercent(args[2])
+	if err != nil {
+		return err
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	if fs.features == nil {
+		fs.features = make(map[string]*Feature)
+	}
+	f := &Feature{Name: args[0], Prefix: "/" + strings.Trim(args[1], "/"), Percent: percent}
+	if len(args) == 4 {
+		f.Host = args[3]
+	}
+	fs.features[f.Name] = f
+	return nil
+}
+
+// set changes the rollout percentage of an already configured feature
+func (fs *featureSet) set(name string, percent int) os.Error {
+	fs.Lock()
+	defer fs.Unlock()
+	f, ok := fs.features[name]
+	if !ok {
+		return os.NewError(fmt.Sprintf("unknown feature `%s'", name))
+	}
+	f.Percent = percent
+	return nil
+}
+
+// list returns copies of all features sorted by name
+func (fs *featureSet) list() []*Feature {
+	fs.RLock()
+	defer fs.RUnlock()
+	names := make([]string, 0, len(fs.features))
+	for name := range fs.features {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+	list := make([]*Feature, len(names))
+	for i, name := range names {
+		f := *fs.features[name]
+		list[i] = &f
+	}
+	return list
+}
+
+// hidden reports whether the selector belongs to a feature the client at ip
+// should not see on the named virtual host
+func (fs *featureSet) hidden(selector string, ip string, host string) bool {
+	fs.RLock()
+	defer fs.RUnlock()
+	selector = "/" + strings.Trim(selector, "/")
+	for _, f := range fs.features {
+		if f.Host != "" && f.Host != host {
+			continue
+		}
+		if selector == f.Prefix || strings.HasPrefix(selector, f.Prefix+"/") {
+			if !f.enabledFor(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetFeature changes the rollout percentage of a configured feature at runtime
+func (s *Server) SetFeature(name string, percent int) os.Error {
+	if err := s.features.set(name, percent); err != nil {
+		return err
+	}
+	s.Logger.Printf("Feature `%s' set to %s\n", name, formatPercent(percent))
+	return nil
+}
+
+// Features returns the configured features sorted by name
+func (s *Server) Features() []*Feature {
+	return s.features.list()
+}
+
+// parsePercent accepts on, off or a percentage such as 25%
+func parsePercent(arg string) (int, os.Error) {
+	switch arg {
+	case "on":
+		return 100, nil
+	case "off":
+		return 0, nil
+	}
+	percent, err := strconv.Atoi(strings.TrimRight(arg, "%"))
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, os.NewError(fmt.Sprintf("expected on, off or a percentage, got `%s'", arg))
+	}
+	return percent, nil
+}
+
+func formatPercent(percent int) string {
+	switch percent {
+	case 100:
+		return "on"
+	case 0:
+		return "off"
+	}
+	return fmt.Sprintf("%d%%", percent)
+}
@@ -0,0 +1,165 @@
+package gopher
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"xml"
+)
+
+// Every directory has a generated Atom feed at dir/.atom and an RSS feed
+// at dir/.rss listing its most recently changed files, so a phlog can be
+// followed with a feed reader. A real file of the same name takes
+// precedence.
+
+// FeedLength is the number of files a generated feed lists
+var FeedLength = 20
+
+// feedFormats are the generated feed selector suffixes
+var feedFormats = map[string]func(s *Server, ctx *Context, f *feed) string{
+	".atom": (*Server).atomFeed,
+	".rss":  (*Server).rssFeed,
+}
+
+// feed is a directory's recent files, newest first
+type feed struct {
+	Selector string // Selector of the directory
+	Title    string
+	Updated  int64 // Seconds since the epoch
+	Items    []*feedItem
+}
+
+type feedItem struct {
+	Title    string
+	Selector string
+	Updated  int64
+}
+
+type feedItems []*feedItem
+
+func (l feedItems) Len() int           { return len(l) }
+func (l feedItems) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l feedItems) Less(i, j int) bool { return l[i].Updated > l[j].Updated }
+
+// feedFormat returns the generator for a feed selector, if it is one
+func feedFormat(selector string) (dir string, gen func(s *Server, ctx *Context, f *feed) string, ok bool) {
+	gen, ok = feedFormats[path.Base(selector)]
+	return path.Dir(selector), gen, ok
+}
+
+// isFeedSelector reports whether selector names a generated feed
+func isFeedSelector(selector string) bool {
+	_, _, ok := feedFormat(selector)
+	return ok
+}
+
+// readFeed collects the recent files of the directory at selector
+func (s *Server) readFeed(ctx *Context, selector string) (*feed, os.Error) {
+	fs, name := s.fileSystem(ctx.Host.Root + selector)
+	entries, err := fs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	title := s.displayName(path.Base(selector))
+	if selector == "/" {
+		title = s.Hostname
+	}
+	f := &feed{Selector: selector, Title: title}
+	items := make(feedItems, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsRegular() || listingHidden(entry.Name) || strings.HasPrefix(entry.Name, ".") {
+			continue
+		}
+		sel := strings.TrimRight(selector, "/") + "/" + entry.Name
+		if !validSelector(strings.Trim(sel, "/")) || s.features.hidden(sel, ctx.ClientIP(), ctx.Host.Name) || s.unpublished(ctx, ctx.Host.Root+sel) {
+			continue
+		}
+		items = append(items, &feedItem{s.displayName(entry.Name), sel, entry.Mtime_ns / 1e9})
+	}
+	sort.Sort(items)
+	if len(items) > FeedLength {
+		items = items[:FeedLength]
+	}
+	if len(items) > 0 {
+		f.Updated = items[0].Updated
+	}
+	f.Items = items
+	return f, nil
+}
+
+// feedURL returns the gopher:// URL of a selector on this server
+func (s *Server) feedURL(ctx *Context, itemType byte, selector string) string {
+	host, port := s.advertise(selector)
+	u := &URL{Host: host, Port: port, Type: itemType, Selector: ctx.withToken(selector)}
+	return u.String()
+}
+
+// xmlText escapes text for use in an XML document
+func xmlText(text string) string {
+	var buf bytes.Buffer
+	xml.Escape(&buf, []byte(text))
+	return buf.String()
+}
+
+func (s *Server) atomFeed(ctx *Context, f *feed) string {
+	stamp := func(sec int64) string {
+		return time.SecondsToUTC(sec).Format("2006-01-02T15:04:05Z")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(&buf, "<feed xmlns=\"http://www.w3.org/2005/Atom\">\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", xmlText(f.Title))
+	fmt.Fprintf(&buf, "<id>%s</id>\n", xmlText(s.feedURL(ctx, '1', f.Selector)))
+	fmt.Fprintf(&buf, "<link href=\"%s\"/>\n", xmlText(s.feedURL(ctx, '1', f.Selector)))
+	fmt.Fprintf(&buf, "<updated>%s</updated>\n", stamp(f.Updated))
+	if s.Admin != "" {
+		fmt.Fprintf(&buf, "<author><name>%s</name></author>\n", xmlText(s.Admin))
+	}
+	for _, item := range f.Items {
+		url := xmlText(s.feedURL(ctx, '0', item.Selector))
+		fmt.Fprintf(&buf, "<entry>\n<title>%s</title>\n<id>%s</id>\n<link href=\"%s\"/>\n<updated>%s</updated>\n</entry>\n",
+			xmlText(item.Title), url, url, stamp(item.Updated))
+	}
+	fmt.Fprintf(&buf, "</feed>\n")
+	return buf.String()
+}
+
+func (s *Server) rssFeed(ctx *Context, f *feed) string {
+	stamp := func(sec int64) string {
+		return time.SecondsToUTC(sec).Format("Mon, 02 Jan 2006 15:04:05 -0700")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(&buf, "<rss version=\"2.0\">\n<channel>\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", xmlText(f.Title))
+	fmt.Fprintf(&buf, "<link>%s</link>\n", xmlText(s.feedURL(ctx, '1', f.Selector)))
+	fmt.Fprintf(&buf, "<description>%s</description>\n", xmlText(f.Title))
+	fmt.Fprintf(&buf, "<lastBuildDate>%s</lastBuildDate>\n", stamp(f.Updated))
+	for _, item := range f.Items {
+		url := xmlText(s.feedURL(ctx, '0', item.Selector))
+		fmt.Fprintf(&buf, "<item>\n<title>%s</title>\n<link>%s</link>\n<guid>%s</guid>\n<pubDate>%s</pubDate>\n</item>\n",
+			xmlText(item.Title), url, url, stamp(item.Updated))
+	}
+	fmt.Fprintf(&buf, "</channel>\n</rss>\n")
+	return buf.String()
+}
+
+// serveFeed sends the generated feed at selector as a text document
+func (s *Server) serveFeed(ctx *Context, selector string) (Status, os.Error) {
+	dir, gen, _ := feedFormat(selector)
+	f, err := s.readFeed(ctx, dir)
+	if err != nil {
+		return StatusNotFound, err
+	}
+	ctx.itemType = '0'
+	for _, line := range strings.Split(strings.TrimRight(gen(s, ctx, f), "\n"), "\n", -1) {
+		ctx.Write(line)
+	}
+	ctx.Write(".")
+	ctx.Logf("Served generated feed `%s'\n", selector)
+	return StatusOK, nil
+}
@@ -0,0 +1,149 @@
+package gopher
+
+import (
+	"strings"
+)
+
+// A gophermap line of the form
+//    !banner Welcome
+// is replaced by the text drawn in big letters, one info line per row of
+// the font, so a site gets large titles without keeping the art in its
+// maps. Letters are drawn in upper case; characters the font lacks are
+// drawn as question marks. Text too wide for a menu is broken between
+// words onto several blocks.
+
+// FigletWidth is the widest a line of big letters is drawn
+var FigletWidth = 70
+
+// figletHeight is the number of rows of every glyph of figletFont
+const figletHeight = 5
+
+// figletFont holds the rows of each character it can draw
+var figletFont = map[byte][]string{
+	'A':  []string{" ### ", "#   #", "#####", "#   #", "#   #"},
+	'B':  []string{"#### ", "#   #", "#### ", "#   #", "#### "},
+	'C':  []string{" ####", "#    ", "#    ", "#    ", " ####"},
+	'D':  []string{"#### ", "#   #", "#   #", "#   #", "#### "},
+	'E':  []string{"#####", "#    ", "#### ", "#    ", "#####"},
+	'F':  []string{"#####", "#    ", "#### ", "#    ", "#    "},
+	'G':  []string{" ####", "#    ", "#  ##", "#   #", " ####"},
+	'H':  []string{"#   #", "#   #", "#####", "#   #", "#   #"},
+	'I':  []string{"###", " # ", " # ", " # ", "###"},
+	'J':  []string{"  ###", "    #", "    #", "#   #", " ### "},
+	'K':  []string{"#   #", "#  # ", "###  ", "#  # ", "#   #"},
+	'L':  []string{"#    ", "#    ", "#    ", "#    ", "#####"},
+	'M':  []string{"#   #", "## ##", "# # #", "#   #", "#   #"},
+	'N':  []string{"#   #", "##  #", "# # #", "#  ##", "#   #"},
+	'O':  []string{" ### ", "#   #", "#   #", "#   #", " ### "},
+	'P':  []string{"#### ", "#   #", "#### ", "#    ", "#    "},
+	'Q':  []string{" ### ", "#   #", "# # #", "#  # ", " ## #"},
+	'R':  []string{"#### ", "#   #", "#### ", "#  # ", "#   #"},
+	'S':  []string{" ####", "#    ", " ### ", "    #", "#### "},
+	'T':  []string{"#####", "  #  ", "  #  ", "  #  ", "  #  "},
+	'U':  []string{"#   #", "#   #", "#   #", "#   #", " ### "},
+	'V':  []string{"#   #", "#   #", "#   #", " # # ", "  #  "},
+	'W':  []string{"#   #", "#   #", "# # #", "## ##", "#   #"},
+	'X':  []string{"#   #", " # # ", "  #  ", " # # ", "#   #"},
+	'Y':  []string{"#   #", " # # ", "  #  ", "  #  ", "  #  "},
+	'Z':  []string{"#####", "   # ", "  #  ", " #   ", "#####"},
+	'0':  []string{" ### ", "#  ##", "# # #", "##  #", " ### "},
+	'1':  []string{" # ", "## ", " # ", " # ", "###"},
+	'2':  []string{" ### ", "#   #", "  ## ", " #   ", "#####"},
+	'3':  []string{"#### ", "    #", " ### ", "    #", "#### "},
+	'4':  []string{"#   #", "#   #", "#####", "    #", "    #"},
+	'5':  []string{"#####", "#    ", "#### ", "    #", "#### "},
+	'6':  []string{" ### ", "#    ", "#### ", "#   #", " ### "},
+	'7':  []string{"#####", "   # ", "  #  ", " #   ", " #   "},
+	'8':  []string{" ### ", "#   #", " ### ", "#   #", " ### "},
+	'9':  []string{" ### ", "#   #", " ####", "    #", " ### "},
+	' ':  []string{"   ", "   ", "   ", "   ", "   "},
+	'.':  []string{"  ", "  ", "  ", "  ", "# "},
+	',':  []string{"  ", "  ", "  ", "# ", "# "},
+	'!':  []string{"#", "#", "#", " ", "#"},
+	'?':  []string{" ### ", "#   #", "  ## ", "     ", "  #  "},
+	'-':  []string{"    ", "    ", "####", "    ", "    "},
+	':':  []string{" ", "#", " ", "#", " "},
+	'\'': []string{"#", "#", " ", " ", " "},
+	'/':  []string{"    #", "   # ", "  #  ", " #   ", "#    "},
+	'&':  []string{" ##  ", "#  # ", " ## #", "#  # ", " ## #"},
+	'+':  []string{"     ", "  #  ", "#####", "  #  ", "     "},
+	'_':  []string{"     ", "     ", "     ", "     ", "#####"},
+	'(':  []string{" #", "# ", "# ", "# ", " #"},
+	')':  []string{"# ", " #", " #", " #", "# "},
+	'*':  []string{"     ", "# # #", " ### ", "# # #", "     "},
+	'#':  []string{" # # ", "#####", " # # ", "#####", " # # "},
+	'=':  []string{"    ", "####", "    ", "####", "    "},
+}
+
+// figletDirective returns the text of a gophermap banner line, if it is one
+func figletDirective(line string) (string, bool) {
+	if !strings.HasPrefix(line, "!banner ") {
+		return "", false
+	}
+	return strings.TrimSpace(line[len("!banner "):]), true
+}
+
+// figletGlyph returns the rows of a character in figletFont
+func figletGlyph(c int) []string {
+	if 'a' <= c && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if c < 0x80 {
+		if g, ok := figletFont[byte(c)]; ok {
+			return g
+		}
+	}
+	return figletFont['?']
+}
+
+// figletWidth returns the width of text drawn in figletFont, with a column
+// between characters
+func figletWidth(text string) int {
+	width := 0
+	for _, c := range text {
+		width += len(figletGlyph(c)[0]) + 1
+	}
+	return width
+}
+
+// figletBlock draws one line of text in figletFont
+func figletBlock(text string) []string {
+	rows := make([]string, figletHeight)
+	for _, c := range text {
+		for r, row := range figletGlyph(c) {
+			rows[r] += row + " "
+		}
+	}
+	for r := range rows {
+		rows[r] = strings.TrimRight(rows[r], " ")
+	}
+	return rows
+}
+
+// Figlet draws text in big letters, breaking it between words into blocks
+// no wider than FigletWidth and separating the blocks by a blank line
+func Figlet(text string) []string {
+	var lines []string
+	line := ""
+	flush := func() {
+		if line == "" {
+			return
+		}
+		if lines != nil {
+			lines = append(lines, "")
+		}
+		lines = append(lines, figletBlock(line)...)
+		line = ""
+	}
+	for _, word := range strings.Fields(text) {
+		if line != "" && figletWidth(line+" "+word) > FigletWidth {
+			flush()
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	flush()
+	return lines
+}
@@ -0,0 +1,155 @@
+package gopher
+
+import (
+	"container/list"
+	"os"
+	"path"
+	"sync"
+)
+
+// With FileCacheSize set, regular files no larger than FileCacheMaxFile
+// are mapped into memory when first served and answered from there after,
+// the least recently used being dropped when the cache would grow past
+// FileCacheSize bytes. With WatchFiles on, cached files are dropped as
+// soon as the file system reports a change and hits need no system calls
+// at all; otherwise every hit costs a stat to see that the file is
+// unchanged. Only plain requests are answered from the cache; Gopher+
+// requests and resumed transfers open the file as usual.
+
+// DefaultFileCacheMaxFile is the largest file cached when
+// FileCacheMaxFile is not set
+const DefaultFileCacheMaxFile = 64 * 1024
+
+// cachedFile is a file mapped into memory
+type cachedFile struct {
+	name    string
+	data    []byte
+	mtime   int64
+	size    int64
+	ino     uint64
+	watched bool          // Whether changes to the file are reported
+	refs    int           // Responses still sending data
+	dropped bool          // Out of the cache, to be unmapped once unused
+	elem    *list.Element // Place in the recently used list
+}
+
+// fileCache holds mapped files by absolute path
+type fileCache struct {
+	sync.Mutex
+	files map[string]*cachedFile
+	lru   list.List // Most recently used at the front
+	bytes int64
+}
+
+// maxCachedFile returns the largest file the server caches
+func (s *Server) maxCachedFile() int64 {
+	if s.FileCacheMaxFile > 0 {
+		return s.FileCacheMaxFile
+	}
+	return DefaultFileCacheMaxFile
+}
+
+// cachedData returns the cached contents of the file at name if they are
+// still current. release must be called once the data has been sent.
+func (s *Server) cachedData(name string) (data []byte, release func(), ok bool) {
+	c := &s.files
+	c.Lock()
+	f, found := c.files[name]
+	if found {
+		f.refs++
+		c.lru.MoveToFront(f.elem)
+	}
+	c.Unlock()
+	if !found {
+		return nil, nil, false
+	}
+	release = func() { c.release(f) }
+	if !f.watched {
+		info, err := os.Stat(name)
+		if err != nil || info.Mtime_ns != f.mtime || info.Size != f.size || info.Ino != f.ino {
+			release()
+			c.drop(name)
+			return nil, nil, false
+		}
+	}
+	return f.data, release, true
+}
+
+// cacheFile maps an opened file into the cache if it fits
+func (s *Server) cacheFile(name string, file *os.File, info *os.FileInfo) {
+	if s.FileCacheSize <= 0 || !info.IsRegular() || info.Size == 0 || info.Size > s.maxCachedFile() || s.tooLarge(info) {
+		return
+	}
+	var generation int64
+	watched := false
+	if s.sidecars != nil {
+		generation, watched = s.sidecars.watch(path.Dir(name))
+	}
+	data, err := mapFile(file, info.Size)
+	if err != nil {
+		s.Logger.Printf("ERROR: Could not map `%s': %s\n", name, err)
+		return
+	}
+	f := &cachedFile{name: name, data: data, mtime: info.Mtime_ns, size: info.Size, ino: info.Ino, watched: watched}
+	if watched && s.sidecars.changedSince(generation) {
+		// The file may have changed while it was being mapped
+		unmapFile(data)
+		return
+	}
+	c := &s.files
+	c.Lock()
+	defer c.Unlock()
+	if c.files == nil {
+		c.files = make(map[string]*cachedFile)
+	}
+	if _, found := c.files[name]; found {
+		unmapFile(data)
+		return
+	}
+	for c.bytes+f.size > s.FileCacheSize && c.lru.Len() > 0 {
+		c.evict(c.lru.Back().Value.(*cachedFile))
+	}
+	f.elem = c.lru.PushFront(f)
+	c.files[name] = f
+	c.bytes += f.size
+}
+
+// evict takes a file out of the cache. The cache must be locked.
+func (c *fileCache) evict(f *cachedFile) {
+	c.files[f.name] = nil, false
+	c.lru.Remove(f.elem)
+	c.bytes -= f.size
+	f.dropped = true
+	if f.refs == 0 {
+		unmapFile(f.data)
+	}
+}
+
+// drop takes the file at name out of the cache, if it is there
+func (c *fileCache) drop(name string) {
+	c.Lock()
+	defer c.Unlock()
+	if f, found := c.files[name]; found {
+		c.evict(f)
+	}
+}
+
+// clear empties the cache
+func (c *fileCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	for _, f := range c.files {
+		c.evict(f)
+	}
+}
+
+// release notes that a response is done with a cached file, unmapping it
+// if it has left the cache meanwhile
+func (c *fileCache) release(f *cachedFile) {
+	c.Lock()
+	defer c.Unlock()
+	f.refs--
+	if f.refs == 0 && f.dropped {
+		unmapFile(f.data)
+	}
+}
@@ -0,0 +1,123 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// With FingerAddr set the server also answers finger requests there,
+// usually on port 79, from the .plan and .project files of the users whose
+// home directories are in FingerHomes. Only users with a .plan are known;
+// a request without a user name lists them. Forwarding (user@host) is
+// refused.
+
+// FingerTimeout is how long a finger client has to send its request, in
+// nanoseconds
+var FingerTimeout int64 = 10e9
+
+// validFingerUser reports whether name can be a user name
+func validFingerUser(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// fingerUsers returns the users with a .plan
+func (s *Server) fingerUsers() []string {
+	dir, err := os.Open(s.FingerHomes, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	defer dir.Close()
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		return nil
+	}
+	var users []string
+	for _, info := range infos {
+		if _, err := os.Stat(s.FingerHomes + "/" + info.Name + "/.plan"); err == nil && validFingerUser(info.Name) {
+			users = append(users, info.Name)
+		}
+	}
+	sort.SortStrings(users)
+	return users
+}
+
+// finger returns the answer to a finger query
+func (s *Server) finger(query string) string {
+	var buf bytes.Buffer
+	query = strings.TrimSpace(query)
+	// Verbose requests look like /W user
+	if strings.HasPrefix(query, "/W") {
+		query = strings.TrimSpace(query[2:])
+	}
+	switch {
+	case query == "":
+		users := s.fingerUsers()
+		if len(users) == 0 {
+			buf.WriteString("No one here has a plan.\n")
+		}
+		for _, user := range users {
+			buf.WriteString(user + "\n")
+		}
+	case strings.Index(query, "@") != -1:
+		buf.WriteString("Finger forwarding is not supported.\n")
+	case !validFingerUser(query):
+		fmt.Fprintf(&buf, "finger: %s: no such user.\n", query)
+	default:
+		home := s.FingerHomes + "/" + query
+		plan, err := ioutil.ReadFile(home + "/.plan")
+		if err != nil {
+			fmt.Fprintf(&buf, "finger: %s: no such user.\n", query)
+			break
+		}
+		fmt.Fprintf(&buf, "Login: %s\n", query)
+		if project, err := ioutil.ReadFile(home + "/.project"); err == nil {
+			fmt.Fprintf(&buf, "Project: %s\n", strings.TrimSpace(string(project)))
+		}
+		buf.WriteString("Plan:\n")
+		buf.Write(plan)
+	}
+	return buf.String()
+}
+
+// ServeFinger answers finger requests on listener
+func (s *Server) ServeFinger(listener net.Listener) os.Error {
+	s.Logger.Printf("finger listening on %s...\n", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.fingerConn(conn)
+	}
+	return nil
+}
+
+// fingerConn answers the finger request on conn
+func (s *Server) fingerConn(conn net.Conn) {
+	defer conn.Close()
+	defer s.recoverConn("finger")
+	conn.SetTimeout(FingerTimeout)
+	query, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || len(query) > 256 {
+		return
+	}
+	answer := strings.Replace(strings.TrimRight(s.finger(query), "\n"), "\n", "\r\n", -1)
+	fmt.Fprintf(conn, "%s\r\n", answer)
+	s.Logger.Printf("Fingered `%s' for %s\n", strings.TrimSpace(query), conn.RemoteAddr())
+}
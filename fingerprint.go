@@ -0,0 +1,88 @@
+package gopher
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DuplicateReport finds files with the same contents anywhere below the
+// document roots, for maintainers of large archives. Files are compared
+// by size first and only hashed when another file has the same size.
+
+// DuplicateGroup is a set of files with the same contents. Selectors are
+// sorted, and the first is taken as the canonical one.
+type DuplicateGroup struct {
+	Size      int64
+	Sum       string // Hex encoded MD5 digest of the contents
+	Root      string // Document root the selectors are below
+	Selectors []string
+}
+
+// Wasted returns the bytes taken by the copies beyond the canonical one
+func (g *DuplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Selectors)-1)
+}
+
+// duplicateGroups sorts groups by the space they waste, most first
+type duplicateGroups []*DuplicateGroup
+
+func (g duplicateGroups) Len() int           { return len(g) }
+func (g duplicateGroups) Less(i, j int) bool { return g[i].Wasted() > g[j].Wasted() }
+func (g duplicateGroups) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+
+// fileCollector gathers the served files below a document root by size
+type fileCollector struct {
+	root   string
+	bySize map[int64][]string
+}
+
+func (c *fileCollector) VisitDir(name string, f *os.FileInfo) bool {
+	return name == c.root+"/" || !strings.HasPrefix(f.Name, ".")
+}
+
+func (c *fileCollector) VisitFile(name string, f *os.FileInfo) {
+	if !f.IsRegular() || f.Size == 0 || strings.HasPrefix(f.Name, ".") || listingHidden(f.Name) {
+		return
+	}
+	c.bySize[f.Size] = append(c.bySize[f.Size], name[len(c.root):])
+}
+
+// DuplicateReport returns the groups of identical files below every
+// document root, those wasting the most space first
+func (s *Server) DuplicateReport() []*DuplicateGroup {
+	s.init()
+	var groups duplicateGroups
+	roots := make(map[string]bool)
+	for _, host := range append([]*VirtualHost{s.defaultHost}, s.VirtualHosts()...) {
+		if roots[host.Root] {
+			continue
+		}
+		roots[host.Root] = true
+		c := &fileCollector{host.Root, make(map[int64][]string)}
+		path.Walk(host.Root+"/", c, nil)
+		for size, selectors := range c.bySize {
+			if len(selectors) < 2 {
+				continue
+			}
+			bySum := make(map[string][]string)
+			for _, selector := range selectors {
+				sum, err := checksumFile(host.Root + selector)
+				if err != nil {
+					s.Logger.Printf("Could not checksum `%s': %s\n", selector, err)
+					continue
+				}
+				bySum[sum] = append(bySum[sum], selector)
+			}
+			for sum, same := range bySum {
+				if len(same) > 1 {
+					sort.SortStrings(same)
+					groups = append(groups, &DuplicateGroup{size, sum, host.Root, same})
+				}
+			}
+		}
+	}
+	sort.Sort(groups)
+	return groups
+}
@@ -0,0 +1,210 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// With GeminiAddr set the server also speaks Gemini there, over TLS with
+// GeminiCert and GeminiKey, or TLSCert and TLSKey if those are unset. A
+// Gemini request for gemini://host/path?query is answered as the Gopher
+// request for selector /path with search string query would be, access
+// rules, gophermaps and handlers included: menus are turned into gemtext,
+// with local items as relative links and others as gopher:// links, and
+// everything else is sent as it is with a MIME type guessed from its name.
+// A link ending in ? asks the client for a search string first.
+
+// GeminiTimeout is how long a Gemini client has to send its request, in
+// nanoseconds
+var GeminiTimeout int64 = 30e9
+
+// geminiConn is the connection a Gemini request is answered on as if it
+// were a Gopher one. Menus are collected for conversion; anything else is
+// streamed to the client after a success header.
+type geminiConn struct {
+	net.Conn           // The client's connection
+	ctx      *Context  // Context of the Gopher request
+	request  io.Reader // The Gopher request line
+	started  bool
+	menu     *bytes.Buffer // The menu being collected, if the reply is one
+}
+
+func (c *geminiConn) Read(b []byte) (int, os.Error) { return c.request.Read(b) }
+
+func (c *geminiConn) Write(b []byte) (int, os.Error) {
+	if !c.started {
+		c.started = true
+		if c.ctx.itemType == '1' || c.ctx.itemType == '3' || c.ctx.itemType == 0 {
+			c.menu = new(bytes.Buffer)
+		} else if _, err := fmt.Fprintf(c.Conn, "20 %s\r\n", geminiType(c.ctx.Request, c.ctx.itemType)); err != nil {
+			return 0, err
+		}
+	}
+	if c.menu != nil {
+		return c.menu.Write(b)
+	}
+	return c.Conn.Write(b)
+}
+
+// Close leaves the client's connection open for the Gemini reply
+func (c *geminiConn) Close() os.Error { return nil }
+
+// geminiType guesses the MIME type of a reply that is not a menu
+func geminiType(selector string, itemType byte) string {
+	if t := mime.TypeByExtension(path.Ext(selector)); t != "" {
+		return t
+	}
+	if itemType == '0' {
+		return "text/plain"
+	}
+	return "application/octet-stream"
+}
+
+// geminiLine escapes a line of text that gemtext would otherwise read as
+// a link, heading, list item, quote or preformatting toggle
+func geminiLine(text string) string {
+	for _, prefix := range []string{"=>", "#", "* ", ">", "```"} {
+		if strings.HasPrefix(text, prefix) {
+			return " " + text
+		}
+	}
+	return text
+}
+
+// gemtext turns a Gopher menu into gemtext
+func (s *Server) gemtext(entries []*MenuEntry) string {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		local := s.advertisedLocal(e)
+		switch {
+		case e.Type == 'i':
+			buf.WriteString(geminiLine(e.Display) + "\n")
+		case e.Type == '3':
+			buf.WriteString(geminiLine("Error: "+e.Display) + "\n")
+		case strings.HasPrefix(e.Selector, "URL:"):
+			fmt.Fprintf(&buf, "=> %s %s\n", e.Selector[4:], e.Display)
+		case isTelnetType(e.Type):
+			fmt.Fprintf(&buf, "=> telnet://%s:%d %s\n", e.Host, e.Port, e.Display)
+		case local && e.Type == '7':
+			fmt.Fprintf(&buf, "=> %s? %s\n", escapeURL(e.Selector), e.Display)
+		case local:
+			fmt.Fprintf(&buf, "=> %s %s\n", escapeURL(e.Selector), e.Display)
+		default:
+			u := &URL{Host: e.Host, Port: e.Port, Type: e.Type, Selector: e.Selector}
+			fmt.Fprintf(&buf, "=> %s %s\n", u, e.Display)
+		}
+	}
+	return buf.String()
+}
+
+// parseGeminiRequest returns the selector and search string of a Gemini
+// request, and whether the client should be asked for a search string
+func parseGeminiRequest(line string) (selector string, query string, prompt bool, err os.Error) {
+	const scheme = "gemini://"
+	if len(line) > 1024 || !strings.HasPrefix(strings.ToLower(line), scheme) {
+		return "", "", false, os.NewError("not a gemini URL")
+	}
+	rest := line[len(scheme):]
+	selector = "/"
+	if i := strings.Index(rest, "/"); i != -1 {
+		selector = rest[i:]
+	}
+	if i := strings.Index(selector, "#"); i != -1 {
+		selector = selector[:i]
+	}
+	if i := strings.Index(selector, "?"); i != -1 {
+		selector, query = selector[:i], selector[i+1:]
+		if query == "" {
+			prompt = true
+		} else if query, err = unescapeURL(query); err != nil {
+			return
+		}
+	}
+	selector, err = unescapeURL(selector)
+	return
+}
+
+// listenGemini listens on GeminiAddr with TLS
+func (s *Server) listenGemini() (net.Listener, os.Error) {
+	cert, key := s.GeminiCert, s.GeminiKey
+	if cert == "" {
+		cert, key = s.TLSCert, s.TLSKey
+	}
+	if cert == "" {
+		return nil, os.NewError("gemini needs a TLS certificate")
+	}
+	l, err := net.Listen("tcp", s.GeminiAddr)
+	if err != nil {
+		return nil, err
+	}
+	return tlsListener(l, cert, key)
+}
+
+// ServeGemini answers Gemini requests on listener, which must be a TLS
+// listener
+func (s *Server) ServeGemini(listener net.Listener) os.Error {
+	s.Logger.Printf("gemini listening on %s...\n", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.geminiRequest(conn)
+	}
+	return nil
+}
+
+// geminiRequest answers the Gemini request on conn
+func (s *Server) geminiRequest(conn net.Conn) {
+	defer conn.Close()
+	defer s.recoverConn("gemini")
+	conn.SetReadTimeout(GeminiTimeout)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	conn.SetReadTimeout(0)
+	selector, query, prompt, err := parseGeminiRequest(strings.TrimRight(line, "\r\n"))
+	switch {
+	case err != nil:
+		fmt.Fprintf(conn, "59 Bad request\r\n")
+		return
+	case prompt:
+		fmt.Fprintf(conn, "10 Search for\r\n")
+		return
+	case strings.IndexAny(selector+query, "\t\r\n") != -1:
+		fmt.Fprintf(conn, "59 Bad request\r\n")
+		return
+	}
+	request := selector
+	if query != "" {
+		request += "\t" + query
+	}
+	gc := &geminiConn{Conn: conn, request: bytes.NewBufferString(request + "\r\n")}
+	ctx := s.newContext(gc, time.Nanoseconds())
+	gc.ctx = ctx
+	s.handle(ctx)
+	switch {
+	case !gc.started:
+		fmt.Fprintf(conn, "40 Temporary failure\r\n")
+	case gc.menu != nil:
+		entries, err := ParseMenu(gc.menu)
+		if err != nil {
+			fmt.Fprintf(conn, "40 Temporary failure\r\n")
+			return
+		}
+		if len(entries) == 1 && entries[0].Type == '3' {
+			fmt.Fprintf(conn, "51 %s\r\n", entries[0].Display)
+			return
+		}
+		fmt.Fprintf(conn, "20 text/gemini\r\n%s", s.gemtext(entries))
+	}
+}
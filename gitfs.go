@@ -0,0 +1,341 @@
+package gopher
+
+import (
+	"bytes"
+	"exec"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A git repository can be served as it is committed:
+//    git /srv/gopher/site /srv/git/site.git revisions
+// serves the tree of the repository's HEAD as the files below /site. With
+// revisions, /site/@ lists the tags and branches and /site/@v1.2/path
+// serves path as it was at v1.2; any revision git understands, such as a
+// commit id, works there. The repository may be bare. Trees and files of
+// a commit never change, so they are cached by commit id; which commit a
+// revision names is looked up again after GitRefTTL. Small files are
+// cached too, up to GitCacheSize bytes in all; larger ones are streamed
+// from git as they are sent, so a file over MaxFileSize is refused before
+// it is read. The git program does the reading, so it must be installed,
+// and in a configured mount it is killed after HandlerTimeout.
+
+// GitRefTTL is how long the commit a revision names is cached, in
+// nanoseconds
+var GitRefTTL int64 = 10e9
+
+// GitCacheSize is how many bytes of file contents a GitFS keeps cached
+var GitCacheSize int64 = 16 << 20
+
+// GitFS is a FileSystem reading the committed trees of a git repository
+type GitFS struct {
+	Repository string // Repository directory, the .git directory if not bare
+	Revisions  bool   // Whether /@revision/ selectors are served
+	Timeout    int64  // Nanoseconds git may run before it is killed, if not 0
+	sync.Mutex
+	refs      map[string]*gitRef       // Commits of revisions
+	trees     map[string][]os.FileInfo // Tree listings by commit:path
+	blobs     map[string][]byte        // File contents by commit:path
+	blobBytes int64                    // Size of the cached contents
+}
+
+// gitRef is the commit a revision named when it was last looked up
+type gitRef struct {
+	commit string
+	time   int64 // Commit time, in nanoseconds since the epoch
+	looked int64
+}
+
+// NewGitFS returns a GitFS for the repository at dir
+func NewGitFS(dir string, revisions bool) *GitFS {
+	return &GitFS{Repository: dir, Revisions: revisions}
+}
+
+// configureGit handles a `git dir repository [revisions]' line
+func (s *Server) configureGit(args []string) os.Error {
+	if len(args) == 3 && args[2] != "revisions" || len(args) != 2 && len(args) != 3 {
+		return os.NewError("expected a directory, a repository and optionally `revisions'")
+	}
+	s.mounts = append(s.mounts, &mount{path.Clean(args[0]), NewGitFS(args[1], len(args) == 3), nil, true})
+	return nil
+}
+
+// gitCmd is a running git whose output is read as it comes
+type gitCmd struct {
+	cmd      *exec.Cmd
+	finished chan bool // Stops the timeout, if there is one
+}
+
+func (c *gitCmd) Read(p []byte) (int, os.Error) {
+	return c.cmd.Stdout.Read(p)
+}
+
+// Close stops reading and waits for git to exit. It reports an error if
+// git failed or was stopped before writing everything.
+func (c *gitCmd) Close() os.Error {
+	c.cmd.Stdout.Close()
+	msg, err := c.cmd.Wait(0)
+	if c.finished != nil {
+		c.finished <- true
+	}
+	if err != nil {
+		return err
+	}
+	if !msg.Exited() || msg.ExitStatus() != 0 {
+		return os.ENOENT
+	}
+	return nil
+}
+
+// start runs git on the repository, leaving its output to be read
+func (fs *GitFS) start(args ...string) (*gitCmd, os.Error) {
+	argv0, err := exec.LookPath("git")
+	if err != nil {
+		return nil, err
+	}
+	argv := append([]string{"git", "--git-dir=" + fs.Repository}, args...)
+	cmd, err := exec.Run(argv0, argv, os.Environ(), "", exec.DevNull, exec.Pipe, exec.DevNull)
+	if err != nil {
+		return nil, err
+	}
+	c := &gitCmd{cmd: cmd}
+	if fs.Timeout > 0 {
+		c.finished = make(chan bool, 1)
+		go killAfter(cmd.Pid, fs.Timeout, c.finished)
+	}
+	return c, nil
+}
+
+// git runs git on the repository and returns its output
+func (fs *GitFS) git(args ...string) ([]byte, os.Error) {
+	c, err := fs.start(args...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(c)
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// split returns the revision a name is read at and its path in the tree.
+// The revision is empty for /@, the list of revisions.
+func (fs *GitFS) split(name string) (rev string, p string) {
+	name = cleanName(name)
+	if !fs.Revisions || !strings.HasPrefix(name, "/@") {
+		return "HEAD", name
+	}
+	rest := name[2:]
+	if rest == "" {
+		return "", "/"
+	}
+	if i := strings.Index(rest, "/"); i != -1 {
+		return rest[:i], rest[i:]
+	}
+	return rest, "/"
+}
+
+// resolve returns the commit a revision names
+func (fs *GitFS) resolve(rev string) (*gitRef, os.Error) {
+	fs.Lock()
+	ref, found := fs.refs[rev]
+	fs.Unlock()
+	if found && time.Nanoseconds()-ref.looked < GitRefTTL {
+		return ref, nil
+	}
+	if strings.HasPrefix(rev, "-") {
+		return nil, os.ENOENT
+	}
+	out, err := fs.git("log", "-1", "--format=%H %ct", rev, "--")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return nil, os.ENOENT
+	}
+	seconds, err := strconv.Atoi64(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	ref = &gitRef{fields[0], seconds * 1e9, time.Nanoseconds()}
+	fs.Lock()
+	if fs.refs == nil || len(fs.refs) >= MaxCachedSidecars {
+		fs.refs = make(map[string]*gitRef)
+	}
+	fs.refs[rev] = ref
+	fs.Unlock()
+	return ref, nil
+}
+
+// revisions lists the tags and branches as directories
+func (fs *GitFS) revisions() ([]os.FileInfo, os.Error) {
+	out, err := fs.git("for-each-ref", "--format=%(refname:short)", "refs/tags", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	var entries []os.FileInfo
+	for _, name := range strings.Fields(string(out)) {
+		if strings.Index(name, "/") == -1 {
+			entries = append(entries, memInfo(name, syscall.S_IFDIR|0755, 0, 0))
+		}
+	}
+	return entries, nil
+}
+
+// tree returns the entries of the directory p in a commit
+func (fs *GitFS) tree(ref *gitRef, p string) ([]os.FileInfo, os.Error) {
+	key := ref.commit + ":" + p
+	fs.Lock()
+	entries, found := fs.trees[key]
+	fs.Unlock()
+	if found {
+		return entries, nil
+	}
+	out, err := fs.git("ls-tree", "-l", "-z", ref.commit+":"+strings.TrimLeft(p, "/"))
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range strings.Split(string(out), "\x00", -1) {
+		tab := strings.Index(record, "\t")
+		if tab == -1 {
+			continue
+		}
+		// <mode> <type> <object> <size>\t<name>
+		fields := strings.Fields(record[:tab])
+		if len(fields) != 4 {
+			continue
+		}
+		switch fields[1] {
+		case "tree":
+			entries = append(entries, memInfo(record[tab+1:], syscall.S_IFDIR|0755, 0, ref.time))
+		case "blob":
+			size, _ := strconv.Atoi64(fields[3])
+			entries = append(entries, memInfo(record[tab+1:], syscall.S_IFREG|0644, size, ref.time))
+		}
+	}
+	fs.Lock()
+	if fs.trees == nil || len(fs.trees) >= MaxCachedSidecars {
+		fs.trees = make(map[string][]os.FileInfo)
+	}
+	fs.trees[key] = entries
+	fs.Unlock()
+	return entries, nil
+}
+
+func (fs *GitFS) ReadDir(name string) ([]os.FileInfo, os.Error) {
+	rev, p := fs.split(name)
+	if rev == "" {
+		return fs.revisions()
+	}
+	ref, err := fs.resolve(rev)
+	if err != nil {
+		return nil, &os.PathError{"readdir", name, err}
+	}
+	entries, err := fs.tree(ref, p)
+	if err != nil {
+		return nil, &os.PathError{"readdir", name, err}
+	}
+	if fs.Revisions && p == "/" && rev == "HEAD" && cleanName(name) == "/" {
+		// The list of revisions is reached from the top of HEAD
+		entries = append(append([]os.FileInfo{}, entries...), memInfo("@", syscall.S_IFDIR|0755, 0, ref.time))
+	}
+	return entries, nil
+}
+
+func (fs *GitFS) Stat(name string) (*os.FileInfo, os.Error) {
+	rev, p := fs.split(name)
+	if p == "/" {
+		// The top of the tree, of a revision or of the list of them
+		if rev != "" {
+			if _, err := fs.resolve(rev); err != nil {
+				return nil, &os.PathError{"stat", name, err}
+			}
+		}
+		info := memInfo(name, syscall.S_IFDIR|0755, 0, 0)
+		return &info, nil
+	}
+	ref, err := fs.resolve(rev)
+	if err != nil {
+		return nil, &os.PathError{"stat", name, err}
+	}
+	entries, err := fs.tree(ref, path.Dir(p))
+	if err != nil {
+		return nil, &os.PathError{"stat", name, err}
+	}
+	for i := range entries {
+		if entries[i].Name == path.Base(p) {
+			return &entries[i], nil
+		}
+	}
+	return nil, &os.PathError{"stat", name, os.ENOENT}
+}
+
+func (fs *GitFS) Open(name string) (File, os.Error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &listedFile{name: cleanName(name), info: info}
+	if info.IsDirectory() {
+		f.list = func() ([]os.FileInfo, os.Error) { return fs.ReadDir(name) }
+		return f, nil
+	}
+	rev, p := fs.split(name)
+	ref, err := fs.resolve(rev)
+	if err != nil {
+		return nil, &os.PathError{"open", name, err}
+	}
+	if info.Size > DefaultFileCacheMaxFile {
+		// Too large to hold, so sent as git writes it
+		c, err := fs.start("cat-file", "blob", ref.commit+":"+strings.TrimLeft(p, "/"))
+		if err != nil {
+			return nil, &os.PathError{"open", name, err}
+		}
+		f.body = c
+		return f, nil
+	}
+	data, err := fs.blob(ref, p)
+	if err != nil {
+		return nil, &os.PathError{"open", name, err}
+	}
+	f.body = nopCloser{bytes.NewBuffer(data)}
+	return f, nil
+}
+
+// blob returns the contents of the file p in a commit
+func (fs *GitFS) blob(ref *gitRef, p string) ([]byte, os.Error) {
+	key := ref.commit + ":" + p
+	fs.Lock()
+	data, found := fs.blobs[key]
+	fs.Unlock()
+	if found {
+		return data, nil
+	}
+	data, err := fs.git("cat-file", "blob", ref.commit+":"+strings.TrimLeft(p, "/"))
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(data))
+	fs.Lock()
+	if _, found := fs.blobs[key]; !found && size <= GitCacheSize {
+		if fs.blobs == nil || len(fs.blobs) >= MaxCachedSidecars || fs.blobBytes+size > GitCacheSize {
+			fs.blobs = make(map[string][]byte)
+			fs.blobBytes = 0
+		}
+		fs.blobs[key] = data
+		fs.blobBytes += size
+	}
+	fs.Unlock()
+	return data, nil
+}
@@ -1,26 +1,56 @@
-package main
+package gopher
 
 import (
 	"bufio"
 	"bytes"
 	"container/vector"
+	"crypto/tls"
 	"encoding/line"
-	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"os"
 	"path"
-	"regexp"
-	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Context represents the union of the re
+// Context represents the union of the request and the connection it arrived on
 type Context struct {
 	conn net.Conn
+	Server *Server
+	Host *VirtualHost
 	Request string
+	Query string // Search string of a type 7 request, if any
+	Params []string // Parts of the selector captured by the matching route
+	paramNames []string // Names of the route's groups, for Param
+	extra string // Gopher+ part of the request, such as + or !
+	reader io.Reader // The rest of the request, for data sent after the selector
+	itemType byte // Item type of the response, for accounting
+	area *ProtectedArea // Protected area the request was authenticated for
+	token string // Token the request was authenticated with
+	ID string // Identifies the request in log lines
+	accepted int64 // When the connection was accepted, in nanoseconds
+	phases []tracePhase // Ends of the phases of a traced request
+	out *bufferedConn // Buffers the response
+	deadline *deadline // Time budget of the request's handler, if it has one
+	sim *Simulation // Collects what happens to a simulated request
+	session *session // Requests kept alive on the connection, if KeepAlive is set
+}
+
+// ClientIP returns the address of the connected client without the port
+func (ctx *Context) ClientIP() string {
+	remote := ctx.conn.RemoteAddr()
+	if remote == nil || isUnix(remote) {
+		return "unix"
+	}
+	addr := remote.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
 }
 
 // Write sends raw <CR><LF> terminated data to the client
@@ -29,35 +59,28 @@ func (ctx *Context) Write(data string) (n int, err os.Error) {
 	return
 }
 
-// Info sends an info-formatted string to the client
-func (s *Server) InfoLine(line string) string {
-	return fmt.Sprintf("i%s\tF\t%s\t%d", line, s.Hostname, s.Port)
-}
-
-func (s *Server) TextfileLine(name string, path string) string {
-	return fmt.Sprintf("0%s\t/%s\t%s\t%d", name, path, s.Hostname, s.Port)
-}
-
-func (s *Server) DirectoryLine(name string, path string) string {
-	return fmt.Sprintf("1%s\t/%s\t%s\t%d", name, path, s.Hostname, s.Port)
-}
-
-// Error sends an error-formatted string to the client
+// Error sends a menu holding a single error item with the given text,
+// followed by the terminating period
 func (ctx *Context) Error(line string) (n int, err os.Error) {
-	n, err = fmt.Fprintf(ctx.conn, "3%s\terror\thost\t0\r\n", line)
+	ctx.itemType = '3'
+	entry := &MenuEntry{Type: '3', Display: line, Host: ctx.Server.Hostname, Port: ctx.Server.Port}
+	n, err = fmt.Fprintf(ctx.conn, "%s\r\n.\r\n", entry)
 	return
 }
 
-type gophermapEntry struct {
-	Type byte
-	Data string
-	Path string
-	Host string
-	Port int
+// NotFound tells the client the requested selector does not exist
+func (ctx *Context) NotFound() (n int, err os.Error) {
+	return ctx.Error(fmt.Sprintf("Resource `%s' not found", ctx.Request))
 }
 
-func (entry *gophermapEntry) String() string {
-	return fmt.Sprintf("%c%s\t%s\t%s\t%d", entry.Type, entry.Data, entry.Path, entry.Host, entry.Port)
+// Forbidden tells the client it may not have the requested selector
+func (ctx *Context) Forbidden() (n int, err os.Error) {
+	return ctx.Error(ctx.Server.denyMessage())
+}
+
+// ServerError tells the client the request failed on the server's side
+func (ctx *Context) ServerError() (n int, err os.Error) {
+	return ctx.Error("Internal server error")
 }
 
 // Returns a vector of gophermap entries
@@ -69,8 +92,16 @@ func (entry *gophermapEntry) String() string {
 // Where X is the Gopher item type and <tab> is the literal \t
 // Any field not specified is automatically provided
 func (s *Server) ParseGophermapLine(ctx *Context, line string) (entries vector.Vector) {
+	if isTelnetType(line[0]) {
+		if entry, err := parseTelnetLine(line); err == nil {
+			entries.Push(entry)
+		} else {
+			ctx.Logf("ERROR: Bad gophermap line for `%s': %s\n", ctx.Request, err)
+		}
+		return
+	}
 	parts := strings.Split(line[1:], "\t", 4)
-	fullpath := s.Cwd+ctx.Request+"/"+parts[0]
+	fullpath := ctx.Host.Root+ctx.Request+"/"+parts[0]
 	var matches []string
 	if len(parts) == 2 && strings.Trim(parts[1], " \t\r\n") == "" {
 		matches = path.Glob(fullpath)
@@ -78,15 +109,15 @@ func (s *Server) ParseGophermapLine(ctx *Context, line string) (entries vector.V
 		matches = []string{fullpath}
 	}
 	for _, match := range matches {
-		entry := &gophermapEntry{Type: line[0]}
-		entry.Data = path.Base(match)
+		entry := &MenuEntry{Type: line[0]}
+		entry.Display = s.displayName(path.Base(match))
 		if strings.Trim(parts[1], " \t\r\n") == "" {
-			entry.Path = ctx.Request+"/"+path.Base(match)
+			entry.Selector = ctx.Request+"/"+path.Base(match)
 		} else {
 			if (strings.HasPrefix(parts[1], "/")) {
-				entry.Path = parts[1]
+				entry.Selector = parts[1]
 			} else {
-				entry.Path = ctx.Request+"/"+parts[1]
+				entry.Selector = ctx.Request+"/"+parts[1]
 			}
 		}
 		if len(parts) > 2 {
@@ -105,214 +136,856 @@ func (s *Server) ParseGophermapLine(ctx *Context, line string) (entries vector.V
 	return
 }
 
-func (s *Server) Gophermap(ctx *Context, gmap *os.File, dir *os.File) (ok bool, err os.Error) {
-	cwd := dir.Name()[len(s.Cwd):]
+func (s *Server) Gophermap(ctx *Context, gmap io.Reader, dir File) (Status, os.Error) {
+	cwd := dir.Name()[len(ctx.Host.Root):]
+	w := NewEntryWriter(ctx)
+	if err := s.gophermapLines(ctx, w, gmap, dir.Name(), nil); err != nil {
+		return StatusError, err
+	}
+	ctx.Logf("Served gophermapped directory `%s`\n", cwd)
+	return StatusOK, w.Close()
+}
+
+// gophermapLines sends the menu entries of a gophermap whose directives
+// are relative to dir. including holds the files being included, to catch
+// cycles.
+func (s *Server) gophermapLines(ctx *Context, w *EntryWriter, gmap io.Reader, dir string, including []string) os.Error {
+	cwd := dir[len(ctx.Host.Root):]
 	linereader := line.NewReader(bufio.NewReader(gmap), 512)
 	for {
 		if read, _, err := linereader.ReadLine(); err == nil {
 			entry := bytes.NewBuffer(read).String()
-			if strings.Index(entry, "\t") == -1 {
-				ctx.Write(s.InfoLine(entry))
-			} else {
-				entries := s.ParseGophermapLine(ctx, entry)
-				for e := 0; e < entries.Len(); e++ {
-					ctx.Write(entries[e].(*gophermapEntry).String())
+			if name, isInclude := includeDirective(entry); isInclude {
+				if err := s.includeGophermap(ctx, w, dir, name, including); err != nil {
+					ctx.Logf("ERROR: Could not include `%s' in `%s': %s\n", name, cwd, err)
 				}
+				continue
 			}
+			if text, isBanner := figletDirective(entry); isBanner {
+				for _, line := range Figlet(text) {
+					w.Info(line)
+				}
+				continue
+			}
+			if name, isExec := execDirective(entry); isExec {
+				lines, err := s.runCommand(ctx, name, dir)
+				if err != nil {
+					ctx.Logf("ERROR: Could not run `%s' for `%s': %s\n", name, cwd, err)
+					w.Info("(This part of the menu is not available right now)")
+				}
+				for _, line := range lines {
+					s.gophermapLine(ctx, w, line)
+				}
+				continue
+			}
+			s.gophermapLine(ctx, w, entry)
 		} else {
 			if err != os.EOF {
-				return false, err
+				return err
 			}
 			break
 		}
-		
 	}
-	ctx.Write(".")
-	s.Logger.Printf("Served gophermapped directory `%s`\n", cwd)
-	return true, nil
+	return nil
 }
 
+// gophermapLine sends the menu entries for one line of a gophermap
+func (s *Server) gophermapLine(ctx *Context, w *EntryWriter, line string) {
+	if strings.Index(line, "\t") == -1 {
+		w.Info(line)
+		return
+	}
+	entries := s.ParseGophermapLine(ctx, line)
+	for e := 0; e < entries.Len(); e++ {
+		entry := entries[e].(*MenuEntry)
+		s.rewriteMirrorLink(ctx.Request, entry)
+		local := entry.Host == s.Hostname && entry.Port == s.Port
+		if local && s.features.hidden(entry.Selector, ctx.ClientIP(), ctx.Host.Name) {
+			continue
+		}
+		if local && entry.Type == '7' && !s.SubsystemUp("search") {
+			w.Info(s.subsystemNotice("search"))
+			continue
+		}
+		w.Write(entry)
+	}
+}
+
+// ListingBatch is how many directory entries are read at a time for a
+// listing
+const ListingBatch = 1000
+
 // Directory sends a Gopher listing of the directory specified
 // If a gophermap file is present, it is used instead of listing the directory contents
-func (s *Server) Directory(ctx *Context, dir *os.File) (ok bool, err os.Error) {
-	cwd := dir.Name()[len(s.Cwd):]
-	if mapfile, maperr := os.Open(dir.Name()+"/gophermap", 0, 0); maperr == nil {
-		defer mapfile.Close()
-		s.Gophermap(ctx, mapfile, dir)
-		ok = true
-	} else {
+func (s *Server) Directory(ctx *Context, dir File) (Status, os.Error) {
+	cwd := dir.Name()[len(ctx.Host.Root):]
+	if gmap, found, maperr := s.gophermapSource(ctx, dir.Name()); found {
+		if maperr != nil {
+			return StatusError, maperr
+		}
+		return s.Gophermap(ctx, gmap, dir)
+	}
+	index, hasIndex := s.indexFile(dir.Name())
+	if hasIndex && s.IndexMode == IndexServe {
+		return s.serveIndex(ctx, index)
+	}
+	names := s.names(dir.Name())
+	w := NewEntryWriter(ctx)
+	s.writeInfoFile(w, dir.Name()+"/"+HeaderFile)
+	if hasIndex && s.IndexMode == IndexPrepend {
+		s.writeInfoFile(w, index)
+	}
+	if s.Dupes != DupesOff {
+		// Finding duplicates needs the whole directory at once
 		entries, err := dir.Readdir(-1)
 		if err != nil {
-			s.Logger.Printf("Could not show directory: `%s'\n", err)
-			return
+			return StatusError, err
 		}
-		for _, entry := range entries {
-			expandedName := strings.Trim(fmt.Sprintf("%s/%s", cwd, entry.Name), "/")
-			switch true {
-			case entry.IsRegular():
-				_, err = ctx.Write(s.TextfileLine(entry.Name, expandedName))
-			case entry.IsDirectory():
-				_, err = ctx.Write(s.DirectoryLine(entry.Name, expandedName))
-			default:
-				_, err = ctx.Write(s.InfoLine(entry.Name))
+		dupes := s.findDuplicates(dir.Name(), entries)
+		for i := range entries {
+			s.listEntry(ctx, w, dir.Name(), &entries[i], names, dupes)
+		}
+	} else {
+		// Entries are sent as they are read, so huge directories need
+		// neither the memory for all of them nor a wait before the first
+		for {
+			entries, err := dir.Readdir(ListingBatch)
+			for i := range entries {
+				s.listEntry(ctx, w, dir.Name(), &entries[i], names, nil)
+			}
+			if err != nil && err != os.EOF {
+				return StatusError, err
+			}
+			if err == os.EOF || len(entries) == 0 {
+				break
 			}
+			ctx.Flush()
 		}
-		s.Logger.Printf("Served directory `%s'\n", cwd);
-		ctx.Write(".")
-		ok = true
 	}
-	return
+	s.writeInfoFile(w, dir.Name()+"/"+FooterFile)
+	if s.Archives {
+		w.Info("")
+		w.Item('9', "Download this directory (tar.gz)", cwd+"/"+ArchiveMarker+"tgz")
+	}
+	ctx.Logf("Served directory `%s'\n", cwd);
+	return StatusOK, w.Close()
 }
 
-func (s *Server) Textfile(ctx *Context, file *os.File) (ok bool, err os.Error) {
+// listEntry sends the lines for one entry of a directory listing. dupes
+// maps the names of duplicate entries to those they duplicate, if
+// duplicates are looked for.
+func (s *Server) listEntry(ctx *Context, w *EntryWriter, dir string, entry *os.FileInfo, names map[string]string, dupes map[string]string) {
+	if listingHidden(entry.Name) || s.unpublished(ctx, dir+"/"+entry.Name) {
+		return
+	}
+	canon, dup := dupes[entry.Name]
+	if dup && s.Dupes == DupesCollapse {
+		return
+	}
+	cwd := dir[len(ctx.Host.Root):]
+	expandedName := strings.Trim(fmt.Sprintf("%s/%s", cwd, entry.Name), "/")
+	if !validSelector(expandedName) {
+		return
+	}
+	if s.features.hidden(expandedName, ctx.ClientIP(), ctx.Host.Name) {
+		return
+	}
+	name := s.listingName(dir+"/"+entry.Name, names)
+	switch true {
+	case entry.IsRegular() && s.markdownMenu(dir+"/"+entry.Name):
+		w.Item('1', name, "/"+expandedName)
+	case entry.IsRegular():
+		w.Item(s.itemType(entry), name, "/"+expandedName)
+	case entry.IsDirectory():
+		w.Item('1', name, "/"+expandedName)
+	default:
+		w.Info(name)
+	}
+	if dup && s.Dupes == DupesAnnotate {
+		w.Info(fmt.Sprintf("  (same as %s)", canon))
+	}
+	if l := s.license(dir + "/" + entry.Name); l != nil {
+		w.Info("  " + l.String())
+	}
+}
+
+func (s *Server) Textfile(ctx *Context, file io.Reader) (Status, os.Error) {
 	const BUFSIZE = 512
 	var buf [BUFSIZE]byte
+	var out io.Writer
+	var reflow *reflowWriter
+	var conn io.Writer = ctx.conn
+	dots := ctx.dotted()
+	if dots != nil {
+		conn = dots
+	}
 	for {
 		switch nr, er := file.Read(buf[:]); true {
 		case nr < 0:
-			s.Logger.Printf("Error reading from text file `%s': %s\n", ctx.Request, er)
-			err = er
-			return
+			return StatusError, er
 		case nr == 0:
-			s.Logger.Printf("Served text file `%s'\n", ctx.Request)
-			ok = true
-			return
+			if reflow != nil {
+				if err := reflow.Flush(); err != nil {
+					return StatusError, err
+				}
+			}
+			if dots != nil {
+				if err := dots.Close(); err != nil {
+					return StatusError, err
+				}
+			}
+			ctx.Logf("Served text file `%s'\n", ctx.Request)
+			return StatusOK, nil
 		case nr > 0:
-			if nw, ew := ctx.conn.Write(buf[0:nr]); nw != nr {
-				s.Logger.Printf("Error sending text file `%s': %s\n", ctx.Request, ew)
-				err = ew
-				return
+			if out == nil {
+				// Text is transcoded to the served charset, binary data is left alone
+				out = conn
+				if from := sniffCharset(buf[0:nr]); from != "" {
+					out = newCharsetWriter(conn, from, s.charset())
+					if s.ReflowWidth > 0 {
+						reflow = newReflowWriter(out, s.ReflowWidth)
+						out = reflow
+					}
+				}
+			}
+			if nw, ew := out.Write(buf[0:nr]); nw != nr {
+				return StatusError, ew
 			}
 		}
 	}
-	return
+	return StatusOK, nil
 }
 
+// Server serves a document root and any registered handlers over Gopher
 type Server struct {
-	listener net.Listener
 	routes vector.Vector
-	Logger *log.Logger
+	Logger *Logger
 	Hostname string
 	Port int
-	Cwd string // Current working directory
+	Cwd string // Document root, the working directory unless configured
+	StagedRoot string // Document root SwitchRoots swaps Cwd with, if any
+	Admin string // Contact for the server's administrator
+	TLSCert string // Certificate and key files; if set, connections use TLS
+	TLSKey string
+	CaseInsensitive bool // Match selectors to file names regardless of case
+	Charset string // Character set text is served in, UTF-8 by default
+	Dupes int // Duplicate handling in listings, one of the Dupes* constants
+	DupeChecksums bool // Also detect duplicates by content, not just inode
+	MetricsAddr string // Address of the HTTP metrics listener, if any
+	ControlSocket string // Path of the unix control socket, if any
+	UnixSocket string // Path of a unix socket to serve on instead of TCP
+	ProxyProtocol bool // Read the client address from a PROXY header
+	Trace bool // Log the time spent in each phase of every request
+	LogLevel int // Verbosity of request logging, one of the Log* constants
+	WatchFiles bool // Cache sidecar files until the file system reports a change
+	Archives bool // Offer directories as tar archives
+	ForbidTelnet bool // Leave telnet and tn3270 items out of menus
+	FingerAddr string // Address of the finger listener, if any
+	FingerHomes string // Directory holding the home directories finger reads
+	ProxyBalance int // How proxies pick an upstream, one of the Balance* constants
+	GeminiAddr string // Address of the Gemini listener, if any
+	GeminiCert string // Certificate and key files of the Gemini listener,
+	GeminiKey string // if not those in TLSCert and TLSKey
+	MaxFileSize int64 // Largest file served, in bytes, if not 0
+	MaxResponseSize int64 // Most bytes sent on one connection, if not 0
+	FileCacheSize int64 // Bytes of small files kept mapped in memory, if not 0
+	FileCacheMaxFile int64 // Largest file cached; DefaultFileCacheMaxFile if 0
+	HandlerTimeout int64 // Nanoseconds a handler or gophermap command may run, if not 0
+	Workers int // Goroutines answering connections; DefaultWorkers if 0
+	ReusePort bool // Listen with SO_REUSEPORT, sharing the port with other sockets
+	Shards int // Sockets Run accepts on with ReusePort; GOMAXPROCS if 0
+	QueueLength int // Accepted connections that may wait for a worker; DefaultQueueLength if 0
+	AdvertiseHost string // Host menus point at, if not Hostname
+	AdvertisePort int // Port menus point at, if not Port
+	AcceptURLs bool // Accept full gopher:// URLs in place of selectors
+	Chroot bool // Chroot into the document root once listening
+	User string // User to switch to once listening, by name or id
+	Group string // Group to switch to once listening, by name or id
+	DenyMessage string // Shown to clients denied by an access rule
+	Banner []string // Info lines put above every menu
+	BannerFile string // File of more banner lines, if any
+	MotdFile string // File a banner template can show as {Motd}, if any
+	StatsFile string // File per-selector counts are kept in, if any
+	StatsInterval int // Seconds between saves of StatsFile
+	StatsSelector string // Selector of the most popular documents menu, if any
+	AdminSelector string // Selector of the admin menu, if any
+	Compress bool // Offer text files gzipped to Gopher+ clients
+	CompressMinSize int64 // Smallest file offered gzipped, in bytes
+	CompressTypes []string // Extensions of files offered gzipped; all if empty
+	IndexMode int // Use of directory index files, one of the Index* constants
+	IndexFiles []string // Names of index files, DefaultIndexFiles if empty
+	MarkdownMode int // Rendering of markdown files, one of the Markdown* constants
+	MarkdownWidth int // Column rendered markdown is wrapped at, DefaultMarkdownWidth if 0
+	ReflowWidth int // Column long lines of text files are wrapped at; 0 sends them as they are
+	MaintenanceFile string // Gophermap shown in maintenance mode, if any
+	MaintenanceMessage []string // Lines shown in maintenance mode without a MaintenanceFile
+	KeepAlive bool // Let clients that ask send several requests on one connection
+	ItemTypes map[string]byte // Item types of files by extension, over DefaultItemTypes
+	Thumbnails bool // Offer images scaled down and previewed in characters to Gopher+ clients
+	ThumbnailSize int // Side of the square thumbnails fit in, DefaultThumbnailSize if 0
+	JournalDir string // Directory request transcripts are recorded in, if any
+	JournalMaxSize int64 // Bytes the journal is kept under, DefaultJournalMaxSize if 0
+	stats statsCollector
+	statsStop chan bool // Stops saving StatsFile, closed to save a last time
+	features featureSet
+	subsystems subsystemSet
+	vhosts []*VirtualHost
+	mirrors []*Mirror
+	commands map[string]*Command
+	acl []*ACLRule
+	protected []*ProtectedArea
+	phlogs []*Phlog
+	guestbooks []*Guestbook
+	dropboxes []*Dropbox
+	ccso []*CCSOGateway
+	sqlite []*SQLiteHandler
+	proxies []*ProxyHandler
+	advertised []*AdvertisedHost
+	rewrites []*RewriteRule
+	logAreas []*LogArea
+	breaker breaker // Failure records of handlers
+	queue chan *Context // Accepted connections waiting for a worker
+	redirects []*Redirect
+	configFile string // Configuration file, for reloading
+	reloadMu sync.RWMutex // Guards the tables Reload swaps
+	defaultHost *VirtualHost
+	startOnce sync.Once // Starts the server for the first listener served
+	requestIDs requestIDs
+	sidecars *sidecarCache // Cached sidecar files, if WatchFiles is set
+	files fileCache // Small files mapped in memory, if FileCacheSize is set
+	missing negativeCache // Files recently found missing
+	thumbnails thumbnailCache // Views made of images
+	journal journal // Transcripts in JournalDir
+	maintenance maintenanceState
+	started int64 // Nanoseconds since the epoch when the server started
+	listener net.Listener // Socket Run listens on, for Upgrade
+	upgraded bool // Whether the listener has been handed to a new process
+	upgradeMu sync.Mutex // Guards listener and upgraded
+	mounts []*mount // File systems served in place of directories
 }
 
-type route struct {
-	pattern string
-	re *regexp.Regexp
-	handler *reflect.FuncValue
+// NewServer returns a Server logging to stdout. The document root defaults
+// to the working directory when the server is run.
+func NewServer() *Server {
+	return &Server{
+		Logger: NewLogger(NewTextSink(os.Stdout)),
+	}
 }
 
-var server = Server{
-	Logger: log.New(os.Stdout, "", log.Ldate|log.Ltime),
-}
+// DefaultServer is the Server used by the package level functions
+var DefaultServer = NewServer()
 
-func (s *Server) addRoute(pattern string, handler interface{}) {
-	var re *regexp.Regexp
-	var err os.Error
-	if re, err = regexp.Compile(pattern); err != nil {
-		s.Logger.Printf("Route failed to compile %q\n", pattern)
+// handle answers a newly accepted connection, or the next request of a
+// kept-alive session
+func (s *Server) handle(ctx *Context) {
+	kept := false
+	conn := ctx.conn
+	defer func() {
+		if !kept {
+			conn.Close()
+		}
+	}()
+	if ctx.session == nil {
+		ctx.mark("accept")
+		// Gemini requests come from the Gemini listener, never from a proxy
+		_, gemini := ctx.conn.(*geminiConn)
+		if s.ProxyProtocol && !gemini {
+			pconn, err := readProxyHeader(ctx.conn)
+			if err != nil {
+				ctx.Logf("ERROR: Bad PROXY header from %s: %s\n", ctx.conn.RemoteAddr(), err)
+				return
+			}
+			ctx.conn, conn = pconn, pconn
+		}
+		if s.KeepAlive && !gemini && ctx.sim == nil {
+			ctx.session = newSession()
+		}
+	}
+	for s.handleRequest(ctx) {
+		if s.queue == nil {
+			// Not answered by the worker pool, so wait right here
+			if ctx = s.nextRequest(ctx, conn); ctx == nil {
+				return
+			}
+			continue
+		}
+		// An idle session must not hold a worker, so the next request
+		// is queued like a new connection once it arrives
+		kept = true
+		go s.requeue(ctx, conn)
 		return
 	}
-	if fv, ok := handler.(*reflect.FuncValue); ok {
-		s.routes.Push(route{pattern, re, fv})
-	} else {
-		fv := reflect.NewValue(handler).(*reflect.FuncValue)
-		s.routes.Push(route{pattern, re, fv})
+}
+
+// handleRequest answers one request on the connection of ctx and reports
+// whether the connection stays open for another
+func (s *Server) handleRequest(ctx *Context) (keep bool) {
+	start := time.Nanoseconds()
+	journal := s.startJournal(ctx)
+	if ctx.session != nil {
+		ctx.session.use(ctx)
+	}
+	ctx.out = newBufferedConn(ctx.conn)
+	counter := &countingConn{Conn: ctx.out, limit: s.MaxResponseSize}
+	ctx.conn = counter
+	defer s.recoverRequest(ctx, counter)
+	ctx.Host = s.virtualHost(ctx.conn.LocalAddr())
+	s.stats.connOpened()
+	status, err := s.serve(ctx)
+	if ferr := ctx.Flush(); ferr != nil && status == StatusOK {
+		status, err = StatusError, ferr
+	}
+	s.respond(ctx, counter.written, status, err)
+	ctx.Flush()
+	ctx.mark("transfer")
+
+	elapsed := time.Nanoseconds() - start
+	failed := status != StatusOK || ctx.itemType == '3'
+	s.stats.record(ctx.itemType, counter.written, failed, elapsed)
+	if !failed && ctx.Request != "" {
+		s.stats.hit(ctx.Request, ctx.itemType, counter.written)
 	}
+	ctx.logTrace()
+	ctx.log(&LogEntry{RequestID: ctx.ID, Selector: ctx.Request, ClientIP: ctx.ClientIP(), Duration: elapsed, Bytes: counter.written, Outcome: status.String()})
+	s.writeJournal(ctx, journal, status.String())
+	return s.keepOpen(ctx, status)
 }
 
-func (s *Server) handle(ctx *Context) (err os.Error) {
-	defer ctx.conn.Close()
-	linereader := line.NewReader(bufio.NewReader(ctx.conn), 512)
-	read, _, err := linereader.ReadLine()
-	if err != nil {
-		s.Logger.Println("Malformed request from client")
+// respond logs the outcome of a request and, if nothing has been sent yet,
+// tells the client what went wrong
+func (s *Server) respond(ctx *Context, written int64, status Status, err os.Error) {
+	if status == StatusOK {
 		return
 	}
-	clientRequest := bytes.NewBuffer(read).String()
-	s.Logger.Printf("REQUEST: %s\n", clientRequest)
-	ctx.Request = "/"+strings.Trim(path.Clean(clientRequest), "/")
-	absReqPath := path.Clean(fmt.Sprintf("%s%s", s.Cwd, ctx.Request))
-		if !strings.HasPrefix(absReqPath, s.Cwd) {
-		s.Logger.Printf("Requested file not in document root")
+	switch {
+	case err == errKnownMissing:
+		// Logged when it was first found missing
+	case err != nil:
+		ctx.Logf("ERROR: `%s': %s: %s\n", ctx.Request, status, err)
+	default:
+		ctx.Logf("ERROR: `%s': %s\n", ctx.Request, status)
+	}
+	if written > 0 {
 		return
 	}
-	var requestedFile *os.File
-	if requestedFile, err = os.Open(absReqPath, 0, 0); err != nil {
+	switch status {
+	case StatusBadRequest:
+		ctx.Error("Bad request")
+	case StatusNotFound:
+		ctx.NotFound()
+	case StatusDenied:
+		ctx.Forbidden()
+	default:
+		ctx.ServerError()
+	}
+}
+
+// serve answers one request, leaving error replies to respond
+func (s *Server) serve(ctx *Context) (Status, os.Error) {
+	reader := bufio.NewReader(ctx.conn)
+	if ctx.session != nil {
+		reader = ctx.session.reader
+	}
+	clientRequest, err := readRequest(ctx.conn, reader)
+	if err != nil {
+		return StatusBadRequest, err
+	}
+	ctx.mark("read")
+	if clientRequest == KeepAliveRequest && ctx.session != nil {
+		return s.startSession(ctx), nil
+	}
+	if s.AcceptURLs && strings.HasPrefix(clientRequest, "gopher://") {
+		// Sloppy clients send the whole URL instead of its selector
+		if u, uerr := ParseURL(clientRequest); uerr == nil {
+			clientRequest = u.Selector
+		}
+	}
+	ctx.reader = reader
+	clientRequest, ctx.Query, ctx.extra = splitRequest(clientRequest)
+	if url, ok := urlSelector(clientRequest); ok {
+		// Cleaning the selector as a path would spoil the URL
+		ctx.Request = clientRequest
+		return s.serveURLRedirect(ctx, url)
+	}
+	ctx.Request = "/"+strings.Trim(path.Clean("/"+clientRequest), "/")
+	if ctx.Request == HealthSelector {
+		return s.ServeHealth(ctx), nil
+	}
+	s.takeToken(ctx)
+	// Logged only now, so that the tokens of protected areas stay out of
+	// the log
+	ctx.Logf("REQUEST: %s\n", strings.TrimRight(ctx.Request+"\t"+ctx.Query+"\t"+ctx.extra, "\t"))
+	if rewritten := s.rewrite(ctx.Request); rewritten != ctx.Request {
+		ctx.Logf("Rewrote `%s' to `%s'\n", ctx.Request, rewritten)
+		ctx.Request = rewritten
+	}
+	ctx.Request = s.resolve(ctx.Host, ctx.Request)
+	if r, rest := s.redirectFor(ctx.Request); r != nil {
+		if !r.Alias {
+			return s.serveRedirect(ctx, r, rest), nil
+		}
+		ctx.Request = r.target(rest)
+	}
+	if !s.authenticate(ctx) {
+		ctx.Error("Authentication required")
+		return StatusDenied, os.NewError("bad or missing token")
+	}
+	if !s.allowed(ctx, ctx.Request) {
+		return StatusDenied, os.NewError("denied by access rules for " + ctx.ClientIP())
+	}
+	if s.features.hidden(ctx.Request, ctx.ClientIP(), ctx.Host.Name) {
+		return StatusNotFound, os.NewError("behind a disabled feature")
+	}
+	if s.StatsSelector != "" && ctx.Request == s.StatsSelector {
+		return s.ServePopular(ctx), nil
+	}
+	if s.isAdmin(ctx.Request) {
+		return s.serveAdmin(ctx), nil
+	}
+	if s.InMaintenance() {
+		return s.serveMaintenance(ctx)
+	}
+	if p := s.phlogFor(ctx.Request); p != nil {
+		return s.servePhlog(ctx, p)
+	}
+	if g := s.guestbookFor(ctx.Request); g != nil {
+		return s.serveGuestbook(ctx, g)
+	}
+	if d := s.dropboxFor(ctx.Request); d != nil {
+		return s.serveDropbox(ctx, d)
+	}
+	if g := s.ccsoFor(ctx.Request); g != nil {
+		return g.ServeGopher(ctx), nil
+	}
+	if h := s.sqliteFor(ctx.Request); h != nil {
+		return s.guard(ctx, "sqlite:"+h.Pattern, func() Status { return h.serve(ctx) }), nil
+	}
+	if p := s.proxyFor(ctx.Request); p != nil {
+		return p.ServeGopher(ctx), nil
+	}
+	if r := s.route(ctx.Request, ctx.Query != ""); r != nil {
+		ctx.mark("open")
+		ctx.Params, ctx.paramNames = r.params(ctx.Request), r.names
+		return s.guard(ctx, r.pattern, func() Status { return s.runHandler(ctx, r) }), nil
+	}
+	if dir, format, ok := archiveSelector(ctx.Request); ok && s.Archives {
+		return s.serveArchive(ctx, dir, format)
+	}
+	absReqPath := path.Clean(fmt.Sprintf("%s%s", ctx.Host.Root, ctx.Request))
+	if !strings.HasPrefix(absReqPath, ctx.Host.Root) {
+		return StatusNotFound, os.NewError("not in document root")
+	}
+	if s.missing.missing(absReqPath) {
+		return StatusNotFound, errKnownMissing
+	}
+	if s.unpublished(ctx, absReqPath) {
+		return StatusNotFound, os.NewError("outside its publication window")
+	}
+	if s.privateSidecar(ctx, absReqPath) {
+		return StatusNotFound, os.NewError("private sidecar")
+	}
+	if s.FileCacheSize > 0 && ctx.extra == "" {
+		if data, release, ok := s.cachedData(absReqPath); ok {
+			defer release()
+			ctx.mark("open")
+			return s.sendFile(ctx, bytes.NewBuffer(data), s.fileType(absReqPath))
+		}
+	}
+	requestedFile, err := s.openFile(absReqPath)
+	if err != nil {
 		if patherr, ok := err.(*os.PathError); ok {
 			switch true {
+			case patherr.Error == os.ENOENT && ctx.Request == "/caps.txt":
+				return s.serveCaps(ctx), nil
+			case patherr.Error == os.ENOENT && ctx.Request == LicensesSelector:
+				return s.serveLicenses(ctx), nil
+			case patherr.Error == os.ENOENT && (ctx.Request == SitemapSelector || ctx.Request == SitemapTextSelector):
+				return s.serveSitemap(ctx), nil
+			case patherr.Error == os.ENOENT && isFeedSelector(ctx.Request):
+				return s.serveFeed(ctx, ctx.Request)
 			case patherr.Error == os.ENOENT:
-				ctx.Error(fmt.Sprintf("Resource `%s' not found", clientRequest))
-				s.Logger.Printf("ERROR: Resource `%s' not found\n", ctx.Request)
-				return
-			case patherr == os.EPERM || patherr == os.EACCES:
-				ctx.Error(fmt.Sprintf("Resource `%s' not found", clientRequest))
-				s.Logger.Printf("ERROR: Access denied for file `%s'\n", ctx.Request)
-				return
-			default:
-				s.Logger.Printf("ERROR: %s\n", err)
-				return
+				s.missing.add(absReqPath)
+				return StatusNotFound, nil
+			case patherr.Error == os.EPERM || patherr.Error == os.EACCES:
+				return StatusDenied, err
 			}
-		} else {
-			s.Logger.Printf("ERROR: %s\n", err)
-			return
 		}
+		return StatusError, err
 	}
+	defer requestedFile.Close()
 	stats, err := requestedFile.Stat()
 	if err != nil {
-		s.Logger.Printf("ERROR: Could not stat file `%s': %s\n", absReqPath, err)
-		return
+		return StatusError, err
 	}
-	if stats.IsDirectory() {
-		s.Directory(ctx, requestedFile)
-	} else if stats.IsRegular() {
-		s.Textfile(ctx, requestedFile)
-	} else {
-		ctx.Write(s.InfoLine("STUMPED"))
+	// Only files on disk can be mapped or seeked
+	diskFile, onDisk := requestedFile.(*os.File)
+	ctx.mark("open")
+	switch {
+	case strings.HasPrefix(ctx.extra, "!"):
+		return s.ServeAttributes(ctx, absReqPath, stats)
+	case s.tooLarge(stats):
+		return s.refuseTooLarge(ctx, stats)
+	case (strings.TrimSpace(ctx.extra) == "+"+ThumbnailView || strings.TrimSpace(ctx.extra) == "+"+PreviewView) && s.thumbnailable(absReqPath, stats):
+		return s.serveImageView(ctx, absReqPath, stats, strings.TrimSpace(ctx.extra)[1:])
+	case strings.TrimSpace(ctx.extra) == "+"+GzipView && s.compressible(absReqPath, stats):
+		return s.ServeCompressed(ctx, requestedFile, stats)
+	case stats.IsDirectory():
+		ctx.itemType = '1'
+		return s.Directory(ctx, requestedFile)
+	case stats.IsRegular() && notModified(ctx.extra, stats):
+		return s.serveNotModified(ctx, stats)
+	case stats.IsRegular() && strings.Index(ctx.extra, "offset=") != -1:
+		if !onDisk {
+			return StatusBadRequest, os.NewError("cannot resume a file that is not on disk")
+		}
+		if offset, ok := resumeOffset(ctx.extra); ok {
+			return s.ServeResumed(ctx, diskFile, stats, offset)
+		}
+		return StatusBadRequest, os.NewError("bad offset " + ctx.extra)
+	case stats.IsRegular() && ctx.extra == "" && s.MarkdownMode != MarkdownRaw && isMarkdown(absReqPath):
+		return s.serveMarkdown(ctx, requestedFile)
+	case stats.IsRegular():
+		if ctx.extra == "" && onDisk {
+			s.cacheFile(absReqPath, diskFile, stats)
+		}
+		return s.sendFile(ctx, requestedFile, s.itemType(stats))
 	}
-	return
+	w := NewEntryWriter(ctx)
+	w.Info("STUMPED")
+	w.Close()
+	return StatusOK, nil
 }
 
 func (s *Server) init() {
 	var err os.Error
-	s.Cwd, err = os.Getwd();
-	if err != nil {
-		s.Logger.Printf("No access to the working directory: %s\n", err);
-		os.Exit(1)
+	if s.Logger == nil {
+		s.Logger = NewLogger(NewTextSink(os.Stdout))
+	}
+	if s.Cwd == "" {
+		s.Cwd, err = os.Getwd();
+		if err != nil {
+			s.Logger.Printf("No access to the working directory: %s\n", err);
+			os.Exit(1)
+		}
+	}
+	s.defaultHost = &VirtualHost{Root: s.Cwd, CaseInsensitive: s.CaseInsensitive}
+	for _, vh := range s.vhosts {
+		if vh.Root == "" {
+			vh.Root = s.Cwd
+		}
+	}
+	for _, m := range s.mounts {
+		// git may run for a configured repository as long as a handler may
+		if g, ok := m.fs.(*GitFS); ok && m.configured {
+			g.Timeout = s.HandlerTimeout
+		}
 	}
 }
 
+// Run listens on the given hostname and port, or on UnixSocket if set, or
+// uses the socket passed by systemd or by the process this one replaces,
+// and serves it. It panics if it cannot listen, and returns once it has
+// handed the socket over to a new process with Upgrade.
 func (s *Server) Run(hostname string, port int) {
-	var err os.Error
-	s.init()
 	s.Hostname = hostname
 	s.Port = port
-	s.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.Hostname, s.Port))
+	l, err := inheritedListener()
+	if l == nil && err == nil {
+		l, err = systemdListener()
+	}
 	if err != nil {
 		panic(err)
 	}
-	s.Logger.Printf("listening on %s:%d...\n", s.Hostname, s.Port)
+	if l == nil && s.UnixSocket != "" {
+		if l, err = listenUnix(s.UnixSocket); err != nil {
+			panic(err)
+		}
+	}
+	var shards []net.Listener
+	if l == nil && s.ReusePort {
+		if shards, err = s.listenShards(); err != nil {
+			panic(err)
+		}
+		l, shards = shards[0], shards[1:]
+	}
+	if l == nil {
+		if l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.Hostname, s.Port)); err != nil {
+			panic(err)
+		}
+	}
+	s.upgradeMu.Lock()
+	s.listener = l
+	s.upgradeMu.Unlock()
+	if s.TLSCert != "" {
+		if l, err = tlsListener(l, s.TLSCert, s.TLSKey); err != nil {
+			panic(err)
+		}
+		for i := range shards {
+			if shards[i], err = tlsListener(shards[i], s.TLSCert, s.TLSKey); err != nil {
+				panic(err)
+			}
+		}
+	}
+	for _, shard := range shards {
+		go s.Serve(shard)
+	}
+	if err = s.Serve(l); err != nil {
+		panic(err)
+	}
+	s.drain()
+}
+
+// ListenAndServe listens on the TCP address addr and serves it
+func (s *Server) ListenAndServe(addr string) os.Error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS listens on the TCP address addr and serves it over TLS
+// with the given certificate and key files
+func (s *Server) ListenAndServeTLS(addr string, certFile string, keyFile string) os.Error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if l, err = tlsListener(l, certFile, keyFile); err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// tlsListener wraps l to accept TLS connections
+func tlsListener(l net.Listener, certFile string, keyFile string) (net.Listener, os.Error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}}), nil
+}
+
+// Serve answers the connections accepted on l until accepting fails, or
+// returns nil once Upgrade has handed the server's socket over. It
+// can be called for several listeners at once, which then share the
+// server's handlers and settings. The first call starts the subsystems and
+// drops privileges, so every listener should be bound before serving any.
+// Menus point back at Hostname and Port whichever listener a request came
+// in on. Connections from every listener are answered by the same pool of
+// workers.
+func (s *Server) Serve(l net.Listener) os.Error {
+	s.startOnce.Do(func() { s.start() })
+	s.Logger.Printf("listening on %s...\n", l.Addr())
 	for {
-		if conn, err := s.listener.Accept(); err == nil {
-			go s.handle(&Context{conn: conn})
+		conn, err := l.Accept()
+		if err != nil && s.upgrading() {
+			return nil
+		}
+		if err != nil {
+			s.Logger.Printf("ERROR: Could not accept on %s: %s\n", l.Addr(), err)
+			return err
+		}
+		s.queue <- s.newContext(conn, time.Nanoseconds())
+	}
+	return nil
+}
+
+// start prepares the server for its first listener
+func (s *Server) start() {
+	s.init()
+	s.started = time.Nanoseconds()
+	// Subsystems may bind privileged ports, and nothing may run before
+	// privileges are dropped
+	bound := s.bindSubsystems()
+	if err := s.dropPrivileges(); err != nil {
+		s.Logger.Printf("ERROR: Could not drop privileges: %s\n", err)
+		os.Exit(1)
+	}
+	s.startSubsystems(bound)
+	s.startWorkers()
+}
+
+// boundSubsystem is a subsystem whose listener is bound but not yet served
+type boundSubsystem struct {
+	name string
+	serve func() os.Error
+}
+
+// bindSubsystems binds the listeners of the optional subsystems. A
+// subsystem that fails to start is handled by its failure policy instead
+// of stopping the server.
+func (s *Server) bindSubsystems() (bound []boundSubsystem) {
+	if s.MetricsAddr != "" {
+		if listener, err := net.Listen("tcp", s.MetricsAddr); err != nil {
+			s.Degrade("metrics", err)
+		} else {
+			bound = append(bound, boundSubsystem{"metrics", func() os.Error { return s.serveMetrics(listener) }})
+		}
+	}
+	if s.FingerAddr != "" {
+		if listener, err := net.Listen("tcp", s.FingerAddr); err != nil {
+			s.Degrade("finger", err)
+		} else {
+			bound = append(bound, boundSubsystem{"finger", func() os.Error { return s.ServeFinger(listener) }})
+		}
+	}
+	if s.GeminiAddr != "" {
+		if listener, err := s.listenGemini(); err != nil {
+			s.Degrade("gemini", err)
+		} else {
+			bound = append(bound, boundSubsystem{"gemini", func() os.Error { return s.ServeGemini(listener) }})
+		}
+	}
+	if s.ControlSocket != "" {
+		if listener, err := listenUnix(s.ControlSocket); err != nil {
+			s.Degrade("control", err)
+		} else {
+			bound = append(bound, boundSubsystem{"control", func() os.Error { return s.serveControl(listener) }})
+		}
+	}
+	return
+}
+
+// startSubsystems serves the listeners bindSubsystems bound and starts the
+// subsystems that need none, once privileges are dropped
+func (s *Server) startSubsystems(bound []boundSubsystem) {
+	for _, b := range bound {
+		s.Recover(b.name)
+		go func(b boundSubsystem) {
+			s.Degrade(b.name, b.serve())
+		}(b)
+	}
+	go s.checkUpstreams()
+	if s.StatsFile != "" {
+		if err := s.startStatsFile(true); err != nil {
+			s.Degrade("stats-file", err)
+		} else {
+			s.Recover("stats-file")
+		}
+	}
+	s.checkDropboxes()
+	if s.WatchFiles {
+		if err := s.startWatcher(); err != nil {
+			s.Degrade("watcher", err)
+		} else {
+			s.Recover("watcher")
 		}
 	}
 }
 
+// Run serves DefaultServer on the given hostname and port
 func Run(hostname string, port int) {
-	server.Run(hostname, port)
+	DefaultServer.Run(hostname, port)
 }
 
-func main() {
-	var defaulthost string
-	var err os.Error
-	if defaulthost, err = os.Hostname(); err != nil {
-		fmt.Fprintln(os.Stderr, "could not determine hostname, defaulting to localhost")
-		defaulthost = "localhost"
-	}
-	var hostname *string = flag.String("hostname", defaulthost, "hostname of the server")
-	var port *int = flag.Int("port", 70, "port of the server")
-	flag.Parse()
-	Run(*hostname, *port)
-}
\ No newline at end of file
+// Serve serves DefaultServer on l
+func Serve(l net.Listener) os.Error {
+	return DefaultServer.Serve(l)
+}
+
+// ListenAndServe serves DefaultServer on the TCP address addr
+func ListenAndServe(addr string) os.Error {
+	return DefaultServer.ListenAndServe(addr)
+}
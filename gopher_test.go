@@ -0,0 +1,58 @@
+package gopher_test
+
+import (
+	"gopher"
+	"gopher/gophertest"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testDir holds the files tests need on disk, such as configurations
+const testDir = "_test/data"
+
+// writeTestFile stores data in testDir and returns its name
+func writeTestFile(t *testing.T, name string, data string) string {
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("%s", err)
+	}
+	name = testDir + "/" + name
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return name
+}
+
+// newServer returns a server of files, named by their selectors, held in
+// memory and configured with the lines of config
+func newServer(t *testing.T, files map[string]string, config ...string) *gopher.Server {
+	fs := gopher.NewMemFS()
+	for name, data := range files {
+		if err := fs.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+	}
+	conf := writeTestFile(t, "site.conf", "root /site\n"+strings.Join(config, "\n")+"\n")
+	s := gopher.NewServer()
+	s.Logger = gopher.NewLogger()
+	if err := s.LoadConfig(conf); err != nil {
+		t.Fatalf("%s", err)
+	}
+	s.Mount("/site", fs)
+	return s
+}
+
+// newSite starts serving what newServer returns
+func newSite(t *testing.T, files map[string]string, config ...string) *gophertest.Server {
+	return gophertest.NewServer(newServer(t, files, config...))
+}
+
+// get requests selector, failing the test if the request cannot be sent
+func get(t *testing.T, ts *gophertest.Server, selector string) *gophertest.ResponseRecorder {
+	rec, err := ts.Get(selector)
+	if err != nil {
+		t.Fatalf("%s: %s", selector, err)
+	}
+	return rec
+}
@@ -0,0 +1,123 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"time"
+)
+
+// Gopher+ clients ask for the attributes of an item by sending its selector
+// followed by a tab and "!". The reply starts with a +-1 header, holds one
+// block per attribute and ends with the terminating period.
+
+// AttributeBlock is one +NAME: block of a Gopher+ attribute reply
+type AttributeBlock struct {
+	Name  string
+	Lines []string
+}
+
+// itemType returns the item type the server lists a file as
+func (s *Server) itemType(info *os.FileInfo) byte {
+	if info.IsDirectory() {
+		return '1'
+	}
+	return s.fileType(info.Name)
+}
+
+// Attributes returns the Gopher+ attribute blocks of the item at selector,
+// whose file is absPath
+func (s *Server) Attributes(ctx *Context, selector string, absPath string, info *os.FileInfo) []*AttributeBlock {
+	attrs := s.itemAttributes(absPath)
+	host, port := s.advertise(selector)
+	entry := &MenuEntry{Type: s.itemType(info), Display: s.listingName(absPath, s.names(path.Dir(absPath))), Selector: selector, Host: host, Port: port}
+	blocks := []*AttributeBlock{
+		&AttributeBlock{"INFO", []string{entry.String() + "\t+"}},
+	}
+
+	admin := &AttributeBlock{Name: "ADMIN"}
+	if s.Admin != "" {
+		admin.Lines = append(admin.Lines, "Admin: "+s.Admin)
+	}
+	for _, line := range attrs.Admin {
+		admin.Lines = append(admin.Lines, "Admin: "+line)
+	}
+	mtime := time.SecondsToUTC(info.Mtime_ns / 1e9)
+	admin.Lines = append(admin.Lines, fmt.Sprintf("Mod-Date: %s <%s>", mtime.Format(time.RFC1123), mtime.Format(ModDateFormat)))
+	if info.IsRegular() {
+		admin.Lines = append(admin.Lines, fmt.Sprintf("Size: %d", info.Size))
+	}
+	blocks = append(blocks, admin)
+	if len(attrs.Abstract) > 0 {
+		blocks = append(blocks, &AttributeBlock{"ABSTRACT", attrs.Abstract})
+	}
+	if l := s.license(absPath); l != nil {
+		blocks = append(blocks, &AttributeBlock{"LICENSE", l.Lines()})
+	}
+
+	views := &AttributeBlock{Name: "VIEWS"}
+	if info.IsDirectory() {
+		views.Lines = []string{"application/gopher+-menu: <0k>"}
+	} else if s.thumbnailable(absPath, info) {
+		views.Lines = s.imageViews(absPath, info)
+	} else {
+		views.Lines = []string{fmt.Sprintf("text/plain; charset=%s: <%dk>", s.charset(), (info.Size+1023)/1024)}
+		if s.compressible(absPath, info) {
+			views.Lines = append(views.Lines, fmt.Sprintf("%s: <%dk>", GzipView, s.gzipViewSize(absPath, info)))
+		}
+	}
+	return append(blocks, views)
+}
+
+// ServeAttributes sends the Gopher+ attributes of an item
+func (s *Server) ServeAttributes(ctx *Context, absPath string, info *os.FileInfo) (Status, os.Error) {
+	if _, err := ctx.Write("+-1"); err != nil {
+		return StatusError, err
+	}
+	for _, block := range s.Attributes(ctx, ctx.Request, absPath, info) {
+		ctx.Write("+" + block.Name + ":")
+		for _, line := range block.Lines {
+			ctx.Write(" " + line)
+		}
+	}
+	if _, err := ctx.Write("."); err != nil {
+		return StatusError, err
+	}
+	ctx.Logf("Served attributes of `%s'\n", ctx.Request)
+	return StatusOK, nil
+}
+
+// serveCaps sends a generated caps.txt describing the server, for sites that
+// do not provide their own
+func (s *Server) serveCaps(ctx *Context) Status {
+	ctx.itemType = '0'
+	lines := []string{
+		"CAPS",
+		"",
+		"# Generated by gopherd",
+		"",
+		"CapsVersion=1",
+		"ExpireCapsAfter=3600",
+		"",
+		"PathDelimeter=/",
+		"PathIdentity=.",
+		"PathParent=..",
+		"PathParentDouble=FALSE",
+		"PathKeepPreDelimeter=FALSE",
+		"",
+		"ServerSoftware=gopherd",
+		"ServerArchitecture=" + runtime.GOOS + "/" + runtime.GOARCH,
+		"ServerSupportsStdinScripts=FALSE",
+		"ServerDefaultEncoding=" + s.charset(),
+	}
+	if s.Admin != "" {
+		lines = append(lines, "ServerAdmin="+s.Admin)
+	}
+	for _, line := range lines {
+		ctx.Write(line)
+	}
+	ctx.Write(".")
+	ctx.Logf("Served generated caps.txt\n")
+	return StatusOK
+}
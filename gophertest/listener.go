@@ -0,0 +1,73 @@
+// Package gophertest helps test Gopher handlers and sites without binding
+// real sockets. A Server answers requests with a gopher.Server over
+// in-memory connections, and a ResponseRecorder holds what it sent back:
+//
+//	server := gopher.NewServer()
+//	server.HandleFunc("^/hello$", hello)
+//	ts := gophertest.NewServer(server)
+//	defer ts.Close()
+//	rec, err := ts.Get("/hello")
+//	menu, err := rec.Menu()
+package gophertest
+
+import (
+	"net"
+	"os"
+	"sync"
+)
+
+// pipeAddr is the address of either end of an in-memory connection
+type pipeAddr struct{}
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return "pipe" }
+
+// Listener is a net.Listener whose connections are made in memory by Dial
+type Listener struct {
+	conns  chan net.Conn
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewListener returns a Listener ready to be served
+func NewListener() *Listener {
+	return &Listener{conns: make(chan net.Conn)}
+}
+
+// Dial connects to the listener, returning the client's end of the
+// connection. It blocks until the connection is accepted.
+func (l *Listener) Dial() (net.Conn, os.Error) {
+	l.mu.Lock()
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		return nil, os.EINVAL
+	}
+	client, server := net.Pipe()
+	l.conns <- server
+	return client, nil
+}
+
+// Accept waits for the next connection made by Dial
+func (l *Listener) Accept() (net.Conn, os.Error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, os.EINVAL
+	}
+	return conn, nil
+}
+
+// Close stops the listener; Accept then fails
+func (l *Listener) Close() os.Error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.conns)
+	}
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
@@ -0,0 +1,69 @@
+package gophertest
+
+import (
+	"bytes"
+	"gopher"
+	"os"
+	"strings"
+)
+
+// ResponseRecorder holds the reply to one request
+type ResponseRecorder struct {
+	Body *bytes.Buffer
+}
+
+// NewRecorder returns an empty ResponseRecorder
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{Body: new(bytes.Buffer)}
+}
+
+// Write adds to the recorded reply
+func (r *ResponseRecorder) Write(p []byte) (int, os.Error) {
+	return r.Body.Write(p)
+}
+
+// Lines returns the reply split into lines, without their <CR><LF>
+func (r *ResponseRecorder) Lines() []string {
+	text := strings.TrimRight(r.Body.String(), "\r\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n", -1)
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines
+}
+
+// Text returns a text reply without its terminating period and with
+// dot-stuffing undone, as a client would show it
+func (r *ResponseRecorder) Text() string {
+	var buf bytes.Buffer
+	for _, line := range r.Lines() {
+		if line == "." {
+			break
+		}
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		buf.WriteString(line + "\n")
+	}
+	return buf.String()
+}
+
+// Menu parses a menu reply
+func (r *ResponseRecorder) Menu() ([]*gopher.MenuEntry, os.Error) {
+	return gopher.ParseMenu(bytes.NewBuffer(r.Body.Bytes()))
+}
+
+// ErrorText returns the text of the first error item of a menu reply, or "" if
+// there is none
+func (r *ResponseRecorder) ErrorText() string {
+	entries, _ := r.Menu()
+	for _, entry := range entries {
+		if entry.Type == '3' {
+			return entry.Display
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package gophertest
+
+import (
+	"fmt"
+	"gopher"
+	"io"
+	"os"
+)
+
+// Server serves a gopher.Server on a Listener for the length of a test
+type Server struct {
+	Server   *gopher.Server
+	Listener *Listener
+}
+
+// NewServer starts serving s in memory. Menus point at localhost port 70
+// unless s has a hostname and port set.
+func NewServer(s *gopher.Server) *Server {
+	if s.Hostname == "" {
+		s.Hostname = "localhost"
+	}
+	if s.Port == 0 {
+		s.Port = 70
+	}
+	ts := &Server{Server: s, Listener: NewListener()}
+	go s.Serve(ts.Listener)
+	return ts
+}
+
+// Get sends request, a selector optionally followed by a tab and search
+// string or Gopher+ data, and records the whole reply
+func (ts *Server) Get(request string) (*ResponseRecorder, os.Error) {
+	conn, err := ts.Listener.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = fmt.Fprintf(conn, "%s\r\n", request); err != nil {
+		return nil, err
+	}
+	rec := NewRecorder()
+	if _, err = io.Copy(rec, conn); err != nil && err != os.EOF {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Close stops serving
+func (ts *Server) Close() {
+	ts.Listener.Close()
+}
@@ -0,0 +1,133 @@
+package gopher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A guestbook at prefix lists its approved entries and offers a search
+// item, prefix/sign, whose search string is taken as a new entry. New
+// entries are appended to File with .pending added for the owner to
+// review; moving a line into File approves it. Lines are of the form
+//    2011-03-04 15:04<TAB>message
+
+// GuestbookMaxLength is the longest entry accepted, in bytes
+var GuestbookMaxLength = 500
+
+// Guestbook is a moderated guestbook
+type Guestbook struct {
+	Prefix string
+	File   string // Approved entries; submissions go to File.pending
+	mu     sync.Mutex
+}
+
+// AddGuestbook serves a guestbook at prefix kept in file
+func (s *Server) AddGuestbook(prefix string, file string) {
+	s.guestbooks = append(s.guestbooks, &Guestbook{Prefix: "/" + strings.Trim(prefix, "/"), File: file})
+}
+
+// configureGuestbook handles a `guestbook prefix file' line
+func (s *Server) configureGuestbook(args []string) os.Error {
+	if len(args) != 2 {
+		return os.NewError("expected a selector prefix and a file")
+	}
+	s.AddGuestbook(args[0], args[1])
+	return nil
+}
+
+// guestbookFor returns the guestbook serving selector, if any
+func (s *Server) guestbookFor(selector string) *Guestbook {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, g := range s.guestbooks {
+		if selector == g.Prefix || selector == g.Prefix+"/sign" {
+			return g
+		}
+	}
+	return nil
+}
+
+// cleanEntry makes a submission fit for a single info line
+func cleanEntry(text string) string {
+	text = strings.Map(func(c int) int {
+		if c < ' ' || c == 0x7f {
+			return ' '
+		}
+		return c
+	}, text)
+	text = strings.TrimSpace(text)
+	if len(text) > GuestbookMaxLength {
+		text = text[:GuestbookMaxLength]
+	}
+	return text
+}
+
+// sign appends an entry for moderation
+func (g *Guestbook) sign(text string) os.Error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	file, err := os.Open(g.File+".pending", os.O_WRONLY|os.O_APPEND|os.O_CREAT, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stamp := time.LocalTime().Format("2006-01-02 15:04")
+	_, err = fmt.Fprintf(file, "%s\t%s\n", stamp, text)
+	return err
+}
+
+// entries returns the approved entries, newest first
+func (g *Guestbook) entries() []string {
+	data, err := ioutil.ReadFile(g.File)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n", -1)
+	entries := make([]string, 0, len(lines))
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// serveGuestbook sends the guestbook menu, or takes a submission
+func (s *Server) serveGuestbook(ctx *Context, g *Guestbook) (Status, os.Error) {
+	w := NewEntryWriter(ctx)
+	if ctx.Request == g.Prefix+"/sign" {
+		text := cleanEntry(ctx.Query)
+		if text == "" {
+			w.Info("Your message was empty.")
+		} else if err := g.sign(text); err != nil {
+			ctx.Logf("ERROR: Could not save guestbook entry for `%s': %s\n", g.Prefix, err)
+			w.Info("Sorry, your message could not be saved.")
+		} else {
+			ctx.Logf("New guestbook entry for `%s' from %s\n", g.Prefix, ctx.ClientIP())
+			w.Info("Thank you! Your message will appear once it is approved.")
+		}
+		w.Item('1', "Back to the guestbook", g.Prefix)
+		return StatusOK, w.Close()
+	}
+	w.Item('7', "Sign the guestbook", g.Prefix+"/sign")
+	w.Info("")
+	entries := g.entries()
+	if len(entries) == 0 {
+		w.Info("No entries yet.")
+	}
+	for _, entry := range entries {
+		fields := strings.Split(entry, "\t", 2)
+		if len(fields) == 2 {
+			w.Info(fields[0])
+			entry = fields[1]
+		}
+		w.Info("  " + s.displayName(entry))
+		w.Info("")
+	}
+	ctx.Logf("Served guestbook `%s'\n", g.Prefix)
+	return StatusOK, w.Close()
+}
@@ -0,0 +1,238 @@
+package gopher
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Status is the outcome of a request. The server logs and counts it, and
+// unless the handler already sent something, answers the client with a
+// matching error item.
+type Status int
+
+const (
+	StatusOK         Status = iota
+	StatusNotFound          // The selector does not exist
+	StatusDenied            // The client may not have the selector
+	StatusError             // Something went wrong on the server's side
+	StatusBadRequest        // The client sent a malformed request
+)
+
+var statusNames = []string{"ok", "not-found", "denied", "error", "bad-request"}
+
+func (st Status) String() string {
+	if st < 0 || int(st) >= len(statusNames) {
+		return "error"
+	}
+	return statusNames[st]
+}
+
+// A Handler responds to a Gopher request
+type Handler interface {
+	ServeGopher(ctx *Context) Status
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface
+type HandlerFunc func(ctx *Context) Status
+
+func (f HandlerFunc) ServeGopher(ctx *Context) Status {
+	return f(ctx)
+}
+
+type route struct {
+	pattern string
+	re      *regexp.Regexp
+	names   []string // Names of the pattern's groups, "" for unnamed ones
+	handler Handler
+	search  bool  // Only for requests carrying a search string
+	timeout int64 // Nanoseconds the handler may run, HandlerTimeout if 0
+}
+
+// addRoute appends a route for pattern, whose regexp form is expr
+func (s *Server) addRoute(pattern string, expr string, handler Handler, search bool) os.Error {
+	expr, names := namedGroups(expr)
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		s.Logger.Printf("Route failed to compile %q\n", pattern)
+		return err
+	}
+	s.routes.Push(&route{pattern, re, names, handler, search, 0})
+	return nil
+}
+
+// Handle registers the handler for selectors matching the regexp pattern.
+// Routes are tried in the order they were registered, before the document
+// root is consulted. What the pattern's groups match is in Context.Params,
+// and groups written (?P<name>re) can be looked up with Context.Param.
+func (s *Server) Handle(pattern string, handler Handler) os.Error {
+	return s.addRoute(pattern, pattern, handler, false)
+}
+
+// HandleGlob registers the handler for selectors matching the glob pattern,
+// in which * stands for any part of one path segment, ** for anything
+// including slashes and ? for one character of a segment:
+//
+//	/blog/*
+//	/files/**.txt
+//
+// The parts matched by the wildcards are in Context.Params.
+func (s *Server) HandleGlob(pattern string, handler Handler) os.Error {
+	return s.addRoute(pattern, globRegexp(pattern), handler, false)
+}
+
+// HandleGlobFunc registers the handler function for selectors matching the
+// glob pattern
+func (s *Server) HandleGlobFunc(pattern string, f func(ctx *Context) Status) os.Error {
+	return s.HandleGlob(pattern, HandlerFunc(f))
+}
+
+// globRegexp translates a glob pattern into an anchored regexp capturing
+// what each wildcard matches
+func globRegexp(pattern string) string {
+	var b bytes.Buffer
+	b.WriteByte('^')
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString("(.*)")
+			i++
+		case c == '*':
+			b.WriteString("([^/]*)")
+		case c == '?':
+			b.WriteString("([^/])")
+		case strings.IndexRune(`\.+()|[]{}^$`, int(c)) != -1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// params returns the parts of selector captured by the route's pattern
+func (r *route) params(selector string) []string {
+	if m := r.re.FindStringSubmatch(selector); len(m) > 1 {
+		return m[1:]
+	}
+	return nil
+}
+
+// namedGroups strips the names from the (?P<name>re) groups of a regexp,
+// which the regexp package does not accept, returning the name of every
+// group in order
+func namedGroups(expr string) (string, []string) {
+	var b bytes.Buffer
+	var names []string
+	inClass := false
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '\\' && i+1 < len(expr):
+			b.WriteByte(c)
+			i++
+			c = expr[i]
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '(':
+			name := ""
+			if strings.HasPrefix(expr[i+1:], "?P<") {
+				if end := strings.Index(expr[i:], ">"); end != -1 {
+					name = expr[i+4 : i+end]
+					i += end
+				}
+			}
+			names = append(names, name)
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), names
+}
+
+// Param returns what the group of the matching route named name captured,
+// or "" if the route has no such group
+func (ctx *Context) Param(name string) string {
+	for i, n := range ctx.paramNames {
+		if n == name && i < len(ctx.Params) {
+			return ctx.Params[i]
+		}
+	}
+	return ""
+}
+
+// HandleSearch registers the handler for search requests, those carrying
+// a search string in Context.Query, at selectors matching pattern. For
+// such requests search routes are tried before the others, so a pattern
+// can have a plain handler for its prompt and a search handler for the
+// results.
+func (s *Server) HandleSearch(pattern string, handler Handler) os.Error {
+	return s.addRoute(pattern, pattern, handler, true)
+}
+
+// HandleSearchFunc registers the handler function for search requests at
+// selectors matching pattern
+func (s *Server) HandleSearchFunc(pattern string, f func(ctx *Context) Status) os.Error {
+	return s.HandleSearch(pattern, HandlerFunc(f))
+}
+
+// HandleFunc registers the handler function for selectors matching pattern
+func (s *Server) HandleFunc(pattern string, f func(ctx *Context) Status) os.Error {
+	return s.Handle(pattern, HandlerFunc(f))
+}
+
+// route returns the first route matching the selector,
+// preferring search routes for requests with a search string
+func (s *Server) route(selector string, search bool) *route {
+	if search {
+		for i := 0; i < s.routes.Len(); i++ {
+			r := s.routes.At(i).(*route)
+			if r.search && r.re.MatchString(selector) {
+				return r
+			}
+		}
+	}
+	for i := 0; i < s.routes.Len(); i++ {
+		r := s.routes.At(i).(*route)
+		if !r.search && r.re.MatchString(selector) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Handle registers the handler on DefaultServer
+func Handle(pattern string, handler Handler) os.Error {
+	return DefaultServer.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler function on DefaultServer
+func HandleFunc(pattern string, f func(ctx *Context) Status) os.Error {
+	return DefaultServer.HandleFunc(pattern, f)
+}
+
+// HandleGlob registers the handler for a glob pattern on DefaultServer
+func HandleGlob(pattern string, handler Handler) os.Error {
+	return DefaultServer.HandleGlob(pattern, handler)
+}
+
+// HandleGlobFunc registers the handler function for a glob pattern on
+// DefaultServer
+func HandleGlobFunc(pattern string, f func(ctx *Context) Status) os.Error {
+	return DefaultServer.HandleGlobFunc(pattern, f)
+}
+
+// HandleSearch registers the handler for search requests on DefaultServer
+func HandleSearch(pattern string, handler Handler) os.Error {
+	return DefaultServer.HandleSearch(pattern, handler)
+}
+
+// HandleSearchFunc registers the handler function for search requests on
+// DefaultServer
+func HandleSearchFunc(pattern string, f func(ctx *Context) Status) os.Error {
+	return DefaultServer.HandleSearchFunc(pattern, f)
+}
@@ -0,0 +1,44 @@
+package gopher
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var globTests = []struct {
+	pattern  string
+	selector string
+	params   []string // nil when the selector must not match
+}{
+	{"/blog/*", "/blog/first-post", []string{"first-post"}},
+	{"/blog/*", "/blog/", []string{""}},
+	{"/blog/*", "/blog/2011/post", nil},
+	{"/files/**.txt", "/files/a/b/notes.txt", []string{"a/b/notes"}},
+	{"/files/**.txt", "/files/notes.gif", nil},
+	{"/files/**.txt", "/files/notesXtxt", nil},
+	{"/*/*", "/phlog/post", []string{"phlog", "post"}},
+	{"/v?/*", "/v1/list", []string{"1", "list"}},
+	{"/v?/*", "/v10/list", nil},
+	{"/a+b(c)", "/a+b(c)", []string{}},
+	{"/a+b(c)", "/aab(c)", nil},
+	{"/about", "/about", []string{}},
+	{"/about", "/about/more", nil},
+}
+
+func TestGlobRegexp(t *testing.T) {
+	for _, test := range globTests {
+		re, err := regexp.Compile(globRegexp(test.pattern))
+		if err != nil {
+			t.Errorf("%q: %s", test.pattern, err)
+			continue
+		}
+		var params []string
+		if m := re.FindStringSubmatch(test.selector); m != nil {
+			params = m[1:]
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%q matching %q: got %q, want %q", test.pattern, test.selector, params, test.params)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package gopher
+
+import (
+	"os"
+	"strings"
+)
+
+// Files whose lines are shown as info lines above and below an automatic
+// directory listing
+const (
+	HeaderFile = ".header"
+	FooterFile = ".footer"
+)
+
+// readSidecar returns the contents of a file kept next to served content to
+// describe it, such as a listing header
+func (s *Server) readSidecar(name string) ([]byte, os.Error) {
+	if s.sidecars != nil && !s.mounted(name) {
+		return s.sidecars.read(name)
+	}
+	return s.readFile(name)
+}
+
+// infoLines reads a sidecar text file into lines fit for info entries. A
+// missing file has no lines.
+func (s *Server) infoLines(name string) []string {
+	data, err := s.readSidecar(name)
+	if err != nil {
+		return nil
+	}
+	return s.textLines(data)
+}
+
+// textLines splits text into lines fit for info entries
+func (s *Server) textLines(data []byte) []string {
+	text := strings.TrimRight(string(data), "\r\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n", -1)
+	for i, line := range lines {
+		line = strings.Replace(strings.TrimRight(line, "\r"), "\t", "        ", -1)
+		lines[i] = s.displayName(line)
+	}
+	return lines
+}
+
+// writeInfoFile sends the lines of a sidecar text file as info lines
+func (s *Server) writeInfoFile(w *EntryWriter, name string) {
+	for _, line := range s.infoLines(name) {
+		w.Info(line)
+	}
+}
+
+// listingHidden reports whether a file is kept out of automatic listings
+func listingHidden(name string) bool {
+	return name == HeaderFile || name == FooterFile || name == "gophermap" || name == TemplateGophermap || name == CapDir || isNamesFile(name) ||
+		strings.HasSuffix(name, LicenseSuffix) || strings.HasSuffix(name, AbstractSuffix) || strings.HasSuffix(name, ScheduleSuffix)
+}
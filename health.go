@@ -0,0 +1,45 @@
+package gopher
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Monitoring systems and load balancers can poll HealthSelector, which is
+// answered before access rules, rewrites and the file system are looked
+// at. The first line of the menu reads "Status: ok", or "Status: degraded"
+// while an optional subsystem is down.
+
+// HealthSelector is where the server reports its health
+const HealthSelector = "/.well-known/health"
+
+// Version is the version of the server software
+var Version = "devel"
+
+// ServeHealth sends the status, uptime and version of the server
+func (s *Server) ServeHealth(ctx *Context) Status {
+	status := "ok"
+	var down []string
+	for _, sub := range s.Subsystems() {
+		if !sub.Up {
+			status = "degraded"
+			down = append(down, sub.Name)
+		}
+	}
+	st := s.Stats()
+	w := NewEntryWriter(ctx)
+	w.SkipBanner()
+	w.Info("Status: " + status)
+	for _, name := range down {
+		w.Info("Down: " + name)
+	}
+	if s.InMaintenance() {
+		w.Info("Maintenance: on")
+	}
+	w.Info("Uptime: " + s.uptime())
+	w.Info("Version: gopherd " + Version + " (" + runtime.Version() + ")")
+	w.Info(fmt.Sprintf("Active connections: %d", st.ActiveConnections))
+	w.Info(fmt.Sprintf("Requests: %d", st.Requests))
+	w.Close()
+	return StatusOK
+}
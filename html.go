@@ -0,0 +1,50 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// HTML files are served as item type h (see DefaultItemTypes). A selector of the form
+//    URL:http://example.com/
+// is the common convention for linking to the web from a menu: clients
+// that know it open the URL themselves, and those that do not request the
+// selector and are sent a small HTML page leading to the URL.
+
+// urlSelector returns the URL of a URL: selector, if the selector is one
+func urlSelector(selector string) (string, bool) {
+	if !strings.HasPrefix(selector, "URL:") && !strings.HasPrefix(selector, "/URL:") {
+		return "", false
+	}
+	return selector[strings.Index(selector, ":")+1:], true
+}
+
+// safeURLScheme reports whether a page may lead to url; scripts may not
+// hide behind a link
+func safeURLScheme(url string) bool {
+	i := strings.Index(url, ":")
+	if i == -1 {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(url[:i])) {
+	case "javascript", "vbscript", "data", "file":
+		return false
+	}
+	return true
+}
+
+// serveURLRedirect sends the HTML page leading to the URL of a URL:
+// selector
+func (s *Server) serveURLRedirect(ctx *Context, url string) (Status, os.Error) {
+	if !safeURLScheme(url) {
+		return StatusBadRequest, os.NewError("refusing to lead to " + url)
+	}
+	ctx.itemType = 'h'
+	u := htmlEscape(url)
+	fmt.Fprintf(ctx.conn, "<!DOCTYPE html>\n<html>\n<head>\n<meta http-equiv=\"refresh\" content=\"2; url=%s\">\n<title>%s</title>\n</head>\n<body>\n"+
+		"<p>You are following a link from gopher to a web site. You will be taken there in a moment; if not, follow this link:</p>\n"+
+		"<p><a href=\"%s\">%s</a></p>\n</body>\n</html>\n", u, u, u, u)
+	ctx.Logf("Led `%s' to the web\n", ctx.Request)
+	return StatusOK, nil
+}
@@ -0,0 +1,56 @@
+package gopher
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// A gophermap line
+//    =include path
+// splices in the entries of another gophermap, given relative to the
+// including map's directory or, starting with a slash, to the document
+// root. Included maps may include others, up to MaxIncludeDepth deep; a
+// map that includes itself, directly or not, is skipped. Selectors in an
+// included map are relative to the directory being served.
+
+// MaxIncludeDepth limits how deeply gophermaps may include each other
+var MaxIncludeDepth = 8
+
+// includeDirective returns the path of a gophermap include line, if it is
+// one
+func includeDirective(line string) (string, bool) {
+	if !strings.HasPrefix(line, "=include ") {
+		return "", false
+	}
+	return strings.TrimSpace(line[len("=include "):]), true
+}
+
+// includeGophermap sends the entries of the gophermap name, included from
+// a map in dir
+func (s *Server) includeGophermap(ctx *Context, w *EntryWriter, dir string, name string, including []string) os.Error {
+	var file string
+	if strings.HasPrefix(name, "/") {
+		file = path.Join(ctx.Host.Root, name)
+	} else {
+		file = path.Join(dir, name)
+	}
+	if !strings.HasPrefix(file, ctx.Host.Root+"/") {
+		return os.NewError("outside the document root")
+	}
+	if len(including) >= MaxIncludeDepth {
+		return os.NewError(fmt.Sprintf("more than %d levels of includes", MaxIncludeDepth))
+	}
+	for _, f := range including {
+		if f == file {
+			return os.NewError("include cycle")
+		}
+	}
+	data, err := s.readSidecar(file)
+	if err != nil {
+		return err
+	}
+	return s.gophermapLines(ctx, w, bytes.NewBuffer(data), path.Dir(file), append(including, file))
+}
@@ -0,0 +1,59 @@
+package gopher
+
+import (
+	"os"
+)
+
+// A directory without a gophermap can have an index file, the first of
+// IndexFiles it holds. With IndexMode IndexServe the index is served as a
+// text document in place of the listing; with IndexPrepend its lines are
+// shown as info lines at the top of the listing.
+const (
+	IndexOff = iota
+	IndexServe
+	IndexPrepend
+)
+
+// DefaultIndexFiles are looked for when IndexFiles is empty
+var DefaultIndexFiles = []string{"index.txt", "about.txt", "README"}
+
+// ParseIndexMode parses the name of an index mode: off, serve or prepend
+func ParseIndexMode(name string) (int, os.Error) {
+	switch name {
+	case "off":
+		return IndexOff, nil
+	case "serve":
+		return IndexServe, nil
+	case "prepend":
+		return IndexPrepend, nil
+	}
+	return IndexOff, os.NewError("expected off, serve or prepend")
+}
+
+// indexFile returns the path of the index file of dir, if it has one
+func (s *Server) indexFile(dir string) (string, bool) {
+	if s.IndexMode == IndexOff {
+		return "", false
+	}
+	names := s.IndexFiles
+	if len(names) == 0 {
+		names = DefaultIndexFiles
+	}
+	for _, name := range names {
+		if info, err := s.statFile(dir + "/" + name); err == nil && info.IsRegular() {
+			return dir + "/" + name, true
+		}
+	}
+	return "", false
+}
+
+// serveIndex sends the index file of a directory as a text document
+func (s *Server) serveIndex(ctx *Context, name string) (Status, os.Error) {
+	file, err := s.openFile(name)
+	if err != nil {
+		return StatusError, err
+	}
+	defer file.Close()
+	ctx.itemType = '0'
+	return s.Textfile(ctx, file)
+}
@@ -0,0 +1,268 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With JournalDir set, a transcript of every request, the bytes the client
+// sent and those it was sent back, is written there as a file of its own,
+// for reproducing what a particular client ran into:
+//    journal /var/spool/gopher/journal 50M
+// Each transcript keeps at most JournalTranscriptLimit bytes of either
+// direction, and once the journal is over JournalMaxSize the oldest
+// transcripts are removed. ReplayTranscript sends a transcript's request to
+// another server, such as a test instance, and compares the reply. The
+// journal holds what clients sent, search strings and tokens included, so
+// keep it somewhere private.
+
+// DefaultJournalMaxSize bounds the journal when JournalMaxSize is not set
+const DefaultJournalMaxSize = 100 * 1024 * 1024
+
+// JournalTranscriptLimit is how many bytes of a request and of its
+// response a transcript keeps
+var JournalTranscriptLimit = 64 * 1024
+
+// journalConn records what goes through a connection, up to a limit
+type journalConn struct {
+	net.Conn
+	in, out bytes.Buffer
+	wrote   int64 // Bytes sent, kept or not
+}
+
+func (c *journalConn) Read(b []byte) (n int, err os.Error) {
+	n, err = c.Conn.Read(b)
+	c.in.Write(b[:room(n, JournalTranscriptLimit-c.in.Len())])
+	return
+}
+
+func (c *journalConn) Write(b []byte) (n int, err os.Error) {
+	n, err = c.Conn.Write(b)
+	c.wrote += int64(n)
+	c.out.Write(b[:room(n, JournalTranscriptLimit-c.out.Len())])
+	return
+}
+
+// room returns how much of n bytes fits in the space left, at least 0
+func room(n int, left int) int {
+	if left < n {
+		n = left
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// journal tracks the transcripts in the journal directory, oldest first
+type journal struct {
+	sync.Mutex
+	loaded bool
+	names  []string
+	sizes  map[string]int64
+	size   int64
+}
+
+// Transcript is one request and response recorded in the journal
+type Transcript struct {
+	RequestID string
+	Client    string
+	Time      int64 // Nanoseconds since the epoch
+	Selector  string
+	Outcome   string
+	Sent      int64 // Bytes the response was long, which may be more than Response holds
+	Request   []byte
+	Response  []byte
+}
+
+// journalMaxSize returns the size the journal is kept under
+func (s *Server) journalMaxSize() int64 {
+	if s.JournalMaxSize > 0 {
+		return s.JournalMaxSize
+	}
+	return DefaultJournalMaxSize
+}
+
+// configureJournal handles a `journal directory [max-size]' line
+func (s *Server) configureJournal(args []string) (err os.Error) {
+	if len(args) != 1 && len(args) != 2 {
+		return os.NewError("expected a directory and optionally a maximum size")
+	}
+	s.JournalDir = args[0]
+	if len(args) == 2 {
+		s.JournalMaxSize, err = parseSize(args[1])
+	}
+	return
+}
+
+// startJournal has the connection of a request recorded for the journal
+func (s *Server) startJournal(ctx *Context) *journalConn {
+	if s.JournalDir == "" || ctx.sim != nil {
+		return nil
+	}
+	jc := &journalConn{Conn: ctx.conn}
+	ctx.conn = jc
+	return jc
+}
+
+// writeJournal writes the transcript of a finished request
+func (s *Server) writeJournal(ctx *Context, jc *journalConn, outcome string) {
+	if jc == nil {
+		return
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Request-ID: %s\nClient: %s\nTime: %d\nSelector: %s\nOutcome: %s\nSent: %d\nRequest-Length: %d\n\n",
+		ctx.ID, ctx.ClientIP(), ctx.accepted, strconv.Quote(ctx.Request), outcome, jc.wrote, jc.in.Len())
+	b.Write(jc.in.Bytes())
+	b.Write(jc.out.Bytes())
+	name := fmt.Sprintf("%019d-%s.txt", ctx.accepted, ctx.ID)
+	file, err := os.Open(path.Join(s.JournalDir, name), os.O_WRONLY|os.O_CREAT|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = file.Write(b.Bytes())
+		file.Close()
+	}
+	if err != nil {
+		s.Logger.Printf("ERROR: Could not journal request %s: %s\n", ctx.ID, err)
+		return
+	}
+	s.journal.add(s.JournalDir, name, int64(b.Len()), s.journalMaxSize())
+}
+
+// add notes a new transcript, removing the oldest while the journal is
+// over max
+func (j *journal) add(dir string, name string, size int64, max int64) {
+	j.Lock()
+	defer j.Unlock()
+	if !j.loaded {
+		j.load(dir)
+	}
+	j.names = append(j.names, name)
+	j.sizes[name] = size
+	j.size += size
+	for j.size > max && len(j.names) > 1 {
+		oldest := j.names[0]
+		j.names = j.names[1:]
+		os.Remove(path.Join(dir, oldest))
+		j.size -= j.sizes[oldest]
+		j.sizes[oldest] = 0, false
+	}
+}
+
+// load reads which transcripts the journal holds. The journal must be
+// locked.
+func (j *journal) load(dir string) {
+	j.loaded = true
+	j.sizes = make(map[string]int64)
+	f, err := os.Open(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+	infos, _ := f.Readdir(-1)
+	f.Close()
+	for _, info := range infos {
+		if info.IsRegular() && strings.HasSuffix(info.Name, ".txt") {
+			j.names = append(j.names, info.Name)
+			j.sizes[info.Name] = info.Size
+			j.size += info.Size
+		}
+	}
+	sort.SortStrings(j.names)
+}
+
+// ReadTranscript reads a transcript from the journal
+func ReadTranscript(name string) (*Transcript, os.Error) {
+	file, err := os.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	t := &Transcript{}
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, os.NewError(name + ": transcript header not ended")
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			break
+		}
+		fields := strings.Split(line, ": ", 2)
+		if len(fields) != 2 {
+			return nil, os.NewError(name + ": bad header line " + strconv.Quote(line))
+		}
+		switch fields[0] {
+		case "Request-ID":
+			t.RequestID = fields[1]
+		case "Client":
+			t.Client = fields[1]
+		case "Time":
+			t.Time, _ = strconv.Atoi64(fields[1])
+		case "Selector":
+			t.Selector, _ = strconv.Unquote(fields[1])
+		case "Outcome":
+			t.Outcome = fields[1]
+		case "Sent":
+			t.Sent, _ = strconv.Atoi64(fields[1])
+		case "Request-Length":
+			length, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, os.NewError(name + ": bad request length")
+			}
+		}
+	}
+	if length < 0 {
+		return nil, os.NewError(name + ": no request length")
+	}
+	t.Request = make([]byte, length)
+	if _, err := io.ReadFull(r, t.Request); err != nil {
+		return nil, err
+	}
+	var rest bytes.Buffer
+	if _, err := io.Copy(&rest, r); err != nil {
+		return nil, err
+	}
+	t.Response = rest.Bytes()
+	return t, nil
+}
+
+// ReplayTranscript sends the request of a transcript to the server at
+// addr and returns its reply and whether it matches the recorded one. A
+// response cut short in the journal is compared as far as it was kept.
+func ReplayTranscript(t *Transcript, addr string) ([]byte, bool, os.Error) {
+	conn, err := net.Dial("tcp", "", addr)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(30e9)
+	if _, err = conn.Write(t.Request); err != nil {
+		return nil, false, err
+	}
+	var reply bytes.Buffer
+	if _, err = io.Copy(&reply, conn); err != nil {
+		return nil, false, err
+	}
+	got := reply.Bytes()
+	if int64(len(t.Response)) < t.Sent {
+		return got, len(got) >= len(t.Response) && bytes.Equal(got[:len(t.Response)], t.Response), nil
+	}
+	return got, bytes.Equal(got, t.Response), nil
+}
+
+// String describes a transcript in one line
+func (t *Transcript) String() string {
+	stamp := time.SecondsToLocalTime(t.Time / 1e9).Format("2006/01/02 15:04:05")
+	return fmt.Sprintf("%s %s %s %s %q", stamp, t.RequestID, t.Client, t.Outcome, t.Selector)
+}
@@ -0,0 +1,190 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Classic Gopher answers one request per connection, the server closing it
+// once the response is sent. With KeepAlive set, a client may instead open
+// the connection with the line KeepAliveRequest, which the server
+// acknowledges with `+KEEP-ALIVE' and the end-of-response marker, a line
+// holding a single period. From then on every response that ends with the
+// marker leaves the connection open for the next request:
+//    C: KEEP-ALIVE
+//    S: +KEEP-ALIVE
+//    S: .
+//    C: /about.txt
+//    S: ...dot-stuffed text...
+//    S: .
+//    C: /
+//    S: ...menu...
+//    S: .
+// Menus and error items end with the marker anyway, text files are sent
+// with lines starting with a period doubled and the marker added. Any other
+// response, such as a binary file, a Gopher+ reply or one cut short, is
+// followed by the server closing the connection, as for classic clients,
+// which never send KeepAliveRequest and see no difference. A session ends
+// after KeepAliveRequests requests or when the client sends nothing for
+// KeepAliveTimeout. An idle session holds no worker: its next request
+// waits in the accept queue like a new connection.
+
+// KeepAliveRequest is the line a client opens a kept-alive session with
+const KeepAliveRequest = "KEEP-ALIVE"
+
+// KeepAliveRequests is the most requests answered on one connection
+var KeepAliveRequests = 100
+
+// KeepAliveTimeout is how long an open session waits for the next
+// request, in nanoseconds
+var KeepAliveTimeout int64 = 15e9
+
+// endMarker ends every response of a kept-alive session
+const endMarker = ".\r\n"
+
+// session is the state of a connection across the requests sent on it
+type session struct {
+	net.Conn               // Connection of the current request
+	reader   *bufio.Reader // Reads requests, including any sent ahead
+	alive    bool          // Whether the client asked for the session
+	requests int           // Requests answered so far
+	tail     []byte        // Last bytes of the current response
+	dotted   bool          // Whether the response was sent dot-stuffed
+}
+
+func newSession() *session {
+	sess := &session{}
+	sess.reader = bufio.NewReader(sess)
+	return sess
+}
+
+// use has the session carry the request of ctx
+func (sess *session) use(ctx *Context) {
+	sess.Conn = ctx.conn
+	sess.tail = sess.tail[:0]
+	sess.dotted = false
+	ctx.conn = sess
+}
+
+func (sess *session) Write(b []byte) (n int, err os.Error) {
+	n, err = sess.Conn.Write(b)
+	sess.tail = append(sess.tail, b[:n]...)
+	if len(sess.tail) > 2*len(endMarker) {
+		sess.tail = sess.tail[len(sess.tail)-len(endMarker)-2:]
+	}
+	return
+}
+
+// terminated reports whether the response so far ends with the
+// end-of-response marker on a line of its own
+func (sess *session) terminated() bool {
+	t := string(sess.tail)
+	return t == endMarker || strings.HasSuffix(t, "\n"+endMarker)
+}
+
+// startSession answers KeepAliveRequest
+func (s *Server) startSession(ctx *Context) Status {
+	ctx.session.alive = true
+	ctx.session.dotted = true // No line of the answer starts with a period
+	ctx.itemType = '0'
+	ctx.Write("+" + KeepAliveRequest)
+	ctx.Write(".")
+	ctx.Logf("Keeping the connection alive\n")
+	return StatusOK
+}
+
+// dotted returns the writer a text response is sent through when the
+// request is part of a session, or nil
+func (ctx *Context) dotted() *dotWriter {
+	if ctx.session == nil || !ctx.session.alive || ctx.extra != "" {
+		return nil
+	}
+	ctx.session.dotted = true
+	return &dotWriter{w: ctx.conn}
+}
+
+// keepOpen reports whether the connection of a finished request can take
+// another
+func (s *Server) keepOpen(ctx *Context, status Status) bool {
+	sess := ctx.session
+	if sess == nil || !sess.alive || status == StatusBadRequest || ctx.Expired() || s.upgrading() {
+		return false
+	}
+	if sess.requests+1 >= KeepAliveRequests || !sess.terminated() {
+		return false
+	}
+	// Types other than these could end with the marker by chance
+	return ctx.itemType == '1' || ctx.itemType == '7' || ctx.itemType == '3' ||
+		ctx.itemType == '0' && sess.dotted
+}
+
+// nextRequest waits for the next request of a session and returns its
+// Context, or nil if the session is over
+func (s *Server) nextRequest(ctx *Context, conn net.Conn) *Context {
+	sess := ctx.session
+	sess.Conn = conn
+	conn.SetReadTimeout(KeepAliveTimeout)
+	_, err := sess.reader.Peek(1)
+	conn.SetReadTimeout(0)
+	if err != nil {
+		return nil
+	}
+	next := s.newContext(conn, time.Nanoseconds())
+	next.session = sess
+	sess.requests++
+	return next
+}
+
+// requeue waits for the next request of a session off the worker pool and
+// queues it for a worker, or closes the connection if none comes
+func (s *Server) requeue(ctx *Context, conn net.Conn) {
+	if next := s.nextRequest(ctx, conn); next != nil {
+		s.queue <- next
+		return
+	}
+	conn.Close()
+}
+
+// dotWriter doubles periods starting a line, so no line of a text response
+// reads as the end-of-response marker, and adds the marker when closed
+type dotWriter struct {
+	w   io.Writer
+	mid bool // Whether the last byte written did not end a line
+}
+
+func (d *dotWriter) Write(b []byte) (n int, err os.Error) {
+	for len(b) > 0 {
+		if !d.mid && b[0] == '.' {
+			if _, err = d.w.Write([]byte{'.'}); err != nil {
+				return
+			}
+		}
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i != -1 {
+			line = b[:i+1]
+		}
+		m, err := d.w.Write(line)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		d.mid = line[len(line)-1] != '\n'
+		b = b[len(line):]
+	}
+	return
+}
+
+// Close ends the response with the end-of-response marker
+func (d *dotWriter) Close() os.Error {
+	end := endMarker
+	if d.mid {
+		end = "\r\n" + endMarker
+	}
+	_, err := io.WriteString(d.w, end)
+	return err
+}
@@ -0,0 +1,57 @@
+package gopher_test
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// readResponse reads a kept-alive response up to and including the line
+// holding a single period
+func readResponse(t *testing.T, r *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading response after %q: %s", lines, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		if line == "." {
+			return lines
+		}
+	}
+	return lines
+}
+
+func TestKeepAlive(t *testing.T) {
+	ts := newSite(t, map[string]string{"/a.txt": "one\n.two\n", "/b.txt": "three"}, "keep-alive on")
+	defer ts.Close()
+	conn, err := ts.Listener.Dial()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		request string
+		want    string // Lines of the response, without the end marker
+	}{
+		{"KEEP-ALIVE", "+KEEP-ALIVE"},
+		// Lines starting with a period are doubled
+		{"/a.txt", "one|..two"},
+		{"/b.txt", "three"},
+		{"/a.txt", "one|..two"},
+	}
+	for _, test := range tests {
+		if _, err := fmt.Fprintf(conn, "%s\r\n", test.request); err != nil {
+			t.Fatalf("%s: %s", test.request, err)
+		}
+		lines := readResponse(t, r)
+		if got := strings.Join(lines[:len(lines)-1], "|"); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.request, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package gopher
+
+import (
+	"sort"
+	"strings"
+)
+
+// A file's license is declared in a sidecar named after it with
+// LicenseSuffix appended, e.g. photo.jpg.license, holding lines such as
+//
+//	License: CC-BY-SA-4.0
+//	Attribution: Jane Doe
+//	Source: gopher://example.org/1/photos
+//
+// A line without a key is taken as the license name.
+const LicenseSuffix = ".license"
+
+// LicensesSelector is where a menu of all licensed items is generated when
+// the site has nothing of its own there
+const LicensesSelector = "/licenses"
+
+// License describes the terms an item is redistributed under
+type License struct {
+	Name        string
+	Attribution string
+	Source      string
+}
+
+// String summarizes the license on one line
+func (l *License) String() string {
+	s := "License: " + l.Name
+	if l.Attribution != "" {
+		s += ", by " + l.Attribution
+	}
+	return s
+}
+
+// Lines returns the license as the lines of a Gopher+ attribute block
+func (l *License) Lines() []string {
+	lines := []string{"License: " + l.Name}
+	if l.Attribution != "" {
+		lines = append(lines, "Attribution: "+l.Attribution)
+	}
+	if l.Source != "" {
+		lines = append(lines, "Source: "+l.Source)
+	}
+	return lines
+}
+
+// parseLicense reads the contents of a license sidecar
+func parseLicense(data []byte) *License {
+	l := &License{}
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, value := "", line
+		if i := strings.Index(line, ":"); i != -1 {
+			key, value = strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+		}
+		switch key {
+		case "license":
+			l.Name = value
+		case "attribution", "author":
+			l.Attribution = value
+		case "source":
+			l.Source = value
+		default:
+			if l.Name == "" {
+				l.Name = line
+			}
+		}
+	}
+	if l.Name == "" {
+		l.Name = "unspecified"
+	}
+	return l
+}
+
+// license returns the license declared for the file at absPath, or nil
+func (s *Server) license(absPath string) *License {
+	data, err := s.readSidecar(absPath + LicenseSuffix)
+	if err != nil {
+		return nil
+	}
+	return parseLicense(data)
+}
+
+// licensedItem is an entry of the generated licenses menu
+type licensedItem struct {
+	entry   *MenuEntry
+	license *License
+}
+
+// licenseCollector gathers every licensed item below a document root
+type licenseCollector struct {
+	s     *Server
+	ctx   *Context
+	items map[string][]*licensedItem
+}
+
+// collect adds the licensed items of the directory at dir and of its
+// subdirectories
+func (c *licenseCollector) collect(dir string) {
+	name := dir
+	if name == "" {
+		// Roots are empty after a chroot
+		name = "/"
+	}
+	fs, inner := c.s.fileSystem(name)
+	infos, err := fs.ReadDir(inner)
+	if err != nil {
+		return
+	}
+	for i := range infos {
+		f := &infos[i]
+		switch {
+		case f.IsDirectory() && !strings.HasPrefix(f.Name, "."):
+			c.collect(dir + "/" + f.Name)
+		case f.IsRegular() && strings.HasSuffix(f.Name, LicenseSuffix):
+			c.add(dir + "/" + f.Name[:len(f.Name)-len(LicenseSuffix)])
+		}
+	}
+}
+
+// add adds the item at target, whose license sidecar was found
+func (c *licenseCollector) add(target string) {
+	info, err := c.s.statFile(target)
+	if err != nil {
+		return
+	}
+	selector := target[len(c.ctx.Host.Root):]
+	if !validSelector(strings.Trim(selector, "/")) || c.s.features.hidden(selector, c.ctx.ClientIP(), c.ctx.Host.Name) {
+		return
+	}
+	l := c.s.license(target)
+	entry := &MenuEntry{Type: c.s.itemType(info), Display: c.s.displayName(selector), Selector: selector}
+	c.items[l.Name] = append(c.items[l.Name], &licensedItem{entry, l})
+}
+
+// serveLicenses sends a generated menu of every licensed item on the host,
+// grouped by license, for sites that do not provide their own
+func (s *Server) serveLicenses(ctx *Context) Status {
+	c := &licenseCollector{s: s, ctx: ctx, items: make(map[string][]*licensedItem)}
+	c.collect(strings.TrimRight(ctx.Host.Root, "/"))
+	names := make([]string, 0, len(c.items))
+	for name := range c.items {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+
+	w := NewEntryWriter(ctx)
+	w.Info("Licenses of the content on this server")
+	if len(names) == 0 {
+		w.Info("")
+		w.Info("No item declares a license.")
+	}
+	for _, name := range names {
+		w.Info("")
+		w.Info(name)
+		for _, item := range c.items[name] {
+			w.Write(item.entry)
+			if item.license.Attribution != "" {
+				w.Info("  by " + item.license.Attribution)
+			}
+			if item.license.Source != "" {
+				w.Info("  from " + item.license.Source)
+			}
+		}
+	}
+	w.Close()
+	ctx.Logf("Served generated licenses menu\n")
+	return StatusOK
+}
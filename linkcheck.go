@@ -0,0 +1,131 @@
+package gopher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// CheckLinks finds the dead links in the gophermaps below the document
+// roots: local selectors that are neither files nor answered by one of the
+// server's handlers, and entries on other servers that do not accept
+// connections. Each other server is only connected to once.
+
+// DeadLink is a gophermap entry leading nowhere
+type DeadLink struct {
+	Gophermap string
+	Entry     *MenuEntry
+	Err       os.Error
+}
+
+func (d *DeadLink) String() string {
+	return fmt.Sprintf("%s: %s -> %s:%d%s: %s", d.Gophermap, d.Entry.Display, d.Entry.Host, d.Entry.Port, d.Entry.Selector, d.Err)
+}
+
+// linkChecker collects the dead links of one document root
+type linkChecker struct {
+	s       *Server
+	host    *VirtualHost
+	remotes map[string]os.Error // Outcome of connecting to each other server
+	dead    []*DeadLink
+}
+
+func (c *linkChecker) VisitDir(name string, f *os.FileInfo) bool {
+	return name == c.host.Root+"/" || !strings.HasPrefix(f.Name, ".")
+}
+
+func (c *linkChecker) VisitFile(name string, f *os.FileInfo) {
+	if f.Name != "gophermap" {
+		return
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		c.dead = append(c.dead, &DeadLink{name, &MenuEntry{}, err})
+		return
+	}
+	dir := path.Dir(name)
+	ctx := &Context{Server: c.s, Host: c.host, Request: strings.TrimRight(dir[len(c.host.Root):], "/")}
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		line = strings.TrimRight(line, "\r")
+		if strings.Index(line, "\t") == -1 {
+			continue
+		}
+		if _, ok := includeDirective(line); ok {
+			continue
+		}
+		if _, ok := execDirective(line); ok {
+			continue
+		}
+		entries := c.s.ParseGophermapLine(ctx, line)
+		for e := 0; e < entries.Len(); e++ {
+			entry := entries[e].(*MenuEntry)
+			if err := c.check(entry); err != nil {
+				c.dead = append(c.dead, &DeadLink{name, entry, err})
+			}
+		}
+	}
+}
+
+// check returns why an entry is dead, or nil if it is not
+func (c *linkChecker) check(entry *MenuEntry) os.Error {
+	if entry.Type == 'i' || entry.Type == '3' || strings.HasPrefix(entry.Selector, "URL:") {
+		return nil
+	}
+	if entry.Host != c.s.Hostname || entry.Port != c.s.Port {
+		addr := fmt.Sprintf("%s:%d", entry.Host, entry.Port)
+		err, checked := c.remotes[addr]
+		if !checked {
+			conn, derr := Dial(addr)
+			if derr == nil {
+				conn.Close()
+			}
+			err = derr
+			c.remotes[addr] = err
+		}
+		return err
+	}
+	return c.s.checkLocal(c.host, entry.Selector)
+}
+
+// checkLocal returns why a local selector leads nowhere, or nil if it
+// does not
+func (s *Server) checkLocal(host *VirtualHost, selector string) os.Error {
+	selector = s.resolve(host, "/"+strings.Trim(path.Clean("/"+selector), "/"))
+	if s.handled(selector) {
+		return nil
+	}
+	_, err := s.statFile(host.Root + selector)
+	return err
+}
+
+// handled reports whether a local selector is answered by something other
+// than a file
+func (s *Server) handled(selector string) bool {
+	if r, _ := s.redirectFor(selector); r != nil {
+		return true
+	}
+	if selector == s.StatsSelector || s.isAdmin(selector) || selector == LicensesSelector || selector == HealthSelector || strings.Index(selector, ArchiveMarker) != -1 {
+		return true
+	}
+	return s.phlogFor(selector) != nil || s.guestbookFor(selector) != nil || s.dropboxFor(selector) != nil ||
+		s.ccsoFor(selector) != nil || s.sqliteFor(selector) != nil || s.proxyFor(selector) != nil || s.route(selector, false) != nil
+}
+
+// CheckLinks returns the dead links in the gophermaps of every document
+// root
+func (s *Server) CheckLinks() []*DeadLink {
+	s.init()
+	c := &linkChecker{s: s, remotes: make(map[string]os.Error)}
+	roots := make(map[string]bool)
+	for _, host := range append([]*VirtualHost{s.defaultHost}, s.VirtualHosts()...) {
+		if roots[host.Root] {
+			continue
+		}
+		roots[host.Root] = true
+		c.host = host
+		path.Walk(host.Root+"/", c, nil)
+	}
+	return c.dead
+}
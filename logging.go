@@ -0,0 +1,242 @@
+package gopher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syslog"
+	"time"
+)
+
+// LogEntry is one structured log record. Access entries carry the request
+// fields; plain messages only set Time and Message.
+type LogEntry struct {
+	Time      int64  // Nanoseconds since the epoch
+	RequestID string // ID of the request the entry is about, if any
+	Message   string
+	Selector  string
+	ClientIP  string
+	Duration  int64 // Nanoseconds spent serving the request
+	Bytes     int64
+	Outcome   string
+}
+
+// Access reports whether the entry describes a served request
+func (e *LogEntry) Access() bool {
+	return e.Outcome != ""
+}
+
+// String renders the entry as a line of key=value pairs
+func (e *LogEntry) String() string {
+	var fields []string
+	if e.RequestID != "" {
+		fields = append(fields, "req="+e.RequestID)
+	}
+	if e.Message != "" {
+		fields = append(fields, "msg="+strconv.Quote(e.Message))
+	}
+	if e.Access() {
+		fields = append(fields,
+			"selector="+strconv.Quote(e.Selector),
+			"client="+e.ClientIP,
+			"duration="+strconv.Ftoa64(float64(e.Duration)/1e9, 'f', 6)+"s",
+			"bytes="+strconv.Itoa64(e.Bytes),
+			"outcome="+e.Outcome)
+	}
+	return strings.Join(fields, " ")
+}
+
+// LogSink receives structured log entries
+type LogSink interface {
+	WriteEntry(entry *LogEntry) os.Error
+}
+
+// LogTailLength is the number of recent entries a Logger keeps
+var LogTailLength = 100
+
+// Logger fans log entries out to a set of sinks, keeping the most recent
+// entries in memory
+type Logger struct {
+	mu     sync.Mutex
+	sinks  []LogSink
+	recent []*LogEntry // Ring of the last LogTailLength entries
+	next   int         // Index in recent of the next entry
+}
+
+// NewLogger returns a Logger writing to the given sinks
+func NewLogger(sinks ...LogSink) *Logger {
+	return &Logger{sinks: sinks}
+}
+
+// SetSinks replaces the sinks of the logger
+func (l *Logger) SetSinks(sinks ...LogSink) {
+	l.mu.Lock()
+	l.sinks = sinks
+	l.mu.Unlock()
+}
+
+// swapSinks replaces the sinks of the logger, returning the old ones
+func (l *Logger) swapSinks(sinks []LogSink) (old []LogSink) {
+	l.mu.Lock()
+	old, l.sinks = l.sinks, sinks
+	l.mu.Unlock()
+	return
+}
+
+// replaceWriter swaps every TextSink writing to w for sink, or drops them if
+// sink is nil
+func (l *Logger) replaceWriter(w io.Writer, sink LogSink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sinks := make([]LogSink, 0, len(l.sinks))
+	replaced := false
+	for _, s := range l.sinks {
+		if t, ok := s.(*TextSink); ok && t.w == w {
+			if sink != nil && !replaced {
+				sinks = append(sinks, sink)
+			}
+			replaced = true
+			continue
+		}
+		sinks = append(sinks, s)
+	}
+	l.sinks = sinks
+}
+
+// Log sends the entry to every sink, stamping it with the time if unset
+func (l *Logger) Log(entry *LogEntry) {
+	if entry.Time == 0 {
+		entry.Time = time.Nanoseconds()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.recent) < LogTailLength {
+		l.recent = append(l.recent, entry)
+	} else if LogTailLength > 0 {
+		l.recent[l.next%len(l.recent)] = entry
+	}
+	l.next++
+	for _, sink := range l.sinks {
+		if err := sink.WriteEntry(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "log sink failed: %s\n", err)
+		}
+	}
+}
+
+// Recent returns the most recently logged entries, oldest first
+func (l *Logger) Recent() []*LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]*LogEntry, 0, len(l.recent))
+	if len(l.recent) == 0 || len(l.recent) < LogTailLength {
+		return append(entries, l.recent...)
+	}
+	start := l.next % len(l.recent)
+	return append(append(entries, l.recent[start:]...), l.recent[:start]...)
+}
+
+// Printf logs a plain message
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.Log(&LogEntry{Message: strings.TrimRight(fmt.Sprintf(format, v...), "\n")})
+}
+
+// Println logs a plain message
+func (l *Logger) Println(v ...interface{}) {
+	l.Log(&LogEntry{Message: strings.TrimRight(fmt.Sprintln(v...), "\n")})
+}
+
+// TextSink writes entries as timestamped key=value lines
+type TextSink struct {
+	w io.Writer
+}
+
+func NewTextSink(w io.Writer) *TextSink {
+	return &TextSink{w}
+}
+
+func (t *TextSink) WriteEntry(entry *LogEntry) (err os.Error) {
+	stamp := time.SecondsToLocalTime(entry.Time / 1e9).Format("2006/01/02 15:04:05")
+	_, err = fmt.Fprintf(t.w, "%s %s\n", stamp, entry)
+	return
+}
+
+// FileSink is a TextSink appending to a named file
+type FileSink struct {
+	TextSink
+	file *os.File
+}
+
+// OpenFileSink opens the named file for appending log entries
+func OpenFileSink(name string) (*FileSink, os.Error) {
+	file, err := os.Open(name, os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{TextSink{file}, file}, nil
+}
+
+func (f *FileSink) Close() os.Error {
+	return f.file.Close()
+}
+
+// SyslogSink sends entries to the system logger
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+func NewSyslogSink(tag string) (*SyslogSink, os.Error) {
+	w, err := syslog.New(syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w}, nil
+}
+
+func (s *SyslogSink) WriteEntry(entry *LogEntry) os.Error {
+	if entry.Outcome != "" && entry.Outcome != "ok" {
+		return s.w.Err(entry.String())
+	}
+	return s.w.Info(entry.String())
+}
+
+// OpenLogSink opens a sink from its name: stdout, stderr, syslog or the
+// path of a file to append to
+func OpenLogSink(spec string) (LogSink, os.Error) {
+	switch spec {
+	case "stdout":
+		return NewTextSink(os.Stdout), nil
+	case "stderr":
+		return NewTextSink(os.Stderr), nil
+	case "syslog":
+		return NewSyslogSink("gopherd")
+	}
+	return OpenFileSink(spec)
+}
+
+// SetLogSinks replaces the server's log sinks with those named in specs.
+// Sinks that cannot be opened are skipped according to the log subsystem's
+// failure policy, falling back to stdout if none are left. The log files
+// replaced are closed.
+func (s *Server) SetLogSinks(specs []string) os.Error {
+	sinks := make([]LogSink, 0, len(specs))
+	for _, spec := range specs {
+		sink, err := OpenLogSink(spec)
+		if err != nil {
+			s.Degrade("log", os.NewError(fmt.Sprintf("%s: %s", spec, err)))
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		sinks = append(sinks, NewTextSink(os.Stdout))
+	}
+	for _, sink := range s.Logger.swapSinks(sinks) {
+		if f, ok := sink.(*FileSink); ok {
+			f.Close()
+		}
+	}
+	return nil
+}
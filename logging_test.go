@@ -0,0 +1,30 @@
+package gopher
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetLogSinksClosesFiles(t *testing.T) {
+	if err := os.MkdirAll("_test", 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.Logger = NewLogger()
+	if err := s.SetLogSinks([]string{"_test/first.log"}); err != nil {
+		t.Fatal(err)
+	}
+	first, ok := s.Logger.sinks[0].(*FileSink)
+	if !ok {
+		t.Fatalf("got sink %T, want a *FileSink", s.Logger.sinks[0])
+	}
+	if err := s.SetLogSinks([]string{"_test/second.log"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err == nil {
+		t.Errorf("the replaced log file was left open")
+	}
+	if second, ok := s.Logger.sinks[0].(*FileSink); !ok || second == first {
+		t.Errorf("got sinks %v, want the second log file", s.Logger.sinks)
+	}
+}
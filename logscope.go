@@ -0,0 +1,172 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Virtual hosts and selector areas can log to their own destinations and at
+// their own verbosity, so a busy public area can log little while an admin
+// area logs everything:
+//    log-level access
+//    log-area /admin all /var/log/gopher/admin.log
+//    log-area /pub errors
+//    vhost 192.0.2.10
+//    log /var/log/gopher/example.log
+//    log-level errors
+//    end
+// The longest matching area wins over the request's virtual host, which
+// wins over the server. An area or virtual host without destinations logs
+// to those it would otherwise use. Messages the server logs outside any
+// request are never filtered.
+
+// Log levels, from most to least verbose
+const (
+	LogInherit = iota // Use the level of the enclosing scope
+	LogAll            // Every request's messages and access entry
+	LogAccess         // Access entries and errors
+	LogErrors         // Errors and requests that did not succeed
+)
+
+// ParseLogLevel parses a log level name: all, access or errors
+func ParseLogLevel(name string) (int, os.Error) {
+	switch name {
+	case "all":
+		return LogAll, nil
+	case "access":
+		return LogAccess, nil
+	case "errors":
+		return LogErrors, nil
+	}
+	return 0, os.NewError(fmt.Sprintf("unknown log level `%s'", name))
+}
+
+// logWanted reports whether an entry is logged at a level
+func logWanted(level int, entry *LogEntry) bool {
+	failed := strings.HasPrefix(entry.Message, "ERROR:") || entry.Access() && entry.Outcome != "ok"
+	switch level {
+	case LogAccess:
+		return entry.Access() || failed
+	case LogErrors:
+		return failed
+	}
+	return true
+}
+
+// LogArea sets the logging of the selectors below Prefix
+type LogArea struct {
+	Prefix string
+	Level  int
+	Logger *Logger // Nil to use the virtual host's or server's
+}
+
+// openLogger returns a Logger writing to the destinations named in specs
+func openLogger(specs []string) (*Logger, os.Error) {
+	sinks := make([]LogSink, 0, len(specs))
+	for _, spec := range specs {
+		sink, err := OpenLogSink(spec)
+		if err != nil {
+			closeLogger(NewLogger(sinks...))
+			return nil, os.NewError(fmt.Sprintf("%s: %s", spec, err))
+		}
+		sinks = append(sinks, sink)
+	}
+	return NewLogger(sinks...), nil
+}
+
+// closeLogger closes the files a scoped logger writes to
+func closeLogger(l *Logger) {
+	if l == nil {
+		return
+	}
+	for _, sink := range l.swapSinks(nil) {
+		if f, ok := sink.(*FileSink); ok {
+			f.Close()
+		}
+	}
+}
+
+// AddLogArea logs the selectors below prefix at level, to the destinations
+// named in specs if there are any
+func (s *Server) AddLogArea(prefix string, level int, specs []string) os.Error {
+	area := &LogArea{Prefix: "/" + strings.Trim(prefix, "/"), Level: level}
+	if len(specs) > 0 {
+		var err os.Error
+		if area.Logger, err = openLogger(specs); err != nil {
+			return err
+		}
+	}
+	s.logAreas = append(s.logAreas, area)
+	return nil
+}
+
+// configureLogArea handles a `log-area prefix level [destination...]' line
+func (s *Server) configureLogArea(args []string) os.Error {
+	if len(args) < 2 {
+		return os.NewError("expected a selector prefix, a level and optionally destinations")
+	}
+	level, err := ParseLogLevel(args[1])
+	if err != nil {
+		return err
+	}
+	return s.AddLogArea(args[0], level, args[2:])
+}
+
+// logArea returns the area with the longest prefix matching selector
+func (s *Server) logArea(selector string) *LogArea {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	var best *LogArea
+	for _, a := range s.logAreas {
+		if !underPrefix(selector, a.Prefix) {
+			continue
+		}
+		if best == nil || len(a.Prefix) > len(best.Prefix) {
+			best = a
+		}
+	}
+	return best
+}
+
+// underPrefix reports whether selector is prefix or lies below it
+func underPrefix(selector string, prefix string) bool {
+	if prefix == "/" {
+		return true
+	}
+	selector = "/" + strings.Trim(selector, "/")
+	return selector == prefix || strings.HasPrefix(selector, prefix+"/")
+}
+
+// log sends an entry about the request to the logger of its area, virtual
+// host or server, if the level there lets it through. Entries about a
+// simulated request go to its Simulation instead.
+func (ctx *Context) log(entry *LogEntry) {
+	if ctx.sim != nil {
+		ctx.sim.record(entry)
+		return
+	}
+	s := ctx.Server
+	logger, level := s.Logger, s.LogLevel
+	if vh := ctx.Host; vh != nil {
+		if vh.logger != nil {
+			logger = vh.logger
+		}
+		if vh.LogLevel != LogInherit {
+			level = vh.LogLevel
+		}
+	}
+	if ctx.Request != "" {
+		if a := s.logArea(ctx.Request); a != nil {
+			if a.Logger != nil {
+				logger = a.Logger
+			}
+			if a.Level != LogInherit {
+				level = a.Level
+			}
+		}
+	}
+	if logWanted(level, entry) {
+		logger.Log(entry)
+	}
+}
@@ -0,0 +1,46 @@
+package gopher
+
+import "testing"
+
+func TestParseLogLevel(t *testing.T) {
+	levels := map[string]int{"all": LogAll, "access": LogAccess, "errors": LogErrors}
+	for name, want := range levels {
+		if got, err := ParseLogLevel(name); err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", "inherit", "ALL", "error", "debug"} {
+		if _, err := ParseLogLevel(name); err == nil {
+			t.Errorf("%q: want an error", name)
+		}
+	}
+}
+
+func TestLogWanted(t *testing.T) {
+	message := &LogEntry{Message: "Serving"}
+	failure := &LogEntry{Message: "ERROR: No such file"}
+	served := &LogEntry{Selector: "/", Outcome: "ok"}
+	refused := &LogEntry{Selector: "/secret", Outcome: "denied"}
+	tests := []struct {
+		level int
+		entry *LogEntry
+		want  bool
+	}{
+		{LogAll, message, true},
+		{LogAll, served, true},
+		{LogInherit, message, true},
+		{LogAccess, message, false},
+		{LogAccess, failure, true},
+		{LogAccess, served, true},
+		{LogAccess, refused, true},
+		{LogErrors, message, false},
+		{LogErrors, served, false},
+		{LogErrors, failure, true},
+		{LogErrors, refused, true},
+	}
+	for _, test := range tests {
+		if got := logWanted(test.level, test.entry); got != test.want {
+			t.Errorf("level %d, %s: got %v, want %v", test.level, test.entry, got, test.want)
+		}
+	}
+}
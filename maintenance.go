@@ -0,0 +1,115 @@
+package gopher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// During a content migration the server can be put in maintenance mode,
+// in which it answers every request with a notice instead of serving
+// files or handlers:
+//    maintenance-file /etc/gopher/maintenance.gophermap
+//    maintenance-message Back after the move, around 18:00 UTC.
+// The notice is the gophermap in MaintenanceFile, read anew for every
+// request so it can be edited meanwhile, or else the lines of
+// MaintenanceMessage, or else a default message. Maintenance mode is
+// switched with SetMaintenance, the `maintenance on|off' control command,
+// the admin menu or, for gopherd, SIGUSR1. The admin menu and the health
+// selector keep working, so the switch can be flipped back.
+
+// maintenanceState is whether the server is in maintenance mode
+type maintenanceState struct {
+	sync.Mutex
+	on    bool
+	since int64 // When maintenance mode was entered, in nanoseconds
+}
+
+// SetMaintenance enters or leaves maintenance mode
+func (s *Server) SetMaintenance(on bool) {
+	m := &s.maintenance
+	m.Lock()
+	defer m.Unlock()
+	if m.on == on {
+		return
+	}
+	m.on = on
+	if on {
+		m.since = time.Nanoseconds()
+		s.Logger.Printf("Entered maintenance mode\n")
+	} else {
+		s.Logger.Printf("Left maintenance mode after %ds\n", (time.Nanoseconds()-m.since)/1e9)
+	}
+}
+
+// InMaintenance reports whether the server is in maintenance mode
+func (s *Server) InMaintenance() bool {
+	m := &s.maintenance
+	m.Lock()
+	defer m.Unlock()
+	return m.on
+}
+
+// ToggleMaintenance switches maintenance mode over and reports whether it
+// is now on
+func (s *Server) ToggleMaintenance() bool {
+	m := &s.maintenance
+	m.Lock()
+	on := !m.on
+	m.Unlock()
+	s.SetMaintenance(on)
+	return on
+}
+
+// configureMaintenance handles a `maintenance on|off' line, which sets the
+// mode the server starts in
+func (s *Server) configureMaintenance(args []string) os.Error {
+	on, err := configBool(args)
+	if err != nil {
+		return err
+	}
+	// A reload loads into a fresh Server, so the mode of a running one is
+	// left as it is
+	s.maintenance.on = on
+	s.maintenance.since = time.Nanoseconds()
+	return nil
+}
+
+// serveMaintenance answers a request with the maintenance notice
+func (s *Server) serveMaintenance(ctx *Context) (Status, os.Error) {
+	w := NewEntryWriter(ctx)
+	if s.MaintenanceFile != "" {
+		file, err := os.Open(s.MaintenanceFile, os.O_RDONLY, 0)
+		if err == nil {
+			defer file.Close()
+			r := bufio.NewReader(file)
+			for {
+				line, err := r.ReadString('\n')
+				if line = strings.TrimRight(line, "\r\n"); line != "" || err == nil {
+					s.gophermapLine(ctx, w, line)
+				}
+				if err != nil {
+					break
+				}
+			}
+			ctx.Logf("Served maintenance notice for `%s'\n", ctx.Request)
+			return StatusOK, w.Close()
+		}
+		ctx.Logf("ERROR: Could not read maintenance file: %s\n", err)
+	}
+	lines := s.MaintenanceMessage
+	if len(lines) == 0 {
+		lines = []string{
+			fmt.Sprintf("%s is down for maintenance.", s.Hostname),
+			"Please try again later.",
+		}
+	}
+	for _, line := range lines {
+		w.Info(line)
+	}
+	ctx.Logf("Served maintenance notice for `%s'\n", ctx.Request)
+	return StatusOK, w.Close()
+}
@@ -0,0 +1,432 @@
+package gopher
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"utf8"
+)
+
+// Markdown files (.md and .markdown) can be served rendered. With
+// MarkdownMode MarkdownText they are sent as plain text wrapped at
+// MarkdownWidth columns: headings are underlined, lists and quotes are
+// indented, code is kept as it is and links are numbered, their targets
+// listed at the end. With MarkdownAuto, files that are mostly links are
+// served as menus instead, each link an item of its own, and listed as
+// such. MarkdownRaw, the default, sends the files as they are.
+
+// Markdown modes
+const (
+	MarkdownRaw = iota
+	MarkdownText
+	MarkdownAuto
+)
+
+// DefaultMarkdownWidth is the column rendered markdown is wrapped at when
+// MarkdownWidth is not set
+const DefaultMarkdownWidth = 70
+
+// ParseMarkdownMode parses the name of a markdown mode: raw, text or auto
+func ParseMarkdownMode(name string) (int, os.Error) {
+	switch name {
+	case "raw":
+		return MarkdownRaw, nil
+	case "text":
+		return MarkdownText, nil
+	case "auto":
+		return MarkdownAuto, nil
+	}
+	return MarkdownRaw, os.NewError("expected raw, text or auto")
+}
+
+// isMarkdown reports whether a file name is that of a markdown file
+func isMarkdown(name string) bool {
+	return strings.HasSuffix(name, ".md") || strings.HasSuffix(name, ".markdown")
+}
+
+// Kinds of markdown blocks
+const (
+	mdParagraph = iota
+	mdHeading
+	mdQuote
+	mdItem
+	mdCode
+	mdRule
+	mdBlank
+)
+
+// mdBlock is a block of a markdown document
+type mdBlock struct {
+	kind   int
+	level  int    // Level of a heading, nesting of a list item
+	marker string // Bullet or number of a list item
+	text   string // Text, or the lines of code joined by newlines
+}
+
+// mdLink is a link found in markdown text
+type mdLink struct {
+	text string
+	url  string
+}
+
+// listMarker returns the marker of a list item line and the indentation
+// and text after it, if the line is one
+func listMarker(line string) (marker string, indent int, text string, ok bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	indent = len(line) - len(trimmed)
+	end := 0
+	switch {
+	case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") || strings.HasPrefix(trimmed, "+ "):
+		end = 1
+	default:
+		for end < len(trimmed) && '0' <= trimmed[end] && trimmed[end] <= '9' {
+			end++
+		}
+		if end == 0 || end+1 >= len(trimmed) || trimmed[end] != '.' && trimmed[end] != ')' || trimmed[end+1] != ' ' {
+			return "", 0, "", false
+		}
+		end++
+	}
+	return trimmed[:end], indent, strings.TrimSpace(trimmed[end:]), true
+}
+
+// isRule reports whether a line is a horizontal rule
+func isRule(line string) bool {
+	line = strings.Replace(strings.TrimSpace(line), " ", "", -1)
+	if len(line) < 3 {
+		return false
+	}
+	return strings.Count(line, line[:1]) == len(line) && strings.IndexRune("-*_", int(line[0])) != -1
+}
+
+// parseMarkdown splits a markdown document into blocks
+func parseMarkdown(src string) []*mdBlock {
+	var blocks []*mdBlock
+	var open *mdBlock // Paragraph, quote or list item taking more lines
+	lines := strings.Split(strings.Replace(src, "\r", "", -1), "\n", -1)
+	for i := 0; i < len(lines); i++ {
+		line := strings.Replace(lines[i], "\t", "    ", -1)
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence := trimmed[:3]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, strings.TrimRight(lines[i], "\r"))
+			}
+			blocks = append(blocks, &mdBlock{kind: mdCode, text: strings.Join(code, "\n")})
+			open = nil
+			continue
+		}
+		marker, indent, itemText, isItem := listMarker(line)
+		switch {
+		case trimmed == "":
+			if len(blocks) == 0 || blocks[len(blocks)-1].kind != mdBlank {
+				blocks = append(blocks, &mdBlock{kind: mdBlank})
+			}
+			open = nil
+		case open != nil && open.kind == mdParagraph && (strings.Count(trimmed, "=") == len(trimmed) || strings.Count(trimmed, "-") == len(trimmed)):
+			// A setext heading, underlined
+			open.kind, open.level = mdHeading, 1
+			if trimmed[0] == '-' {
+				open.level = 2
+			}
+			open = nil
+		case isRule(line):
+			blocks = append(blocks, &mdBlock{kind: mdRule})
+			open = nil
+		case strings.HasPrefix(trimmed, "#"):
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			text := strings.TrimSpace(strings.TrimRight(trimmed[level:], "#"))
+			blocks = append(blocks, &mdBlock{kind: mdHeading, level: level, text: text})
+			open = nil
+		case isItem:
+			open = &mdBlock{kind: mdItem, level: indent / 2, marker: marker, text: itemText}
+			blocks = append(blocks, open)
+		case strings.HasPrefix(trimmed, ">"):
+			text := strings.TrimSpace(trimmed[1:])
+			if open != nil && open.kind == mdQuote {
+				open.text += " " + text
+				continue
+			}
+			open = &mdBlock{kind: mdQuote, text: text}
+			blocks = append(blocks, open)
+		case open != nil:
+			open.text += " " + trimmed
+		case strings.HasPrefix(line, "    "):
+			code := []string{line[4:]}
+			for i+1 < len(lines) && (strings.HasPrefix(lines[i+1], "    ") || strings.HasPrefix(lines[i+1], "\t")) {
+				i++
+				code = append(code, strings.Replace(lines[i], "\t", "    ", -1)[4:])
+			}
+			blocks = append(blocks, &mdBlock{kind: mdCode, text: strings.Join(code, "\n")})
+		default:
+			open = &mdBlock{kind: mdParagraph, text: trimmed}
+			blocks = append(blocks, open)
+		}
+	}
+	return blocks
+}
+
+// isWordRune reports whether r belongs to a word, for telling emphasis
+// markers from underscores inside names
+func isWordRune(r int) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// mdInline renders the inline markup of a block's text, collecting its
+// links. With numbered, each link's text is followed by its number in
+// links, counting from one.
+func mdInline(text string, numbered bool, links *[]mdLink) string {
+	var out []int
+	runes := []int(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		rest := string(runes[i:])
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			out = append(out, runes[i])
+		case r == '`':
+			end := strings.Index(rest[1:], "`")
+			if end == -1 {
+				out = append(out, r)
+				continue
+			}
+			code := []int(rest[1 : end+1])
+			out = append(out, code...)
+			i += len(code) + 1
+		case r == '<' && (strings.HasPrefix(rest, "<http://") || strings.HasPrefix(rest, "<https://") || strings.HasPrefix(rest, "<gopher://")):
+			end := strings.Index(rest, ">")
+			if end == -1 {
+				out = append(out, r)
+				continue
+			}
+			url := rest[1:end]
+			*links = append(*links, mdLink{url, url})
+			out = append(out, []int(url)...)
+			if numbered {
+				out = append(out, []int(" ["+strconv.Itoa(len(*links))+"]")...)
+			}
+			i += len([]int(rest[:end]))
+		case r == '[' || r == '!' && strings.HasPrefix(rest, "!["):
+			image := r == '!'
+			start := 1
+			if image {
+				start = 2
+			}
+			close := strings.Index(rest, "](")
+			end := -1
+			if close != -1 {
+				end = strings.Index(rest[close:], ")")
+			}
+			if close == -1 || end == -1 {
+				out = append(out, r)
+				continue
+			}
+			label := mdInline(rest[start:close], false, new([]mdLink))
+			url := strings.TrimSpace(rest[close+2 : close+end])
+			if sp := strings.Index(url, " "); sp != -1 {
+				// Drop a title
+				url = url[:sp]
+			}
+			if image {
+				label = "[image: " + label + "]"
+			}
+			*links = append(*links, mdLink{label, url})
+			out = append(out, []int(label)...)
+			if numbered {
+				out = append(out, []int(" ["+strconv.Itoa(len(*links))+"]")...)
+			}
+			i += len([]int(rest[:close+end]))
+		case r == '*' || r == '_':
+			before, after := ' ', ' '
+			if i > 0 {
+				before = runes[i-1]
+			}
+			if i+1 < len(runes) {
+				after = runes[i+1]
+			}
+			if (before == r || after == r) || isWordRune(before) != isWordRune(after) {
+				// Emphasis, which plain text does without
+				continue
+			}
+			out = append(out, r)
+		default:
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+// wrapWords fills the words of text into lines of at most width columns,
+// the first line starting with first and the others with rest. Words too
+// long for a line get one of their own.
+func wrapWords(text string, width int, first string, rest string) []string {
+	var lines []string
+	line, prefix := "", first
+	for _, word := range strings.Fields(text) {
+		if line != "" && utf8.RuneCountInString(prefix+line+" "+word) > width {
+			lines = append(lines, prefix+line)
+			line, prefix = "", rest
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, prefix+line)
+	}
+	return lines
+}
+
+// markdownWidth returns the column rendered markdown is wrapped at
+func (s *Server) markdownWidth() int {
+	if s.MarkdownWidth > 0 {
+		return s.MarkdownWidth
+	}
+	return DefaultMarkdownWidth
+}
+
+// renderBlock returns the lines of a block as plain text
+func renderBlock(b *mdBlock, width int, numbered bool, links *[]mdLink) []string {
+	switch b.kind {
+	case mdHeading:
+		text := mdInline(b.text, numbered, links)
+		switch b.level {
+		case 1:
+			return []string{text, strings.Repeat("=", utf8.RuneCountInString(text))}
+		case 2:
+			return []string{text, strings.Repeat("-", utf8.RuneCountInString(text))}
+		}
+		return []string{text}
+	case mdQuote:
+		return wrapWords(mdInline(b.text, numbered, links), width, "> ", "> ")
+	case mdItem:
+		indent := strings.Repeat("  ", b.level)
+		return wrapWords(mdInline(b.text, numbered, links), width, indent+b.marker+" ", indent+strings.Repeat(" ", len(b.marker)+1))
+	case mdCode:
+		var lines []string
+		for _, line := range strings.Split(b.text, "\n", -1) {
+			lines = append(lines, "    "+line)
+		}
+		return lines
+	case mdRule:
+		return []string{strings.Repeat("-", width)}
+	case mdBlank:
+		return []string{""}
+	}
+	return wrapWords(mdInline(b.text, numbered, links), width, "", "")
+}
+
+// MarkdownText renders a markdown document as plain text wrapped at width
+func MarkdownText(src string, width int) []string {
+	var lines []string
+	var links []mdLink
+	for _, b := range parseMarkdown(src) {
+		lines = append(lines, renderBlock(b, width, true, &links)...)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(links) > 0 {
+		lines = append(lines, "", "Links:")
+		for i, l := range links {
+			lines = append(lines, "["+strconv.Itoa(i+1)+"] "+l.url)
+		}
+	}
+	return lines
+}
+
+// linkHeavy reports whether most of the paragraphs and list items of a
+// document are links, making it better served as a menu
+func linkHeavy(blocks []*mdBlock) bool {
+	text, linked := 0, 0
+	for _, b := range blocks {
+		if b.kind != mdParagraph && b.kind != mdItem {
+			continue
+		}
+		text++
+		var links []mdLink
+		mdInline(b.text, false, &links)
+		if len(links) > 0 {
+			linked++
+		}
+	}
+	return linked > 0 && linked*2 >= text
+}
+
+// markdownMenu reports whether the markdown file at name is served as a
+// menu
+func (s *Server) markdownMenu(name string) bool {
+	if s.MarkdownMode != MarkdownAuto || !isMarkdown(name) {
+		return false
+	}
+	data, err := s.readSidecar(name)
+	return err == nil && linkHeavy(parseMarkdown(string(data)))
+}
+
+// linkEntry returns the menu entry for a link in a markdown file served
+// at selector
+func (s *Server) linkEntry(ctx *Context, selector string, l mdLink) *MenuEntry {
+	if u, err := ParseURL(l.url); err == nil {
+		return &MenuEntry{u.Type, l.text, u.Selector, u.Host, u.Port}
+	}
+	if strings.Index(l.url, "://") != -1 || strings.HasPrefix(l.url, "mailto:") {
+		host, port := s.advertise(selector)
+		return &MenuEntry{'h', l.text, "URL:" + l.url, host, port}
+	}
+	target := l.url
+	for _, sep := range []string{"#", "?"} {
+		if i := strings.Index(target, sep); i != -1 {
+			target = target[:i]
+		}
+	}
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(path.Dir(selector), target)
+	}
+	target = "/" + strings.Trim(path.Clean("/"+target), "/")
+	t := byte('0')
+	if info, err := s.statFile(ctx.Host.Root + target); err == nil {
+		t = s.itemType(info)
+	}
+	host, port := s.advertise(target)
+	return &MenuEntry{t, l.text, target, host, port}
+}
+
+// serveMarkdown sends a markdown file rendered as text, or as a menu if
+// it is mostly links and MarkdownMode is MarkdownAuto
+func (s *Server) serveMarkdown(ctx *Context, file io.Reader) (Status, os.Error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return StatusError, err
+	}
+	blocks := parseMarkdown(string(data))
+	width := s.markdownWidth()
+	if s.MarkdownMode != MarkdownAuto || !linkHeavy(blocks) {
+		ctx.itemType = '0'
+		for _, line := range MarkdownText(string(data), width) {
+			ctx.Write(line)
+		}
+		ctx.Write(".")
+		ctx.Logf("Served markdown `%s' as text\n", ctx.Request)
+		return StatusOK, nil
+	}
+	ctx.itemType = '1'
+	w := NewEntryWriter(ctx)
+	for _, b := range blocks {
+		var links []mdLink
+		for _, line := range renderBlock(b, width, false, &links) {
+			w.Info(line)
+		}
+		for _, l := range links {
+			w.Write(s.linkEntry(ctx, ctx.Request, l))
+		}
+	}
+	ctx.Logf("Served markdown `%s' as a menu\n", ctx.Request)
+	return StatusOK, w.Close()
+}
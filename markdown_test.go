@@ -0,0 +1,124 @@
+package gopher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var markdownTests = []struct {
+	src   string
+	width int
+	want  []string
+}{
+	{
+		"Title\n" +
+			"=====\n" +
+			"\n" +
+			"Some *emphasis* and a [link](http://example.com/ \"Site\") here.\n" +
+			"\n" +
+			"- one\n" +
+			"- two `co*de`\n" +
+			"\n" +
+			"    indented code\n" +
+			"\n" +
+			"> quoted\n" +
+			"> more\n",
+		70,
+		[]string{
+			"Title",
+			"=====",
+			"",
+			"Some emphasis and a link [1] here.",
+			"",
+			"- one",
+			"- two co*de",
+			"",
+			"    indented code",
+			"",
+			"> quoted more",
+			"",
+			"Links:",
+			"[1] http://example.com/",
+		},
+	},
+	{
+		"# Top\n" +
+			"\n" +
+			"one two three four five six seven\n" +
+			"\n" +
+			"***\n" +
+			"\n" +
+			"## Sub ##\n" +
+			"### Deep\n" +
+			"1. first item text here\n" +
+			"   * nested\n" +
+			"\n" +
+			"```\n" +
+			"x := *p\n" +
+			"```\n" +
+			"See <gopher://example.com/1/> and ![logo](/logo.png), file_name \\*not\\*.\n",
+		20,
+		[]string{
+			"Top",
+			"===",
+			"",
+			"one two three four",
+			"five six seven",
+			"",
+			"--------------------",
+			"",
+			"Sub",
+			"---",
+			"Deep",
+			"1. first item text",
+			"   here",
+			"  * nested",
+			"",
+			"    x := *p",
+			"See",
+			"gopher://example.com/1/",
+			"[1] and [image:",
+			"logo] [2], file_name",
+			"*not*.",
+			"",
+			"Links:",
+			"[1] gopher://example.com/1/",
+			"[2] /logo.png",
+		},
+	},
+}
+
+func TestMarkdownText(t *testing.T) {
+	for _, test := range markdownTests {
+		if got := MarkdownText(test.src, test.width); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q:\ngot:\n%s\nwant:\n%s", test.src, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestLinkHeavy(t *testing.T) {
+	tests := map[string]bool{
+		"- [a](/a)\n- [b](/b)\n- plain\n":                 true,
+		"Intro\n\n[a](/a)\n\n<gopher://example.com/>\n":   true,
+		"Text\n\n[a](/a)\n\nmore\n\nmore text\n":          false,
+		"# Only a heading [a](/a)\n\n    [b](/b) in code": false,
+	}
+	for src, want := range tests {
+		if got := linkHeavy(parseMarkdown(src)); got != want {
+			t.Errorf("%q: got %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestParseMarkdownMode(t *testing.T) {
+	modes := map[string]int{"raw": MarkdownRaw, "text": MarkdownText, "auto": MarkdownAuto}
+	for name, want := range modes {
+		if got, err := ParseMarkdownMode(name); err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", name, got, err, want)
+		}
+	}
+	if _, err := ParseMarkdownMode("html"); err == nil {
+		t.Errorf("html: want an error")
+	}
+}
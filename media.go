@@ -0,0 +1,111 @@
+package gopher
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Regular files are listed with an item type chosen by their extension
+// from ItemTypes, then DefaultItemTypes, and are text (0) otherwise:
+//    item-type .opus s
+//    item-type .cbz 9
+// Files of any type other than text (0) and HTML (h) are sent exactly as
+// they are, in large blocks, without the character set conversion and
+// reflowing text goes through.
+
+// DefaultItemTypes are the item types of files by extension
+var DefaultItemTypes = map[string]byte{
+	".html": 'h',
+	".htm":  'h',
+	".gif":  'g',
+	".png":  'I',
+	".jpg":  'I',
+	".jpeg": 'I',
+	".bmp":  'I',
+	".webp": 'I',
+	".wav":  's',
+	".mp3":  's',
+	".ogg":  's',
+	".flac": 's',
+	".au":   's',
+	".mid":  's',
+	".mp4":  ';',
+	".m4v":  ';',
+	".mkv":  ';',
+	".webm": ';',
+	".avi":  ';',
+	".mov":  ';',
+	".zip":  '9',
+	".gz":   '9',
+	".tgz":  '9',
+	".pdf":  'd',
+}
+
+// BinaryBlockSize is how much of a binary file is read and sent at a time
+var BinaryBlockSize = 32 * 1024
+
+// SetItemType lists the files with extension ext as items of type t
+func (s *Server) SetItemType(ext string, t byte) {
+	if s.ItemTypes == nil {
+		s.ItemTypes = make(map[string]byte)
+	}
+	s.ItemTypes["."+strings.ToLower(strings.TrimLeft(ext, "."))] = t
+}
+
+// configureItemType handles an `item-type extension type' line
+func (s *Server) configureItemType(args []string) os.Error {
+	if len(args) != 2 || len(args[1]) != 1 || !validItemType(args[1][0]) {
+		return os.NewError("expected an extension and a one character item type")
+	}
+	s.SetItemType(args[0], args[1][0])
+	return nil
+}
+
+// validItemType reports whether t can be the item type of a file
+func validItemType(t byte) bool {
+	return t > ' ' && t < 0x7f && t != '1' && t != '7' && t != '3' && t != 'i'
+}
+
+// fileType returns the item type of a regular file by its name
+func (s *Server) fileType(name string) byte {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := s.ItemTypes[ext]; ok {
+		return t
+	}
+	if t, ok := DefaultItemTypes[ext]; ok {
+		return t
+	}
+	return '0'
+}
+
+// textType reports whether files of item type t are text
+func textType(t byte) bool {
+	return t == '0' || t == 'h'
+}
+
+// sendFile sends the contents of a file served as item type t
+func (s *Server) sendFile(ctx *Context, file io.Reader, t byte) (Status, os.Error) {
+	ctx.itemType = t
+	if textType(t) {
+		return s.Textfile(ctx, file)
+	}
+	buf := make([]byte, BinaryBlockSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if _, werr := ctx.conn.Write(buf[:n]); werr != nil {
+				return StatusError, werr
+			}
+		}
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return StatusError, err
+		}
+	}
+	ctx.Logf("Served binary file `%s'\n", ctx.Request)
+	return StatusOK, nil
+}
@@ -0,0 +1,222 @@
+package gopher
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is a FileSystem held in memory, for content that is generated or
+// unpacked rather than kept on disk. Directories are made as files are
+// added below them.
+type MemFS struct {
+	sync.RWMutex
+	nodes map[string]*memNode
+}
+
+// memNode is a file or directory of a MemFS
+type memNode struct {
+	info     os.FileInfo
+	data     []byte
+	open     func() (io.ReadCloser, os.Error) // Opens the contents, if not in data
+	children []string                         // Names of a directory's entries, sorted
+}
+
+// NewMemFS returns an empty MemFS
+func NewMemFS() *MemFS {
+	m := &MemFS{nodes: make(map[string]*memNode)}
+	m.nodes["/"] = &memNode{info: memInfo("/", syscall.S_IFDIR|0755, 0, time.Nanoseconds())}
+	return m
+}
+
+// memInfo returns the FileInfo of a MemFS entry
+func memInfo(name string, mode uint32, size int64, mtime int64) os.FileInfo {
+	return os.FileInfo{Name: path.Base(name), Mode: mode, Size: size, Mtime_ns: mtime, Atime_ns: mtime, Ctime_ns: mtime, Nlink: 1}
+}
+
+// cleanName returns the canonical form of a MemFS name
+func cleanName(name string) string {
+	return path.Clean("/" + name)
+}
+
+// WriteFile stores data as the file at name, replacing what was there
+func (m *MemFS) WriteFile(name string, data []byte) os.Error {
+	name = cleanName(name)
+	return m.add(name, &memNode{info: memInfo(name, syscall.S_IFREG|0644, int64(len(data)), time.Nanoseconds()), data: data})
+}
+
+// Mkdir makes the directory at name and those above it
+func (m *MemFS) Mkdir(name string) os.Error {
+	m.Lock()
+	defer m.Unlock()
+	return m.mkdir(cleanName(name))
+}
+
+// addOpener adds a file whose contents are read by open when it is opened
+func (m *MemFS) addOpener(name string, size int64, mtime int64, open func() (io.ReadCloser, os.Error)) os.Error {
+	name = cleanName(name)
+	return m.add(name, &memNode{info: memInfo(name, syscall.S_IFREG|0644, size, mtime), open: open})
+}
+
+// add puts a file node at name
+func (m *MemFS) add(name string, n *memNode) os.Error {
+	m.Lock()
+	defer m.Unlock()
+	if old, found := m.nodes[name]; found && old.info.IsDirectory() {
+		return &os.PathError{"write", name, os.EISDIR}
+	}
+	if err := m.mkdir(path.Dir(name)); err != nil {
+		return err
+	}
+	if _, found := m.nodes[name]; !found {
+		m.link(name)
+	}
+	m.nodes[name] = n
+	return nil
+}
+
+// mkdir makes the directory at name and those above it. The MemFS must be
+// locked.
+func (m *MemFS) mkdir(name string) os.Error {
+	if n, found := m.nodes[name]; found {
+		if !n.info.IsDirectory() {
+			return &os.PathError{"mkdir", name, os.ENOTDIR}
+		}
+		return nil
+	}
+	if err := m.mkdir(path.Dir(name)); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{info: memInfo(name, syscall.S_IFDIR|0755, 0, time.Nanoseconds())}
+	m.link(name)
+	return nil
+}
+
+// link lists name in its directory. The MemFS must be locked.
+func (m *MemFS) link(name string) {
+	dir := m.nodes[path.Dir(name)]
+	dir.children = append(dir.children, path.Base(name))
+	sort.SortStrings(dir.children)
+	dir.info.Mtime_ns = time.Nanoseconds()
+}
+
+// node returns the node at name
+func (m *MemFS) node(op string, name string) (*memNode, os.Error) {
+	m.RLock()
+	defer m.RUnlock()
+	n, found := m.nodes[cleanName(name)]
+	if !found {
+		return nil, &os.PathError{op, name, os.ENOENT}
+	}
+	return n, nil
+}
+
+func (m *MemFS) Open(name string) (File, os.Error) {
+	n, err := m.node("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f := &memFile{fs: m, name: cleanName(name), node: n}
+	switch {
+	case n.open != nil:
+		if f.contents, err = n.open(); err != nil {
+			return nil, &os.PathError{"open", name, err}
+		}
+	case !n.info.IsDirectory():
+		f.contents = nopCloser{bytes.NewBuffer(n.data)}
+	}
+	return f, nil
+}
+
+func (m *MemFS) Stat(name string) (*os.FileInfo, os.Error) {
+	n, err := m.node("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info := n.info
+	return &info, nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]os.FileInfo, os.Error) {
+	f, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+// nopCloser makes a Reader a ReadCloser
+type nopCloser struct {
+	io.Reader
+}
+
+func (nopCloser) Close() os.Error {
+	return nil
+}
+
+// memFile is an open file or directory of a MemFS
+type memFile struct {
+	fs       *MemFS
+	name     string
+	node     *memNode
+	contents io.ReadCloser // Nil for a directory
+	read     int           // Directory entries returned so far
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(b []byte) (int, os.Error) {
+	if f.contents == nil {
+		return 0, &os.PathError{"read", f.name, os.EISDIR}
+	}
+	return f.contents.Read(b)
+}
+
+func (f *memFile) Close() os.Error {
+	if f.contents == nil {
+		return nil
+	}
+	return f.contents.Close()
+}
+
+func (f *memFile) Stat() (*os.FileInfo, os.Error) {
+	info := f.node.info
+	return &info, nil
+}
+
+// Readdir returns up to count entries of the directory, or all the rest if
+// count is not positive, like os.File's
+func (f *memFile) Readdir(count int) ([]os.FileInfo, os.Error) {
+	if !f.node.info.IsDirectory() {
+		return nil, &os.PathError{"readdir", f.name, os.ENOTDIR}
+	}
+	f.fs.RLock()
+	children := f.node.children
+	f.fs.RUnlock()
+	rest := children[f.read:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, os.EOF
+		}
+		if len(rest) > count {
+			rest = rest[:count]
+		}
+	}
+	entries := make([]os.FileInfo, 0, len(rest))
+	for _, child := range rest {
+		if info, err := f.fs.Stat(strings.TrimRight(f.name, "/") + "/" + child); err == nil {
+			entries = append(entries, *info)
+		}
+	}
+	f.read += len(rest)
+	return entries, nil
+}
@@ -0,0 +1,184 @@
+package gopher
+
+import (
+	"container/vector"
+	"fmt"
+	"os"
+)
+
+// MenuEntry is one line of a Gopher menu
+type MenuEntry struct {
+	Type     byte
+	Display  string
+	Selector string
+	Host     string
+	Port     int
+}
+
+// String renders the entry as a menu line without the <CR><LF>
+func (entry *MenuEntry) String() string {
+	return fmt.Sprintf("%c%s\t%s\t%s\t%d", entry.Type, entry.Display, entry.Selector, entry.Host, entry.Port)
+}
+
+// Menu is a Gopher menu under construction. Entries added without a host
+// point back at Hostname and Port.
+type Menu struct {
+	Hostname string
+	Port     int
+	entries  vector.Vector
+}
+
+// NewMenu returns an empty menu whose local entries point at the server
+// serving ctx
+func NewMenu(ctx *Context) *Menu {
+	return &Menu{Hostname: ctx.Server.Hostname, Port: ctx.Server.Port}
+}
+
+// Add appends an entry, filling in the host and port if they are unset
+func (m *Menu) Add(entry *MenuEntry) {
+	if entry.Host == "" {
+		entry.Host = m.Hostname
+		entry.Port = m.Port
+	}
+	m.entries.Push(entry)
+}
+
+// Item appends an entry for a selector on this server
+func (m *Menu) Item(itemType byte, display string, selector string) {
+	m.Add(&MenuEntry{Type: itemType, Display: display, Selector: selector})
+}
+
+// Info appends an informational line
+func (m *Menu) Info(text string) {
+	m.Add(&MenuEntry{Type: 'i', Display: text})
+}
+
+// Len returns the number of entries in the menu
+func (m *Menu) Len() int {
+	return m.entries.Len()
+}
+
+// At returns the i'th entry of the menu
+func (m *Menu) At(i int) *MenuEntry {
+	return m.entries.At(i).(*MenuEntry)
+}
+
+// WriteTo sends the menu to the client followed by the terminating period
+func (m *Menu) WriteTo(ctx *Context) os.Error {
+	w := NewEntryWriter(ctx)
+	w.Hostname, w.Port = m.Hostname, m.Port
+	for i := 0; i < m.entries.Len(); i++ {
+		if err := w.Write(m.At(i)); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// EntryWriter sends menu entries to the client as they are produced, for
+// menus too large or too slow to build up front. Entries without a host
+// point back at Hostname and Port.
+type EntryWriter struct {
+	Hostname string
+	Port     int
+	ctx      *Context
+	err      os.Error
+	banner   bool // Whether the server's banner is still to be sent
+}
+
+// NewEntryWriter returns an EntryWriter whose local entries point at the
+// server serving ctx
+func NewEntryWriter(ctx *Context) *EntryWriter {
+	ctx.itemType = '1'
+	return &EntryWriter{Hostname: ctx.Server.Hostname, Port: ctx.Server.Port, ctx: ctx, banner: true}
+}
+
+// SkipBanner leaves the server's banner off the menu, for menus meant for
+// programs or relayed from elsewhere
+func (w *EntryWriter) SkipBanner() {
+	w.banner = false
+}
+
+// writeBanner sends the server's banner if it has not been sent yet
+func (w *EntryWriter) writeBanner() {
+	if !w.banner {
+		return
+	}
+	w.banner = false
+	lines := w.ctx.Server.bannerLines(w.ctx)
+	for _, line := range lines {
+		w.Info(line)
+	}
+	if len(lines) > 0 {
+		w.Info("")
+	}
+}
+
+// Write sends an entry, filling in the host and port if they are unset and
+// applying any advertised host for its selector. The server's banner goes
+// before the first entry. Telnet items are dropped if the server forbids
+// them. After a failed write every later write returns the same error.
+func (w *EntryWriter) Write(entry *MenuEntry) os.Error {
+	w.writeBanner()
+	if w.err != nil {
+		return w.err
+	}
+	if isTelnetType(entry.Type) && w.ctx.Server.ForbidTelnet {
+		return nil
+	}
+	if entry.Host == "" {
+		entry.Host = w.Hostname
+		entry.Port = w.Port
+	}
+	if entry.Host == w.Hostname && entry.Port == w.Port && entry.Type != 'i' && !isTelnetType(entry.Type) {
+		if s := w.ctx.Server; entry.Host == s.Hostname && entry.Port == s.Port {
+			entry.Host, entry.Port = s.advertise(entry.Selector)
+		}
+		entry.Selector = w.ctx.withToken(entry.Selector)
+	}
+	_, w.err = w.ctx.Write(entry.String())
+	return w.err
+}
+
+// Item sends an entry for a selector on this server
+func (w *EntryWriter) Item(itemType byte, display string, selector string) os.Error {
+	return w.Write(&MenuEntry{Type: itemType, Display: display, Selector: selector})
+}
+
+// Info sends an informational line
+func (w *EntryWriter) Info(text string) os.Error {
+	return w.Write(&MenuEntry{Type: 'i', Display: text})
+}
+
+// Close ends the menu with the terminating period
+func (w *EntryWriter) Close() os.Error {
+	w.writeBanner()
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = w.ctx.Write(".")
+	return w.err
+}
+
+// InfoLine formats an info line pointing at the server.
+// This method is deprecated; use Menu.Info or EntryWriter.Info instead.
+func (s *Server) InfoLine(line string) string {
+	entry := &MenuEntry{Type: 'i', Display: line, Host: s.Hostname, Port: s.Port}
+	return entry.String()
+}
+
+// TextfileLine formats an entry for the text file at path.
+// This method is deprecated; use Menu.Item or EntryWriter.Item instead.
+func (s *Server) TextfileLine(name string, path string) string {
+	host, port := s.advertise("/" + path)
+	entry := &MenuEntry{Type: '0', Display: name, Selector: "/" + path, Host: host, Port: port}
+	return entry.String()
+}
+
+// DirectoryLine formats an entry for the directory at path.
+// This method is deprecated; use Menu.Item or EntryWriter.Item instead.
+func (s *Server) DirectoryLine(name string, path string) string {
+	host, port := s.advertise("/" + path)
+	entry := &MenuEntry{Type: '1', Display: name, Selector: "/" + path, Host: host, Port: port}
+	return entry.String()
+}
@@ -0,0 +1,93 @@
+package gopher
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Mirror is a local copy, below Prefix, of content from other servers.
+// Menu entries in the copy that point back at one of the Origins are
+// rewritten to point into the copy, so browsing stays on this server.
+type Mirror struct {
+	Prefix  string
+	Origins []string // host or host:port; *.example.org matches subdomains
+	Except  []string // Hosts that are never rewritten
+}
+
+// configureMirror handles a `mirror prefix origin... [except host...]' line
+func (s *Server) configureMirror(args []string) os.Error {
+	if len(args) < 2 {
+		return os.NewError("expected a selector prefix and at least one origin host")
+	}
+	m := &Mirror{Prefix: "/" + strings.Trim(args[0], "/")}
+	list := &m.Origins
+	for _, arg := range args[1:] {
+		if arg == "except" {
+			list = &m.Except
+			continue
+		}
+		*list = append(*list, strings.ToLower(arg))
+	}
+	if len(m.Origins) == 0 {
+		return os.NewError("expected at least one origin host")
+	}
+	s.mirrors = append(s.mirrors, m)
+	return nil
+}
+
+// matches reports whether an entry pointing at host and port leads back to
+// the mirror's origin
+func (m *Mirror) matches(host string, port int) bool {
+	host = strings.ToLower(host)
+	for _, except := range m.Except {
+		if host == except {
+			return false
+		}
+	}
+	for _, origin := range m.Origins {
+		originHost := origin
+		if i := strings.LastIndex(origin, ":"); i != -1 {
+			originPort, err := strconv.Atoi(origin[i+1:])
+			if err != nil || originPort != port {
+				continue
+			}
+			originHost = origin[:i]
+		}
+		if originHost == host {
+			return true
+		}
+		if strings.HasPrefix(originHost, "*.") && strings.HasSuffix(host, originHost[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorFor returns the mirror the selector lies in, if any
+func (s *Server) mirrorFor(selector string) *Mirror {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, m := range s.mirrors {
+		if selector == m.Prefix || strings.HasPrefix(selector, m.Prefix+"/") {
+			return m
+		}
+	}
+	return nil
+}
+
+// rewriteMirrorLink points an entry of a menu served from selector at the
+// local copy if it leads back to the mirror's origin
+func (s *Server) rewriteMirrorLink(selector string, entry *MenuEntry) {
+	m := s.mirrorFor(selector)
+	if m == nil || entry.Type == '8' || entry.Type == 'T' || entry.Type == 'i' || strings.HasPrefix(entry.Selector, "URL:") {
+		return
+	}
+	if !m.matches(entry.Host, entry.Port) {
+		return
+	}
+	entry.Selector = path.Join(m.Prefix, "/"+entry.Selector)
+	entry.Host = s.Hostname
+	entry.Port = s.Port
+}
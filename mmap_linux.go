@@ -0,0 +1,20 @@
+package gopher
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of file into memory, read only
+func mapFile(file *os.File, size int64) ([]byte, os.Error) {
+	data, errno := syscall.Mmap(file.Fd(), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if errno != 0 {
+		return nil, os.NewSyscallError("mmap", errno)
+	}
+	return data, nil
+}
+
+// unmapFile releases memory returned by mapFile
+func unmapFile(data []byte) {
+	syscall.Munmap(data)
+}
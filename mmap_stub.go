@@ -0,0 +1,22 @@
+package gopher
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of file into memory where files are
+// not mapped yet
+func mapFile(file *os.File, size int64) ([]byte, os.Error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmapFile releases memory returned by mapFile
+func unmapFile(data []byte) {}
@@ -0,0 +1,48 @@
+package gopher
+
+import (
+	"strings"
+)
+
+// A directory's names file gives its files titles for automatic listings
+// without writing a whole gophermap. Each line holds a file name, a tab
+// and the title to show; lines starting with # are comments:
+//    2011-report.txt	Annual report for 2011
+//    pics	Photographs
+
+// NamesFiles are the names a directory's names file can have, tried in
+// order
+var NamesFiles = []string{".names", "filenames"}
+
+// isNamesFile reports whether a file is a names file
+func isNamesFile(name string) bool {
+	for _, n := range NamesFiles {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the titles given in the names file of dir, if it has one
+func (s *Server) names(dir string) map[string]string {
+	for _, n := range NamesFiles {
+		data, err := s.readSidecar(dir + "/" + n)
+		if err != nil {
+			continue
+		}
+		names := make(map[string]string)
+		for _, line := range strings.Split(string(data), "\n", -1) {
+			line = strings.TrimRight(line, "\r")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Split(line, "\t", 2)
+			if len(fields) == 2 && strings.TrimSpace(fields[1]) != "" {
+				names[fields[0]] = strings.TrimSpace(fields[1])
+			}
+		}
+		return names
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package gopher
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Selectors found missing are remembered for NegativeCacheTTL, and asked
+// for again within that time are answered as missing straight away,
+// without touching the file system or logging an error each time. This
+// keeps crawlers hammering dead links cheap. A file created meanwhile is
+// served once the entry expires, or at once if WatchFiles is on and its
+// directory is being watched.
+
+// NegativeCacheTTL is how long a missing file is remembered, in
+// nanoseconds; 0 turns the cache off
+var NegativeCacheTTL int64 = 10e9
+
+// MaxNegativeEntries bounds the number of missing files remembered
+var MaxNegativeEntries = 10000
+
+// errKnownMissing is the error of a request for a file remembered missing
+var errKnownMissing = os.NewError("recently found missing")
+
+// negativeCache remembers missing files by absolute path
+type negativeCache struct {
+	sync.Mutex
+	expires map[string]int64 // When each entry expires, in nanoseconds
+}
+
+// missing reports whether name was found missing less than
+// NegativeCacheTTL ago
+func (c *negativeCache) missing(name string) bool {
+	if NegativeCacheTTL <= 0 {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	expires, ok := c.expires[name]
+	if ok && time.Nanoseconds() >= expires {
+		c.expires[name] = 0, false
+		return false
+	}
+	return ok
+}
+
+// add remembers that name is missing
+func (c *negativeCache) add(name string) {
+	if NegativeCacheTTL <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.expires == nil || len(c.expires) >= MaxNegativeEntries {
+		c.expires = make(map[string]int64)
+	}
+	c.expires[name] = time.Nanoseconds() + NegativeCacheTTL
+}
+
+// forget drops name, for when it has been created
+func (c *negativeCache) forget(name string) {
+	c.Lock()
+	if c.expires != nil {
+		c.expires[name] = 0, false
+	}
+	c.Unlock()
+}
+
+// clear drops every entry
+func (c *negativeCache) clear() {
+	c.Lock()
+	c.expires = nil
+	c.Unlock()
+}
@@ -0,0 +1,49 @@
+package gopher
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+)
+
+// panicStack describes the calls leading to a recovered panic
+func panicStack() string {
+	var b bytes.Buffer
+	// Skip panicStack and the deferred function that recovered
+	for i := 2; ; i++ {
+		pc, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		name := "?"
+		if f := runtime.FuncForPC(pc); f != nil {
+			name = f.Name()
+		}
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", name, file, line)
+	}
+	return b.String()
+}
+
+// recoverRequest stops a panic while answering ctx from taking the server
+// down: it is logged with the selector and stack, and the client gets an
+// error item unless something was already sent. It must be deferred.
+func (s *Server) recoverRequest(ctx *Context, counter *countingConn) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	ctx.Logf("ERROR: Panic answering `%s': %v\n%s", ctx.Request, e, panicStack())
+	if counter.written == 0 {
+		ctx.ServerError()
+	}
+	ctx.Flush()
+}
+
+// recoverConn stops a panic while answering a connection to one of the
+// other listeners, such as finger or the control socket, from taking the
+// server down. It must be deferred.
+func (s *Server) recoverConn(listener string) {
+	if e := recover(); e != nil {
+		s.Logger.Printf("ERROR: Panic answering %s connection: %v\n%s", listener, e, panicStack())
+	}
+}
@@ -0,0 +1,209 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A phlog is a directory of posts named after the day they were written,
+// such as 2011-03-04-spring.txt or a 2011-03-04 directory. Its selector
+// serves an index of the posts, newest first, titled by the first line of
+// each post, and prefix/archive/YYYY-MM lists the posts of a month. The
+// directory's .header is shown above the index, and posts with a Tags line
+// are listed by tag below prefix/tags.
+
+// Phlog is a directory served as a phlog
+type Phlog struct {
+	Prefix string
+}
+
+// phlogPost is one post of a phlog
+type phlogPost struct {
+	Date     string // YYYY-MM-DD
+	Title    string
+	Selector string
+	Type     byte
+	Tags     []string
+}
+
+type phlogPosts []*phlogPost
+
+func (l phlogPosts) Len() int      { return len(l) }
+func (l phlogPosts) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l phlogPosts) Less(i, j int) bool {
+	if l[i].Date != l[j].Date {
+		return l[i].Date > l[j].Date
+	}
+	return l[i].Selector > l[j].Selector
+}
+
+// AddPhlog serves the directory at prefix as a phlog
+func (s *Server) AddPhlog(prefix string) {
+	s.phlogs = append(s.phlogs, &Phlog{"/" + strings.Trim(prefix, "/")})
+}
+
+// phlogFor returns the phlog whose index or archive selector is requested
+func (s *Server) phlogFor(selector string) *Phlog {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, p := range s.phlogs {
+		if selector == p.Prefix || selector == p.Prefix+"/archive" || strings.HasPrefix(selector, p.Prefix+"/archive/") ||
+			selector == p.Prefix+"/tags" || strings.HasPrefix(selector, p.Prefix+"/tags/") {
+			return p
+		}
+	}
+	return nil
+}
+
+// isPhlog reports whether the directory at selector is served as a phlog
+func (s *Server) isPhlog(selector string) bool {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, p := range s.phlogs {
+		if p.Prefix == selector {
+			return true
+		}
+	}
+	return false
+}
+
+// postDate returns the date a post is named after, if it is one
+func postDate(name string) (string, bool) {
+	if len(name) < 10 {
+		return "", false
+	}
+	if _, err := time.Parse("2006-01-02", name[:10]); err != nil {
+		return "", false
+	}
+	if len(name) > 10 && name[10] != '-' && name[10] != '.' && name[10] != '_' {
+		return "", false
+	}
+	return name[:10], true
+}
+
+// firstLine returns the first non-blank line at the start of a file
+func (s *Server) firstLine(name string) string {
+	file, err := s.openFile(name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	var buf [512]byte
+	n, _ := file.Read(buf[:])
+	for _, line := range strings.Split(string(buf[:n]), "\n", -1) {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// posts returns the phlog's posts, newest first
+func (s *Server) posts(ctx *Context, p *Phlog) (phlogPosts, os.Error) {
+	dirname := ctx.Host.Root + p.Prefix
+	fs, name := s.fileSystem(dirname)
+	entries, err := fs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	posts := make(phlogPosts, 0, len(entries))
+	for _, entry := range entries {
+		date, ok := postDate(entry.Name)
+		if !ok || listingHidden(entry.Name) || s.unpublished(ctx, dirname+"/"+entry.Name) {
+			continue
+		}
+		sel := p.Prefix + "/" + entry.Name
+		if !validSelector(strings.Trim(sel, "/")) || s.features.hidden(sel, ctx.ClientIP(), ctx.Host.Name) {
+			continue
+		}
+		post := &phlogPost{Date: date, Selector: sel}
+		switch {
+		case entry.IsRegular():
+			post.Type = '0'
+			post.Title = s.firstLine(dirname + "/" + entry.Name)
+			post.Tags = s.postTags(dirname + "/" + entry.Name)
+		case entry.IsDirectory():
+			post.Type = '1'
+			post.Title = s.firstLine(dirname + "/" + entry.Name + "/" + HeaderFile)
+			post.Tags = s.postTags(dirname + "/" + entry.Name + "/" + HeaderFile)
+		default:
+			continue
+		}
+		if post.Title == "" {
+			post.Title = strings.Trim(entry.Name[10:], "-._")
+			if ext := path.Ext(post.Title); ext != "" {
+				post.Title = post.Title[:len(post.Title)-len(ext)]
+			}
+		}
+		post.Title = s.displayName(post.Title)
+		posts = append(posts, post)
+	}
+	sort.Sort(posts)
+	return posts, nil
+}
+
+// monthName renders YYYY-MM as e.g. March 2011
+func monthName(month string) string {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return month
+	}
+	return t.Format("January 2006")
+}
+
+// servePhlog sends the index or an archive menu of a phlog
+func (s *Server) servePhlog(ctx *Context, p *Phlog) (Status, os.Error) {
+	posts, err := s.posts(ctx, p)
+	if err != nil {
+		return StatusNotFound, err
+	}
+	w := NewEntryWriter(ctx)
+	switch {
+	case ctx.Request == p.Prefix:
+		s.writeInfoFile(w, ctx.Host.Root+p.Prefix+"/"+HeaderFile)
+		for _, post := range posts {
+			w.Item(post.Type, post.Date+"  "+post.Title, post.Selector)
+		}
+		if len(posts) > 0 {
+			w.Info("")
+			if counts := countTags(posts); len(counts) > 0 {
+				writeTagCloud(w, counts)
+				w.Item('1', "Posts by tag", p.Prefix+"/tags")
+			}
+			w.Item('1', "Archive by month", p.Prefix+"/archive")
+			w.Item('0', "Atom feed", p.Prefix+"/.atom")
+		}
+	case ctx.Request == p.Prefix+"/tags" || strings.HasPrefix(ctx.Request, p.Prefix+"/tags/"):
+		if status, err := s.serveTags(ctx, w, p, posts); status != StatusOK {
+			return status, err
+		}
+	case ctx.Request == p.Prefix+"/archive":
+		counts := make(map[string]int)
+		months := make([]string, 0)
+		for _, post := range posts {
+			month := post.Date[:7]
+			if counts[month] == 0 {
+				months = append(months, month)
+			}
+			counts[month]++
+		}
+		for _, month := range months {
+			w.Item('1', fmt.Sprintf("%s (%d)", monthName(month), counts[month]), p.Prefix+"/archive/"+month)
+		}
+	default:
+		month := ctx.Request[len(p.Prefix+"/archive/"):]
+		w.Info(monthName(month))
+		w.Info("")
+		for _, post := range posts {
+			if post.Date[:7] == month {
+				w.Item(post.Type, post.Date+"  "+post.Title, post.Selector)
+			}
+		}
+	}
+	ctx.Logf("Served phlog index `%s'\n", ctx.Request)
+	return StatusOK, w.Close()
+}
@@ -0,0 +1,36 @@
+package gopher
+
+// Connections are answered by a fixed pool of Workers goroutines rather
+// than one goroutine each, so a flood of connections cannot exhaust
+// memory. Accepted connections wait in a queue of QueueLength for a free
+// worker; while the queue is full the listeners stop accepting, and
+// further clients wait in the kernel's backlog instead.
+
+// DefaultWorkers is the number of workers when Workers is not set
+const DefaultWorkers = 256
+
+// DefaultQueueLength is the length of the accept queue when QueueLength is
+// not set
+const DefaultQueueLength = 1024
+
+// startWorkers starts the worker pool
+func (s *Server) startWorkers() {
+	workers, length := s.Workers, s.QueueLength
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if length <= 0 {
+		length = DefaultQueueLength
+	}
+	s.queue = make(chan *Context, length)
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+}
+
+// worker answers queued connections
+func (s *Server) worker() {
+	for ctx := range s.queue {
+		s.handle(ctx)
+	}
+}
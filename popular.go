@@ -0,0 +1,205 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hits and bytes are counted per selector for successful requests. With
+// StatsFile set the counts are loaded when the server starts and saved
+// every StatsInterval seconds, and with StatsSelector set a menu of the
+// most requested selectors is served there.
+
+// DefaultStatsInterval is how often, in seconds, counts are saved unless
+// StatsInterval is set
+const DefaultStatsInterval = 300
+
+// SelectorStats counts the successful requests of one selector
+type SelectorStats struct {
+	Selector string
+	Type     byte // Item type it was last served as
+	Hits     int64
+	Bytes    int64
+}
+
+// selectorStatsList sorts by hits, most first
+type selectorStatsList []*SelectorStats
+
+func (l selectorStatsList) Len() int      { return len(l) }
+func (l selectorStatsList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l selectorStatsList) Less(i, j int) bool {
+	if l[i].Hits != l[j].Hits {
+		return l[i].Hits > l[j].Hits
+	}
+	return l[i].Selector < l[j].Selector
+}
+
+// hit accounts for a successful request of selector
+func (c *statsCollector) hit(selector string, itemType byte, sent int64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.selectors == nil {
+		c.selectors = make(map[string]*SelectorStats)
+	}
+	st, ok := c.selectors[selector]
+	if !ok {
+		st = &SelectorStats{Selector: selector}
+		c.selectors[selector] = st
+	}
+	if itemType != 0 {
+		st.Type = itemType
+	}
+	st.Hits++
+	st.Bytes += sent
+}
+
+// TopSelectors returns the n most requested selectors, or all of them if n
+// is 0 or less
+func (s *Server) TopSelectors(n int) []*SelectorStats {
+	c := &s.stats
+	c.Lock()
+	list := make(selectorStatsList, 0, len(c.selectors))
+	for _, st := range c.selectors {
+		copied := *st
+		list = append(list, &copied)
+	}
+	c.Unlock()
+	sort.Sort(list)
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// writeSelectorStats saves the counts as `hits bytes type selector' lines
+func (s *Server) writeSelectorStats(w io.Writer) os.Error {
+	for _, st := range s.TopSelectors(0) {
+		if _, err := fmt.Fprintf(w, "%d %d %c %s\n", st.Hits, st.Bytes, st.Type, st.Selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSelectorStats loads counts saved by writeSelectorStats
+func (s *Server) readSelectorStats(r io.Reader) os.Error {
+	reader := bufio.NewReader(r)
+	c := &s.stats
+	c.Lock()
+	defer c.Unlock()
+	if c.selectors == nil {
+		c.selectors = make(map[string]*SelectorStats)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err == os.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Split(strings.TrimRight(line, "\n"), " ", 4)
+		if len(fields) != 4 || len(fields[2]) != 1 {
+			continue
+		}
+		hits, herr := strconv.Atoi64(fields[0])
+		sent, berr := strconv.Atoi64(fields[1])
+		if herr != nil || berr != nil {
+			continue
+		}
+		c.selectors[fields[3]] = &SelectorStats{fields[3], fields[2][0], hits, sent}
+	}
+	return nil
+}
+
+// startStatsFile loads the saved counts if load is set and saves them
+// periodically until stopStatsFile is called. The file is kept open so
+// saving still works after a chroot.
+func (s *Server) startStatsFile(load bool) os.Error {
+	file, err := os.Open(s.StatsFile, os.O_RDWR|os.O_CREAT, 0644)
+	if err != nil {
+		return err
+	}
+	if load {
+		if err = s.readSelectorStats(file); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	interval := s.StatsInterval
+	if interval <= 0 {
+		interval = DefaultStatsInterval
+	}
+	stop := make(chan bool)
+	s.statsStop = stop
+	go func() {
+		ticker := time.NewTicker(int64(interval) * 1e9)
+		defer ticker.Stop()
+		defer file.Close()
+		for {
+			stopped := false
+			select {
+			case <-ticker.C:
+			case <-stop:
+				stopped = true
+			}
+			if err := s.saveSelectorStats(file); err != nil {
+				s.Degrade("stats-file", err)
+				return
+			}
+			if stopped {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// stopStatsFile saves the counts a last time and stops saving them
+func (s *Server) stopStatsFile() {
+	if s.statsStop != nil {
+		close(s.statsStop)
+		s.statsStop = nil
+	}
+}
+
+// saveSelectorStats replaces the contents of file with the counts
+func (s *Server) saveSelectorStats(file *os.File) os.Error {
+	var buf bytes.Buffer
+	s.writeSelectorStats(&buf)
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.Write(buf.Bytes())
+	return err
+}
+
+// ServePopular sends a menu of the most requested selectors
+func (s *Server) ServePopular(ctx *Context) Status {
+	menu := NewMenu(ctx)
+	menu.Info("Most popular documents")
+	menu.Info("")
+	top := s.TopSelectors(20)
+	if len(top) == 0 {
+		menu.Info("Nothing has been requested yet.")
+	}
+	for i, st := range top {
+		itemType := st.Type
+		if itemType == 0 {
+			itemType = '0'
+		}
+		menu.Item(itemType, fmt.Sprintf("%2d. %s (%d hits)", i+1, st.Selector, st.Hits), st.Selector)
+	}
+	menu.WriteTo(ctx)
+	return StatusOK
+}
@@ -0,0 +1,143 @@
+package gopher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// A server started as root to bind port 70 can give up root once its
+// listeners are open: with Chroot set it confines itself to the document
+// root, and with User or Group set it switches to that user and group.
+// Without Group, the user's primary group from /etc/passwd is used, and
+// the supplementary groups are always reduced to that one group. A server
+// already running as that user and group, as one started by Upgrade does,
+// is left as it is. Names are looked up in /etc/passwd and /etc/group
+// before the chroot. Virtual host roots must lie inside the document root
+// when chrooting. A server told to switch user that would still run as
+// root or in group 0 exits instead.
+
+// lookupID returns the numeric id of a user or group given by name or number
+// in a passwd(5) style file
+func lookupID(file string, name string) (int, os.Error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		fields := strings.Split(line, ":", 4)
+		if len(fields) == 4 && fields[0] == name {
+			return strconv.Atoi(fields[2])
+		}
+	}
+	return 0, os.NewError(fmt.Sprintf("no entry for `%s' in %s", name, file))
+}
+
+// lookupUser returns the numeric user id and primary group id of a user
+// given by name or number in a passwd(5) file
+func lookupUser(file string, name string) (uid int, gid int, err os.Error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		fields := strings.Split(line, ":", 5)
+		if len(fields) < 4 || (fields[0] != name && fields[2] != name) {
+			continue
+		}
+		if uid, err = strconv.Atoi(fields[2]); err != nil {
+			return 0, 0, err
+		}
+		if gid, err = strconv.Atoi(fields[3]); err != nil {
+			return 0, 0, err
+		}
+		return uid, gid, nil
+	}
+	return 0, 0, os.NewError(fmt.Sprintf("no entry for `%s' in %s", name, file))
+}
+
+// chrootPath returns where root is found once the server has chrooted into
+// jail
+func chrootPath(jail string, root string) (string, os.Error) {
+	root = path.Clean(root)
+	if root == jail {
+		return "", nil
+	}
+	if !strings.HasPrefix(root, jail+"/") {
+		return "", os.NewError(fmt.Sprintf("`%s' is outside the chroot `%s'", root, jail))
+	}
+	return root[len(jail):], nil
+}
+
+// dropPrivileges chroots and switches user as configured. It is called
+// once the listeners are bound and before the workers start, as on Linux
+// setuid changes only the calling thread and threads started for the
+// workers would keep running as root.
+func (s *Server) dropPrivileges() os.Error {
+	uid, gid := -1, -1
+	var err os.Error
+	if s.User != "" {
+		if uid, gid, err = lookupUser("/etc/passwd", s.User); err != nil {
+			return err
+		}
+	}
+	if s.Group != "" {
+		if gid, err = lookupID("/etc/group", s.Group); err != nil {
+			return err
+		}
+	}
+	if s.Chroot {
+		jail := path.Clean(s.Cwd)
+		hosts := append([]*VirtualHost{s.defaultHost}, s.vhosts...)
+		roots := make([]string, len(hosts))
+		for i, vh := range hosts {
+			if roots[i], err = chrootPath(jail, vh.Root); err != nil {
+				return err
+			}
+		}
+		if errno := syscall.Chroot(jail); errno != 0 {
+			return os.NewSyscallError("chroot", errno)
+		}
+		if errno := syscall.Chdir("/"); errno != 0 {
+			return os.NewSyscallError("chdir", errno)
+		}
+		for i, vh := range hosts {
+			vh.Root = roots[i]
+		}
+		s.Cwd = "/"
+		s.Logger.Printf("chrooted into %s\n", jail)
+	}
+	if (uid == -1 || syscall.Getuid() == uid && syscall.Geteuid() == uid) &&
+		(gid == -1 || syscall.Getgid() == gid && syscall.Getegid() == gid) && syscall.Geteuid() != 0 {
+		// Already switched, as a process started by Upgrade is, and no
+		// longer allowed to call setgroups
+		uid, gid = -1, -1
+	}
+	if gid != -1 {
+		if errno := syscall.Setgroups([]int{gid}); errno != 0 {
+			return os.NewSyscallError("setgroups", errno)
+		}
+		if errno := syscall.Setgid(gid); errno != 0 {
+			return os.NewSyscallError("setgid", errno)
+		}
+	}
+	if uid != -1 {
+		if errno := syscall.Setuid(uid); errno != 0 {
+			return os.NewSyscallError("setuid", errno)
+		}
+	}
+	if uid != -1 || gid != -1 {
+		s.Logger.Printf("running as uid %d, gid %d\n", syscall.Getuid(), syscall.Getgid())
+	}
+	if s.User != "" && (syscall.Getuid() == 0 || syscall.Geteuid() == 0 || syscall.Getgid() == 0 || syscall.Getegid() == 0) {
+		return os.NewError("still running as root or in group 0 after switching to " + s.User)
+	}
+	return nil
+}
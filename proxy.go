@@ -0,0 +1,130 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Behind a TCP load balancer every connection comes from the balancer.
+// With ProxyProtocol set, each connection must start with a PROXY protocol
+// header, version 1 or 2, naming the real client, which is then used for
+// logging, access rules and features. Connections without a valid header
+// are closed, so only enable it when every client is the balancer.
+
+// ProxyHeaderTimeout is how long a connection has to send its PROXY
+// header, in nanoseconds
+var ProxyHeaderTimeout int64 = 5e9
+
+// proxySignature starts a version 2 header
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn is a connection whose client address came from a PROXY header
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr // Nil if the header named no client
+}
+
+func (c *proxyConn) Read(b []byte) (int, os.Error) { return c.reader.Read(b) }
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// readProxyHeader reads the PROXY header conn starts with
+func readProxyHeader(conn net.Conn) (net.Conn, os.Error) {
+	conn.SetReadTimeout(ProxyHeaderTimeout)
+	defer conn.SetReadTimeout(0)
+	c := &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}
+	start, err := c.reader.Peek(len(proxySignature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, proxySignature) {
+		c.remote, err = readProxyV2(c.reader)
+	} else {
+		c.remote, err = readProxyV1(c.reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readProxyV1 reads a text header such as
+//    PROXY TCP4 192.0.2.1 192.0.2.10 56324 70\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, os.Error) {
+	line := make([]byte, 0, 107)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) == cap(line) {
+			return nil, os.NewError("PROXY header too long")
+		}
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, os.NewError("malformed PROXY header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, os.NewError("malformed PROXY header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, os.NewError("malformed PROXY header")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 reads a binary header
+func readProxyV2(r *bufio.Reader) (net.Addr, os.Error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, os.NewError("unsupported PROXY protocol version")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if header[12]&0xf == 0 {
+		// LOCAL: the balancer's own connection, such as a health check
+		return nil, nil
+	}
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, os.NewError("short PROXY header")
+		}
+		return &net.TCPAddr{IP: net.IPv4(body[0], body[1], body[2], body[3]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, os.NewError("short PROXY header")
+		}
+		ip := make(net.IP, 16)
+		copy(ip, body[0:16])
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
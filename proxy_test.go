@@ -0,0 +1,115 @@
+package gopher
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+// checkProxyAddr fails the test unless addr is the TCP address ip:port, or
+// nil if ip is empty
+func checkProxyAddr(t *testing.T, name string, addr net.Addr, ip string, port int) {
+	if ip == "" {
+		if addr != nil {
+			t.Errorf("%s: got %s, want no address", name, addr)
+		}
+		return
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || tcp.IP.String() != ip || tcp.Port != port {
+		t.Errorf("%s: got %v, want %s port %d", name, addr, ip, port)
+	}
+}
+
+func TestReadProxyV1(t *testing.T) {
+	tests := []struct {
+		header string
+		ip     string
+		port   int
+	}{
+		{"PROXY TCP4 192.0.2.1 192.0.2.10 56324 70\r\n", "192.0.2.1", 56324},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 4000 70\r\n", "2001:db8::1", 4000},
+		{"PROXY UNKNOWN\r\n", "", 0},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(test.header + "/selector\r\n"))
+		addr, err := readProxyV1(r)
+		if err != nil {
+			t.Errorf("%q: %s", test.header, err)
+			continue
+		}
+		checkProxyAddr(t, test.header, addr, test.ip, test.port)
+		// The request after the header is left to read
+		if rest, _ := r.ReadString('\n'); rest != "/selector\r\n" {
+			t.Errorf("%q: left %q to read", test.header, rest)
+		}
+	}
+	bad := []string{
+		"PROXY TCP4 192.0.2.1 192.0.2.10 56324 70\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.10 56324\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.10 99999 70\r\n",
+		"PROXY TCP4 nowhere 192.0.2.10 56324 70\r\n",
+		"PROXY UDP4 192.0.2.1 192.0.2.10 56324 70\r\n",
+		"HELLO TCP4 192.0.2.1 192.0.2.10 56324 70\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n",
+		"PROXY TCP4 192.0.2.1",
+	}
+	for _, header := range bad {
+		if addr, err := readProxyV1(bufio.NewReader(strings.NewReader(header))); err == nil {
+			t.Errorf("%.40q: got %v, want an error", header, addr)
+		}
+	}
+}
+
+// proxyV2 builds a version 2 header with the given command, family and
+// address block
+func proxyV2(command byte, family byte, body []byte) []byte {
+	header := append([]byte{}, proxySignature...)
+	header = append(header, 0x20|command, family, byte(len(body)>>8), byte(len(body)))
+	return append(header, body...)
+}
+
+func TestReadProxyV2(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 192, 0, 2, 10, 0xdc, 0x04, 0, 70}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::1"))
+	ipv6[32], ipv6[33] = 0x0f, 0xa0
+	tests := []struct {
+		name   string
+		header []byte
+		ip     string
+		port   int
+	}{
+		{"TCP4", proxyV2(1, 0x11, ipv4), "192.0.2.1", 56324},
+		{"TCP6", proxyV2(1, 0x21, ipv6), "2001:db8::1", 4000},
+		{"LOCAL", proxyV2(0, 0x11, ipv4), "", 0},
+		{"UNSPEC", proxyV2(1, 0x00, nil), "", 0},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(bytes.NewBuffer(append(test.header, []byte("/selector\r\n")...)))
+		addr, err := readProxyV2(r)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		checkProxyAddr(t, test.name, addr, test.ip, test.port)
+		if rest, _ := r.ReadString('\n'); rest != "/selector\r\n" {
+			t.Errorf("%s: left %q to read", test.name, rest)
+		}
+	}
+	version1 := proxyV2(1, 0x11, ipv4)
+	version1[12] = 0x11
+	bad := map[string][]byte{
+		"version 1":        version1,
+		"short TCP4 block": proxyV2(1, 0x11, ipv4[:8]),
+		"short TCP6 block": proxyV2(1, 0x21, ipv6[:20]),
+		"truncated":        proxyV2(1, 0x11, ipv4)[:20],
+	}
+	for name, header := range bad {
+		if addr, err := readProxyV2(bufio.NewReader(bytes.NewBuffer(header))); err == nil {
+			t.Errorf("%s: got %v, want an error", name, addr)
+		}
+	}
+}
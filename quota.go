@@ -0,0 +1,58 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// On a small host an accidental multi-gigabyte transfer can use up the
+// month's bandwidth. MaxFileSize refuses files larger than it with an
+// error item saying so, and MaxResponseSize cuts off any response, such
+// as a generated archive, once that many bytes have been sent on one
+// connection. Sizes in the configuration file may end in K, M or G.
+
+// ErrResponseTooLarge is the error of a write past MaxResponseSize
+var ErrResponseTooLarge = os.NewError("response exceeds the maximum response size")
+
+// parseSize parses a byte count with an optional K, M or G suffix
+func parseSize(s string) (int64, os.Error) {
+	digits, mult := s, int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"), strings.HasSuffix(s, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"), strings.HasSuffix(s, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"), strings.HasSuffix(s, "g"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		digits = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi64(digits)
+	if err != nil || n < 0 {
+		return 0, os.NewError(fmt.Sprintf("bad size `%s'", s))
+	}
+	return n * mult, nil
+}
+
+// configSize reads a single size argument
+func configSize(args []string) (int64, os.Error) {
+	arg, err := configString(args)
+	if err != nil {
+		return 0, err
+	}
+	return parseSize(arg)
+}
+
+// tooLarge reports whether a file is over MaxFileSize
+func (s *Server) tooLarge(info *os.FileInfo) bool {
+	return s.MaxFileSize > 0 && info.IsRegular() && info.Size > s.MaxFileSize
+}
+
+// refuseTooLarge tells the client a file is too large to be sent
+func (s *Server) refuseTooLarge(ctx *Context, info *os.FileInfo) (Status, os.Error) {
+	ctx.Error(fmt.Sprintf("Sorry, this file is too large to download from this server (%d bytes, the limit is %d)", info.Size, s.MaxFileSize))
+	return StatusDenied, os.NewError(fmt.Sprintf("file of %d bytes is over the maximum file size", info.Size))
+}
@@ -0,0 +1,27 @@
+package gopher
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	good := map[string]int64{
+		"0":     0,
+		"512":   512,
+		"4k":    4 << 10,
+		"4K":    4 << 10,
+		"10M":   10 << 20,
+		"10m":   10 << 20,
+		"2G":    2 << 30,
+		"2g":    2 << 30,
+		"1024K": 1 << 20,
+	}
+	for s, want := range good {
+		if got, err := parseSize(s); err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "K", "-1", "-1M", "1T", "1.5M", "10 M", "ten"} {
+		if got, err := parseSize(s); err == nil {
+			t.Errorf("%q: got %d, want an error", s, got)
+		}
+	}
+}
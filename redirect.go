@@ -0,0 +1,98 @@
+package gopher
+
+import (
+	"os"
+	"strings"
+)
+
+// Moved content is kept reachable with
+//    alias /old /new
+// which serves /new under the old selector, and
+//    redirect /old /new|gopher://host/1/new [message...]
+// which answers with a menu pointing at the new location. Both also cover
+// the selectors below the old one, so /old/a.txt becomes /new/a.txt.
+
+// Redirect maps an old selector to where its content is now
+type Redirect struct {
+	From    string
+	To      string // Selector on this server; empty if URL is set
+	URL     *URL   // Location on another server
+	Message string // Shown above the link to the new location
+	Alias   bool   // Serve the new location instead of pointing at it
+}
+
+// configureRedirect handles alias and redirect lines
+func (s *Server) configureRedirect(alias bool, args []string) os.Error {
+	if len(args) < 2 || (alias && len(args) != 2) {
+		return os.NewError("expected an old selector and a new location")
+	}
+	r := &Redirect{From: "/" + strings.Trim(args[0], "/"), Alias: alias, Message: strings.Join(args[2:], " ")}
+	if strings.HasPrefix(args[1], "gopher://") {
+		if alias {
+			return os.NewError("aliases must point at a selector on this server")
+		}
+		u, err := ParseURL(args[1])
+		if err != nil {
+			return err
+		}
+		r.URL = u
+	} else {
+		r.To = "/" + strings.Trim(args[1], "/")
+	}
+	s.redirects = append(s.redirects, r)
+	return nil
+}
+
+// redirectFor returns the redirect covering selector and the selector's
+// path below the redirect's old selector
+func (s *Server) redirectFor(selector string) (r *Redirect, rest string) {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, r := range s.redirects {
+		if selector == r.From {
+			return r, ""
+		}
+		if strings.HasPrefix(selector, strings.TrimRight(r.From, "/")+"/") {
+			return r, selector[len(strings.TrimRight(r.From, "/")):]
+		}
+	}
+	return nil, ""
+}
+
+// target returns the new selector of a local redirect
+func (r *Redirect) target(rest string) string {
+	if r.To == "/" && rest != "" {
+		return rest
+	}
+	return r.To + rest
+}
+
+// serveRedirect answers with a menu pointing at the new location
+func (s *Server) serveRedirect(ctx *Context, r *Redirect, rest string) Status {
+	w := NewEntryWriter(ctx)
+	if r.Message != "" {
+		w.Info(r.Message)
+	} else {
+		w.Info("This item has moved.")
+	}
+	w.Info("")
+	if r.URL != nil {
+		u := *r.URL
+		u.Selector += rest
+		w.Write(&MenuEntry{Type: u.Type, Display: u.String(), Selector: u.Selector, Host: u.Host, Port: u.Port})
+	} else {
+		to := r.target(rest)
+		w.Item(s.selectorType(ctx, to), to, to)
+	}
+	w.Close()
+	ctx.Logf("Redirected `%s'\n", ctx.Request)
+	return StatusOK
+}
+
+// selectorType guesses the item type of a selector on this server
+func (s *Server) selectorType(ctx *Context, selector string) byte {
+	if info, err := s.statFile(ctx.Host.Root + selector); err == nil {
+		return s.itemType(info)
+	}
+	return '1'
+}
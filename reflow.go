@@ -0,0 +1,95 @@
+package gopher
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// With ReflowWidth set, text files are sent with their long lines wrapped
+// at that column, for clients on narrow terminals. Lines that look
+// preformatted are left alone: those indented by a tab or four spaces,
+// those with wide gaps between words, as in tables and drawings, and
+// everything between ``` or ~~~ fence lines. A wrapped line keeps its
+// indentation on every line it becomes.
+
+// DefaultReflowWidth is the classic column text is wrapped at
+const DefaultReflowWidth = 70
+
+// reflowWriter wraps the long lines of text written through it
+type reflowWriter struct {
+	w       io.Writer
+	width   int
+	partial []byte // Start of a line not yet ended
+	fenced  bool   // Inside a fenced block
+}
+
+// newReflowWriter returns a writer wrapping lines at width and writing
+// them to w
+func newReflowWriter(w io.Writer, width int) *reflowWriter {
+	return &reflowWriter{w: w, width: width}
+}
+
+func (rw *reflowWriter) Write(p []byte) (int, os.Error) {
+	data := append(rw.partial, p...)
+	rw.partial = nil
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			break
+		}
+		if err := rw.writeLine(string(data[:i+1])); err != nil {
+			return 0, err
+		}
+		data = data[i+1:]
+	}
+	rw.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Flush sends a last line that had no line break
+func (rw *reflowWriter) Flush() os.Error {
+	if len(rw.partial) == 0 {
+		return nil
+	}
+	line := string(rw.partial)
+	rw.partial = nil
+	return rw.writeLine(line)
+}
+
+// preformatted reports whether a line is to be sent as it is
+func preformatted(line string) bool {
+	if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    ") {
+		return true
+	}
+	return strings.Index(strings.TrimSpace(line), "   ") != -1
+}
+
+// writeLine sends one line, ending included, wrapped if it is too long
+func (rw *reflowWriter) writeLine(line string) os.Error {
+	text := strings.TrimRight(line, "\r\n")
+	end := line[len(text):]
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+		rw.fenced = !rw.fenced
+	}
+	if rw.fenced || len([]int(text)) <= rw.width || preformatted(text) || trimmed == "```" || trimmed == "~~~" {
+		_, err := io.WriteString(rw.w, line)
+		return err
+	}
+	indent := text[:len(text)-len(strings.TrimLeft(text, " "))]
+	lines := wrapWords(trimmed, rw.width, indent, indent)
+	for i, l := range lines {
+		if i < len(lines)-1 && end == "" {
+			// The last line of a file without a final line break
+			l += "\r\n"
+		} else {
+			l += end
+		}
+		if _, err := io.WriteString(rw.w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package gopher
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReflowWriter(t *testing.T) {
+	writes := []string{
+		"short line\r\none two three",
+		" four five six seven\r\n",
+		"  alpha beta gamma delta epsilon\n",
+		"    code code code code code code\n",
+		"a   b   c long long long long\n",
+		"```\nlong line inside the fence stays\n```\n",
+		"tail words that go past twenty",
+	}
+	want := "short line\r\n" +
+		"one two three four\r\n" +
+		"five six seven\r\n" +
+		"  alpha beta gamma\n" +
+		"  delta epsilon\n" +
+		"    code code code code code code\n" +
+		"a   b   c long long long long\n" +
+		"```\n" +
+		"long line inside the fence stays\n" +
+		"```\n"
+	var b bytes.Buffer
+	rw := newReflowWriter(&b, 20)
+	for _, s := range writes {
+		if n, err := rw.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("writing %q: got %d, %v", s, n, err)
+		}
+	}
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want += "tail words that go\r\npast twenty"
+	if got := b.String(); got != want {
+		t.Errorf("after Flush got:\n%s\nwant:\n%s", got, want)
+	}
+}
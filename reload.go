@@ -0,0 +1,166 @@
+package gopher
+
+import (
+	"os"
+)
+
+// Reload reads the configuration file the server was loaded from again and
+// swaps the new settings in. Log files are reopened. override, if not nil,
+// is applied to the new settings before they take effect, e.g. to reapply
+// command line flags.
+//
+// Connections being answered are not dropped. Settings tied to listeners
+// or to the process (hostname, port, TLS, metrics, control socket, chroot,
+// user and group) only change on restart.
+func (s *Server) Reload(override func(*Server) os.Error) os.Error {
+	if s.configFile == "" {
+		return os.NewError("no configuration file to reload")
+	}
+	if s.Chroot {
+		return os.NewError("cannot reload from inside a chroot; restart instead")
+	}
+	fresh := NewServer()
+	if err := fresh.LoadConfig(s.configFile); err != nil {
+		return err
+	}
+	if override != nil {
+		if err := override(fresh); err != nil {
+			return err
+		}
+	}
+	fresh.init()
+	s.reloadMu.RLock()
+	switched := fresh.StagedRoot != "" && s.Cwd == fresh.StagedRoot && s.StagedRoot == fresh.Cwd
+	s.reloadMu.RUnlock()
+	if switched {
+		// Keep the roots the way SwitchRoots left them
+		fresh.switchRoots()
+	}
+	// Drop box directories are checked before anything is swapped in, so
+	// that a reload cannot lose one the failure policy requires
+	dropboxErr := fresh.dropboxError()
+	if dropboxErr != nil && s.subsystems.policy("dropbox") == PolicyFatal {
+		return dropboxErr
+	}
+
+	s.reloadMu.Lock()
+	s.Cwd = fresh.Cwd
+	s.StagedRoot = fresh.StagedRoot
+	s.Admin = fresh.Admin
+	s.CaseInsensitive = fresh.CaseInsensitive
+	s.Charset = fresh.Charset
+	s.Dupes = fresh.Dupes
+	s.DupeChecksums = fresh.DupeChecksums
+	s.AcceptURLs = fresh.AcceptURLs
+	s.ProxyProtocol = fresh.ProxyProtocol
+	s.Trace = fresh.Trace
+	s.LogLevel = fresh.LogLevel
+	s.Archives = fresh.Archives
+	s.ForbidTelnet = fresh.ForbidTelnet
+	s.ProxyBalance = fresh.ProxyBalance
+	s.MaxFileSize = fresh.MaxFileSize
+	s.MaxResponseSize = fresh.MaxResponseSize
+	s.HandlerTimeout = fresh.HandlerTimeout
+	s.FileCacheSize = fresh.FileCacheSize
+	s.FileCacheMaxFile = fresh.FileCacheMaxFile
+	s.FingerHomes = fresh.FingerHomes
+	s.Compress = fresh.Compress
+	s.CompressMinSize = fresh.CompressMinSize
+	s.CompressTypes = fresh.CompressTypes
+	s.IndexMode = fresh.IndexMode
+	s.IndexFiles = fresh.IndexFiles
+	s.MarkdownMode = fresh.MarkdownMode
+	s.MarkdownWidth = fresh.MarkdownWidth
+	s.ReflowWidth = fresh.ReflowWidth
+	s.KeepAlive = fresh.KeepAlive
+	s.MaintenanceFile = fresh.MaintenanceFile
+	s.MaintenanceMessage = fresh.MaintenanceMessage
+	s.ItemTypes = fresh.ItemTypes
+	s.Thumbnails = fresh.Thumbnails
+	s.ThumbnailSize = fresh.ThumbnailSize
+	s.JournalDir = fresh.JournalDir
+	s.JournalMaxSize = fresh.JournalMaxSize
+	statsFile, statsInterval := s.StatsFile, s.StatsInterval
+	s.StatsFile = fresh.StatsFile
+	s.StatsInterval = fresh.StatsInterval
+	s.StatsSelector = fresh.StatsSelector
+	vhosts, logAreas, mounts := s.vhosts, s.logAreas, s.mounts
+	s.vhosts = fresh.vhosts
+	s.defaultHost = fresh.defaultHost
+	s.mirrors = fresh.mirrors
+	s.commands = fresh.commands
+	s.acl = fresh.acl
+	s.protected = fresh.protected
+	s.AdminSelector = fresh.AdminSelector
+	s.phlogs = fresh.phlogs
+	s.guestbooks = fresh.guestbooks
+	s.dropboxes = fresh.dropboxes
+	s.ccso = fresh.ccso
+	s.sqlite = fresh.sqlite
+	s.proxies = fresh.proxies
+	s.AdvertiseHost = fresh.AdvertiseHost
+	s.AdvertisePort = fresh.AdvertisePort
+	s.advertised = fresh.advertised
+	s.redirects = fresh.redirects
+	s.rewrites = fresh.rewrites
+	s.logAreas = fresh.logAreas
+	s.DenyMessage = fresh.DenyMessage
+	s.Banner = fresh.Banner
+	s.BannerFile = fresh.BannerFile
+	s.MotdFile = fresh.MotdFile
+	s.mounts = fresh.mounts
+	for _, m := range mounts {
+		if !m.configured {
+			s.mounts = append(s.mounts, m)
+		}
+	}
+	s.reloadMu.Unlock()
+
+	s.missing.clear()
+	switch {
+	case dropboxErr != nil:
+		s.Degrade("dropbox", dropboxErr)
+	case len(fresh.dropboxes) > 0:
+		s.Recover("dropbox")
+	}
+
+	fresh.features.RLock()
+	features := fresh.features.features
+	fresh.features.RUnlock()
+	s.features.Lock()
+	s.features.features = features
+	s.features.Unlock()
+
+	for _, sink := range s.Logger.swapSinks(fresh.Logger.sinks) {
+		if f, ok := sink.(*FileSink); ok {
+			f.Close()
+		}
+	}
+	for _, vh := range vhosts {
+		closeLogger(vh.logger)
+	}
+	for _, a := range logAreas {
+		closeLogger(a.Logger)
+	}
+	for _, m := range mounts {
+		if m.configured && m.closer != nil {
+			m.closer.Close()
+		}
+	}
+	if s.StatsFile != statsFile || s.StatsInterval != statsInterval {
+		// The counts kept so far carry over to the new file
+		s.stopStatsFile()
+		if s.StatsFile != "" {
+			if err := s.startStatsFile(false); err != nil {
+				s.Degrade("stats-file", err)
+			} else {
+				s.Recover("stats-file")
+			}
+		}
+	}
+	if fresh.Hostname != s.Hostname || fresh.Port != s.Port || fresh.TLSCert != s.TLSCert || fresh.MetricsAddr != s.MetricsAddr || fresh.ControlSocket != s.ControlSocket || fresh.UnixSocket != s.UnixSocket || fresh.WatchFiles != s.WatchFiles || fresh.FingerAddr != s.FingerAddr || fresh.GeminiAddr != s.GeminiAddr || fresh.Workers != s.Workers || fresh.QueueLength != s.QueueLength || fresh.ReusePort != s.ReusePort || fresh.Shards != s.Shards {
+		s.Logger.Printf("Some changed settings only take effect on restart\n")
+	}
+	s.Logger.Printf("Reloaded %s\n", s.configFile)
+	return nil
+}
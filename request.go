@@ -0,0 +1,90 @@
+package gopher
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+)
+
+// A request is a single line: the selector, optionally followed by a tab
+// and a search string or Gopher+ data, ended by <CR><LF> or a bare <LF>.
+// Gopher+ clients send `selector<TAB>+', `selector<TAB>!' or
+// `selector<TAB>$' for items and `selector<TAB>query<TAB>+' for searches.
+// Requests holding NULs or other control characters, longer than the
+// limits below, or not ended within RequestTimeout are rejected.
+
+// MaxRequestLength is the longest request line accepted, in bytes
+var MaxRequestLength = 4096
+
+// MaxSelectorLength is the longest selector accepted, in bytes
+var MaxSelectorLength = 1024
+
+// RequestTimeout is how long a client has to send its request line, in
+// nanoseconds
+var RequestTimeout int64 = 30e9
+
+// readRequest reads the request line from reader, which reads from conn
+func readRequest(conn net.Conn, reader *bufio.Reader) (string, os.Error) {
+	conn.SetReadTimeout(RequestTimeout)
+	defer conn.SetReadTimeout(0)
+	buf := make([]byte, 0, 128)
+	for {
+		c, err := reader.ReadByte()
+		if err == os.EOF {
+			return "", os.NewError("connection closed before the end of the request")
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == '\n' {
+			break
+		}
+		if len(buf) == MaxRequestLength {
+			return "", os.NewError("request too long")
+		}
+		buf = append(buf, c)
+	}
+	if len(buf) > 0 && buf[len(buf)-1] == '\r' {
+		buf = buf[:len(buf)-1]
+	}
+	for _, c := range buf {
+		switch {
+		case c == 0:
+			return "", os.NewError("NUL in request")
+		case (c < ' ' && c != '\t') || c == 0x7f:
+			return "", os.NewError("control character in request")
+		}
+	}
+	request := string(buf)
+	selector := request
+	if i := strings.Index(request, "\t"); i != -1 {
+		selector = request[:i]
+	}
+	if len(selector) > MaxSelectorLength {
+		return "", os.NewError("selector too long")
+	}
+	return request, nil
+}
+
+// splitRequest splits a request line into its selector, search string and
+// Gopher+ part
+func splitRequest(request string) (selector string, query string, plus string) {
+	fields := strings.Split(request, "\t", 3)
+	selector = fields[0]
+	switch {
+	case len(fields) == 3:
+		query, plus = fields[1], fields[2]
+	case len(fields) == 2 && isGopherPlus(fields[1]):
+		plus = fields[1]
+	case len(fields) == 2:
+		query = fields[1]
+	}
+	return
+}
+
+// isGopherPlus reports whether what followed the selector is a Gopher+
+// item, attribute or directory request rather than a search string
+func isGopherPlus(s string) bool {
+	return s != "" && (s[0] == '+' || s[0] == '!' || s[0] == '$')
+}
@@ -0,0 +1,56 @@
+package gopher
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// requestLine has readRequest read input sent by a client
+func requestLine(input string) (string, os.Error) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write([]byte(input))
+		client.Close()
+	}()
+	return readRequest(server, bufio.NewReader(server))
+}
+
+func TestReadRequest(t *testing.T) {
+	long := strings.Repeat("a", MaxSelectorLength)
+	tests := []struct {
+		input   string
+		request string
+	}{
+		{"/about.txt\r\n", "/about.txt"},
+		{"/about.txt\n", "/about.txt"},
+		{"\r\n", ""},
+		{"/search\tgopher servers\r\n", "/search\tgopher servers"},
+		{"/item\t+\r\n", "/item\t+"},
+		// Only the selector counts towards MaxSelectorLength
+		{long + "\t" + strings.Repeat("q", 100) + "\r\n", long + "\t" + strings.Repeat("q", 100)},
+	}
+	for _, test := range tests {
+		request, err := requestLine(test.input)
+		if err != nil || request != test.request {
+			t.Errorf("%q: got %q, %v, want %q", test.input, request, err, test.request)
+		}
+	}
+	bad := []string{
+		"",
+		"/no/line/end",
+		"/nul\x00\r\n",
+		"/bell\a\r\n",
+		"/del\x7f\r\n",
+		long + "a\r\n",
+		strings.Repeat("a", MaxRequestLength+1) + "\r\n",
+	}
+	for _, input := range bad {
+		if request, err := requestLine(input); err == nil {
+			t.Errorf("%.40q: got %q, want an error", input, request)
+		}
+	}
+}
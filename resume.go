@@ -0,0 +1,46 @@
+package gopher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// An interrupted download is resumed by asking for the rest of the file
+// from a byte offset, either as a parameter of a Gopher+ view
+//    selector<TAB>+application/octet-stream;offset=N
+// or on its own
+//    selector<TAB>+offset=N
+// The reply is a Gopher+ header giving the number of bytes that follow,
+// then the file from offset N on, sent as it is on disk.
+
+// resumeOffset returns the offset the Gopher+ part of a request asks to
+// resume from, if it asks to
+func resumeOffset(plus string) (int64, bool) {
+	value, ok := plusParam(plus, "offset")
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.Atoi64(value)
+	return offset, err == nil && offset >= 0
+}
+
+// ServeResumed sends the rest of a file from offset as a Gopher+ reply
+func (s *Server) ServeResumed(ctx *Context, file *os.File, info *os.FileInfo, offset int64) (Status, os.Error) {
+	if offset > info.Size {
+		return StatusBadRequest, os.NewError(fmt.Sprintf("offset %d beyond the end of the file", offset))
+	}
+	if _, err := file.Seek(offset, 0); err != nil {
+		return StatusError, err
+	}
+	ctx.itemType = '9'
+	if _, err := ctx.Write(fmt.Sprintf("+%d", info.Size-offset)); err != nil {
+		return StatusError, err
+	}
+	if _, err := io.Copyn(ctx.conn, file, info.Size-offset); err != nil {
+		return StatusError, err
+	}
+	ctx.Logf("Resumed `%s' from byte %d\n", ctx.Request, offset)
+	return StatusOK, nil
+}
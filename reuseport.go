@@ -0,0 +1,36 @@
+package gopher
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+)
+
+// With ReusePort set, Run opens Shards sockets on its address with
+// SO_REUSEPORT and accepts on all of them at once, and the kernel spreads
+// new connections among them, for archives too busy for one accept loop.
+// Other gopherd processes started with ReusePort can listen on the same
+// address as well, sharing its load across processes. This needs Linux
+// 3.9 or later.
+
+// listenShards opens the sockets Run serves with ReusePort set
+func (s *Server) listenShards() ([]net.Listener, os.Error) {
+	n := s.Shards
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	addr := fmt.Sprintf("%s:%d", s.Hostname, s.Port)
+	shards := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := listenReusePort(addr)
+		if err != nil {
+			for _, shard := range shards {
+				shard.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, l)
+	}
+	return shards, nil
+}
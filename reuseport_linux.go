@@ -0,0 +1,55 @@
+package gopher
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// soReusePort is SO_REUSEPORT, which the syscall package does not define
+const soReusePort = 0xf
+
+// listenReusePort listens on the TCP address addr with SO_REUSEPORT set,
+// so that other sockets, in this process or another, can listen on the
+// same address and the kernel spreads connections among them
+func listenReusePort(addr string) (net.Listener, os.Error) {
+	a, err := net.ResolveTCPAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	family := syscall.AF_INET
+	var sa syscall.Sockaddr
+	if ip4 := a.IP.To4(); ip4 != nil || a.IP == nil {
+		sa4 := &syscall.SockaddrInet4{Port: a.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		family = syscall.AF_INET6
+		sa6 := &syscall.SockaddrInet6{Port: a.Port}
+		copy(sa6.Addr[:], a.IP)
+		sa = sa6
+	}
+	fd, errno := syscall.Socket(family, syscall.SOCK_STREAM, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("socket", errno)
+	}
+	syscall.CloseOnExec(fd)
+	fail := func(call string, errno int) (net.Listener, os.Error) {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError(call, errno)
+	}
+	for _, opt := range []int{syscall.SO_REUSEADDR, soReusePort} {
+		if errno = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, opt, 1); errno != 0 {
+			return fail("setsockopt", errno)
+		}
+	}
+	if errno = syscall.Bind(fd, sa); errno != 0 {
+		return fail("bind", errno)
+	}
+	if errno = syscall.Listen(fd, syscall.SOMAXCONN); errno != 0 {
+		return fail("listen", errno)
+	}
+	file := os.NewFile(fd, "reuseport")
+	defer file.Close()
+	return net.FileListener(file)
+}
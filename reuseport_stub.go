@@ -0,0 +1,11 @@
+package gopher
+
+import (
+	"net"
+	"os"
+)
+
+// listenReusePort fails where SO_REUSEPORT does not spread connections
+func listenReusePort(addr string) (net.Listener, os.Error) {
+	return nil, os.NewError("SO_REUSEPORT is not supported on this system")
+}
@@ -0,0 +1,86 @@
+package gopher
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rewrite rules change the selectors clients ask for before anything else
+// looks at them, for clean selectors in front of dynamic handlers or to
+// keep an old selector scheme working:
+//    rewrite ^/u/([^/]+)/?$ /users/$1/index
+//    rewrite ^/old/(.*) /new/$1 last
+// Rules are tried in order, each on the result of the ones before, and a
+// rule marked last ends the rewriting when it matches. $1 to $9 in the
+// replacement stand for the pattern's groups, $0 for the whole match and
+// $$ for a dollar sign.
+
+// RewriteRule replaces selectors matching Pattern with Replacement
+type RewriteRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	Last        bool // Stop rewriting after this rule if it matches
+}
+
+// AddRewrite adds a rewrite rule after the existing ones
+func (s *Server) AddRewrite(pattern string, replacement string, last bool) os.Error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	s.rewrites = append(s.rewrites, &RewriteRule{re, replacement, last})
+	return nil
+}
+
+// configureRewrite handles a `rewrite pattern replacement [last]' line
+func (s *Server) configureRewrite(args []string) os.Error {
+	if len(args) < 2 || len(args) > 3 || len(args) == 3 && args[2] != "last" {
+		return os.NewError("expected a pattern, a replacement and optionally `last'")
+	}
+	return s.AddRewrite(args[0], args[1], len(args) == 3)
+}
+
+// expand substitutes the groups of a match into the replacement
+func (r *RewriteRule) expand(groups []string) string {
+	var b []byte
+	for i := 0; i < len(r.Replacement); i++ {
+		c := r.Replacement[i]
+		if c != '$' || i+1 == len(r.Replacement) {
+			b = append(b, c)
+			continue
+		}
+		next := r.Replacement[i+1]
+		switch {
+		case next == '$':
+			b = append(b, '$')
+			i++
+		case next >= '0' && next <= '9':
+			if n := int(next - '0'); n < len(groups) {
+				b = append(b, []byte(groups[n])...)
+			}
+			i++
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// rewrite applies the rewrite rules to a selector
+func (s *Server) rewrite(selector string) string {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, r := range s.rewrites {
+		groups := r.Pattern.FindStringSubmatch(selector)
+		if groups == nil {
+			continue
+		}
+		selector = "/" + strings.Trim(path.Clean("/"+r.expand(groups)), "/")
+		if r.Last {
+			break
+		}
+	}
+	return selector
+}
@@ -0,0 +1,46 @@
+package gopher
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRewriteExpand(t *testing.T) {
+	groups := []string{"/old/2011/post", "2011", "post"}
+	tests := map[string]string{
+		"/new/$1/$2":  "/new/2011/post",
+		"/all$0":      "/all/old/2011/post",
+		"/price$$1":   "/price$1",
+		"/missing/$7": "/missing/",
+		"/literal$x":  "/literal$x",
+		"/trailing$":  "/trailing$",
+		"/plain":      "/plain",
+		"$2-$1-$2":    "post-2011-post",
+	}
+	for replacement, want := range tests {
+		r := &RewriteRule{regexp.MustCompile("."), replacement, false}
+		if got := r.expand(groups); got != want {
+			t.Errorf("%q: got %q, want %q", replacement, got, want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	s := new(Server)
+	s.AddRewrite(`^/old/([0-9]+)/(.*)$`, "/phlog/$1/$2", false)
+	s.AddRewrite(`^/phlog/2010/`, "/archive/", true)
+	s.AddRewrite(`^/archive/`, "/never/", false)
+	s.AddRewrite(`^/up/(.*)$`, "/../../$1", false)
+	tests := map[string]string{
+		"/old/2011/post": "/phlog/2011/post",
+		"/old/2010/post": "/archive",
+		"/phlog/2010/x":  "/archive",
+		"/about":         "/about",
+		"/up/etc/passwd": "/etc/passwd",
+	}
+	for selector, want := range tests {
+		if got := s.rewrite(selector); got != want {
+			t.Errorf("%q: got %q, want %q", selector, got, want)
+		}
+	}
+}
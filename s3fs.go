@@ -0,0 +1,241 @@
+package gopher
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"fmt"
+	"http"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"xml"
+)
+
+// Large archives can live in an S3 compatible bucket instead of on disk:
+//    s3 /srv/gopher/archive https://s3.example.com gopher-archive /etc/gopher/s3.key
+// serves the objects of the bucket gopher-archive as the files below
+// /archive, with the slashes in object keys as directories, so listing a
+// prefix of the bucket makes a menu. The optional credentials file holds
+// one access-key-id:secret line; without it the bucket is read anonymously.
+// Listings are cached for S3ListingTTL, so most lookups cost no request;
+// object contents are fetched as they are served. Keep an empty directory
+// where the bucket is mounted so it shows up in the listing above.
+
+// S3ListingTTL is how long the listing of a bucket prefix is cached, in
+// nanoseconds
+var S3ListingTTL int64 = 60e9
+
+// S3FS is a FileSystem reading the objects of an S3 bucket
+type S3FS struct {
+	Endpoint  string // Base URL of the service, such as https://s3.amazonaws.com
+	Bucket    string
+	AccessKey string // Credentials to sign requests with, if any
+	Secret    string
+	sync.Mutex
+	listings map[string]*s3Listing // Cached listings by directory
+}
+
+// s3Listing is the cached listing of a directory of a bucket
+type s3Listing struct {
+	entries []os.FileInfo
+	fetched int64
+}
+
+// s3ListResult is the reply to a ListObjects request
+type s3ListResult struct {
+	IsTruncated    bool
+	NextMarker     string
+	Contents       []s3Object
+	CommonPrefixes []s3Prefix
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	Size         int64
+}
+
+type s3Prefix struct {
+	Prefix string
+}
+
+// NewS3FS returns an S3FS for bucket on the service at endpoint
+func NewS3FS(endpoint string, bucket string, accessKey string, secret string) *S3FS {
+	return &S3FS{Endpoint: strings.TrimRight(endpoint, "/"), Bucket: bucket, AccessKey: accessKey, Secret: secret}
+}
+
+// configureS3 handles an `s3 dir endpoint bucket [credentials-file]' line
+func (s *Server) configureS3(args []string) os.Error {
+	if len(args) != 3 && len(args) != 4 {
+		return os.NewError("expected a directory, an endpoint URL, a bucket and optionally a credentials file")
+	}
+	var accessKey, secret string
+	if len(args) == 4 {
+		data, err := ioutil.ReadFile(args[3])
+		if err != nil {
+			return err
+		}
+		fields := strings.Split(strings.TrimSpace(string(data)), ":", 2)
+		if len(fields) != 2 {
+			return os.NewError(args[3] + ": expected an access-key-id:secret line")
+		}
+		accessKey, secret = fields[0], fields[1]
+	}
+	s.mounts = append(s.mounts, &mount{path.Clean(args[0]), NewS3FS(args[1], args[2], accessKey, secret), nil, true})
+	return nil
+}
+
+// s3Escape escapes an object key for a URL path
+func s3Escape(key string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexRune("-_.~/", int(c)) != -1 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// get sends a signed GET request for key with the query appended
+func (fs *S3FS) get(key string, query string) (*http.Response, os.Error) {
+	url := fs.Endpoint + "/" + fs.Bucket + "/" + s3Escape(key)
+	if query != "" {
+		url += "?" + query
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	date := time.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	req.Header.Set("Date", date)
+	if fs.AccessKey != "" {
+		mac := hmac.NewSHA1([]byte(fs.Secret))
+		fmt.Fprintf(mac, "GET\n\n\n%s\n/%s/%s", date, fs.Bucket, s3Escape(key))
+		req.Header.Set("Authorization", "AWS "+fs.AccessKey+":"+base64.StdEncoding.EncodeToString(mac.Sum()))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, os.ENOENT
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, os.NewError(fmt.Sprintf("%s: %s", url, resp.Status))
+	}
+	return resp, nil
+}
+
+// list returns the entries of the directory at name, from the cache if it
+// is recent enough
+func (fs *S3FS) list(name string) ([]os.FileInfo, os.Error) {
+	fs.Lock()
+	l, found := fs.listings[name]
+	fs.Unlock()
+	if found && time.Nanoseconds()-l.fetched < S3ListingTTL {
+		return l.entries, nil
+	}
+	prefix := strings.TrimLeft(name, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	var entries []os.FileInfo
+	marker := ""
+	for {
+		resp, err := fs.get("", "delimiter=%2F&prefix="+http.URLEscape(prefix)+"&marker="+http.URLEscape(marker))
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.Unmarshal(resp.Body, &result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range result.CommonPrefixes {
+			entries = append(entries, memInfo(strings.TrimRight(p.Prefix, "/"), syscall.S_IFDIR|0755, 0, 0))
+		}
+		for _, o := range result.Contents {
+			if o.Key == prefix {
+				// The marker object some tools create for a directory
+				continue
+			}
+			mtime := int64(0)
+			if t, err := time.Parse("2006-01-02T15:04:05.000Z", o.LastModified); err == nil {
+				mtime = t.Seconds() * 1e9
+			}
+			entries = append(entries, memInfo(o.Key, syscall.S_IFREG|0644, o.Size, mtime))
+			marker = o.Key
+		}
+		if !result.IsTruncated {
+			break
+		}
+		if result.NextMarker != "" {
+			marker = result.NextMarker
+		}
+	}
+	if len(entries) == 0 && prefix != "" {
+		return nil, os.ENOENT
+	}
+	fs.Lock()
+	if fs.listings == nil || len(fs.listings) >= MaxCachedSidecars {
+		fs.listings = make(map[string]*s3Listing)
+	}
+	fs.listings[name] = &s3Listing{entries, time.Nanoseconds()}
+	fs.Unlock()
+	return entries, nil
+}
+
+func (fs *S3FS) Stat(name string) (*os.FileInfo, os.Error) {
+	name = cleanName(name)
+	if name == "/" {
+		info := memInfo("/", syscall.S_IFDIR|0755, 0, 0)
+		return &info, nil
+	}
+	entries, err := fs.list(path.Dir(name))
+	if err != nil {
+		return nil, &os.PathError{"stat", name, err}
+	}
+	for i := range entries {
+		if entries[i].Name == path.Base(name) {
+			return &entries[i], nil
+		}
+	}
+	return nil, &os.PathError{"stat", name, os.ENOENT}
+}
+
+func (fs *S3FS) ReadDir(name string) ([]os.FileInfo, os.Error) {
+	entries, err := fs.list(cleanName(name))
+	if err != nil {
+		return nil, &os.PathError{"readdir", name, err}
+	}
+	return entries, nil
+}
+
+func (fs *S3FS) Open(name string) (File, os.Error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &listedFile{name: cleanName(name), info: info}
+	if info.IsDirectory() {
+		f.list = func() ([]os.FileInfo, os.Error) { return fs.ReadDir(name) }
+		return f, nil
+	}
+	resp, err := fs.get(f.name[1:], "")
+	if err != nil {
+		return nil, &os.PathError{"open", name, err}
+	}
+	f.body = resp.Body
+	return f, nil
+}
@@ -0,0 +1,126 @@
+package gopher
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// A file can be given a publication window in a sidecar named after it
+// with ScheduleSuffix appended, e.g. news.txt.schedule, holding either or
+// both of
+//
+//	Publish: 2011-04-01 09:00
+//	Expire: 2011-04-30
+//
+// in UTC. Outside its window a file is left out of listings, phlog
+// indexes, feeds and generated menus, and requesting it, or its abstract
+// or license, is answered as if it did not exist. Schedules themselves are
+// never served. Phlog posts named after a day later than today are
+// held back until that day the same way, so posts can be written ahead.
+const ScheduleSuffix = ".schedule"
+
+// scheduleLayouts are the forms times are accepted in by a schedule
+var scheduleLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// Schedule is the window in which a file is served
+type Schedule struct {
+	Publish int64 // Seconds since the epoch the file is served from, if not 0
+	Expire  int64 // Seconds since the epoch the file is served until, if not 0
+}
+
+// Open reports whether the window includes t, in seconds since the epoch
+func (sc *Schedule) Open(t int64) bool {
+	return (sc.Publish == 0 || t >= sc.Publish) && (sc.Expire == 0 || t < sc.Expire)
+}
+
+// parseScheduleTime parses a time given in a schedule
+func parseScheduleTime(value string) (int64, os.Error) {
+	for _, layout := range scheduleLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Seconds(), nil
+		}
+	}
+	return 0, os.NewError("bad time `" + value + "', expected YYYY-MM-DD [HH:MM[:SS]]")
+}
+
+// parseSchedule reads the contents of a schedule sidecar
+func parseSchedule(data []byte) (*Schedule, os.Error) {
+	sc := &Schedule{}
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i == -1 {
+			return nil, os.NewError("expected `Publish:' or `Expire:' in `" + line + "'")
+		}
+		t, err := parseScheduleTime(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(strings.TrimSpace(line[:i])) {
+		case "publish":
+			sc.Publish = t
+		case "expire":
+			sc.Expire = t
+		default:
+			return nil, os.NewError("unknown key in `" + line + "'")
+		}
+	}
+	return sc, nil
+}
+
+// schedule returns the publication window of the file at absPath, or nil
+// if it has none
+func (s *Server) schedule(absPath string) *Schedule {
+	data, err := s.readSidecar(absPath + ScheduleSuffix)
+	if err != nil {
+		return nil
+	}
+	sc, err := parseSchedule(data)
+	if err != nil {
+		s.Logger.Printf("ERROR: Bad schedule for `%s': %s\n", absPath, err)
+		// Better held back than published early
+		return &Schedule{Publish: 1 << 62}
+	}
+	return sc
+}
+
+// unpublished reports whether the file at absPath, below the document
+// root of ctx, is outside its publication window
+func (s *Server) unpublished(ctx *Context, absPath string) bool {
+	if sc := s.schedule(absPath); sc != nil && !sc.Open(time.Seconds()) {
+		return true
+	}
+	if !strings.HasPrefix(absPath, ctx.Host.Root+"/") {
+		return false
+	}
+	dir, name := path.Split(absPath[len(ctx.Host.Root):])
+	if date, ok := postDate(name); ok && date > today() {
+		return s.isPhlog(strings.TrimRight(dir, "/"))
+	}
+	return false
+}
+
+// privateSidecar reports whether the file at absPath is a sidecar that is
+// never served: a schedule, which would tell what is still to come, or the
+// abstract or license of a file outside its publication window
+func (s *Server) privateSidecar(ctx *Context, absPath string) bool {
+	if strings.HasSuffix(absPath, ScheduleSuffix) {
+		return true
+	}
+	for _, suffix := range []string{AbstractSuffix, LicenseSuffix} {
+		if strings.HasSuffix(absPath, suffix) && s.unpublished(ctx, absPath[:len(absPath)-len(suffix)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// today returns the current day in UTC as YYYY-MM-DD
+func today() string {
+	return time.UTC().Format("2006-01-02")
+}
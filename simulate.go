@@ -0,0 +1,69 @@
+package gopher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// Simulate answers a request in memory, the way it would be answered over
+// the network, and records how it was answered: the selector it ended up
+// as after rewrites, aliases and virtual host mapping, the file it names,
+// the item type sent, the outcome and the messages logged along the way.
+// Nothing is logged to the server's own destinations.
+
+// Simulation is what became of one simulated request
+type Simulation struct {
+	Selector string   // Selector as sent
+	Request  string   // Selector after rewrites, aliases and virtual hosts
+	File     string   // File the request was answered from, if any
+	ItemType byte     // Item type of the reply
+	Outcome  string   // Outcome logged for the request
+	Log      []string // Messages logged while answering
+	Reply    []byte   // Everything sent to the client
+	mu       sync.Mutex
+}
+
+// record notes an entry logged about the simulated request
+func (sim *Simulation) record(entry *LogEntry) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if entry.Access() {
+		sim.Outcome = entry.Outcome
+		return
+	}
+	sim.Log = append(sim.Log, entry.Message)
+}
+
+// Simulate sends selector to the server in memory and returns what
+// became of it
+func (s *Server) Simulate(selector string) (*Simulation, os.Error) {
+	s.init()
+	sim := &Simulation{Selector: selector}
+	client, server := net.Pipe()
+	defer client.Close()
+	ctx := s.newContext(server, time.Nanoseconds())
+	ctx.sim = sim
+	go s.handle(ctx)
+	if _, err := fmt.Fprintf(client, "%s\r\n", selector); err != nil {
+		return nil, err
+	}
+	reply, err := ioutil.ReadAll(client)
+	if err != nil && err != os.EOF {
+		return nil, err
+	}
+	// The connection is closed once the request has been answered
+	sim.Reply = reply
+	sim.Request, sim.ItemType = ctx.Request, ctx.itemType
+	if ctx.Request != "" && ctx.Host != nil && !s.handled(ctx.Request) {
+		file := path.Clean(ctx.Host.Root + ctx.Request)
+		if _, err := s.statFile(file); err == nil {
+			sim.File = file
+		}
+	}
+	return sim, nil
+}
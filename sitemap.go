@@ -0,0 +1,133 @@
+package gopher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SitemapSelector is where a menu of every public item on the server is
+// generated when the site has nothing of its own there. Items appear
+// below their directories, indented by depth. SitemapTextSelector is the
+// same list as plain text, one gopher:// URL per line, for crawlers and
+// mirroring tools. Only what directory listings would show is included:
+// hidden and dot files, disabled features, unpublished files, protected
+// areas and selectors the client is denied are all left out.
+const (
+	SitemapSelector     = "/sitemap"
+	SitemapTextSelector = "/sitemap.txt"
+)
+
+// MaxSitemapEntries bounds the size of a generated sitemap
+var MaxSitemapEntries = 10000
+
+// MaxSitemapDepth is how many directories deep a sitemap goes
+var MaxSitemapDepth = 16
+
+// sitemapEntry is one item of a sitemap
+type sitemapEntry struct {
+	Depth    int
+	Type     byte
+	Name     string
+	Selector string
+}
+
+// sitemap walks the document root of ctx and returns its public items,
+// each directory followed by its contents
+func (s *Server) sitemap(ctx *Context) []*sitemapEntry {
+	var entries []*sitemapEntry
+	s.walkSitemap(ctx, "", 0, &entries)
+	return entries
+}
+
+func (s *Server) walkSitemap(ctx *Context, selector string, depth int, entries *[]*sitemapEntry) {
+	dir := ctx.Host.Root + selector
+	fs, name := s.fileSystem(dir)
+	infos, err := fs.ReadDir(name)
+	if err != nil {
+		return
+	}
+	byName := make(map[string]int)
+	names := make([]string, 0, len(infos))
+	for i, info := range infos {
+		byName[info.Name] = i
+		names = append(names, info.Name)
+	}
+	sort.SortStrings(names)
+	titles := s.names(dir)
+	for _, n := range names {
+		if len(*entries) >= MaxSitemapEntries {
+			return
+		}
+		info := &infos[byName[n]]
+		sel := selector + "/" + n
+		if !s.public(ctx, sel, info.Name) || !(info.IsRegular() || info.IsDirectory()) {
+			continue
+		}
+		e := &sitemapEntry{Depth: depth, Name: s.listingName(dir+"/"+n, titles), Selector: sel}
+		switch {
+		case info.IsDirectory(), s.markdownMenu(dir + "/" + n):
+			e.Type = '1'
+		default:
+			e.Type = s.itemType(info)
+		}
+		*entries = append(*entries, e)
+		if info.IsDirectory() && depth+1 < MaxSitemapDepth {
+			s.walkSitemap(ctx, sel, depth+1, entries)
+		}
+	}
+}
+
+// public reports whether the item at selector, named name, may be handed
+// out in bulk, by the sitemap or an archive: it must be one a listing
+// would show, allowed to the client, published and outside the admin menu
+// and any protected area but the one the request was authenticated for
+func (s *Server) public(ctx *Context, selector string, name string) bool {
+	if listingHidden(name) || strings.HasPrefix(name, ".") || !validSelector(strings.Trim(selector, "/")) {
+		return false
+	}
+	if s.features.hidden(selector, ctx.ClientIP(), ctx.Host.Name) || !s.allowed(ctx, selector) {
+		return false
+	}
+	if s.isAdmin(selector) || s.unpublished(ctx, ctx.Host.Root+selector) {
+		return false
+	}
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, a := range s.protected {
+		if a != ctx.area && ctx.inArea(selector, a.Prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// serveSitemap sends the generated sitemap as a menu or, for
+// SitemapTextSelector, as a list of URLs
+func (s *Server) serveSitemap(ctx *Context) Status {
+	entries := s.sitemap(ctx)
+	if ctx.Request == SitemapTextSelector {
+		ctx.itemType = '0'
+		for _, e := range entries {
+			ctx.Write(s.feedURL(ctx, e.Type, e.Selector))
+		}
+		ctx.Logf("Served generated sitemap, %d items\n", len(entries))
+		return StatusOK
+	}
+	w := NewEntryWriter(ctx)
+	w.Info(fmt.Sprintf("Everything on %s", s.Hostname))
+	w.Info("")
+	if len(entries) == 0 {
+		w.Info("Nothing is published here yet.")
+	}
+	for _, e := range entries {
+		w.Item(e.Type, strings.Repeat("  ", e.Depth)+e.Name, e.Selector)
+	}
+	if len(entries) >= MaxSitemapEntries {
+		w.Info("")
+		w.Info(fmt.Sprintf("(Only the first %d items are shown.)", MaxSitemapEntries))
+	}
+	w.Close()
+	ctx.Logf("Served generated sitemap, %d items\n", len(entries))
+	return StatusOK
+}
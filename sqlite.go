@@ -0,0 +1,200 @@
+package gopher
+
+import (
+	"bytes"
+	"exec"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Selectors can be answered from a SQLite database, for link directories
+// and the like without a script of their own:
+//    sqlite /links/* /var/db/links.db menu SELECT type, title, selector, host, port FROM links WHERE category = $1
+//    sqlite /note/* /var/db/links.db document SELECT body FROM notes WHERE id = $1
+// The first argument is a glob pattern as for HandleGlob. In the query, $1,
+// $2 and so on stand for the parts of the selector the pattern's wildcards
+// matched and $query for the search string of a type 7 request, each put
+// in as a quoted SQL string. The rows of a menu query are the item type,
+// display string, selector and optionally host and port of an item, or
+// just the text of an info line; the rows of a document query are its
+// lines, their columns joined by tabs. Queries are run by the sqlite3
+// program, which must be installed.
+
+// SQLiteHandler answers the selectors matching a pattern with the result
+// of a query
+type SQLiteHandler struct {
+	Pattern  string
+	Database string
+	Query    string
+	Menu     bool // Whether rows are menu items rather than lines of text
+	re       *regexp.Regexp
+}
+
+// sqliteSeparator separates the columns of the rows sqlite3 prints
+const sqliteSeparator = "\x1f"
+
+// AddSQLite answers the selectors matching the glob pattern with the rows
+// query returns from database, as a menu or as a document
+func (s *Server) AddSQLite(pattern string, database string, query string, menu bool) os.Error {
+	re, err := regexp.Compile(globRegexp(pattern))
+	if err != nil {
+		return err
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.sqlite = append(s.sqlite, &SQLiteHandler{pattern, database, query, menu, re})
+	return nil
+}
+
+// configureSQLite handles a `sqlite pattern database menu|document query'
+// line
+func (s *Server) configureSQLite(args []string) os.Error {
+	if len(args) < 4 || args[2] != "menu" && args[2] != "document" {
+		return os.NewError("expected a pattern, a database, menu or document and a query")
+	}
+	return s.AddSQLite(args[0], args[1], strings.Join(args[3:], " "), args[2] == "menu")
+}
+
+// sqliteFor returns the handler for a selector, if there is one
+func (s *Server) sqliteFor(selector string) *SQLiteHandler {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, h := range s.sqlite {
+		if h.re.MatchString(selector) {
+			return h
+		}
+	}
+	return nil
+}
+
+// sqlQuote quotes a string for use in a SQL statement
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// bind puts the parts of the request into the query
+func (h *SQLiteHandler) bind(ctx *Context) (string, os.Error) {
+	var params []string
+	if m := h.re.FindStringSubmatch(ctx.Request); len(m) > 1 {
+		params = m[1:]
+	}
+	var b bytes.Buffer
+	q := h.Query
+	for i := 0; i < len(q); i++ {
+		if q[i] != '$' {
+			b.WriteByte(q[i])
+			continue
+		}
+		if strings.HasPrefix(q[i+1:], "query") {
+			b.WriteString(sqlQuote(ctx.Query))
+			i += len("query")
+			continue
+		}
+		j := i + 1
+		for j < len(q) && '0' <= q[j] && q[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(q[i+1 : j])
+		if err != nil {
+			b.WriteByte(q[i])
+			continue
+		}
+		if n < 1 || n > len(params) {
+			return "", os.NewError(fmt.Sprintf("pattern %s has no wildcard $%d", h.Pattern, n))
+		}
+		b.WriteString(sqlQuote(params[n-1]))
+		i = j - 1
+	}
+	if strings.Index(b.String(), "\x00") != -1 {
+		return "", os.NewError("NUL in the request")
+	}
+	return b.String(), nil
+}
+
+// runSQLite runs a query on the database and returns the columns of its rows
+func (s *Server) runSQLite(database string, query string) (rows [][]string, err os.Error) {
+	argv0, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return nil, err
+	}
+	argv := []string{"sqlite3", "-batch", "-noheader", "-separator", sqliteSeparator, database, query}
+	cmd, err := exec.Run(argv0, argv, os.Environ(), "", exec.DevNull, exec.Pipe, exec.PassThrough)
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Close()
+	if s.HandlerTimeout > 0 {
+		finished := make(chan bool, 1)
+		defer func() { finished <- true }()
+		go killAfter(cmd.Pid, s.HandlerTimeout, finished)
+	}
+	out, err := ioutil.ReadAll(cmd.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := cmd.Wait(0)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Exited() || msg.ExitStatus() != 0 {
+		return nil, os.NewError(fmt.Sprintf("sqlite3 %s: %s", database, msg))
+	}
+	text := strings.TrimRight(string(out), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	for _, line := range strings.Split(text, "\n", -1) {
+		rows = append(rows, strings.Split(line, sqliteSeparator, -1))
+	}
+	return rows, nil
+}
+
+// serve answers a request with the result of the query
+func (h *SQLiteHandler) serve(ctx *Context) Status {
+	query, err := h.bind(ctx)
+	if err != nil {
+		ctx.Logf("ERROR: `%s': %s\n", ctx.Request, err)
+		return StatusBadRequest
+	}
+	rows, err := ctx.Server.runSQLite(h.Database, query)
+	if err != nil {
+		ctx.Logf("ERROR: Query for `%s' failed: %s\n", ctx.Request, err)
+		return StatusError
+	}
+	if !h.Menu {
+		ctx.itemType = '0'
+		for _, row := range rows {
+			ctx.Write(strings.Join(row, "\t"))
+		}
+		ctx.Write(".")
+		ctx.Logf("Served query result `%s'\n", ctx.Request)
+		return StatusOK
+	}
+	ctx.itemType = '1'
+	w := NewEntryWriter(ctx)
+	for _, row := range rows {
+		switch {
+		case len(row) == 1 || len(row[0]) != 1:
+			w.Info(strings.Join(row, " "))
+		case len(row) == 3:
+			w.Item(row[0][0], row[1], row[2])
+		case len(row) >= 5:
+			port, err := strconv.Atoi(row[4])
+			if err != nil {
+				port = 70
+			}
+			w.Write(&MenuEntry{row[0][0], row[1], row[2], row[3], port})
+		default:
+			w.Info(strings.Join(row[1:], " "))
+		}
+	}
+	if err := w.Close(); err != nil {
+		return StatusError
+	}
+	ctx.Logf("Served query menu `%s'\n", ctx.Request)
+	return StatusOK
+}
@@ -0,0 +1,163 @@
+package gopher
+
+import (
+	"fmt"
+	"http"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Upper bounds, in seconds, of the request latency histogram buckets
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Stats is a snapshot of the server's internal counters
+type Stats struct {
+	Requests          int64
+	BytesSent         int64
+	Errors            int64
+	ActiveConnections int64
+	ItemTypes         map[byte]int64 // Responses served per Gopher item type
+	LatencyBuckets    []float64      // Histogram bucket upper bounds in seconds
+	LatencyCounts     []int64        // Cumulative request count per bucket
+	LatencySum        float64        // Total seconds spent serving requests
+}
+
+type statsCollector struct {
+	sync.Mutex
+	requests      int64
+	bytesSent     int64
+	errors        int64
+	active        int64
+	itemTypes     map[byte]int64
+	latencyCounts []int64
+	latencySum    float64
+	selectors     map[string]*SelectorStats
+}
+
+// countingConn counts the bytes written to the wrapped connection
+type countingConn struct {
+	net.Conn
+	written int64
+	limit   int64 // Bytes that may be written, if not 0
+}
+
+func (c *countingConn) Write(b []byte) (n int, err os.Error) {
+	if c.limit > 0 && c.written+int64(len(b)) > c.limit {
+		return 0, ErrResponseTooLarge
+	}
+	n, err = c.Conn.Write(b)
+	c.written += int64(n)
+	return
+}
+
+func (c *statsCollector) connOpened() {
+	c.Lock()
+	c.active++
+	c.Unlock()
+}
+
+// record accounts for a finished request that took nsec nanoseconds
+func (c *statsCollector) record(itemType byte, sent int64, failed bool, nsec int64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.itemTypes == nil {
+		c.itemTypes = make(map[byte]int64)
+		c.latencyCounts = make([]int64, len(latencyBuckets))
+	}
+	c.active--
+	c.requests++
+	c.bytesSent += sent
+	if failed {
+		c.errors++
+	}
+	if itemType != 0 {
+		c.itemTypes[itemType]++
+	}
+	seconds := float64(nsec) / 1e9
+	c.latencySum += seconds
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			c.latencyCounts[i]++
+		}
+	}
+}
+
+// Stats returns a snapshot of the server's counters, for embedding programs
+func (s *Server) Stats() *Stats {
+	c := &s.stats
+	c.Lock()
+	defer c.Unlock()
+	st := &Stats{
+		Requests:          c.requests,
+		BytesSent:         c.bytesSent,
+		Errors:            c.errors,
+		ActiveConnections: c.active,
+		ItemTypes:         make(map[byte]int64),
+		LatencyBuckets:    latencyBuckets,
+		LatencyCounts:     make([]int64, len(latencyBuckets)),
+		LatencySum:        c.latencySum,
+	}
+	for t, n := range c.itemTypes {
+		st.ItemTypes[t] = n
+	}
+	copy(st.LatencyCounts, c.latencyCounts)
+	return st
+}
+
+// WriteMetrics renders the server's counters in the Prometheus text format
+func (s *Server) WriteMetrics(w io.Writer) {
+	st := s.Stats()
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("gopher_requests_total", "counter", "Requests served.")
+	fmt.Fprintf(w, "gopher_requests_total %d\n", st.Requests)
+	metric("gopher_bytes_sent_total", "counter", "Bytes sent to clients.")
+	fmt.Fprintf(w, "gopher_bytes_sent_total %d\n", st.BytesSent)
+	metric("gopher_errors_total", "counter", "Requests that ended in an error.")
+	fmt.Fprintf(w, "gopher_errors_total %d\n", st.Errors)
+	metric("gopher_active_connections", "gauge", "Connections currently being served.")
+	fmt.Fprintf(w, "gopher_active_connections %d\n", st.ActiveConnections)
+
+	metric("gopher_items_total", "counter", "Responses served by item type.")
+	types := make([]int, 0, len(st.ItemTypes))
+	for t := range st.ItemTypes {
+		types = append(types, int(t))
+	}
+	sort.SortInts(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "gopher_items_total{type=%q} %d\n", string(t), st.ItemTypes[byte(t)])
+	}
+
+	metric("gopher_request_duration_seconds", "histogram", "Time spent serving requests.")
+	for i, bound := range st.LatencyBuckets {
+		le := strconv.Ftoa64(bound, 'g', -1)
+		fmt.Fprintf(w, "gopher_request_duration_seconds_bucket{le=%q} %d\n", le, st.LatencyCounts[i])
+	}
+	fmt.Fprintf(w, "gopher_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", st.Requests)
+	fmt.Fprintf(w, "gopher_request_duration_seconds_sum %s\n", strconv.Ftoa64(st.LatencySum, 'g', -1))
+	fmt.Fprintf(w, "gopher_request_duration_seconds_count %d\n", st.Requests)
+}
+
+// ServeMetrics runs an HTTP listener on addr exposing /metrics
+func (s *Server) ServeMetrics(addr string) os.Error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serveMetrics(listener)
+}
+
+func (s *Server) serveMetrics(listener net.Listener) os.Error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.SetHeader("Content-Type", "text/plain; version=0.0.4")
+		s.WriteMetrics(w)
+	})
+	s.Logger.Printf("metrics listening on %s...\n", listener.Addr())
+	return http.Serve(listener, mux)
+}
@@ -0,0 +1,151 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Failure policies for optional subsystems
+const (
+	PolicyWarn  = iota // Log the failure and keep serving without the subsystem
+	PolicyFatal        // Refuse to run without the subsystem
+)
+
+// Subsystem is the state of an optional server component such as the
+// metrics listener. Core file serving never depends on one being up.
+type Subsystem struct {
+	Name   string
+	Policy int
+	Notice string // Shown to clients in place of the subsystem's menu entries while it is down
+	Up     bool
+	Err    os.Error // Why the subsystem is down
+}
+
+func (sub *Subsystem) String() string {
+	status := "up"
+	if !sub.Up {
+		status = fmt.Sprintf("down (%s)", sub.Err)
+	}
+	policy := "warn"
+	if sub.Policy == PolicyFatal {
+		policy = "fatal"
+	}
+	return fmt.Sprintf("%s %s policy=%s", sub.Name, status, policy)
+}
+
+type subsystemSet struct {
+	sync.RWMutex
+	m map[string]*Subsystem
+}
+
+// get returns the named subsystem, creating it as up if it is new. The
+// caller must hold the lock for writing.
+func (set *subsystemSet) get(name string) *Subsystem {
+	if set.m == nil {
+		set.m = make(map[string]*Subsystem)
+	}
+	sub, ok := set.m[name]
+	if !ok {
+		sub = &Subsystem{Name: name, Policy: PolicyWarn, Up: true}
+		set.m[name] = sub
+	}
+	return sub
+}
+
+// configure handles a `policy subsystem warn|fatal [notice...]' line
+func (set *subsystemSet) configure(args []string) os.Error {
+	if len(args) < 2 {
+		return os.NewError("expected a subsystem name, warn or fatal and an optional notice")
+	}
+	set.Lock()
+	defer set.Unlock()
+	sub := set.get(args[0])
+	switch args[1] {
+	case "warn":
+		sub.Policy = PolicyWarn
+	case "fatal":
+		sub.Policy = PolicyFatal
+	default:
+		return os.NewError(fmt.Sprintf("expected warn or fatal, got `%s'", args[1]))
+	}
+	sub.Notice = strings.Join(args[2:], " ")
+	return nil
+}
+
+// Recover marks the named subsystem as up again, or as started
+func (s *Server) Recover(name string) {
+	s.subsystems.Lock()
+	sub := s.subsystems.get(name)
+	sub.Up = true
+	sub.Err = nil
+	s.subsystems.Unlock()
+}
+
+// Degrade marks the named subsystem as down and applies its failure
+// policy, exiting if it is PolicyFatal. The server's own subsystems are
+// marked this way; embedding programs can do the same for theirs, such as
+// a search backend, whose items gophermaps then replace with its notice.
+func (s *Server) Degrade(name string, err os.Error) {
+	s.subsystems.Lock()
+	sub := s.subsystems.get(name)
+	sub.Up = false
+	sub.Err = err
+	policy := sub.Policy
+	s.subsystems.Unlock()
+	if policy == PolicyFatal {
+		s.Logger.Printf("FATAL: required subsystem %s failed: %s\n", name, err)
+		os.Exit(1)
+	}
+	s.Logger.Printf("WARNING: %s unavailable, continuing without it: %s\n", name, err)
+}
+
+// policy returns the failure policy of the named subsystem
+func (set *subsystemSet) policy(name string) int {
+	set.RLock()
+	defer set.RUnlock()
+	if sub, ok := set.m[name]; ok {
+		return sub.Policy
+	}
+	return PolicyWarn
+}
+
+// SubsystemUp reports whether the named subsystem is working. Subsystems
+// that were never started count as up.
+func (s *Server) SubsystemUp(name string) bool {
+	s.subsystems.RLock()
+	defer s.subsystems.RUnlock()
+	if sub, ok := s.subsystems.m[name]; ok {
+		return sub.Up
+	}
+	return true
+}
+
+// subsystemNotice returns the notice to show in place of a down subsystem
+func (s *Server) subsystemNotice(name string) string {
+	s.subsystems.RLock()
+	defer s.subsystems.RUnlock()
+	if sub, ok := s.subsystems.m[name]; ok && sub.Notice != "" {
+		return sub.Notice
+	}
+	return fmt.Sprintf("The %s service is temporarily unavailable.", name)
+}
+
+// Subsystems returns the state of all known subsystems sorted by name
+func (s *Server) Subsystems() []*Subsystem {
+	s.subsystems.RLock()
+	defer s.subsystems.RUnlock()
+	names := make([]string, 0, len(s.subsystems.m))
+	for name := range s.subsystems.m {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+	list := make([]*Subsystem, len(names))
+	for i, name := range names {
+		sub := *s.subsystems.m[name]
+		list[i] = &sub
+	}
+	return list
+}
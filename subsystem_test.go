@@ -0,0 +1,48 @@
+package gopher_test
+
+import (
+	"gopher/gophertest"
+	"os"
+	"testing"
+)
+
+// searchItems returns the search items and info lines of the root menu
+func searchItems(t *testing.T, ts *gophertest.Server) (searches []string, infos []string) {
+	entries, err := get(t, ts, "/").Menu()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, e := range entries {
+		switch e.Type {
+		case '7':
+			searches = append(searches, e.Selector)
+		case 'i':
+			infos = append(infos, e.Display)
+		}
+	}
+	return
+}
+
+func TestSearchDown(t *testing.T) {
+	s := newServer(t, map[string]string{"/gophermap": "7Search the site\t/search\n7Elsewhere\t/find\texample.org\t70\n"},
+		"policy search warn Search is resting.")
+	ts := gophertest.NewServer(s)
+	defer ts.Close()
+
+	if searches, infos := searchItems(t, ts); len(searches) != 2 || len(infos) != 0 {
+		t.Errorf("search up: got items %q and info %q, want both items", searches, infos)
+	}
+	s.Degrade("search", os.NewError("index missing"))
+	if s.SubsystemUp("search") {
+		t.Errorf("search is up after Degrade")
+	}
+	// Only local search items are replaced by the notice
+	searches, infos := searchItems(t, ts)
+	if len(searches) != 1 || searches[0] != "/find" || len(infos) != 1 || infos[0] != "Search is resting." {
+		t.Errorf("search down: got items %q and info %q, want /find and the notice", searches, infos)
+	}
+	s.Recover("search")
+	if searches, infos := searchItems(t, ts); len(searches) != 2 || len(infos) != 0 {
+		t.Errorf("search recovered: got items %q and info %q, want both items", searches, infos)
+	}
+}
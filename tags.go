@@ -0,0 +1,152 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Phlog posts can be tagged with a line near their top such as
+//    Tags: golang, gopher servers
+// (in the .header of a post directory). The phlog then serves
+// prefix/tags, a tag cloud above a menu of every tag, and prefix/tags/TAG,
+// the posts carrying TAG, e.g. /phlog/tags/golang, and shows the cloud
+// under its index. Tags are compared in lower case, with runs of spaces
+// made into a single dash.
+
+// TagLines is how many lines at the top of a post are searched for its
+// tags
+const TagLines = 20
+
+// TagCloudWidth is the column the tag cloud is wrapped at
+const TagCloudWidth = 70
+
+// postTags returns the tags declared near the top of a file
+func (s *Server) postTags(name string) []string {
+	file, err := s.openFile(name)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var buf [2048]byte
+	n, _ := file.Read(buf[:])
+	for i, line := range strings.Split(string(buf[:n]), "\n", -1) {
+		if i == TagLines {
+			break
+		}
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			continue
+		}
+		if key := strings.ToLower(strings.TrimSpace(line[:colon])); key != "tags" && key != "tag" {
+			continue
+		}
+		var tags []string
+		for _, tag := range strings.Split(line[colon+1:], ",", -1) {
+			if tag = normalizeTag(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		return tags
+	}
+	return nil
+}
+
+// normalizeTag makes a tag fit for comparison and for a selector
+func normalizeTag(tag string) string {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	drop := func(c int) int {
+		if c < ' ' || c == '/' || c == 0x7f {
+			return -1
+		}
+		return c
+	}
+	return strings.Map(drop, tag)
+}
+
+// tagCount is a tag and the number of posts carrying it
+type tagCount struct {
+	Tag   string
+	Posts int
+}
+
+type tagCounts []*tagCount
+
+func (l tagCounts) Len() int      { return len(l) }
+func (l tagCounts) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l tagCounts) Less(i, j int) bool {
+	if l[i].Posts != l[j].Posts {
+		return l[i].Posts > l[j].Posts
+	}
+	return l[i].Tag < l[j].Tag
+}
+
+// countTags returns the tags of posts, most used first
+func countTags(posts phlogPosts) tagCounts {
+	byTag := make(map[string]*tagCount)
+	counts := make(tagCounts, 0)
+	for _, post := range posts {
+		for _, tag := range post.Tags {
+			c, found := byTag[tag]
+			if !found {
+				c = &tagCount{Tag: tag}
+				byTag[tag] = c
+				counts = append(counts, c)
+			}
+			c.Posts++
+		}
+	}
+	sort.Sort(counts)
+	return counts
+}
+
+// writeTagCloud sends the tags of a phlog as info lines
+func writeTagCloud(w *EntryWriter, counts tagCounts) {
+	words := make([]string, len(counts))
+	for i, c := range counts {
+		words[i] = fmt.Sprintf("%s(%d)", c.Tag, c.Posts)
+	}
+	for _, line := range wrapWords(strings.Join(words, " "), TagCloudWidth, "Tags: ", "      ") {
+		w.Info(line)
+	}
+}
+
+// serveTags sends the tag menu of a phlog, or the posts carrying one tag
+func (s *Server) serveTags(ctx *Context, w *EntryWriter, p *Phlog, posts phlogPosts) (Status, os.Error) {
+	counts := countTags(posts)
+	if ctx.Request == p.Prefix+"/tags" {
+		if len(counts) == 0 {
+			w.Info("No posts are tagged yet.")
+			return StatusOK, nil
+		}
+		writeTagCloud(w, counts)
+		w.Info("")
+		for _, c := range counts {
+			w.Item('1', fmt.Sprintf("%s (%d)", c.Tag, c.Posts), p.Prefix+"/tags/"+c.Tag)
+		}
+		return StatusOK, nil
+	}
+	tag := ctx.Request[len(p.Prefix+"/tags/"):]
+	found := false
+	for _, post := range posts {
+		for _, t := range post.Tags {
+			if t != tag {
+				continue
+			}
+			if !found {
+				w.Info("Posts tagged " + tag)
+				w.Info("")
+				found = true
+			}
+			w.Item(post.Type, post.Date+"  "+post.Title, post.Selector)
+			break
+		}
+	}
+	if !found {
+		return StatusNotFound, os.NewError("no posts tagged " + tag)
+	}
+	w.Info("")
+	w.Item('1', "All tags", p.Prefix+"/tags")
+	return StatusOK, nil
+}
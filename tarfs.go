@@ -0,0 +1,134 @@
+package gopher
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// MaxGzipTarSize is how many bytes of files a gzipped tar archive may hold.
+// Its files are kept in memory, as a compressed archive cannot be read
+// from the middle.
+const MaxGzipTarSize = 64 << 20
+
+// TarFS is a FileSystem serving the contents of a tar archive without
+// unpacking it. The archive's headers are read once when it is opened, so
+// looking names up costs no I/O. Files of a plain archive are read from
+// it as they are served; those of a gzipped one, named .gz or .tgz, are
+// held in memory, up to MaxGzipTarSize bytes.
+type TarFS struct {
+	*MemFS
+	file *os.File
+}
+
+// OpenTarFS opens the tar archive at name as a FileSystem
+func OpenTarFS(name string) (*TarFS, os.Error) {
+	file, err := os.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fs := &TarFS{NewMemFS(), file}
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		err = fs.readGzipped()
+		file.Close()
+		fs.file = nil
+	} else {
+		err = fs.index()
+	}
+	if err != nil {
+		if fs.file != nil {
+			file.Close()
+		}
+		return nil, os.NewError(name + ": " + err.String())
+	}
+	return fs, nil
+}
+
+// index records where each file of a plain archive starts, to be read
+// from there when it is opened
+func (fs *TarFS) index() os.Error {
+	t := tar.NewReader(fs.file)
+	for {
+		hdr, err := t.Next()
+		if err != nil {
+			return err
+		}
+		if hdr == nil {
+			return nil
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = fs.Mkdir(hdr.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			// The reader has just read the header, so the file is
+			// where the archive's offset is
+			var offset int64
+			if offset, err = fs.file.Seek(0, 1); err == nil {
+				err = fs.addOpener(hdr.Name, hdr.Size, hdr.Mtime*1e9, sectionOpener(fs.file, offset, hdr.Size))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGzipped reads the files of a gzipped archive into memory
+func (fs *TarFS) readGzipped() os.Error {
+	gz, err := gzip.NewReader(fs.file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	t := tar.NewReader(gz)
+	var total int64
+	for {
+		hdr, err := t.Next()
+		if err != nil {
+			return err
+		}
+		if hdr == nil {
+			return nil
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = fs.Mkdir(hdr.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			if total += hdr.Size; total > MaxGzipTarSize {
+				return os.NewError(fmt.Sprintf("holds more than %d bytes; serve it unzipped instead", MaxGzipTarSize))
+			}
+			var data []byte
+			if data, err = ioutil.ReadAll(t); err == nil {
+				err = fs.addOpener(hdr.Name, int64(len(data)), hdr.Mtime*1e9, bytesOpener(data))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the archive
+func (fs *TarFS) Close() os.Error {
+	if fs.file == nil {
+		return nil
+	}
+	return fs.file.Close()
+}
+
+// sectionOpener returns a function opening the size bytes at offset of r
+func sectionOpener(r io.ReaderAt, offset int64, size int64) func() (io.ReadCloser, os.Error) {
+	return func() (io.ReadCloser, os.Error) { return nopCloser{io.NewSectionReader(r, offset, size)}, nil }
+}
+
+// bytesOpener returns a function opening data for reading
+func bytesOpener(data []byte) func() (io.ReadCloser, os.Error) {
+	return func() (io.ReadCloser, os.Error) { return nopCloser{bytes.NewBuffer(data)}, nil }
+}
@@ -0,0 +1,32 @@
+package gopher
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Telnet (8) and tn3270 (T) items open a terminal session rather than
+// fetch a selector. In a gophermap their selector is the login name to
+// suggest, if any, and is passed on unchanged, while host and port must be
+// given, since the session is never with this server:
+//    8Library catalogue<TAB>guest<TAB>catalog.example.edu<TAB>23
+// With ForbidTelnet set, such items are left out of every menu.
+
+// isTelnetType reports whether an item type opens a terminal session
+func isTelnetType(itemType byte) bool {
+	return itemType == '8' || itemType == 'T'
+}
+
+// parseTelnetLine parses a gophermap line of a telnet item
+func parseTelnetLine(line string) (*MenuEntry, os.Error) {
+	parts := strings.Split(line[1:], "\t", 4)
+	if len(parts) != 4 || strings.TrimSpace(parts[2]) == "" {
+		return nil, os.NewError("telnet items need a host and a port")
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(parts[3]))
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, os.NewError("bad port in telnet item")
+	}
+	return &MenuEntry{Type: line[0], Display: parts[0], Selector: parts[1], Host: strings.TrimSpace(parts[2]), Port: port}, nil
+}
@@ -0,0 +1,145 @@
+package gopher
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"template"
+	"time"
+)
+
+// A gophermap is run through the template package before it is parsed when
+// it is named TemplateGophermap or its first line is TemplateMagic. The
+// template is executed with a *GophermapData, so a map can say
+//
+//	Hello {ClientIP}, it is {Time} on {Date}
+//	{.repeated section Entries}
+//	{Type}{Name}	{Selector}
+//	{.end}
+//
+// where the gap in the last item line is a literal tab. The search string
+// in Query comes from the client, so it is made unable to start an item or
+// a directive: tabs and line breaks are dropped from it, and so are the
+// characters that begin directives at its start.
+const (
+	TemplateGophermap = "gophermap.tpl"
+	TemplateMagic     = "#!template"
+)
+
+// GophermapData is what a gophermap template is executed with
+type GophermapData struct {
+	Selector string
+	Query    string
+	ClientIP string
+	Date     string // 2006-01-02, local time
+	Time     string // 15:04:05, local time
+	Hostname string
+	Port     int
+	Entries  []*GophermapEntry // Contents of the directory, by name
+}
+
+// GophermapEntry is a file or directory in the templated gophermap's
+// directory
+type GophermapEntry struct {
+	Type     string // Item type, 0 or 1
+	Name     string
+	Selector string
+	Size     int64
+	Modified string // 2006-01-02 15:04, local time
+}
+
+// templateQuery makes a search string safe to expand into a gophermap
+func templateQuery(query string) string {
+	query = strings.Map(func(c int) int {
+		if c == '\t' || c == '\r' || c == '\n' {
+			return -1
+		}
+		return c
+	}, query)
+	for {
+		trimmed := strings.TrimLeft(strings.TrimSpace(query), "=!")
+		if strings.HasPrefix(trimmed, "exec:") {
+			trimmed = trimmed[len("exec:"):]
+		}
+		if trimmed == query {
+			return query
+		}
+		query = trimmed
+	}
+	return query
+}
+
+// gophermapData builds the template data for a request of dir
+func (s *Server) gophermapData(ctx *Context, dir string) *GophermapData {
+	now := time.LocalTime()
+	data := &GophermapData{
+		Selector: ctx.Request,
+		Query:    templateQuery(ctx.Query),
+		ClientIP: ctx.ClientIP(),
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("15:04:05"),
+		Hostname: s.Hostname,
+		Port:     s.Port,
+	}
+	fs, name := s.fileSystem(dir)
+	infos, err := fs.ReadDir(name)
+	if err != nil {
+		return data
+	}
+	byName := make(map[string]*os.FileInfo)
+	names := make([]string, 0, len(infos))
+	for i := range infos {
+		info := &infos[i]
+		if listingHidden(info.Name) || !(info.IsRegular() || info.IsDirectory()) || s.unpublished(ctx, dir+"/"+info.Name) {
+			continue
+		}
+		selector := strings.TrimRight(ctx.Request, "/") + "/" + info.Name
+		if !validSelector(strings.Trim(selector, "/")) || s.features.hidden(selector, ctx.ClientIP(), ctx.Host.Name) {
+			continue
+		}
+		byName[info.Name] = info
+		names = append(names, info.Name)
+	}
+	sort.SortStrings(names)
+	for _, name := range names {
+		info := byName[name]
+		data.Entries = append(data.Entries, &GophermapEntry{
+			Type:     string(s.itemType(info)),
+			Name:     s.displayName(name),
+			Selector: strings.TrimRight(ctx.Request, "/") + "/" + name,
+			Size:     info.Size,
+			Modified: time.SecondsToLocalTime(info.Mtime_ns / 1e9).Format("2006-01-02 15:04"),
+		})
+	}
+	return data
+}
+
+// gophermapSource returns the gophermap of dir ready for parsing, expanding
+// it first if it is a template. found is false if dir has no gophermap.
+func (s *Server) gophermapSource(ctx *Context, dir string) (r io.Reader, found bool, err os.Error) {
+	src, err := s.readSidecar(dir + "/" + TemplateGophermap)
+	if err != nil {
+		if src, err = s.readSidecar(dir + "/gophermap"); err != nil {
+			return nil, false, nil
+		}
+		if !bytes.HasPrefix(src, []byte(TemplateMagic)) {
+			return bytes.NewBuffer(src), true, nil
+		}
+		if i := bytes.IndexByte(src, '\n'); i != -1 {
+			src = src[i+1:]
+		} else {
+			src = nil
+		}
+	}
+	t, err := template.Parse(string(src), nil)
+	if err != nil {
+		return nil, true, err
+	}
+	var out bytes.Buffer
+	if err = t.Execute(&out, s.gophermapData(ctx, dir)); err != nil {
+		return nil, true, err
+	}
+	return &out, true, nil
+}
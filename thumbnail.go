@@ -0,0 +1,252 @@
+package gopher
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// With Thumbnails on, images are offered in two more Gopher+ views besides
+// the original: ThumbnailView, the image scaled down to fit in a square of
+// ThumbnailSize pixels, and PreviewView, a rough picture of it in
+// characters for text-only clients. Both are made the first time they are
+// asked for and kept in memory until the image changes, so browsing a
+// gallery over a slow link costs a few kilobytes per picture.
+
+// Gopher+ views of images besides the original
+const (
+	ThumbnailView = "image/png; thumbnail"
+	PreviewView   = "text/plain; preview"
+)
+
+// DefaultThumbnailSize is the side of the square thumbnails are fit in
+// when ThumbnailSize is not set, in pixels
+const DefaultThumbnailSize = 128
+
+// PreviewWidth is how many characters wide image previews are
+var PreviewWidth = 60
+
+// MaxCachedThumbnails is how many thumbnails and previews are kept in
+// memory before the cache is emptied
+var MaxCachedThumbnails = 512
+
+// imageTypes are the content types of the images that can be scaled, by
+// extension
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+}
+
+// previewRamp are the characters of previews from dark to light
+const previewRamp = "@%#*+=-:. "
+
+// thumbnailCache holds the views made of images
+type thumbnailCache struct {
+	sync.Mutex
+	views map[string][]byte // By view, path, size and modification time
+}
+
+// imageType returns the content type of an image file that can be scaled
+func imageType(name string) (string, bool) {
+	t, ok := imageTypes[strings.ToLower(path.Ext(name))]
+	return t, ok
+}
+
+// thumbnailable reports whether the file at absPath is offered scaled down
+func (s *Server) thumbnailable(absPath string, info *os.FileInfo) bool {
+	_, ok := imageType(absPath)
+	return s.Thumbnails && ok && info.IsRegular()
+}
+
+// thumbnailSize returns the side of the square thumbnails are fit in
+func (s *Server) thumbnailSize() int {
+	if s.ThumbnailSize > 0 {
+		return s.ThumbnailSize
+	}
+	return DefaultThumbnailSize
+}
+
+// imageViews returns the VIEWS lines of an image
+func (s *Server) imageViews(absPath string, info *os.FileInfo) []string {
+	t, _ := imageType(absPath)
+	return []string{
+		fmt.Sprintf("%s: <%dk>", t, (info.Size+1023)/1024),
+		ThumbnailView + ": <8k>",
+		PreviewView + ": <2k>",
+	}
+}
+
+// decodeImage reads the image at absPath
+func (s *Server) decodeImage(absPath string) (image.Image, os.Error) {
+	file, err := s.openFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m, _, err := image.Decode(file)
+	return m, err
+}
+
+// brightness returns the brightness of a color from 0 to 65535, as if it
+// were on a white background, colors being premultiplied by their alpha
+func brightness(c image.Color) uint32 {
+	r, g, b, a := c.RGBA()
+	y := (299*r + 587*g + 114*b) / 1000
+	return y + 0xffff - a
+}
+
+// average returns the mean color of a rectangle of an image
+func average(m image.Image, x0 int, y0 int, x1 int, y1 int) image.RGBAColor {
+	var r, g, b, a, n uint32
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			cr, cg, cb, ca := m.At(x, y).RGBA()
+			r, g, b, a, n = r+cr, g+cg, b+cb, a+ca, n+1
+		}
+	}
+	if n == 0 {
+		return image.RGBAColor{}
+	}
+	return image.RGBAColor{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)}
+}
+
+// scale returns how many pixels of an image go across and down into one
+// of a w by h picture of it
+func scale(bounds image.Rectangle, w int, h int) (fx float64, fy float64) {
+	return float64(bounds.Dx()) / float64(w), float64(bounds.Dy()) / float64(h)
+}
+
+// makeThumbnail returns the image scaled down to fit in a square of size
+// pixels as a PNG
+func makeThumbnail(m image.Image, size int) ([]byte, os.Error) {
+	bounds := m.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > size || h > size {
+		if w > h {
+			w, h = size, h*size/w
+		} else {
+			w, h = w*size/h, size
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	fx, fy := scale(bounds, w, h)
+	thumb := image.NewRGBA(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			x0, y0 := bounds.Min.X+int(float64(x)*fx), bounds.Min.Y+int(float64(y)*fy)
+			x1, y1 := bounds.Min.X+int(float64(x+1)*fx), bounds.Min.Y+int(float64(y+1)*fy)
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			if y1 <= y0 {
+				y1 = y0 + 1
+			}
+			thumb.Set(x, y, average(m, x0, y0, x1, y1))
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, thumb); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// makePreview returns the image drawn in characters, width columns wide
+func makePreview(m image.Image, width int) []byte {
+	bounds := m.Bounds()
+	w := width
+	if bounds.Dx() < w {
+		w = bounds.Dx()
+	}
+	// Characters are about twice as tall as they are wide
+	h := bounds.Dy() * w / bounds.Dx() / 2
+	if h < 1 {
+		h = 1
+	}
+	fx, fy := scale(bounds, w, h)
+	var b bytes.Buffer
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			x0, y0 := bounds.Min.X+int(float64(x)*fx), bounds.Min.Y+int(float64(y)*fy)
+			c := average(m, x0, y0, x0+int(fx+1), y0+int(fy+1))
+			b.WriteByte(previewRamp[int(brightness(c))*len(previewRamp)/0x10000])
+		}
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+// imageView returns a view of the image at absPath, made by render unless
+// it is cached
+func (s *Server) imageView(view string, absPath string, info *os.FileInfo, render func(image.Image) ([]byte, os.Error)) ([]byte, os.Error) {
+	key := fmt.Sprintf("%s %s %d %d", view, absPath, info.Size, info.Mtime_ns)
+	c := &s.thumbnails
+	c.Lock()
+	data, found := c.views[key]
+	c.Unlock()
+	if found {
+		return data, nil
+	}
+	m, err := s.decodeImage(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if bounds := m.Bounds(); bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, os.NewError("empty image")
+	}
+	if data, err = render(m); err != nil {
+		return nil, err
+	}
+	c.Lock()
+	if c.views == nil || len(c.views) >= MaxCachedThumbnails {
+		c.views = make(map[string][]byte)
+	}
+	c.views[key] = data
+	c.Unlock()
+	return data, nil
+}
+
+// serveImageView sends the thumbnail or preview of an image as a Gopher+
+// reply
+func (s *Server) serveImageView(ctx *Context, absPath string, info *os.FileInfo, view string) (Status, os.Error) {
+	var data []byte
+	var err os.Error
+	if view == ThumbnailView {
+		size := s.thumbnailSize()
+		data, err = s.imageView(view, absPath, info, func(m image.Image) ([]byte, os.Error) { return makeThumbnail(m, size) })
+		ctx.itemType = 'I'
+	} else {
+		data, err = s.imageView(view, absPath, info, func(m image.Image) ([]byte, os.Error) { return makePreview(m, PreviewWidth), nil })
+		ctx.itemType = '0'
+	}
+	if err != nil {
+		return StatusError, err
+	}
+	header := fmt.Sprintf("+%d", len(data))
+	if view == PreviewView {
+		header = "+-1"
+	}
+	if _, err = ctx.Write(header); err != nil {
+		return StatusError, err
+	}
+	if _, err = ctx.conn.Write(data); err != nil {
+		return StatusError, err
+	}
+	if view == PreviewView {
+		ctx.Write(".")
+	}
+	ctx.Logf("Served the %s view of `%s'\n", view, ctx.Request)
+	return StatusOK, nil
+}
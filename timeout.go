@@ -0,0 +1,151 @@
+package gopher
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// With HandlerTimeout set, or a timeout set for its route with
+// SetRouteTimeout, a handler that has not returned within its time is cut
+// off: the client gets an error item unless part of the response was
+// already written, later writes of the handler fail with ErrHandlerTimeout,
+// whether through the Context or straight to its connection, the channel
+// returned by its Context's Done method is closed, so a handler doing slow
+// work can stop early, and the connection is closed even in a kept-alive
+// session. Gophermap commands running longer than HandlerTimeout are
+// killed.
+
+// ErrHandlerTimeout is returned by the writes of a handler that has run
+// out of time
+var ErrHandlerTimeout = os.NewError("handler timed out")
+
+// deadline is the time budget of one handler run
+type deadline struct {
+	sync.Mutex
+	done    chan bool // Closed when the handler runs out of time
+	expired bool
+	wrote   bool // Whether the handler has written anything
+}
+
+// Done returns a channel that is closed when the request's handler runs
+// out of time. Handlers without a timeout get a channel that is never
+// closed.
+func (ctx *Context) Done() <-chan bool {
+	if ctx.deadline == nil {
+		return nil
+	}
+	return ctx.deadline.done
+}
+
+// Expired reports whether the request's handler has run out of time
+func (ctx *Context) Expired() bool {
+	d := ctx.deadline
+	if d == nil {
+		return false
+	}
+	d.Lock()
+	defer d.Unlock()
+	return d.expired
+}
+
+// deadlineConn is what a handler with a time budget writes to, whether
+// through the Context's methods or straight to its connection. Once the
+// handler is cut off its writes fail, so they cannot interleave with what
+// the server sends after.
+type deadlineConn struct {
+	net.Conn
+	d *deadline
+}
+
+func (c *deadlineConn) Write(b []byte) (int, os.Error) {
+	c.d.Lock()
+	defer c.d.Unlock()
+	if c.d.expired {
+		return 0, ErrHandlerTimeout
+	}
+	if len(b) > 0 {
+		c.d.wrote = true
+	}
+	return c.Conn.Write(b)
+}
+
+// flush sends what the response has buffered, unless the handler has been
+// cut off
+func (c *deadlineConn) flush(out *bufferedConn) os.Error {
+	c.d.Lock()
+	defer c.d.Unlock()
+	if c.d.expired {
+		return ErrHandlerTimeout
+	}
+	return out.Flush()
+}
+
+// expire cuts the handler off. The client gets an error item in its place
+// if the handler had not written anything yet.
+func (d *deadline) expire(ctx *Context) {
+	d.Lock()
+	d.expired = true
+	close(d.done)
+	wrote := d.wrote
+	d.Unlock()
+	if !wrote {
+		ctx.Error("This item took too long, please try again later")
+	}
+	ctx.Flush()
+}
+
+// SetRouteTimeout limits the handlers registered for pattern to nsec
+// nanoseconds, overriding HandlerTimeout; 0 leaves them unlimited
+func (s *Server) SetRouteTimeout(pattern string, nsec int64) os.Error {
+	found := false
+	for i := 0; i < s.routes.Len(); i++ {
+		if r := s.routes.At(i).(*route); r.pattern == pattern {
+			r.timeout = nsec
+			found = true
+		}
+	}
+	if !found {
+		return os.NewError(fmt.Sprintf("no route for %q", pattern))
+	}
+	return nil
+}
+
+// runHandler runs the handler of a route within its time budget
+func (s *Server) runHandler(ctx *Context, r *route) Status {
+	timeout := r.timeout
+	if timeout == 0 {
+		timeout = s.HandlerTimeout
+	}
+	if timeout <= 0 {
+		return r.handler.ServeGopher(ctx)
+	}
+	// The handler gets a copy of the context whose writes stop once it is
+	// cut off, so it cannot interleave with what the server sends after.
+	// The request's own context shares the deadline, so it knows.
+	d := &deadline{done: make(chan bool)}
+	ctx.deadline = d
+	hctx := *ctx
+	hctx.conn = &deadlineConn{ctx.conn, d}
+	result := make(chan Status, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				hctx.Logf("ERROR: Panic answering `%s': %v\n%s", hctx.Request, e, panicStack())
+				result <- StatusError
+			}
+		}()
+		result <- r.handler.ServeGopher(&hctx)
+	}()
+	select {
+	case status := <-result:
+		ctx.itemType = hctx.itemType
+		return status
+	case <-time.After(timeout):
+	}
+	d.expire(ctx)
+	ctx.Logf("ERROR: `%s' cut off: handler %s ran longer than %.3fs\n", ctx.Request, r.pattern, float64(timeout)/1e9)
+	return StatusError
+}
@@ -0,0 +1,52 @@
+package gopher_test
+
+import (
+	"gopher"
+	"gopher/gophertest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	s := newServer(t, nil)
+	cutOff := make(chan bool, 1)
+	s.HandleFunc("^/slow$", func(ctx *gopher.Context) gopher.Status {
+		select {
+		case <-ctx.Done():
+			cutOff <- true
+		case <-time.After(1e9):
+			cutOff <- false
+		}
+		ctx.Write("too late")
+		return gopher.StatusOK
+	})
+	s.HandleFunc("^/partial$", func(ctx *gopher.Context) gopher.Status {
+		ctx.Write("first part")
+		time.Sleep(200e6)
+		ctx.Write("second part")
+		return gopher.StatusOK
+	})
+	for _, pattern := range []string{"^/slow$", "^/partial$"} {
+		if err := s.SetRouteTimeout(pattern, 50e6); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	ts := gophertest.NewServer(s)
+	defer ts.Close()
+
+	rec := get(t, ts, "/slow")
+	if body := rec.Body.String(); strings.Index(rec.ErrorText(), "took too long") == -1 || strings.Index(body, "too late") != -1 {
+		t.Errorf("/slow: got %q, want only the timeout error item", body)
+	}
+	if !<-cutOff {
+		t.Errorf("/slow: Done was not closed when the handler ran out of time")
+	}
+
+	// What was written before the deadline is sent, with no error item
+	// appended to it
+	body := get(t, ts, "/partial").Body.String()
+	if strings.Index(body, "first part") == -1 || strings.Index(body, "second part") != -1 || strings.Index(body, "took too long") != -1 {
+		t.Errorf("/partial: got %q, want only the first part", body)
+	}
+}
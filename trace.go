@@ -0,0 +1,74 @@
+package gopher
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every connection gets an ID, logged with each line about its request so
+// the lines of one request can be told apart from those of others served
+// at the same time. With Trace set the time spent in each phase of a
+// request is logged as well:
+//    accept    waiting to be picked up after the connection was accepted
+//    read      reading the selector
+//    open      finding what the selector refers to
+//    transfer  sending the response
+
+// requestIDs hands out request IDs, unique to the process and unlikely to
+// repeat across restarts
+type requestIDs struct {
+	sync.Mutex
+	prefix string
+	next   int64
+}
+
+func (r *requestIDs) newID() string {
+	r.Lock()
+	defer r.Unlock()
+	if r.prefix == "" {
+		r.prefix = strconv.Itob64(time.Seconds(), 36)
+	}
+	r.next++
+	return r.prefix + "-" + strconv.Itob64(r.next, 36)
+}
+
+// tracePhase marks the end of a phase of a request
+type tracePhase struct {
+	name string
+	end  int64
+}
+
+// newContext returns the context of a connection accepted at accepted
+func (s *Server) newContext(conn net.Conn, accepted int64) *Context {
+	return &Context{conn: conn, Server: s, ID: s.requestIDs.newID(), accepted: accepted}
+}
+
+// Logf logs a message about the request, tagged with its ID
+func (ctx *Context) Logf(format string, v ...interface{}) {
+	ctx.log(&LogEntry{RequestID: ctx.ID, Message: strings.TrimRight(fmt.Sprintf(format, v...), "\n")})
+}
+
+// mark ends the named phase of the request, if it is being traced
+func (ctx *Context) mark(phase string) {
+	if ctx.Server.Trace {
+		ctx.phases = append(ctx.phases, tracePhase{phase, time.Nanoseconds()})
+	}
+}
+
+// logTrace logs the time spent in each phase of the request
+func (ctx *Context) logTrace() {
+	if !ctx.Server.Trace || len(ctx.phases) == 0 {
+		return
+	}
+	fields := make([]string, len(ctx.phases))
+	last := ctx.accepted
+	for i, p := range ctx.phases {
+		fields[i] = fmt.Sprintf("%s=%.6fs", p.name, float64(p.end-last)/1e9)
+		last = p.end
+	}
+	ctx.Logf("TRACE: %s\n", strings.Join(fields, " "))
+}
@@ -0,0 +1,35 @@
+package gopher
+
+import (
+	"net"
+	"os"
+)
+
+// Behind a reverse proxy or a supervisor the server can listen on a unix
+// socket instead of TCP. Menus still point at Hostname and Port, the
+// address clients reach the proxy on, since the socket has none a client
+// could use. Requests on a socket come from ClientIP "unix" unless a PROXY
+// header names the client.
+
+// ListenAndServeUnix listens on the unix socket at path, replacing a stale
+// one, and serves it
+func (s *Server) ListenAndServeUnix(path string) os.Error {
+	l, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// listenUnix listens on the unix socket at path, removing whatever a
+// previous run left there
+func listenUnix(path string) (net.Listener, os.Error) {
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// isUnix reports whether addr is a unix socket address
+func isUnix(addr net.Addr) bool {
+	_, ok := addr.(*net.UnixAddr)
+	return ok
+}
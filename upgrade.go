@@ -0,0 +1,104 @@
+package gopher
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A running server can be replaced by a new build of itself without
+// refusing a single client. Upgrade starts the program again with the same
+// arguments and hands it the listening socket as file descriptor 3, named
+// by UpgradeFDVariable in its environment. The new process serves the
+// socket as soon as it is up, while the old one stops accepting, finishes
+// the requests it has and returns from Run. Only the TCP socket Run
+// listens on is handed over; other listeners, such as finger or metrics,
+// are bound by the new process and stay down there until the old one has
+// exited. A server that switched user starts its successor as that user,
+// which cannot bind them again if their ports are below 1024: they stay
+// down until the next full restart. A chrooted server cannot upgrade, as
+// the program is out of its reach.
+
+// UpgradeFDVariable names the environment variable that tells a new
+// process which file descriptor holds the inherited listening socket
+const UpgradeFDVariable = "GOPHER_LISTEN_FD"
+
+// DrainTimeout is how long a server being replaced waits for the requests
+// in progress, in nanoseconds
+var DrainTimeout int64 = 30e9
+
+// inheritedListener returns the listener handed over by the process this
+// one replaces, or nil if there is none
+func inheritedListener() (net.Listener, os.Error) {
+	fd, err := strconv.Atoi(os.Getenv(UpgradeFDVariable))
+	if err != nil || fd < 3 {
+		return nil, nil
+	}
+	os.Setenv(UpgradeFDVariable, "")
+	return net.FileListener(os.NewFile(fd, "inherited"))
+}
+
+// Upgrade starts a new copy of the program, hands it the listener Run is
+// serving and stops accepting connections. Run returns once the requests
+// in progress have been answered.
+func (s *Server) Upgrade() os.Error {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	if s.upgraded {
+		return os.NewError("already handed over to a new process")
+	}
+	tcp, ok := s.listener.(*net.TCPListener)
+	if !ok {
+		return os.NewError("only a TCP listener served by Run can be handed over")
+	}
+	if s.ReusePort {
+		return os.NewError("with SO_REUSEPORT, start the new process alongside and stop this one instead")
+	}
+	if s.Chroot {
+		return os.NewError("cannot start the program again from inside a chroot")
+	}
+	file, err := tcp.File()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	env := []string{}
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, UpgradeFDVariable+"=") {
+			env = append(env, v)
+		}
+	}
+	env = append(env, UpgradeFDVariable+"=3")
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	pid, err := os.ForkExec(os.Args[0], os.Args, env, dir, []*os.File{os.Stdin, os.Stdout, os.Stderr, file})
+	if err != nil {
+		return err
+	}
+	s.Logger.Printf("Handed %s over to process %d; finishing requests in progress\n", tcp.Addr(), pid)
+	s.upgraded = true
+	return tcp.Close()
+}
+
+// upgrading reports whether the server has handed its listener over
+func (s *Server) upgrading() bool {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	return s.upgraded
+}
+
+// drain waits up to DrainTimeout for the queued and active connections to
+// be answered
+func (s *Server) drain() {
+	for start := time.Nanoseconds(); time.Nanoseconds()-start < DrainTimeout; {
+		if len(s.queue) == 0 && s.Stats().ActiveConnections == 0 {
+			return
+		}
+		time.Sleep(100e6)
+	}
+	s.Logger.Printf("ERROR: Gave up waiting for %d connections\n", s.Stats().ActiveConnections+int64(len(s.queue)))
+}
@@ -0,0 +1,318 @@
+package gopher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ProxyHandler answers the selectors below Prefix from other Gopher
+// servers, so several servers can be offered under one hostname. A request
+// for Prefix/rest is sent upstream as Root/rest, search string included,
+// and replies that look like menus have the entries pointing back into
+// Root on an upstream server rewritten to point below Prefix here:
+//
+//    proxy /archive archive.example.org:70
+//    proxy /phlog phlog.example.org /users/jdp
+//    proxy /mirror a.example.org,b.example.org:7070
+//
+// Upstream selectors not beginning with Root, or not path-like below it,
+// are left pointing at the upstream server.
+//
+// With several upstreams, which should serve the same content, requests
+// are spread over them in turn, or with ProxyBalance set to
+// BalanceLeastConn to the one with the fewest requests in progress. An
+// upstream that cannot be connected to is taken out of the rotation, and
+// the next one tried, until a health check every ProxyCheckInterval finds
+// it accepting connections again.
+
+// ProxyTimeout is how long an upstream server has to answer, in
+// nanoseconds
+var ProxyTimeout int64 = 30e9
+
+// ProxyCheckInterval is how often upstream servers are checked, in
+// nanoseconds
+var ProxyCheckInterval int64 = 10e9
+
+// Ways of picking an upstream server for a request
+const (
+	BalanceRoundRobin = iota
+	BalanceLeastConn
+)
+
+// ParseBalanceMode converts a balancing mode name into a Balance* constant
+func ParseBalanceMode(mode string) (int, os.Error) {
+	switch mode {
+	case "", "round-robin":
+		return BalanceRoundRobin, nil
+	case "least-conn":
+		return BalanceLeastConn, nil
+	}
+	return BalanceRoundRobin, os.NewError(fmt.Sprintf("unknown balancing mode `%s'", mode))
+}
+
+// ProxyHandler forwards a selector subtree to upstream servers
+type ProxyHandler struct {
+	Prefix    string
+	Upstreams []string // host:port of each upstream server; the port defaults to 70
+	Root      string   // Selector on the upstream servers that Prefix stands for
+
+	mu     sync.Mutex
+	next   int // Where the next round-robin pick starts
+	down   map[string]bool
+	active map[string]int // Requests in progress on each upstream
+}
+
+// AddProxy forwards the selectors below prefix to root on the servers at
+// upstreams
+func (s *Server) AddProxy(prefix string, upstreams []string, root string) {
+	s.proxies = append(s.proxies, &ProxyHandler{Prefix: "/" + strings.Trim(prefix, "/"), Upstreams: upstreams, Root: strings.TrimRight(root, "/")})
+}
+
+// configureProxy handles a `proxy prefix host[:port][,host[:port]...]
+// [selector]' line
+func (s *Server) configureProxy(args []string) os.Error {
+	if len(args) != 2 && len(args) != 3 {
+		return os.NewError("expected a selector prefix, upstream host:ports and optionally an upstream selector")
+	}
+	root := ""
+	if len(args) == 3 {
+		root = args[2]
+	}
+	s.AddProxy(args[0], strings.Split(args[1], ",", -1), root)
+	return nil
+}
+
+// proxyFor returns the proxy handling selector, if any
+func (s *Server) proxyFor(selector string) *ProxyHandler {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, p := range s.proxies {
+		if selector == p.Prefix || strings.HasPrefix(selector, p.Prefix+"/") {
+			return p
+		}
+	}
+	return nil
+}
+
+// pick returns the upstream to try next, skipping those in tried, or ""
+// once every upstream has been tried. Upstreams that are down are only
+// tried when no other is left.
+func (p *ProxyHandler) pick(balance int, tried map[string]bool) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	best := ""
+	for _, healthy := range []bool{true, false} {
+		for i := range p.Upstreams {
+			addr := p.Upstreams[(p.next+i)%len(p.Upstreams)]
+			if tried[addr] || p.down[addr] == healthy {
+				continue
+			}
+			if best == "" || balance == BalanceLeastConn && p.active[addr] < p.active[best] {
+				best = addr
+			}
+		}
+		if best != "" {
+			break
+		}
+	}
+	if best != "" {
+		p.next++
+		if p.active == nil {
+			p.active = make(map[string]int)
+		}
+		p.active[best]++
+	}
+	return best
+}
+
+// done records the end of a request to addr
+func (p *ProxyHandler) done(addr string) {
+	p.mu.Lock()
+	p.active[addr]--
+	p.mu.Unlock()
+}
+
+// setDown takes addr out of the rotation, or puts it back
+func (p *ProxyHandler) setDown(addr string, down bool) {
+	p.mu.Lock()
+	if p.down == nil {
+		p.down = make(map[string]bool)
+	}
+	p.down[addr] = down
+	p.mu.Unlock()
+}
+
+// check connects to each upstream and updates whether it is in the
+// rotation
+func (p *ProxyHandler) check(s *Server) {
+	for _, addr := range p.Upstreams {
+		go func(addr string) {
+			conn, err := Dial(addr)
+			p.mu.Lock()
+			wasDown := p.down[addr]
+			p.mu.Unlock()
+			if err != nil {
+				if !wasDown {
+					s.Logger.Printf("ERROR: Upstream %s for `%s' is down: %s\n", addr, p.Prefix, err)
+				}
+				p.setDown(addr, true)
+				return
+			}
+			conn.Close()
+			if wasDown {
+				s.Logger.Printf("Upstream %s for `%s' is back up\n", addr, p.Prefix)
+			}
+			p.setDown(addr, false)
+		}(addr)
+	}
+}
+
+// checkUpstreams checks the upstreams of every proxy every
+// ProxyCheckInterval
+func (s *Server) checkUpstreams() {
+	for {
+		time.Sleep(ProxyCheckInterval)
+		s.reloadMu.RLock()
+		proxies := s.proxies
+		s.reloadMu.RUnlock()
+		for _, p := range proxies {
+			p.check(s)
+		}
+	}
+}
+
+// upstreamHost returns the host and port of an upstream server
+func upstreamHost(addr string) (string, int) {
+	host, port := addr, 70
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		if n, err := strconv.Atoi(host[i+1:]); err == nil {
+			host, port = host[:i], n
+		}
+	}
+	return strings.ToLower(host), port
+}
+
+// local returns the selector here for an entry of an upstream menu, if it
+// points into the proxied subtree
+func (p *ProxyHandler) local(entry *MenuEntry) (string, bool) {
+	if !strings.HasPrefix(entry.Selector, p.Root) {
+		return "", false
+	}
+	upstream := false
+	for _, addr := range p.Upstreams {
+		host, port := upstreamHost(addr)
+		if strings.ToLower(entry.Host) == host && entry.Port == port {
+			upstream = true
+			break
+		}
+	}
+	rest := entry.Selector[len(p.Root):]
+	if !upstream || rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return p.Prefix + rest, true
+}
+
+// looksLikeMenu reports whether the first line of a reply is a menu line
+func looksLikeMenu(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "." {
+		return true
+	}
+	if strings.Count(line, "\t") < 3 {
+		return false
+	}
+	entry, err := ParseMenuLine(line)
+	return err == nil && entry.Port > 0
+}
+
+// ServeGopher forwards the request upstream and relays the reply
+func (p *ProxyHandler) ServeGopher(ctx *Context) Status {
+	selector := p.Root + ctx.Request[len(p.Prefix):]
+	if ctx.Query != "" {
+		selector += "\t" + ctx.Query
+	}
+	tried := make(map[string]bool)
+	for {
+		addr := p.pick(ctx.Server.ProxyBalance, tried)
+		if addr == "" {
+			return StatusError
+		}
+		tried[addr] = true
+		resp, err := Get(addr, selector)
+		if err != nil {
+			ctx.Logf("ERROR: Upstream %s for `%s' failed: %s\n", addr, ctx.Request, err)
+			p.setDown(addr, true)
+			p.done(addr)
+			continue
+		}
+		status := p.relay(ctx, addr, resp)
+		resp.Close()
+		p.done(addr)
+		return status
+	}
+	return StatusError
+}
+
+// relay sends the reply of the upstream at addr on to the client
+func (p *ProxyHandler) relay(ctx *Context, addr string, resp *Response) Status {
+	resp.conn.SetReadTimeout(ProxyTimeout)
+	first, err := resp.reader.ReadString('\n')
+	if err != nil && err != os.EOF {
+		ctx.Logf("ERROR: Upstream %s for `%s' failed: %s\n", addr, ctx.Request, err)
+		return StatusError
+	}
+	if looksLikeMenu(first) {
+		return p.relayMenu(ctx, addr, io.MultiReader(strings.NewReader(first), &textReader{reader: resp.reader}))
+	}
+	ctx.itemType = '9'
+	if _, err = io.WriteString(ctx.conn, first); err == nil {
+		_, err = io.Copy(ctx.conn, resp.reader)
+	}
+	if err != nil {
+		ctx.Logf("ERROR: Relaying `%s' from %s failed: %s\n", ctx.Request, addr, err)
+		return StatusError
+	}
+	ctx.Logf("Proxied `%s' from %s\n", ctx.Request, addr)
+	return StatusOK
+}
+
+// relayMenu sends an upstream menu with the entries pointing into the
+// proxied subtree rewritten to point here
+func (p *ProxyHandler) relayMenu(ctx *Context, addr string, r io.Reader) Status {
+	w := NewEntryWriter(ctx)
+	w.SkipBanner()
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != os.EOF {
+			ctx.Logf("ERROR: Relaying menu `%s' from %s failed: %s\n", ctx.Request, addr, err)
+			return StatusError
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			entry, perr := ParseMenuLine(line)
+			if perr != nil {
+				entry = &MenuEntry{Type: 'i', Display: line}
+			}
+			if selector, ok := p.local(entry); ok {
+				entry.Selector, entry.Host, entry.Port = selector, "", 0
+			}
+			w.Write(entry)
+		}
+		if err == os.EOF {
+			break
+		}
+	}
+	if err := w.Close(); err != nil {
+		return StatusError
+	}
+	ctx.Logf("Proxied menu `%s' from %s\n", ctx.Request, addr)
+	return StatusOK
+}
@@ -0,0 +1,131 @@
+package gopher
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// URL is a gopher:// URL as described by RFC 4266:
+//    gopher://host[:port]/<type><selector>[%09<search>]
+type URL struct {
+	Host     string
+	Port     int
+	Type     byte
+	Selector string
+	Query    string // Search string sent after a tab, for type 7 items
+}
+
+// ParseURL parses a gopher:// URL. A missing port is 70 and a missing path
+// is the root menu.
+func ParseURL(rawurl string) (*URL, os.Error) {
+	const scheme = "gopher://"
+	if len(rawurl) < len(scheme) || strings.ToLower(rawurl[:len(scheme)]) != scheme {
+		return nil, os.NewError(fmt.Sprintf("not a gopher URL: %q", rawurl))
+	}
+	rest := rawurl[len(scheme):]
+	u := &URL{Port: 70, Type: '1'}
+	hostport := rest
+	if i := strings.Index(rest, "/"); i != -1 {
+		hostport, rest = rest[:i], rest[i+1:]
+	} else {
+		rest = ""
+	}
+	u.Host = hostport
+	if i := strings.LastIndex(hostport, ":"); i != -1 && !strings.HasSuffix(hostport, "]") {
+		port, err := strconv.Atoi(hostport[i+1:])
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("bad port in gopher URL %q", rawurl))
+		}
+		u.Host, u.Port = hostport[:i], port
+	}
+	u.Host = strings.Trim(u.Host, "[]")
+	if u.Host == "" {
+		return nil, os.NewError(fmt.Sprintf("missing host in gopher URL %q", rawurl))
+	}
+	if rest == "" {
+		return u, nil
+	}
+	u.Type = rest[0]
+	path := rest[1:]
+	// A second %09 introduces a Gopher+ string, which is dropped
+	parts := strings.Split(path, "%09", 3)
+	var err os.Error
+	if u.Selector, err = unescapeURL(parts[0]); err != nil {
+		return nil, err
+	}
+	if len(parts) > 1 {
+		if u.Query, err = unescapeURL(parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// Addr returns the host and port to dial
+func (u *URL) Addr() string {
+	return fmt.Sprintf("%s:%d", u.Host, u.Port)
+}
+
+// String renders the URL, leaving out the default port
+func (u *URL) String() string {
+	host := u.Host
+	if strings.Index(host, ":") != -1 {
+		host = "[" + host + "]"
+	}
+	if u.Port != 70 {
+		host = fmt.Sprintf("%s:%d", host, u.Port)
+	}
+	s := fmt.Sprintf("gopher://%s/%c%s", host, u.Type, escapeURL(u.Selector))
+	if u.Query != "" {
+		s += "%09" + escapeURL(u.Query)
+	}
+	return s
+}
+
+// Get fetches the item the URL points at
+func (u *URL) Get() (*Response, os.Error) {
+	selector := u.Selector
+	if u.Query != "" {
+		selector += "\t" + u.Query
+	}
+	return Get(u.Addr(), selector)
+}
+
+// escapeURL percent-encodes everything but unreserved characters and slashes
+func escapeURL(s string) string {
+	const hex = "0123456789ABCDEF"
+	escaped := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			escaped = append(escaped, c)
+		default:
+			escaped = append(escaped, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return string(escaped)
+}
+
+func unescapeURL(s string) (string, os.Error) {
+	unescaped := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			unescaped = append(unescaped, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", os.NewError(fmt.Sprintf("bad escape in %q", s))
+		}
+		c, err := strconv.Btoui64(s[i+1:i+3], 16)
+		if err != nil {
+			return "", os.NewError(fmt.Sprintf("bad escape in %q", s))
+		}
+		unescaped = append(unescaped, byte(c))
+		i += 2
+	}
+	return string(unescaped), nil
+}
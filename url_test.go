@@ -0,0 +1,56 @@
+package gopher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		raw string
+		url URL
+	}{
+		{"gopher://example.org", URL{Host: "example.org", Port: 70, Type: '1'}},
+		{"gopher://example.org/", URL{Host: "example.org", Port: 70, Type: '1'}},
+		{"GOPHER://example.org:7070/0/about.txt", URL{Host: "example.org", Port: 7070, Type: '0', Selector: "/about.txt"}},
+		{"gopher://example.org/7/search%09gopher%20servers", URL{Host: "example.org", Port: 70, Type: '7', Selector: "/search", Query: "gopher servers"}},
+		// A Gopher+ string after a second tab is dropped
+		{"gopher://example.org/7/s%09q%09+", URL{Host: "example.org", Port: 70, Type: '7', Selector: "/s", Query: "q"}},
+		{"gopher://[2001:db8::1]:71/1/", URL{Host: "2001:db8::1", Port: 71, Type: '1', Selector: "/"}},
+	}
+	for _, test := range tests {
+		u, err := ParseURL(test.raw)
+		if err != nil {
+			t.Errorf("%s: %s", test.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(*u, test.url) {
+			t.Errorf("%s: got %+v, want %+v", test.raw, *u, test.url)
+		}
+	}
+	for _, raw := range []string{"http://example.org/", "gopher://", "gopher://example.org:x/", "gopher://example.org/0/a%4"} {
+		if u, err := ParseURL(raw); err == nil {
+			t.Errorf("%s: got %+v, want an error", raw, *u)
+		}
+	}
+}
+
+func TestURLString(t *testing.T) {
+	tests := []struct {
+		url URL
+		raw string
+	}{
+		{URL{Host: "example.org", Port: 70, Type: '1'}, "gopher://example.org/1"},
+		{URL{Host: "example.org", Port: 7070, Type: '0', Selector: "/a b.txt"}, "gopher://example.org:7070/0/a%20b.txt"},
+		{URL{Host: "2001:db8::1", Port: 70, Type: '7', Selector: "/s", Query: "q"}, "gopher://[2001:db8::1]/7/s%09q"},
+	}
+	for _, test := range tests {
+		if got := test.url.String(); got != test.raw {
+			t.Errorf("%+v: got %s, want %s", test.url, got, test.raw)
+		}
+		// Rendering and parsing back are inverses
+		if u, err := ParseURL(test.raw); err != nil || !reflect.DeepEqual(*u, test.url) {
+			t.Errorf("%s: parsed back as %+v, %v", test.raw, u, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package gopher
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Content need not live in the host's file system. A FileSystem mounted
+// with Mount at a path answers for every name below that path, so
+//    server.Mount("/srv/gopher", fs)
+// serves fs as a document root of /srv/gopher, and the sidecar files,
+// gophermaps and listings below it come from fs as well. Names outside
+// every mount are looked up on disk. Files from a mounted FileSystem are
+// neither mapped into the file cache nor offered gzipped or resumed, and
+// gophermap commands cannot run in their directories.
+
+// File is an open file or directory of a FileSystem
+type File interface {
+	io.ReadCloser
+	Name() string
+	Stat() (*os.FileInfo, os.Error)
+	Readdir(count int) ([]os.FileInfo, os.Error)
+}
+
+// FileSystem is a tree of files content can be served from. Names are
+// slash separated and start with "/", the top of the tree.
+type FileSystem interface {
+	Open(name string) (File, os.Error)
+	Stat(name string) (*os.FileInfo, os.Error)
+	ReadDir(name string) ([]os.FileInfo, os.Error)
+}
+
+// OSFileSystem is the file system of the host, with names taken as they are
+var OSFileSystem FileSystem = osFileSystem{}
+
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (File, os.Error) {
+	f, err := os.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		// A nil *os.File must not become a non-nil File
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFileSystem) Stat(name string) (*os.FileInfo, os.Error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) ReadDir(name string) ([]os.FileInfo, os.Error) {
+	f, err := os.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+// listedFile is an open file of a FileSystem whose directories are listed
+// whole
+type listedFile struct {
+	name string
+	info *os.FileInfo
+	body io.ReadCloser                    // Contents of a file, nil for a directory
+	list func() ([]os.FileInfo, os.Error) // Lists a directory
+	read bool                             // Whether a directory has been listed
+}
+
+func (f *listedFile) Name() string {
+	return f.name
+}
+
+func (f *listedFile) Read(b []byte) (int, os.Error) {
+	if f.body == nil {
+		return 0, &os.PathError{"read", f.name, os.EISDIR}
+	}
+	return f.body.Read(b)
+}
+
+func (f *listedFile) Close() os.Error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+func (f *listedFile) Stat() (*os.FileInfo, os.Error) {
+	return f.info, nil
+}
+
+// Readdir returns the directory's entries all at once, whatever count is
+func (f *listedFile) Readdir(count int) ([]os.FileInfo, os.Error) {
+	if f.list == nil {
+		return nil, &os.PathError{"readdir", f.name, os.ENOTDIR}
+	}
+	if f.read {
+		if count > 0 {
+			return nil, os.EOF
+		}
+		return nil, nil
+	}
+	f.read = true
+	return f.list()
+}
+
+// mount is a FileSystem answering for the names below a path
+type mount struct {
+	path       string
+	fs         FileSystem
+	closer     io.Closer // Closed once the mount is no longer served, if set
+	configured bool      // From the configuration, replaced on reload
+}
+
+// mountedFile is a file of a mounted FileSystem, named by its full path
+type mountedFile struct {
+	File
+	name string
+}
+
+func (f *mountedFile) Name() string {
+	return f.name
+}
+
+// Mount serves fs in place of the files below dir, which is typically the
+// document root of the server or of a virtual host
+func (s *Server) Mount(dir string, fs FileSystem) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.mounts = append(s.mounts, &mount{path.Clean(dir), fs, nil, false})
+}
+
+// Mount serves fs in place of the files below dir on DefaultServer
+func Mount(dir string, fs FileSystem) {
+	DefaultServer.Mount(dir, fs)
+}
+
+// fileSystem returns the FileSystem holding the file at name, and its name
+// there
+func (s *Server) fileSystem(name string) (FileSystem, string) {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, m := range s.mounts {
+		if name == m.path || strings.HasPrefix(name, m.path+"/") {
+			return m.fs, "/" + strings.TrimLeft(name[len(m.path):], "/")
+		}
+	}
+	return OSFileSystem, name
+}
+
+// mounted reports whether the file at name comes from a mounted FileSystem
+func (s *Server) mounted(name string) bool {
+	fs, _ := s.fileSystem(name)
+	return fs != OSFileSystem
+}
+
+// openFile opens the file at name from the FileSystem holding it
+func (s *Server) openFile(name string) (File, os.Error) {
+	fs, inner := s.fileSystem(name)
+	if fs == OSFileSystem {
+		return fs.Open(name)
+	}
+	f, err := fs.Open(inner)
+	if err != nil {
+		return nil, err
+	}
+	return &mountedFile{f, name}, nil
+}
+
+// statFile returns the FileInfo of the file at name from the FileSystem
+// holding it
+func (s *Server) statFile(name string) (*os.FileInfo, os.Error) {
+	fs, inner := s.fileSystem(name)
+	return fs.Stat(inner)
+}
+
+// readFile returns the contents of the file at name from the FileSystem
+// holding it
+func (s *Server) readFile(name string) ([]byte, os.Error) {
+	fs, inner := s.fileSystem(name)
+	if fs == OSFileSystem {
+		return ioutil.ReadFile(name)
+	}
+	f, err := fs.Open(inner)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,164 @@
+package gopher
+
+import (
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// VirtualHost is a site served by the server. A connection belongs to the
+// virtual host whose Name matches the local address it arrived on, either
+// as an IP or as IP:port; connections matching none use the server's own
+// settings.
+type VirtualHost struct {
+	Name            string
+	Root            string // Document root, the server's unless configured
+	CaseInsensitive bool   // Match selectors to file names regardless of case
+	LogLevel        int    // Verbosity of request logging, the server's if LogInherit
+	logger          *Logger
+	index           caseIndex
+	acl             []*ACLRule
+}
+
+// virtualHost returns the virtual host serving connections to addr
+func (s *Server) virtualHost(addr net.Addr) *VirtualHost {
+	local := addr.String()
+	ip := local
+	if host, _, err := net.SplitHostPort(local); err == nil {
+		ip = host
+	}
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	for _, vh := range s.vhosts {
+		if vh.Name == local || vh.Name == ip {
+			return vh
+		}
+	}
+	return s.defaultHost
+}
+
+// VirtualHosts returns the configured virtual hosts
+func (s *Server) VirtualHosts() []*VirtualHost {
+	s.reloadMu.RLock()
+	defer s.reloadMu.RUnlock()
+	return s.vhosts
+}
+
+// vhostDirectives are the configuration directives allowed in a vhost block
+var vhostDirectives = map[string]func(vh *VirtualHost, args []string) os.Error{
+	"root": func(vh *VirtualHost, args []string) (err os.Error) {
+		if vh.Root, err = configString(args); err == nil {
+			vh.Root = path.Clean(vh.Root)
+		}
+		return
+	},
+	"case-insensitive": func(vh *VirtualHost, args []string) (err os.Error) {
+		vh.CaseInsensitive, err = configBool(args)
+		return
+	},
+	"log": func(vh *VirtualHost, args []string) (err os.Error) {
+		if len(args) == 0 {
+			return os.NewError("expected stdout, stderr, syslog or a file name")
+		}
+		closeLogger(vh.logger)
+		vh.logger, err = openLogger(args)
+		return
+	},
+	"log-level": func(vh *VirtualHost, args []string) (err os.Error) {
+		var level string
+		if level, err = configString(args); err == nil {
+			vh.LogLevel, err = ParseLogLevel(level)
+		}
+		return
+	},
+	"allow": func(vh *VirtualHost, args []string) os.Error {
+		r, err := ParseACLRule(true, args)
+		if err == nil {
+			vh.acl = append(vh.acl, r)
+		}
+		return err
+	},
+	"deny": func(vh *VirtualHost, args []string) os.Error {
+		r, err := ParseACLRule(false, args)
+		if err == nil {
+			vh.acl = append(vh.acl, r)
+		}
+		return err
+	},
+}
+
+// resolve maps a selector onto the on-disk spelling of its path below the
+// root of vh, if the host is case insensitive
+func (s *Server) resolve(vh *VirtualHost, selector string) string {
+	if !vh.CaseInsensitive {
+		return selector
+	}
+	dir := vh.Root
+	resolved := ""
+	for _, part := range strings.Split(strings.Trim(selector, "/"), "/", -1) {
+		if part == "" {
+			continue
+		}
+		name := vh.index.lookup(s, dir+"/", part)
+		dir += "/" + name
+		resolved += "/" + name
+	}
+	if resolved == "" {
+		return "/"
+	}
+	return resolved
+}
+
+// caseIndex caches the names in each directory by their lower case form.
+// A directory is read again when its modification time changes.
+type caseIndex struct {
+	sync.Mutex
+	dirs map[string]*caseDir
+}
+
+type caseDir struct {
+	mtime int64
+	names map[string]string // Lower case name to on-disk name
+	exact map[string]bool
+}
+
+// lookup returns the on-disk name in dir matching name, preferring an exact
+// match, or name itself if there is none
+func (ci *caseIndex) lookup(s *Server, dir string, name string) string {
+	fs, inner := s.fileSystem(path.Clean(dir))
+	info, err := fs.Stat(inner)
+	if err != nil || !info.IsDirectory() {
+		return name
+	}
+	ci.Lock()
+	defer ci.Unlock()
+	if ci.dirs == nil {
+		ci.dirs = make(map[string]*caseDir)
+	}
+	cd, ok := ci.dirs[dir]
+	if !ok || cd.mtime != info.Mtime_ns {
+		infos, err := fs.ReadDir(inner)
+		if err != nil {
+			return name
+		}
+		cd = &caseDir{info.Mtime_ns, make(map[string]string), make(map[string]bool)}
+		for i := range infos {
+			n := infos[i].Name
+			cd.exact[n] = true
+			lower := strings.ToLower(n)
+			if prev, seen := cd.names[lower]; !seen || n < prev {
+				cd.names[lower] = n
+			}
+		}
+		ci.dirs[dir] = cd
+	}
+	if cd.exact[name] {
+		return name
+	}
+	if actual, ok := cd.names[strings.ToLower(name)]; ok {
+		return actual
+	}
+	return name
+}
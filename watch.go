@@ -0,0 +1,129 @@
+package gopher
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+)
+
+// With WatchFiles set, sidecar files (gophermaps and templates, headers and
+// footers, licenses, abstracts, names files and included maps) are kept in
+// memory once read, as is the fact that one does not exist, and dropped as
+// soon as the file system reports a change in their directory. Where the
+// file system cannot be watched the "watcher" subsystem is down and
+// nothing is cached.
+
+// MaxCachedSidecars bounds the number of sidecar files kept in memory
+var MaxCachedSidecars = 10000
+
+// fileWatcher reports changes to the files of the directories it watches
+type fileWatcher interface {
+	Watch(dir string) os.Error
+	Close() os.Error
+}
+
+type cachedSidecar struct {
+	data []byte
+	err  os.Error
+}
+
+// sidecarCache holds sidecar files read from watched directories
+type sidecarCache struct {
+	sync.RWMutex
+	watcher    fileWatcher
+	files      map[string]*cachedSidecar
+	watched    map[string]bool
+	generation int64 // Counts invalidations, so reads racing one are not kept
+}
+
+// startWatcher starts caching sidecar files
+func (s *Server) startWatcher() os.Error {
+	c := &sidecarCache{files: make(map[string]*cachedSidecar), watched: make(map[string]bool)}
+	changed := func(name string) {
+		c.changed(name)
+		s.files.drop(name)
+		s.missing.forget(name)
+	}
+	reset := func() {
+		c.reset()
+		s.files.clear()
+		s.missing.clear()
+	}
+	w, err := newFileWatcher(changed, reset)
+	if err != nil {
+		return err
+	}
+	c.watcher = w
+	s.sidecars = c
+	return nil
+}
+
+// read returns the contents of the named file, from memory if it has not
+// changed since it was last read
+func (c *sidecarCache) read(name string) ([]byte, os.Error) {
+	c.RLock()
+	f, ok := c.files[name]
+	c.RUnlock()
+	if ok {
+		return f.data, f.err
+	}
+	generation, watching := c.watch(path.Dir(name))
+	data, err := ioutil.ReadFile(name)
+	if !watching || (err != nil && !notExist(err)) {
+		return data, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.generation == generation {
+		if len(c.files) >= MaxCachedSidecars {
+			c.files = make(map[string]*cachedSidecar)
+		}
+		c.files[name] = &cachedSidecar{data, err}
+	}
+	return data, err
+}
+
+// watch makes sure dir is watched, returning the current generation
+func (c *sidecarCache) watch(dir string) (int64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.watched[dir] {
+		if err := c.watcher.Watch(dir); err != nil {
+			return c.generation, false
+		}
+		c.watched[dir] = true
+	}
+	return c.generation, true
+}
+
+// changedSince reports whether anything has been invalidated since
+// generation
+func (c *sidecarCache) changedSince(generation int64) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.generation != generation
+}
+
+// changed drops the named file
+func (c *sidecarCache) changed(name string) {
+	c.Lock()
+	c.files[name] = nil, false
+	c.generation++
+	c.Unlock()
+}
+
+// reset drops everything, for when changes may have been missed
+func (c *sidecarCache) reset() {
+	c.Lock()
+	c.files = make(map[string]*cachedSidecar)
+	c.watched = make(map[string]bool)
+	c.generation++
+	c.Unlock()
+}
+
+// notExist reports whether err says a file does not exist
+func notExist(err os.Error) bool {
+	pe, ok := err.(*os.PathError)
+	return ok && pe.Error == os.ENOENT
+}
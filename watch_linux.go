@@ -0,0 +1,52 @@
+package gopher
+
+import (
+	"os"
+	"os/inotify"
+)
+
+const inotifyFlags = inotify.IN_CREATE | inotify.IN_DELETE | inotify.IN_MODIFY | inotify.IN_ATTRIB |
+	inotify.IN_MOVED_FROM | inotify.IN_MOVED_TO | inotify.IN_DELETE_SELF | inotify.IN_MOVE_SELF
+
+// inotifyWatcher watches directories with inotify
+type inotifyWatcher struct {
+	w *inotify.Watcher
+}
+
+// newFileWatcher calls changed with the path of every file that changes in
+// a watched directory, and reset when changes may have been missed
+func newFileWatcher(changed func(name string), reset func()) (fileWatcher, os.Error) {
+	w, err := inotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Event:
+				if !ok {
+					return
+				}
+				if ev.Mask&(inotify.IN_Q_OVERFLOW|inotify.IN_IGNORED|inotify.IN_DELETE_SELF|inotify.IN_MOVE_SELF) != 0 {
+					reset()
+				} else {
+					changed(ev.Name)
+				}
+			case _, ok := <-w.Error:
+				if !ok {
+					return
+				}
+				reset()
+			}
+		}
+	}()
+	return &inotifyWatcher{w}, nil
+}
+
+func (iw *inotifyWatcher) Watch(dir string) os.Error {
+	return iw.w.AddWatch(dir, inotifyFlags)
+}
+
+func (iw *inotifyWatcher) Close() os.Error {
+	return iw.w.Close()
+}
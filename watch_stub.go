@@ -0,0 +1,10 @@
+package gopher
+
+import (
+	"os"
+)
+
+// newFileWatcher fails where there is no way to watch files yet
+func newFileWatcher(changed func(name string), reset func()) (fileWatcher, os.Error) {
+	return nil, os.NewError("watching files is not supported on this system")
+}
@@ -0,0 +1,55 @@
+package gopher
+
+import (
+	"bufio"
+	"net"
+	"os"
+)
+
+// Responses are written to the client through a buffer, so a menu of
+// hundreds of lines goes out in a few large writes rather than one per
+// line. The buffer is flushed when the response is complete; handlers
+// producing output slowly can flush it themselves with Context.Flush. A
+// client that stops reading is dropped once a write has waited
+// WriteTimeout.
+
+// WriteTimeout is how long one write to a client may take, in nanoseconds
+var WriteTimeout int64 = 60e9
+
+// ResponseBufferSize is the size of the buffer responses are written
+// through
+var ResponseBufferSize = 16 * 1024
+
+// bufferedConn is a connection whose writes are buffered
+type bufferedConn struct {
+	net.Conn
+	w *bufio.Writer
+}
+
+func newBufferedConn(conn net.Conn) *bufferedConn {
+	conn.SetWriteTimeout(WriteTimeout)
+	w, _ := bufio.NewWriterSize(conn, ResponseBufferSize)
+	return &bufferedConn{conn, w}
+}
+
+func (c *bufferedConn) Write(b []byte) (int, os.Error) {
+	return c.w.Write(b)
+}
+
+// Flush sends what has been buffered. A write the connection only took
+// part of is reported as an error.
+func (c *bufferedConn) Flush() os.Error {
+	return c.w.Flush()
+}
+
+// Flush sends what the response has buffered so far, for handlers that
+// want the client to see part of a response before the rest is ready
+func (ctx *Context) Flush() os.Error {
+	if ctx.out == nil {
+		return nil
+	}
+	if dc, ok := ctx.conn.(*deadlineConn); ok {
+		return dc.flush(ctx.out)
+	}
+	return ctx.out.Flush()
+}
@@ -0,0 +1,58 @@
+package gopher
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strings"
+)
+
+// ZipFS is a FileSystem serving the contents of a zip archive without
+// unpacking it. The archive's directory is read once when it is opened,
+// so looking names up costs no I/O; file contents are inflated as they
+// are read.
+type ZipFS struct {
+	*MemFS
+	file *os.File
+}
+
+// OpenZipFS opens the zip archive at name as a FileSystem
+func OpenZipFS(name string) (*ZipFS, os.Error) {
+	file, err := os.Open(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r, err := zip.NewReader(file, info.Size)
+	if err != nil {
+		file.Close()
+		return nil, os.NewError(name + ": " + err.String())
+	}
+	z := &ZipFS{NewMemFS(), file}
+	for _, f := range r.File {
+		if strings.HasSuffix(f.Name, "/") {
+			err = z.Mkdir(f.Name)
+		} else {
+			err = z.addOpener(f.Name, int64(f.UncompressedSize), info.Mtime_ns, zipOpener(f))
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+// zipOpener returns a function opening an archived file
+func zipOpener(f *zip.File) func() (io.ReadCloser, os.Error) {
+	return func() (io.ReadCloser, os.Error) { return f.Open() }
+}
+
+// Close closes the archive
+func (z *ZipFS) Close() os.Error {
+	return z.file.Close()
+}